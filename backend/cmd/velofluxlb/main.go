@@ -45,6 +45,7 @@ func main() {
 	if err != nil {
 		logger.Fatal("Failed to create server", zap.Error(err))
 	}
+	srv.SetConfigPath(configPath)
 
 	// Start server in background
 	ctx, cancel := context.WithCancel(context.Background())
@@ -63,11 +64,19 @@ func main() {
 		zap.Bool("clustering_enabled", cfg.Cluster.Enabled),
 		zap.Bool("geo_routing_enabled", cfg.Global.GeoIP.Enabled))
 
-	// Wait for shutdown signal
+	// Wait for shutdown signal, reloading configuration on SIGHUP
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	<-sigChan
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		logger.Info("Received SIGHUP, reloading configuration", zap.String("path", configPath))
+		if err := srv.Reload(); err != nil {
+			logger.Error("Failed to reload configuration", zap.Error(err))
+		}
+	}
 	logger.Info("Received shutdown signal, gracefully shutting down...")
 
 	// Graceful shutdown with timeout
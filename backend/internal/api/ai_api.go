@@ -135,7 +135,7 @@ func (api *API) setupAIRoutes() {
 // getAIMetrics retorna métricas completas da IA
 func (api *API) getAIMetrics(w http.ResponseWriter, r *http.Request) {
 	metrics := &AIMetrics{
-		Enabled:          api.config.Global.AI.Enabled,
+		Enabled:          api.currentConfig().Global.AI.Enabled,
 		CurrentAlgorithm: "unknown",
 		LastUpdate:       time.Now(),
 		ModelPerformance: make(map[string]ModelPerformance),
@@ -184,7 +184,7 @@ func (api *API) getAIMetrics(w http.ResponseWriter, r *http.Request) {
 // getAIStatus retorna status simples da IA
 func (api *API) getAIStatus(w http.ResponseWriter, r *http.Request) {
 	status := map[string]interface{}{
-		"enabled":           api.config.Global.AI.Enabled,
+		"enabled":           api.currentConfig().Global.AI.Enabled,
 		"adaptive_balancer": api.adaptiveBalancer != nil,
 		"current_algorithm": "traditional",
 		"health":            "healthy",
@@ -254,14 +254,15 @@ func (api *API) getModelStatus(w http.ResponseWriter, r *http.Request) {
 
 // getAIConfig retorna configuração atual da IA
 func (api *API) getAIConfig(w http.ResponseWriter, r *http.Request) {
+	ai := api.currentConfig().Global.AI
 	config := AIConfigUpdate{
-		Enabled:             api.config.Global.AI.Enabled,
-		ModelType:           api.config.Global.AI.ModelType,
-		ConfidenceThreshold: api.config.Global.AI.ConfidenceThreshold,
-		ApplicationAware:    api.config.Global.AI.ApplicationAware,
-		PredictiveScaling:   api.config.Global.AI.PredictiveScaling,
-		LearningRate:        api.config.Global.AI.LearningRate,
-		ExplorationRate:     api.config.Global.AI.ExplorationRate,
+		Enabled:             ai.Enabled,
+		ModelType:           ai.ModelType,
+		ConfidenceThreshold: ai.ConfidenceThreshold,
+		ApplicationAware:    ai.ApplicationAware,
+		PredictiveScaling:   ai.PredictiveScaling,
+		LearningRate:        ai.LearningRate,
+		ExplorationRate:     ai.ExplorationRate,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -277,11 +278,15 @@ func (api *API) updateAIConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Atualizar configuração (simplified - em produção seria mais complexo)
-	api.config.Global.AI.Enabled = update.Enabled
-	api.config.Global.AI.ModelType = update.ModelType
-	api.config.Global.AI.ConfidenceThreshold = update.ConfidenceThreshold
-	api.config.Global.AI.ApplicationAware = update.ApplicationAware
-	api.config.Global.AI.PredictiveScaling = update.PredictiveScaling
+	api.configMu.Lock()
+	next := *api.config
+	next.Global.AI.Enabled = update.Enabled
+	next.Global.AI.ModelType = update.ModelType
+	next.Global.AI.ConfidenceThreshold = update.ConfidenceThreshold
+	next.Global.AI.ApplicationAware = update.ApplicationAware
+	next.Global.AI.PredictiveScaling = update.PredictiveScaling
+	api.config = &next
+	api.configMu.Unlock()
 
 	api.logger.Info("AI configuration updated",
 		zap.Bool("enabled", update.Enabled),
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -19,7 +20,10 @@ import (
 	"github.com/eltonciatto/veloflux/internal/billing"
 	"github.com/eltonciatto/veloflux/internal/clustering"
 	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/health"
 	"github.com/eltonciatto/veloflux/internal/orchestration"
+	"github.com/eltonciatto/veloflux/internal/rollout"
+	"github.com/eltonciatto/veloflux/internal/router"
 	"github.com/eltonciatto/veloflux/internal/tenant"
 	"github.com/eltonciatto/veloflux/internal/websocket"
 
@@ -45,6 +49,23 @@ type API struct {
 	orchestrator     *orchestration.Orchestrator
 	wsHub            *websocket.Hub
 	upgrader         ws.Upgrader // Para upgrade de conexões WebSocket
+	reloader         Reloader
+	healthStatus     HealthStatusProvider
+	splits           SplitController
+	shiftMu          sync.Mutex
+	shifts           map[string]*splitShift // gradual split changes keyed by route ID
+	rollouts         *rollout.Controller
+	domains          DomainRegistry
+}
+
+// Reloader re-applies the configuration from its source to the running server
+type Reloader interface {
+	Reload() error
+}
+
+// HealthStatusProvider exposes the health of every probed backend
+type HealthStatusProvider interface {
+	Statuses() []health.BackendStatus
 }
 
 // BackendRequest represents a request to add/update a backend
@@ -63,9 +84,31 @@ type BackendRequest struct {
 
 // RouteRequest represents a request to add/update a route
 type RouteRequest struct {
-	Host       string `json:"host"`
-	Pool       string `json:"pool"`
-	PathPrefix string `json:"path_prefix,omitempty"`
+	ID          string              `json:"id,omitempty"`
+	Host        string              `json:"host"`
+	Pool        string              `json:"pool"`
+	PathPrefix  string              `json:"path_prefix,omitempty"`
+	PathRegex   string              `json:"path_regex,omitempty"`
+	Methods     []string            `json:"methods,omitempty"`
+	Headers     []config.ValueMatch `json:"headers,omitempty"`
+	Query       []config.ValueMatch `json:"query,omitempty"`
+	Cookies     []config.ValueMatch `json:"cookies,omitempty"`
+	SourceCIDRs []string            `json:"source_cidrs,omitempty"`
+	Priority    int                 `json:"priority,omitempty"`
+}
+
+// applyTo copies the match conditions and pool of the request onto a route
+func (req RouteRequest) applyTo(route *config.Route) {
+	route.Host = req.Host
+	route.Pool = req.Pool
+	route.PathPrefix = req.PathPrefix
+	route.PathRegex = req.PathRegex
+	route.Methods = req.Methods
+	route.Headers = req.Headers
+	route.Query = req.Query
+	route.Cookies = req.Cookies
+	route.SourceCIDRs = req.SourceCIDRs
+	route.Priority = req.Priority
 }
 
 // PoolRequest represents a request to add/update a pool
@@ -123,6 +166,42 @@ func New(cfg *config.Config, bal *balancer.Balancer, adaptiveBal *balancer.Adapt
 	return a
 }
 
+// SetReloader sets the component used by the reload endpoint
+func (a *API) SetReloader(r Reloader) {
+	a.reloader = r
+}
+
+// SetHealthStatusProvider sets the source of backend health snapshots
+func (a *API) SetHealthStatusProvider(p HealthStatusProvider) {
+	a.healthStatus = p
+}
+
+// UpdateConfig replaces the configuration served by the API after a reload.
+// The API never modifies a configuration, which it shares with the server:
+// changes are made to a copy that replaces it.
+func (a *API) UpdateConfig(cfg *config.Config) {
+	a.configMu.Lock()
+	a.config = cfg
+	a.configMu.Unlock()
+}
+
+// currentConfig returns the configuration served by the API. It is never
+// modified, so it may be read without holding a.configMu.
+func (a *API) currentConfig() *config.Config {
+	a.configMu.RLock()
+	defer a.configMu.RUnlock()
+	return a.config
+}
+
+// setRoutes replaces the configuration with a copy holding routes, which
+// must not share an array with the current routes. Callers must hold
+// a.configMu.
+func (a *API) setRoutes(routes []config.Route) {
+	next := *a.config
+	next.Routes = routes
+	a.config = &next
+}
+
 // Start begins the API server
 func (a *API) Start() error {
 	// Start WebSocket hub
@@ -149,7 +228,7 @@ func (a *API) Start() error {
 
 	// Setup API server
 	a.server = &http.Server{
-		Addr:         a.config.API.BindAddress,
+		Addr:         a.currentConfig().API.BindAddress,
 		Handler:      a.router,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
@@ -157,7 +236,7 @@ func (a *API) Start() error {
 	}
 
 	a.logger.Info("API Server created",
-		zap.String("address", a.config.API.BindAddress),
+		zap.String("address", a.server.Addr),
 		zap.String("handler_type", fmt.Sprintf("%T", a.router)))
 
 	// Start HTTP server
@@ -246,7 +325,8 @@ func (a *API) setupRoutes() {
 	authMiddleware := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			username, password, ok := r.BasicAuth()
-			if !ok || username != a.config.API.Username || password != a.config.API.Password {
+			cfg := a.currentConfig()
+			if !ok || username != cfg.API.Username || password != cfg.API.Password {
 				w.Header().Set("WWW-Authenticate", `Basic realm="VeloFlux API"`)
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
@@ -256,7 +336,7 @@ func (a *API) setupRoutes() {
 	}
 
 	// Apply basic authentication
-	if a.config.API.AuthEnabled {
+	if a.currentConfig().API.AuthEnabled {
 		apiRouter.Use(authMiddleware)
 		a.logger.Info("API authentication enabled")
 	}
@@ -280,6 +360,15 @@ func (a *API) setupRoutes() {
 	apiRouter.HandleFunc("/routes", a.handleCreateRoute).Methods("POST")
 	apiRouter.HandleFunc("/routes/{id}", a.handleUpdateRoute).Methods("PUT")
 	apiRouter.HandleFunc("/routes/{id}", a.handleDeleteRoute).Methods("DELETE")
+	apiRouter.HandleFunc("/routes/{id}/split", a.handleGetSplit).Methods("GET")
+	apiRouter.HandleFunc("/routes/{id}/split", a.handleUpdateSplit).Methods("PUT")
+
+	// Progressive delivery
+	apiRouter.HandleFunc("/rollouts", a.handleListRollouts).Methods("GET")
+	apiRouter.HandleFunc("/rollouts", a.handleCreateRollout).Methods("POST")
+	apiRouter.HandleFunc("/rollouts/{id}", a.handleGetRollout).Methods("GET")
+	apiRouter.HandleFunc("/rollouts/{id}/promote", a.handlePromoteRollout).Methods("POST")
+	apiRouter.HandleFunc("/rollouts/{id}/rollback", a.handleRollbackRollout).Methods("POST")
 
 	// Using handleClusterInfo instead of handleGetCluster
 	apiRouter.HandleFunc("/cluster", a.handleClusterInfo).Methods("GET")
@@ -369,6 +458,11 @@ func (a *API) setupRoutes() {
 		tenantSpecificRouter.HandleFunc("/oidc/config", a.requireTenantAccess(a.handleUpdateTenantOIDCConfig)).Methods("PUT")
 		tenantSpecificRouter.HandleFunc("/oidc/test", a.requireTenantAccess(a.handleTestTenantOIDCConfig)).Methods("POST")
 
+		// Custom domain APIs
+		tenantSpecificRouter.HandleFunc("/domains", a.requireTenantAccess(a.handleListTenantDomains)).Methods("GET")
+		tenantSpecificRouter.HandleFunc("/domains", a.requireTenantAccess(a.handleAddTenantDomain)).Methods("POST")
+		tenantSpecificRouter.HandleFunc("/domains/{domain}", a.requireTenantAccess(a.handleDeleteTenantDomain)).Methods("DELETE")
+
 		// Tenant Configuration APIs
 		tenantSpecificRouter.HandleFunc("/config", a.requireTenantAccess(a.handleGetTenantConfig)).Methods("GET")
 		tenantSpecificRouter.HandleFunc("/billing", a.requireTenantAccess(a.handleGetTenantBilling)).Methods("GET")
@@ -576,6 +670,9 @@ func (a *API) handleUpdatePool(w http.ResponseWriter, r *http.Request) {
 		Name:           name,
 		Algorithm:      req.Algorithm,
 		StickySessions: req.StickySessions,
+		HashPolicy:     existingPool.HashPolicy,
+		SlowStart:      existingPool.SlowStart,
+		Sticky:         existingPool.Sticky,
 		Backends:       existingPool.Backends,
 	}
 
@@ -760,6 +857,30 @@ func (a *API) handleListRoutes(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, routes)
 }
 
+// findRoute returns the index of the route with the given ID. Callers must hold a.configMu.
+func (a *API) findRoute(id string) int {
+	for i, route := range a.config.Routes {
+		if route.ID == id || (route.ID == "" && config.RouteID(route) == id) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (a *API) handleGetRoute(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	a.configMu.RLock()
+	defer a.configMu.RUnlock()
+
+	i := a.findRoute(id)
+	if i < 0 {
+		writeError(w, "Route not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, a.config.Routes[i])
+}
+
 func (a *API) handleCreateRoute(w http.ResponseWriter, r *http.Request) {
 	// Only leader can modify configuration
 	if a.cluster != nil && !a.cluster.IsLeader() {
@@ -773,8 +894,8 @@ func (a *API) handleCreateRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Host == "" || req.Pool == "" {
-		writeError(w, "Host and pool are required", http.StatusBadRequest)
+	if req.Pool == "" {
+		writeError(w, "Pool is required", http.StatusBadRequest)
 		return
 	}
 
@@ -784,33 +905,31 @@ func (a *API) handleCreateRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if route already exists
-	a.configMu.RLock()
-	for _, route := range a.config.Routes {
-		if route.Host == req.Host {
-			a.configMu.RUnlock()
-			writeError(w, "Route already exists", http.StatusConflict)
-			return
-		}
-	}
-	a.configMu.RUnlock()
-
 	// Create route
-	route := config.Route{
-		Host:       req.Host,
-		Pool:       req.Pool,
-		PathPrefix: req.PathPrefix,
+	route := config.Route{ID: req.ID}
+	req.applyTo(&route)
+	if err := router.ValidateRoute(route); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if route.ID == "" {
+		route.ID = config.RouteID(route)
 	}
 
-	// Add to config
+	// Add to config unless a route with the same ID exists
 	a.configMu.Lock()
-	a.config.Routes = append(a.config.Routes, route)
+	if a.findRoute(route.ID) >= 0 {
+		a.configMu.Unlock()
+		writeError(w, "Route already exists", http.StatusConflict)
+		return
+	}
+	a.setRoutes(append(slices.Clip(a.config.Routes), route))
 	a.configMu.Unlock()
 
 	// Sync to cluster if enabled
 	if a.cluster != nil {
 		data, _ := json.Marshal(route)
-		a.cluster.PublishState(clustering.StateRoute, route.Host, data)
+		a.cluster.PublishState(clustering.StateRoute, route.ID, data)
 	}
 
 	w.WriteHeader(http.StatusCreated)
@@ -824,8 +943,7 @@ func (a *API) handleUpdateRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	vars := mux.Vars(r)
-	host := vars["host"]
+	id := mux.Vars(r)["id"]
 
 	var req RouteRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -844,35 +962,31 @@ func (a *API) handleUpdateRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Find and update route
+	// Find and update route, keeping its ID and policies
 	a.configMu.Lock()
-	var updated bool
-	for i, route := range a.config.Routes {
-		if route.Host == host {
-			a.config.Routes[i].Pool = req.Pool
-			a.config.Routes[i].PathPrefix = req.PathPrefix
-			updated = true
-			break
-		}
-	}
-	a.configMu.Unlock()
-
-	if !updated {
+	i := a.findRoute(id)
+	if i < 0 {
+		a.configMu.Unlock()
 		writeError(w, "Route not found", http.StatusNotFound)
 		return
 	}
-
-	// Create updated route
-	route := config.Route{
-		Host:       host,
-		Pool:       req.Pool,
-		PathPrefix: req.PathPrefix,
+	route := a.config.Routes[i]
+	route.ID = id
+	req.applyTo(&route)
+	if err := router.ValidateRoute(route); err != nil {
+		a.configMu.Unlock()
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	routes := slices.Clone(a.config.Routes)
+	routes[i] = route
+	a.setRoutes(routes)
+	a.configMu.Unlock()
 
 	// Sync to cluster if enabled
 	if a.cluster != nil {
 		data, _ := json.Marshal(route)
-		a.cluster.PublishState(clustering.StateRoute, route.Host, data)
+		a.cluster.PublishState(clustering.StateRoute, route.ID, data)
 	}
 
 	writeJSON(w, route)
@@ -885,30 +999,26 @@ func (a *API) handleDeleteRoute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	vars := mux.Vars(r)
-	host := vars["host"]
+	id := mux.Vars(r)["id"]
 
 	// Find and remove route
 	a.configMu.Lock()
-	var found bool
-	for i, route := range a.config.Routes {
-		if route.Host == host {
-			// Remove this route
-			a.config.Routes = append(a.config.Routes[:i], a.config.Routes[i+1:]...)
-			found = true
-			break
-		}
+	i := a.findRoute(id)
+	if i >= 0 {
+		routes := make([]config.Route, 0, len(a.config.Routes)-1)
+		routes = append(routes, a.config.Routes[:i]...)
+		a.setRoutes(append(routes, a.config.Routes[i+1:]...))
 	}
 	a.configMu.Unlock()
 
-	if !found {
+	if i < 0 {
 		writeError(w, "Route not found", http.StatusNotFound)
 		return
 	}
 
 	// Sync to cluster if enabled
 	if a.cluster != nil {
-		a.cluster.PublishState(clustering.StateRoute, host, nil)
+		a.cluster.PublishState(clustering.StateRoute, id, nil)
 	}
 
 	w.WriteHeader(http.StatusNoContent)
@@ -965,9 +1075,16 @@ func (a *API) handleReload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Trigger a configuration reload
-	// This would typically reload from disk or database
-	// For now, we'll just return success
+	if a.reloader == nil {
+		writeError(w, "Reload not supported", http.StatusNotImplemented)
+		return
+	}
+
+	if err := a.reloader.Reload(); err != nil {
+		a.logger.Error("Configuration reload failed", zap.Error(err))
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	writeJSON(w, map[string]string{"status": "reloaded"})
 }
@@ -1016,18 +1133,16 @@ func (a *API) handleRouteStateChange(stateType clustering.StateType, key string,
 		return
 	}
 
-	host := key
+	id := key
 
 	if value == nil {
 		// Route was deleted
 		a.configMu.Lock()
-		for i, route := range a.config.Routes {
-			if route.Host == host {
-				a.config.Routes = append(a.config.Routes[:i], a.config.Routes[i+1:]...)
-				a.configMu.Unlock()
-				a.logger.Info("Removed route via cluster sync", zap.String("host", host))
-				return
-			}
+		if i := a.findRoute(id); i >= 0 {
+			a.setRoutes(slices.Delete(slices.Clone(a.config.Routes), i, i+1))
+			a.configMu.Unlock()
+			a.logger.Info("Removed route via cluster sync", zap.String("route", id))
+			return
 		}
 		a.configMu.Unlock()
 		return
@@ -1041,22 +1156,21 @@ func (a *API) handleRouteStateChange(stateType clustering.StateType, key string,
 	}
 
 	// Update or add route
-	a.configMu.Lock()
-	var found bool
-	for i, r := range a.config.Routes {
-		if r.Host == host {
-			a.config.Routes[i] = route
-			found = true
-			break
-		}
+	if route.ID == "" {
+		route.ID = id
 	}
-
-	if !found {
-		a.config.Routes = append(a.config.Routes, route)
+	a.configMu.Lock()
+	if i := a.findRoute(id); i >= 0 {
+		routes := slices.Clone(a.config.Routes)
+		routes[i] = route
+		a.setRoutes(routes)
+	} else {
+		a.setRoutes(append(slices.Clip(a.config.Routes), route))
 	}
 	a.configMu.Unlock()
+	a.syncSplit(route)
 
-	a.logger.Info("Updated route via cluster sync", zap.String("host", host))
+	a.logger.Info("Updated route via cluster sync", zap.String("route", id))
 }
 
 func (a *API) handleConfigStateChange(stateType clustering.StateType, key string, value []byte) {
@@ -1281,16 +1395,42 @@ func (a *API) handleGetBackend(w http.ResponseWriter, r *http.Request) {
 func (a *API) handleUpdateBackend(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, map[string]string{"status": "ok"})
 }
-func (a *API) handleGetRoute(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, map[string]string{"status": "ok"})
-}
 
 // Advanced status/metrics/health
 func (a *API) handleAdvancedStatus(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, map[string]string{"status": "ok"})
 }
 func (a *API) handleAdvancedHealth(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, map[string]string{"status": "ok"})
+	if a.healthStatus == nil {
+		writeError(w, "Health checker not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	statuses := a.healthStatus.Statuses()
+	if pool := r.URL.Query().Get("pool"); pool != "" {
+		filtered := make([]health.BackendStatus, 0, len(statuses))
+		for _, status := range statuses {
+			if status.Pool == pool {
+				filtered = append(filtered, status)
+			}
+		}
+		statuses = filtered
+	}
+
+	healthy := 0
+	for _, status := range statuses {
+		if status.Healthy {
+			healthy++
+		}
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"backends":  statuses,
+		"total":     len(statuses),
+		"healthy":   healthy,
+		"unhealthy": len(statuses) - healthy,
+		"timestamp": time.Now().Unix(),
+	})
 }
 func (a *API) handleAdvancedMetrics(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, map[string]string{"status": "ok"})
@@ -1759,7 +1899,8 @@ func (a *API) monitorClusterStateChanges() {
 		// Em uma implementação real, isso seria disparado por eventos do cluster
 
 		// Verificar mudanças de backend
-		for poolName, pool := range a.config.Pools {
+		cfg := a.currentConfig()
+		for poolName, pool := range cfg.Pools {
 			for _, backend := range pool.Backends {
 				backendKey := fmt.Sprintf("%d/%s", poolName, backend.Address)
 				backendData, _ := json.Marshal(backend)
@@ -1768,13 +1909,13 @@ func (a *API) monitorClusterStateChanges() {
 		}
 
 		// Verificar mudanças de rotas
-		for _, route := range a.config.Routes {
+		for _, route := range cfg.Routes {
 			routeData, _ := json.Marshal(route)
-			a.handleRouteStateChange(clustering.StateRoute, route.Host, routeData)
+			a.handleRouteStateChange(clustering.StateRoute, route.ID, routeData)
 		}
 
 		// Verificar mudanças de configuração global
-		configData, _ := json.Marshal(a.config)
+		configData, _ := json.Marshal(cfg)
 		a.handleConfigStateChange(clustering.StateConfig, "global", configData)
 	}
 }
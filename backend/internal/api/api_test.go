@@ -0,0 +1,260 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/eltonciatto/veloflux/internal/balancer"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/domains"
+	"github.com/eltonciatto/veloflux/internal/router"
+	"github.com/eltonciatto/veloflux/internal/tenant"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRouteCRUDByID(t *testing.T) {
+	bal := balancer.New()
+	bal.AddPool(config.Pool{Name: "web"})
+	api := &API{config: &config.Config{}, balancer: bal, logger: zap.NewNop()}
+
+	call := func(handler http.HandlerFunc, method, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/routes/"+id, strings.NewReader(body))
+		if id != "" {
+			req = mux.SetURLVars(req, map[string]string{"id": id})
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	body := `{"host":"example.com","pool":"web","methods":["GET"],"headers":[{"name":"X-Beta"}]}`
+	w := call(api.handleCreateRoute, http.MethodPost, "", body)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created config.Route
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, config.RouteID(created), created.ID)
+
+	// Creating the same route twice conflicts
+	assert.Equal(t, http.StatusConflict, call(api.handleCreateRoute, http.MethodPost, "", body).Code)
+
+	// Invalid match conditions are rejected
+	w = call(api.handleCreateRoute, http.MethodPost, "", `{"pool":"web","path_regex":"("}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = call(api.handleGetRoute, http.MethodGet, created.ID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Updating the conditions keeps the ID
+	w = call(api.handleUpdateRoute, http.MethodPut, created.ID, `{"host":"example.com","pool":"web","priority":5}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var updated config.Route
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.Equal(t, created.ID, updated.ID)
+	assert.Equal(t, 5, updated.Priority)
+	assert.Empty(t, updated.Methods)
+
+	assert.Equal(t, http.StatusNoContent, call(api.handleDeleteRoute, http.MethodDelete, created.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, call(api.handleGetRoute, http.MethodGet, created.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, call(api.handleDeleteRoute, http.MethodDelete, created.ID, "").Code)
+}
+
+func TestUpdateConfigLeavesSharedConfigs(t *testing.T) {
+	bal := balancer.New()
+	bal.AddPool(config.Pool{Name: "web"})
+	loaded := &config.Config{Routes: []config.Route{{ID: "a", Pool: "web"}}}
+	api := &API{config: loaded, balancer: bal, logger: zap.NewNop()}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/routes", strings.NewReader(`{"id":"b","pool":"web"}`))
+	w := httptest.NewRecorder()
+	api.handleCreateRoute(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Len(t, api.currentConfig().Routes, 2)
+	assert.Len(t, loaded.Routes, 1, "the server's configuration is not modified")
+
+	// A reload replaces the configuration as a whole
+	reloaded := &config.Config{Routes: []config.Route{{ID: "c", Pool: "web"}}}
+	api.UpdateConfig(reloaded)
+	assert.Same(t, reloaded, api.currentConfig())
+
+	req = mux.SetURLVars(httptest.NewRequest(http.MethodPut, "/api/routes/c", strings.NewReader(`{"pool":"web","priority":5}`)), map[string]string{"id": "c"})
+	w = httptest.NewRecorder()
+	api.handleUpdateRoute(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 5, api.currentConfig().Routes[0].Priority)
+	assert.Zero(t, reloaded.Routes[0].Priority)
+}
+
+// fakeSplits is an in-memory SplitController
+type fakeSplits struct {
+	mu       sync.Mutex
+	variants map[string][]config.SplitVariant
+}
+
+func (f *fakeSplits) SplitWeights(routeID string) ([]config.SplitVariant, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	variants, ok := f.variants[routeID]
+	return append([]config.SplitVariant(nil), variants...), ok
+}
+
+func (f *fakeSplits) SetSplitWeights(routeID string, weights map[string]int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	variants, ok := f.variants[routeID]
+	if !ok {
+		return router.ErrRouteNotFound
+	}
+	for pool, weight := range weights {
+		variants = mergeVariant(variants, config.SplitVariant{Pool: pool, Weight: weight})
+	}
+	f.variants[routeID] = variants
+	return nil
+}
+
+func TestSplitAPI(t *testing.T) {
+	bal := balancer.New()
+	bal.AddPool(config.Pool{Name: "stable"})
+	bal.AddPool(config.Pool{Name: "canary"})
+	splits := &fakeSplits{variants: map[string][]config.SplitVariant{
+		"web": {{Pool: "stable", Weight: 100}},
+	}}
+	api := &API{
+		config:   &config.Config{Routes: []config.Route{{ID: "web", Pool: "stable"}}},
+		balancer: bal,
+		logger:   zap.NewNop(),
+		splits:   splits,
+	}
+
+	call := func(handler http.HandlerFunc, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/routes/"+id+"/split", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	w := call(api.handleUpdateSplit, "web", `{"weights":{"stable":95,"canary":5}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []config.SplitVariant{{Pool: "stable", Weight: 95}, {Pool: "canary", Weight: 5}},
+		api.config.Routes[0].Split.Variants, "the route configuration records the split")
+
+	assert.Equal(t, http.StatusNotFound, call(api.handleUpdateSplit, "missing", `{"weights":{"canary":5}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(api.handleUpdateSplit, "web", `{"weights":{"other":5}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(api.handleUpdateSplit, "web", `{"weights":{"canary":5},"step":5}`).Code)
+
+	// A gradual shift moves by one step right away and reaches its target
+	w = call(api.handleUpdateSplit, "web", `{"weights":{"stable":55,"canary":45},"step":20,"interval":"10ms"}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var status SplitStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, []SplitWeight{{Pool: "stable", Weight: 75}, {Pool: "canary", Weight: 25}}, status.Weights)
+	assert.Equal(t, 45, status.Target["canary"])
+
+	assert.Eventually(t, func() bool {
+		variants, _ := splits.SplitWeights("web")
+		return variants[1].Weight == 45 && variants[0].Weight == 55
+	}, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		api.shiftMu.Lock()
+		defer api.shiftMu.Unlock()
+		return len(api.shifts) == 0
+	}, time.Second, 5*time.Millisecond)
+
+	w = call(api.handleGetSplit, "web", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	status = SplitStatus{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Nil(t, status.Target)
+}
+
+func TestDomainAPI(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer client.Close()
+	registry := domains.New(client, zap.NewNop())
+	api := &API{logger: zap.NewNop()}
+
+	call := func(handler http.HandlerFunc, method string, vars map[string]string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/tenants/"+vars["tenant_id"]+"/domains", strings.NewReader(body))
+		req = mux.SetURLVars(req, vars)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	acme := map[string]string{"tenant_id": "acme"}
+
+	assert.Equal(t, http.StatusServiceUnavailable, call(api.handleListTenantDomains, http.MethodGet, acme, "").Code)
+	api.SetDomainRegistry(registry)
+
+	w := call(api.handleAddTenantDomain, http.MethodPost, acme, `{"domain":"shop.example.com"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusBadRequest, call(api.handleAddTenantDomain, http.MethodPost, acme, `{"domain":"localhost"}`).Code)
+	assert.Equal(t, http.StatusConflict,
+		call(api.handleAddTenantDomain, http.MethodPost, map[string]string{"tenant_id": "other"}, `{"domain":"shop.example.com"}`).Code)
+
+	w = call(api.handleListTenantDomains, http.MethodGet, acme, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Domains []string `json:"domains"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, []string{"shop.example.com"}, list.Domains)
+
+	remove := map[string]string{"tenant_id": "acme", "domain": "shop.example.com"}
+	assert.Equal(t, http.StatusNoContent, call(api.handleDeleteTenantDomain, http.MethodDelete, remove, "").Code)
+	assert.Equal(t, http.StatusNotFound, call(api.handleDeleteTenantDomain, http.MethodDelete, remove, "").Code)
+}
+
+func TestTenantAPISyncsCustomDomains(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer client.Close()
+	registry := domains.New(client, zap.NewNop())
+	api := &TenantAPI{logger: zap.NewNop(), tenantManager: tenant.NewManager(client, zap.NewNop())}
+	api.SetDomainRegistry(registry)
+
+	call := func(handler http.HandlerFunc, method, tenantID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/tenants/"+tenantID, strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"tenant_id": tenantID})
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	owner := func(host string) string {
+		tenantID, _ := registry.TenantForHost(host)
+		return tenantID
+	}
+
+	w := call(api.handleCreateTenant, http.MethodPost, "", `{"id":"acme","name":"Acme","custom_domain":"shop.example.com"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "acme", owner("shop.example.com"))
+
+	// Another tenant cannot be created with the domain
+	w = call(api.handleCreateTenant, http.MethodPost, "", `{"id":"other","name":"Other","custom_domain":"shop.example.com"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	_, err := api.tenantManager.GetTenant(context.Background(), "other")
+	assert.Error(t, err)
+
+	// A new domain replaces the previous one
+	w = call(api.handleUpdateTenant, http.MethodPut, "acme", `{"name":"Acme","active":true,"custom_domain":"store.example.com"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "acme", owner("store.example.com"))
+	assert.Empty(t, owner("shop.example.com"))
+
+	// Deleting the tenant removes every domain it registered
+	require.NoError(t, registry.Register(context.Background(), "acme", "api.example.com"))
+	assert.Equal(t, http.StatusNoContent, call(api.handleDeleteTenant, http.MethodDelete, "acme", "").Code)
+	names, err := registry.Domains(context.Background(), "acme")
+	require.NoError(t, err)
+	assert.Empty(t, names)
+	assert.Empty(t, owner("store.example.com"))
+}
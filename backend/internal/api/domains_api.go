@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/eltonciatto/veloflux/internal/domains"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// DomainRegistry keeps the custom domains of tenants
+type DomainRegistry interface {
+	Domains(ctx context.Context, tenantID string) ([]string, error)
+	Register(ctx context.Context, tenantID, domain string) error
+	Remove(ctx context.Context, tenantID, domain string) error
+}
+
+// DomainRequest registers a custom domain for a tenant
+type DomainRequest struct {
+	Domain string `json:"domain"`
+}
+
+// SetDomainRegistry sets the registry of tenants' custom domains
+func (a *API) SetDomainRegistry(r DomainRegistry) {
+	a.domains = r
+}
+
+func (a *API) handleListTenantDomains(w http.ResponseWriter, r *http.Request) {
+	if a.domains == nil {
+		writeError(w, "Custom domains not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	tenantID := mux.Vars(r)["tenant_id"]
+	names, err := a.domains.Domains(r.Context(), tenantID)
+	if err != nil {
+		a.logger.Error("Failed to list custom domains", zap.String("tenant_id", tenantID), zap.Error(err))
+		writeError(w, "Failed to list custom domains", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"domains": names})
+}
+
+func (a *API) handleAddTenantDomain(w http.ResponseWriter, r *http.Request) {
+	if a.domains == nil {
+		writeError(w, "Custom domains not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req DomainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	tenantID := mux.Vars(r)["tenant_id"]
+	if err := a.domains.Register(r.Context(), tenantID, req.Domain); err != nil {
+		writeDomainError(w, a.logger, tenantID, err)
+		return
+	}
+
+	a.logger.Info("Custom domain registered", zap.String("tenant_id", tenantID), zap.String("domain", req.Domain))
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, req)
+}
+
+func (a *API) handleDeleteTenantDomain(w http.ResponseWriter, r *http.Request) {
+	if a.domains == nil {
+		writeError(w, "Custom domains not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	vars := mux.Vars(r)
+	tenantID := vars["tenant_id"]
+	if err := a.domains.Remove(r.Context(), tenantID, vars["domain"]); err != nil {
+		writeDomainError(w, a.logger, tenantID, err)
+		return
+	}
+
+	a.logger.Info("Custom domain removed", zap.String("tenant_id", tenantID), zap.String("domain", vars["domain"]))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeDomainError(w http.ResponseWriter, logger *zap.Logger, tenantID string, err error) {
+	switch {
+	case errors.Is(err, domains.ErrInvalidDomain):
+		writeError(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, domains.ErrDomainTaken):
+		writeError(w, "Domain already registered", http.StatusConflict)
+	case errors.Is(err, domains.ErrDomainNotFound):
+		writeError(w, "Domain not found", http.StatusNotFound)
+	default:
+		logger.Error("Failed to update custom domains", zap.String("tenant_id", tenantID), zap.Error(err))
+		writeError(w, "Failed to update custom domains", http.StatusInternalServerError)
+	}
+}
+
+// registerDomains registers the domains a tenant is given by another API,
+// such as its tenant or orchestration settings. It returns those the tenant
+// did not have yet, for a change that fails to take them back; when one
+// cannot be registered none are.
+func registerDomains(ctx context.Context, registry DomainRegistry, tenantID string, names []string) ([]string, error) {
+	owned, err := registry.Domains(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	var added []string
+	for _, name := range names {
+		if normalized, err := domains.Normalize(name); err == nil && slices.Contains(owned, normalized) {
+			continue
+		}
+		if err := registry.Register(ctx, tenantID, name); err != nil {
+			removeDomains(ctx, registry, tenantID, added)
+			return nil, err
+		}
+		added = append(added, name)
+	}
+	return added, nil
+}
+
+// removeDomains takes domains away from a tenant, skipping those it does not
+// have
+func removeDomains(ctx context.Context, registry DomainRegistry, tenantID string, names []string) error {
+	var errs []error
+	for _, name := range names {
+		err := registry.Remove(ctx, tenantID, name)
+		if err != nil && !errors.Is(err, domains.ErrDomainNotFound) && !errors.Is(err, domains.ErrInvalidDomain) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
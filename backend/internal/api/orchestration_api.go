@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 
 	"github.com/eltonciatto/veloflux/internal/orchestration"
 	"github.com/eltonciatto/veloflux/internal/tenant"
@@ -16,6 +17,7 @@ type OrchestrationAPI struct {
 	logger        *zap.Logger
 	orchestrator  *orchestration.Orchestrator
 	tenantManager *tenant.Manager
+	domains       DomainRegistry
 }
 
 // NewOrchestrationAPI creates a new orchestration API handler
@@ -27,6 +29,12 @@ func NewOrchestrationAPI(orchestrator *orchestration.Orchestrator, tenantManager
 	}
 }
 
+// SetDomainRegistry sets the registry the custom domains of tenants are kept
+// in, so the domains of their deployments reach them
+func (api *OrchestrationAPI) SetDomainRegistry(r DomainRegistry) {
+	api.domains = r
+}
+
 // SetupRoutes sets up the routes for the orchestration API
 func (api *OrchestrationAPI) SetupRoutes(router *mux.Router) {
 	// Tenant-specific orchestration endpoints
@@ -105,13 +113,42 @@ func (api *OrchestrationAPI) handleSetConfig(w http.ResponseWriter, r *http.Requ
 		}
 	}
 
+	// Register the custom domains, replacing those of the previous
+	// configuration once it is updated
+	var added, dropped []string
+	if api.domains != nil {
+		previous, err := api.orchestrator.GetTenantConfig(r.Context(), tenantID)
+		if err != nil {
+			api.logger.Error("Failed to get orchestration config", zap.Error(err))
+			http.Error(w, "Failed to update orchestration configuration", http.StatusInternalServerError)
+			return
+		}
+		for _, domain := range previous.CustomDomains {
+			if !slices.Contains(config.CustomDomains, domain) {
+				dropped = append(dropped, domain)
+			}
+		}
+		if added, err = registerDomains(r.Context(), api.domains, tenantID, config.CustomDomains); err != nil {
+			writeDomainError(w, api.logger, tenantID, err)
+			return
+		}
+	}
+
 	// Update orchestration configuration
 	err = api.orchestrator.SetTenantConfig(r.Context(), &config)
 	if err != nil {
+		if api.domains != nil {
+			removeDomains(r.Context(), api.domains, tenantID, added)
+		}
 		api.logger.Error("Failed to set orchestration config", zap.Error(err))
 		http.Error(w, "Failed to update orchestration configuration", http.StatusInternalServerError)
 		return
 	}
+	if api.domains != nil {
+		if err := removeDomains(r.Context(), api.domains, tenantID, dropped); err != nil {
+			api.logger.Error("Failed to remove custom domains", zap.String("tenant_id", tenantID), zap.Error(err))
+		}
+	}
 
 	// Return success
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/eltonciatto/veloflux/internal/rollout"
+	"github.com/gorilla/mux"
+)
+
+// RolloutActionRequest carries the optional reason of a manual promotion or rollback
+type RolloutActionRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// SetRolloutController sets the controller behind the rollout endpoints.
+// Rollout changes are published on the WebSocket hub.
+func (a *API) SetRolloutController(c *rollout.Controller) {
+	a.rollouts = c
+	if a.wsHub != nil {
+		c.SetBroadcaster(a.wsHub)
+	}
+}
+
+// rolloutsAvailable writes an error unless rollouts can be served
+func (a *API) rolloutsAvailable(w http.ResponseWriter) bool {
+	if a.rollouts == nil {
+		writeError(w, "Rollouts not available", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// rolloutsWritable writes an error unless this node may change rollouts
+func (a *API) rolloutsWritable(w http.ResponseWriter) bool {
+	// Only leader can modify configuration
+	if a.cluster != nil && !a.cluster.IsLeader() {
+		writeError(w, "Operation only allowed on leader node", http.StatusForbidden)
+		return false
+	}
+	return a.rolloutsAvailable(w)
+}
+
+func writeRolloutError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, rollout.ErrNotFound):
+		writeError(w, "Rollout not found", http.StatusNotFound)
+	case errors.Is(err, rollout.ErrRouteBusy), errors.Is(err, rollout.ErrNotInProgress):
+		writeError(w, err.Error(), http.StatusConflict)
+	default:
+		writeError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (a *API) handleListRollouts(w http.ResponseWriter, r *http.Request) {
+	if !a.rolloutsAvailable(w) {
+		return
+	}
+	rollouts, err := a.rollouts.List(r.Context())
+	if err != nil {
+		writeRolloutError(w, err)
+		return
+	}
+	writeJSON(w, rollouts)
+}
+
+func (a *API) handleGetRollout(w http.ResponseWriter, r *http.Request) {
+	if !a.rolloutsAvailable(w) {
+		return
+	}
+	ro, err := a.rollouts.Get(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeRolloutError(w, err)
+		return
+	}
+	writeJSON(w, ro)
+}
+
+func (a *API) handleCreateRollout(w http.ResponseWriter, r *http.Request) {
+	if !a.rolloutsWritable(w) {
+		return
+	}
+
+	var spec rollout.Spec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	for _, pool := range []string{spec.StablePool, spec.CandidatePool} {
+		if pool != "" && a.balancer.GetPool(pool) == nil {
+			writeError(w, "Referenced pool does not exist", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ro, err := a.rollouts.Create(r.Context(), spec)
+	if errors.Is(err, rollout.ErrRouteBusy) {
+		writeRolloutError(w, err)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, ro)
+}
+
+func (a *API) handlePromoteRollout(w http.ResponseWriter, r *http.Request) {
+	a.finishRollout(w, r, a.rollouts.Promote, "promoted manually")
+}
+
+func (a *API) handleRollbackRollout(w http.ResponseWriter, r *http.Request) {
+	a.finishRollout(w, r, a.rollouts.Rollback, "rolled back manually")
+}
+
+func (a *API) finishRollout(w http.ResponseWriter, r *http.Request,
+	finish func(ctx context.Context, id, reason string) (*rollout.Rollout, error), defaultReason string) {
+	if !a.rolloutsWritable(w) {
+		return
+	}
+
+	var req RolloutActionRequest
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Reason == "" {
+		req.Reason = defaultReason
+	}
+
+	ro, err := finish(r.Context(), mux.Vars(r)["id"], req.Reason)
+	if err != nil {
+		writeRolloutError(w, err)
+		return
+	}
+	writeJSON(w, ro)
+}
@@ -68,18 +68,19 @@ func (a *API) handleGetSMTPSettings(w http.ResponseWriter, r *http.Request) {
 
 	// In a real implementation, these settings would be fetched from the tenant's configuration	// For now, we'll return the global SMTP settings if any
 	var response SMTPSettingsResponse
-	if a.config.Auth.SMTPEnabled {
+	cfg := a.currentConfig()
+	if cfg.Auth.SMTPEnabled {
 		response = SMTPSettingsResponse{
-			Enabled:  a.config.Auth.SMTPEnabled,
-			Host:     a.config.Auth.SMTPConfig.Host,
-			Port:     a.config.Auth.SMTPConfig.Port,
-			Username: a.config.Auth.SMTPConfig.Username,
+			Enabled:  cfg.Auth.SMTPEnabled,
+			Host:     cfg.Auth.SMTPConfig.Host,
+			Port:     cfg.Auth.SMTPConfig.Port,
+			Username: cfg.Auth.SMTPConfig.Username,
 			// Don't return actual password, just indicate if one exists
 			Password:  "********",
-			FromEmail: a.config.Auth.SMTPConfig.FromEmail,
-			FromName:  a.config.Auth.SMTPConfig.FromName,
-			UseTLS:    a.config.Auth.SMTPConfig.UseTLS,
-			AppDomain: a.config.Auth.SMTPConfig.AppDomain,
+			FromEmail: cfg.Auth.SMTPConfig.FromEmail,
+			FromName:  cfg.Auth.SMTPConfig.FromName,
+			UseTLS:    cfg.Auth.SMTPConfig.UseTLS,
+			AppDomain: cfg.Auth.SMTPConfig.AppDomain,
 		}
 	}
 
@@ -125,20 +126,24 @@ func (a *API) handleUpdateSMTPSettings(w http.ResponseWriter, r *http.Request) {
 	}
 	// In a real implementation, this would update the tenant's configuration in Redis
 	// For now, we'll update the global SMTP settings
-	a.config.Auth.SMTPEnabled = req.Enabled
-	a.config.Auth.SMTPConfig.Host = req.Host
-	a.config.Auth.SMTPConfig.Port = req.Port
-	a.config.Auth.SMTPConfig.Username = req.Username
+	a.configMu.Lock()
+	next := *a.config
+	next.Auth.SMTPEnabled = req.Enabled
+	next.Auth.SMTPConfig.Host = req.Host
+	next.Auth.SMTPConfig.Port = req.Port
+	next.Auth.SMTPConfig.Username = req.Username
 	if req.Password != "" && req.Password != "********" {
-		a.config.Auth.SMTPConfig.Password = req.Password
-	}
-	a.config.Auth.SMTPConfig.FromEmail = req.FromEmail
-	a.config.Auth.SMTPConfig.FromName = req.FromName
-	a.config.Auth.SMTPConfig.UseTLS = req.UseTLS
-	a.config.Auth.SMTPConfig.AppDomain = req.AppDomain
+		next.Auth.SMTPConfig.Password = req.Password
+	}
+	next.Auth.SMTPConfig.FromEmail = req.FromEmail
+	next.Auth.SMTPConfig.FromName = req.FromName
+	next.Auth.SMTPConfig.UseTLS = req.UseTLS
+	next.Auth.SMTPConfig.AppDomain = req.AppDomain
+	a.config = &next
+	a.configMu.Unlock()
 	// If SMTP is enabled, reinitialize the email provider
-	if a.config.Auth.SMTPEnabled {
-		authSMTPConfig := convertConfigSMTPToAuthSMTP(a.config.Auth.SMTPConfig)
+	if next.Auth.SMTPEnabled {
+		authSMTPConfig := convertConfigSMTPToAuthSMTP(next.Auth.SMTPConfig)
 		a.authenticator.UpdateEmailProvider(auth.NewEmailProvider(authSMTPConfig, a.logger))
 	}
 
@@ -194,7 +199,7 @@ func (a *API) handleTestSMTP(w http.ResponseWriter, r *http.Request) {
 
 	// If password not provided in test config, use existing password
 	if testConfig.Password == "" || testConfig.Password == "********" {
-		testConfig.Password = a.config.Auth.SMTPConfig.Password
+		testConfig.Password = a.currentConfig().Auth.SMTPConfig.Password
 	}
 
 	emailProvider := auth.NewEmailProvider(testConfig, a.logger)
@@ -0,0 +1,314 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/clustering"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/router"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// SplitController changes how the requests of a route are split between pools
+type SplitController interface {
+	SplitWeights(routeID string) ([]config.SplitVariant, bool)
+	SetSplitWeights(routeID string, weights map[string]int) error
+}
+
+// SplitRequest sets the weights of the pool variants of a route. With Step
+// and Interval set, weights move towards their targets by at most Step every
+// Interval instead of at once.
+type SplitRequest struct {
+	Weights  map[string]int `json:"weights"`
+	Step     int            `json:"step,omitempty"`
+	Interval string         `json:"interval,omitempty"`
+}
+
+// SplitStatus describes the current split of a route
+type SplitStatus struct {
+	Route    string         `json:"route"`
+	Weights  []SplitWeight  `json:"weights"`
+	Target   map[string]int `json:"target,omitempty"` // set while weights are being shifted
+	Step     int            `json:"step,omitempty"`
+	Interval string         `json:"interval,omitempty"`
+}
+
+// SplitWeight is the weight of one pool variant
+type SplitWeight struct {
+	Pool   string `json:"pool"`
+	Weight int    `json:"weight"`
+}
+
+// splitShift is a gradual weight change in progress
+type splitShift struct {
+	target   map[string]int
+	step     int
+	interval time.Duration
+	cancel   context.CancelFunc
+}
+
+// SetSplitController sets the component applying traffic split changes
+func (a *API) SetSplitController(c SplitController) {
+	a.splits = c
+}
+
+func (a *API) splitStatus(id string, variants []config.SplitVariant) SplitStatus {
+	status := SplitStatus{Route: id, Weights: make([]SplitWeight, len(variants))}
+	for i, variant := range variants {
+		status.Weights[i] = SplitWeight{Pool: variant.Pool, Weight: variant.Weight}
+	}
+
+	a.shiftMu.Lock()
+	if shift, ok := a.shifts[id]; ok {
+		status.Target = shift.target
+		status.Step = shift.step
+		status.Interval = shift.interval.String()
+	}
+	a.shiftMu.Unlock()
+	return status
+}
+
+func (a *API) handleGetSplit(w http.ResponseWriter, r *http.Request) {
+	if a.splits == nil {
+		writeError(w, "Traffic splitting not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	variants, ok := a.splits.SplitWeights(id)
+	if !ok {
+		writeError(w, "Route not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, a.splitStatus(id, variants))
+}
+
+func (a *API) handleUpdateSplit(w http.ResponseWriter, r *http.Request) {
+	// Only leader can modify configuration
+	if a.cluster != nil && !a.cluster.IsLeader() {
+		writeError(w, "Operation only allowed on leader node", http.StatusForbidden)
+		return
+	}
+	if a.splits == nil {
+		writeError(w, "Traffic splitting not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if _, ok := a.splits.SplitWeights(id); !ok {
+		writeError(w, "Route not found", http.StatusNotFound)
+		return
+	}
+
+	var req SplitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if len(req.Weights) == 0 {
+		writeError(w, "Weights are required", http.StatusBadRequest)
+		return
+	}
+	for pool, weight := range req.Weights {
+		if weight < 0 {
+			writeError(w, "Weights must not be negative", http.StatusBadRequest)
+			return
+		}
+		if a.balancer.GetPool(pool) == nil {
+			writeError(w, "Referenced pool does not exist", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var interval time.Duration
+	if req.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(req.Interval); err != nil || interval <= 0 {
+			writeError(w, "Invalid interval", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Step < 0 || (req.Step > 0) != (interval > 0) {
+		writeError(w, "Step and interval must be set together", http.StatusBadRequest)
+		return
+	}
+
+	// A new request replaces any shift in progress
+	a.cancelSplitShift(id)
+
+	if req.Step == 0 {
+		if err := a.applySplit(id, req.Weights); err != nil {
+			writeSplitError(w, err)
+			return
+		}
+		variants, _ := a.splits.SplitWeights(id)
+		writeJSON(w, a.splitStatus(id, variants))
+		return
+	}
+
+	// Take the first step right away so invalid targets are reported
+	done, err := a.stepSplit(id, req.Weights, req.Step)
+	if err != nil {
+		writeSplitError(w, err)
+		return
+	}
+	var ctx context.Context
+	var shift *splitShift
+	if !done {
+		shift = &splitShift{target: req.Weights, step: req.Step, interval: interval}
+		ctx, shift.cancel = context.WithCancel(context.Background())
+		a.shiftMu.Lock()
+		if a.shifts == nil {
+			a.shifts = make(map[string]*splitShift)
+		}
+		a.shifts[id] = shift
+		a.shiftMu.Unlock()
+	}
+
+	variants, _ := a.splits.SplitWeights(id)
+	status := a.splitStatus(id, variants)
+	if shift != nil {
+		go a.runSplitShift(ctx, id, shift)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, status)
+}
+
+func writeSplitError(w http.ResponseWriter, err error) {
+	if errors.Is(err, router.ErrRouteNotFound) {
+		writeError(w, "Route not found", http.StatusNotFound)
+		return
+	}
+	writeError(w, err.Error(), http.StatusBadRequest)
+}
+
+// applySplit sets the weights of a route's variants, records them in the
+// route configuration and syncs them to the cluster.
+func (a *API) applySplit(id string, weights map[string]int) error {
+	if err := a.splits.SetSplitWeights(id, weights); err != nil {
+		return err
+	}
+	variants, _ := a.splits.SplitWeights(id)
+
+	a.configMu.Lock()
+	i := a.findRoute(id)
+	var route config.Route
+	if i >= 0 {
+		route = a.config.Routes[i]
+		route.Split.Variants = slices.Clone(route.Split.Variants)
+		for _, variant := range variants {
+			route.Split.Variants = mergeVariant(route.Split.Variants, variant)
+		}
+		routes := slices.Clone(a.config.Routes)
+		routes[i] = route
+		a.setRoutes(routes)
+	}
+	a.configMu.Unlock()
+
+	a.logger.Info("Traffic split updated", zap.String("route", id), zap.Any("weights", weights))
+
+	// Sync to cluster if enabled
+	if i >= 0 && a.cluster != nil {
+		data, _ := json.Marshal(route)
+		a.cluster.PublishState(clustering.StateRoute, id, data)
+	}
+	return nil
+}
+
+// mergeVariant updates the weight of a pool in configured variants, keeping
+// their overrides, or appends it
+func mergeVariant(variants []config.SplitVariant, variant config.SplitVariant) []config.SplitVariant {
+	for i := range variants {
+		if variants[i].Pool == variant.Pool {
+			variants[i].Weight = variant.Weight
+			return variants
+		}
+	}
+	return append(variants, variant)
+}
+
+// stepSplit moves the weights of a route towards target by at most step and
+// reports whether the target was reached
+func (a *API) stepSplit(id string, target map[string]int, step int) (bool, error) {
+	variants, ok := a.splits.SplitWeights(id)
+	if !ok {
+		return false, router.ErrRouteNotFound
+	}
+	current := make(map[string]int, len(variants))
+	for _, variant := range variants {
+		current[variant.Pool] = variant.Weight
+	}
+
+	next := make(map[string]int, len(target))
+	done := true
+	for pool, weight := range target {
+		from := current[pool]
+		switch {
+		case weight > from+step:
+			next[pool] = from + step
+			done = false
+		case weight < from-step:
+			next[pool] = from - step
+			done = false
+		default:
+			next[pool] = weight
+		}
+	}
+	return done, a.applySplit(id, next)
+}
+
+func (a *API) runSplitShift(ctx context.Context, id string, shift *splitShift) {
+	ticker := time.NewTicker(shift.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		done, err := a.stepSplit(id, shift.target, shift.step)
+		if err != nil {
+			a.logger.Error("Traffic split shift aborted", zap.Error(err), zap.String("route", id))
+		}
+		if err != nil || done {
+			a.shiftMu.Lock()
+			if a.shifts[id] == shift {
+				delete(a.shifts, id)
+			}
+			a.shiftMu.Unlock()
+			return
+		}
+	}
+}
+
+func (a *API) cancelSplitShift(id string) {
+	a.shiftMu.Lock()
+	defer a.shiftMu.Unlock()
+	if shift, ok := a.shifts[id]; ok {
+		shift.cancel()
+		delete(a.shifts, id)
+	}
+}
+
+// syncSplit applies the split weights of a route received from the cluster
+func (a *API) syncSplit(route config.Route) {
+	if a.splits == nil || len(route.Split.Variants) == 0 {
+		return
+	}
+	weights := make(map[string]int, len(route.Split.Variants))
+	for _, variant := range route.Split.Variants {
+		weights[variant.Pool] = variant.Weight
+	}
+	if err := a.splits.SetSplitWeights(route.ID, weights); err != nil && !errors.Is(err, router.ErrRouteNotFound) {
+		a.logger.Warn("Failed to apply traffic split from cluster", zap.Error(err), zap.String("route", route.ID))
+	}
+}
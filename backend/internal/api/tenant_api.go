@@ -24,6 +24,7 @@ type TenantAPI struct {
 	router        *mux.Router
 	balancer      *balancer.Balancer
 	cluster       *clustering.Cluster
+	domains       DomainRegistry
 }
 
 // NewTenantAPI creates a new tenant API
@@ -44,6 +45,12 @@ func NewTenantAPI(cfg *config.Config, balancer *balancer.Balancer, tenantManager
 	return api
 }
 
+// SetDomainRegistry sets the registry the custom domains of tenants are kept
+// in, so requests to them reach the tenant
+func (api *TenantAPI) SetDomainRegistry(r DomainRegistry) {
+	api.domains = r
+}
+
 // setupRoutes configures the API routes
 func (api *TenantAPI) setupRoutes() {
 	// Public endpoints (no authentication required)
@@ -360,7 +367,18 @@ func (api *TenantAPI) handleCreateTenant(w http.ResponseWriter, r *http.Request)
 	}
 
 	ctx := r.Context()
+	var added []string
+	if api.domains != nil && t.CustomDomain != "" {
+		var err error
+		if added, err = registerDomains(ctx, api.domains, t.ID, []string{t.CustomDomain}); err != nil {
+			writeDomainError(w, api.logger, t.ID, err)
+			return
+		}
+	}
 	if err := api.tenantManager.CreateTenant(ctx, t); err != nil {
+		if api.domains != nil {
+			removeDomains(ctx, api.domains, t.ID, added)
+		}
 		api.logger.Error("Failed to create tenant", zap.Error(err))
 		writeError(w, "Failed to create tenant", http.StatusInternalServerError)
 		return
@@ -412,6 +430,7 @@ func (api *TenantAPI) handleUpdateTenant(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Update fields
+	previousDomain := t.CustomDomain
 	t.Name = req.Name
 	t.Plan = req.Plan
 	t.Active = req.Active
@@ -423,11 +442,29 @@ func (api *TenantAPI) handleUpdateTenant(w http.ResponseWriter, r *http.Request)
 		t.Limits = req.Limits
 	}
 
+	// A new custom domain replaces the previous one once the tenant is saved
+	syncDomain := api.domains != nil && t.CustomDomain != previousDomain
+	var added []string
+	if syncDomain {
+		if added, err = registerDomains(ctx, api.domains, t.ID, []string{t.CustomDomain}); err != nil {
+			writeDomainError(w, api.logger, t.ID, err)
+			return
+		}
+	}
+
 	if err := api.tenantManager.UpdateTenant(ctx, t); err != nil {
+		if syncDomain {
+			removeDomains(ctx, api.domains, t.ID, added)
+		}
 		api.logger.Error("Failed to update tenant", zap.Error(err))
 		writeError(w, "Failed to update tenant", http.StatusInternalServerError)
 		return
 	}
+	if syncDomain && previousDomain != "" {
+		if err := removeDomains(ctx, api.domains, t.ID, []string{previousDomain}); err != nil {
+			api.logger.Error("Failed to remove custom domain", zap.String("tenant_id", t.ID), zap.Error(err))
+		}
+	}
 
 	writeJSON(w, t)
 }
@@ -443,6 +480,17 @@ func (api *TenantAPI) handleDeleteTenant(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Requests to the custom domains of a deleted tenant are no longer routed
+	if api.domains != nil {
+		names, err := api.domains.Domains(ctx, tenantID)
+		if err == nil {
+			err = removeDomains(ctx, api.domains, tenantID, names)
+		}
+		if err != nil {
+			api.logger.Error("Failed to remove custom domains", zap.String("tenant_id", tenantID), zap.Error(err))
+		}
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -756,6 +804,9 @@ func (api *TenantAPI) handleUpdateTenantPool(w http.ResponseWriter, r *http.Requ
 		Name:           fullPoolName,
 		Algorithm:      poolReq.Algorithm,
 		StickySessions: poolReq.StickySessions,
+		HashPolicy:     existingPool.HashPolicy,
+		SlowStart:      existingPool.SlowStart,
+		Sticky:         existingPool.Sticky,
 		Backends:       existingPool.Backends,
 	}
 
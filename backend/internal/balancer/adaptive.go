@@ -8,11 +8,11 @@ import (
 	"fmt"
 	"net"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/eltonciatto/veloflux/internal/ai"
+	"github.com/eltonciatto/veloflux/internal/clientip"
 	"github.com/eltonciatto/veloflux/internal/config"
 	"go.uber.org/zap"
 )
@@ -749,25 +749,10 @@ func (ab *AdaptiveBalancer) RecordRequestMetrics(requestRate, responseTime, erro
 	ab.metricsCollector.mu.Unlock()
 }
 
-// extractClientIP extrai IP do cliente da requisição
+// extractClientIP retorna o IP do cliente resolvido pelo router, que só
+// confia nos cabeçalhos de encaminhamento enviados por proxies confiáveis
 func (ab *AdaptiveBalancer) extractClientIP(r *http.Request) net.IP {
-	// Tentar X-Forwarded-For primeiro
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		if ip := net.ParseIP(strings.Split(xff, ",")[0]); ip != nil {
-			return ip
-		}
-	}
-	
-	// Tentar X-Real-IP
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		if ip := net.ParseIP(xri); ip != nil {
-			return ip
-		}
-	}
-	
-	// Usar RemoteAddr como fallback
-	host, _, _ := net.SplitHostPort(r.RemoteAddr)
-	if ip := net.ParseIP(host); ip != nil {
+	if ip := clientip.FromRequest(r); ip != nil {
 		return ip
 	}
 	
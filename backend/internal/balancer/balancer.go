@@ -26,6 +26,9 @@ const (
 	IPHash             Algorithm = "ip_hash"
 	WeightedRoundRobin Algorithm = "weighted_round_robin"
 	GeoProximity       Algorithm = "geo_proximity"
+	RingHash           Algorithm = "ring_hash"
+	Maglev             Algorithm = "maglev"
+	PowerOfTwoChoices  Algorithm = "p2c"
 )
 
 type Backend struct {
@@ -36,6 +39,9 @@ type Backend struct {
 	LastUsed    atomic.Int64
 	Config      config.Backend
 	Region      string // Region for geo-routing
+
+	currentWeight int64        // smooth weighted round robin state, guarded by Pool.wrrMu
+	rampStart     atomic.Int64 // unix nanos when the backend was added or recovered
 }
 
 type Pool struct {
@@ -45,20 +51,34 @@ type Pool struct {
 	mu             sync.RWMutex
 	counter        atomic.Uint64
 	StickySessions bool
+	HashPolicy     config.HashPolicy
+	SlowStart      config.SlowStart
+	Sticky         config.StickyConfig
+
+	affinity *affinityLRU // session bindings of sticky sessions
+
+	wrrMu sync.Mutex // serializes smooth weighted round robin picks
+
+	generation atomic.Uint64 // bumped whenever the set of healthy backends changes
+	hashMu     sync.Mutex
+	hashTables *hashTables
 }
 
 type Balancer struct {
 	pools      map[string]*Pool
 	mu         sync.RWMutex
 	geoManager *geo.Manager
-	stickyMu   sync.RWMutex
-	stickyMap  map[string]string // sessionID -> backend address
+
+	stickyMu      sync.RWMutex
+	affinityStore AffinityStore
+
+	listenersMu sync.RWMutex
+	listeners   []func(MembershipEvent)
 }
 
 func New() *Balancer {
 	return &Balancer{
-		pools:     make(map[string]*Pool),
-		stickyMap: make(map[string]string),
+		pools: make(map[string]*Pool),
 	}
 }
 
@@ -69,44 +89,135 @@ func (b *Balancer) SetGeoManager(gm *geo.Manager) {
 
 func (b *Balancer) AddPool(poolConfig config.Pool) {
 	b.mu.Lock()
-	defer b.mu.Unlock()
+	old := poolBackendConfigs(b.pools[poolConfig.Name])
+	b.pools[poolConfig.Name] = newPool(poolConfig)
+	b.mu.Unlock()
 
+	b.notify(membershipDiff(poolConfig.Name, old, poolConfig.Backends))
+}
+
+func newPool(poolConfig config.Pool) *Pool {
 	backends := make([]*Backend, len(poolConfig.Backends))
 	for i, backendConfig := range poolConfig.Backends {
-		backend := &Backend{
-			Address: backendConfig.Address,
-			Weight:  backendConfig.Weight,
-			Config:  backendConfig,
-		}
-		backend.Healthy.Store(true)
-		backends[i] = backend
+		backends[i] = newBackend(backendConfig)
 	}
 
-	pool := &Pool{
+	return &Pool{
 		Name:           poolConfig.Name,
 		Algorithm:      Algorithm(poolConfig.Algorithm),
 		Backends:       backends,
 		StickySessions: poolConfig.StickySessions,
+		HashPolicy:     poolConfig.HashPolicy,
+		SlowStart:      poolConfig.SlowStart,
+		Sticky:         poolConfig.Sticky,
+		affinity:       newAffinityLRU(poolConfig.Sticky.MaxEntries),
+	}
+}
+
+func newBackend(cfg config.Backend) *Backend {
+	backend := &Backend{
+		Address: cfg.Address,
+		Weight:  cfg.Weight,
+		Config:  cfg,
+	}
+
+	// Set as healthy by default
+	backend.Healthy.Store(true)
+	return backend
+}
+
+// ReloadPools reconciles the running pools with a freshly loaded configuration.
+// New pools and backends are added, the ones no longer configured are removed,
+// and backends that keep their address retain their health state and
+// connection counters so in-flight requests are accounted for correctly.
+func (b *Balancer) ReloadPools(pools []config.Pool) {
+	b.mu.Lock()
+
+	var events []MembershipEvent
+	wanted := make(map[string]bool, len(pools))
+	for _, poolConfig := range pools {
+		wanted[poolConfig.Name] = true
+
+		pool, exists := b.pools[poolConfig.Name]
+		events = append(events, membershipDiff(poolConfig.Name, poolBackendConfigs(pool), poolConfig.Backends)...)
+		if !exists {
+			b.pools[poolConfig.Name] = newPool(poolConfig)
+			continue
+		}
+
+		pool.mu.Lock()
+		current := make(map[string]*Backend, len(pool.Backends))
+		for _, backend := range pool.Backends {
+			current[backend.Address] = backend
+		}
+
+		backends := make([]*Backend, 0, len(poolConfig.Backends))
+		for _, backendConfig := range poolConfig.Backends {
+			backend, ok := current[backendConfig.Address]
+			if !ok {
+				backend = newBackend(backendConfig)
+				backend.startSlowStart()
+			} else {
+				backend.Weight = backendConfig.Weight
+				backend.Config = backendConfig
+			}
+			backends = append(backends, backend)
+		}
+
+		pool.Backends = backends
+		pool.Algorithm = Algorithm(poolConfig.Algorithm)
+		pool.StickySessions = poolConfig.StickySessions
+		pool.HashPolicy = poolConfig.HashPolicy
+		pool.SlowStart = poolConfig.SlowStart
+		pool.Sticky = poolConfig.Sticky
+		pool.affinity.resize(poolConfig.Sticky.MaxEntries)
+		pool.invalidate()
+		pool.mu.Unlock()
+	}
+
+	for name, pool := range b.pools {
+		if !wanted[name] {
+			events = append(events, membershipDiff(name, poolBackendConfigs(pool), nil)...)
+			delete(b.pools, name)
+		}
 	}
+	b.mu.Unlock()
 
-	b.pools[poolConfig.Name] = pool
+	b.notify(events)
 }
 
+// GetBackend picks a backend of a pool for a request. Picking does not count
+// a connection: callers that send traffic to the backend count it with
+// IncrementConnections and DecrementConnections, so picks they discard do not
+// weigh on least_conn and p2c.
 func (b *Balancer) GetBackend(poolName string, clientIP net.IP, sessionID string, r *http.Request) (*Backend, error) {
 	b.mu.RLock()
-	defer b.mu.RUnlock()
-
 	pool, exists := b.pools[poolName]
+	b.mu.RUnlock()
+
 	if !exists {
 		return nil, fmt.Errorf("pool not found: %s", poolName)
 	}
 
+	// The shared affinity store is only used while the pool is unlocked
+	shared, sharedAddress := b.sharedBinding(pool, sessionID)
+	backend, syncShared, err := b.pickBackend(pool, clientIP, sessionID, r, shared, sharedAddress)
+	if syncShared != nil {
+		syncShared()
+	}
+	return backend, err
+}
+
+// pickBackend selects a backend of a locked pool. Changes to the shared
+// affinity store are returned for the caller to make once it is unlocked.
+func (b *Balancer) pickBackend(pool *Pool, clientIP net.IP, sessionID string, r *http.Request, shared AffinityStore, sharedAddress string) (*Backend, func(), error) {
+	poolName := pool.Name
 	pool.mu.RLock()
 	defer pool.mu.RUnlock()
 
 	// Check if there are any backends
 	if len(pool.Backends) == 0 {
-		return nil, fmt.Errorf("no backends available in pool: %s", poolName)
+		return nil, nil, fmt.Errorf("no backends available in pool: %s", poolName)
 	}
 
 	// Get only healthy backends
@@ -119,14 +230,16 @@ func (b *Balancer) GetBackend(poolName string, clientIP net.IP, sessionID string
 
 	// If no healthy backends, return error
 	if len(healthyBackends) == 0 {
-		return nil, fmt.Errorf("no healthy backends in pool: %s", poolName)
+		return nil, nil, fmt.Errorf("no healthy backends in pool: %s", poolName)
 	}
 
 	// Handle sticky sessions if enabled
+	var syncShared func()
 	if pool.StickySessions && sessionID != "" {
-		backend := b.getStickyBackend(pool, sessionID, healthyBackends)
+		var backend *Backend
+		backend, syncShared = b.getStickyBackend(pool, sessionID, shared, sharedAddress, healthyBackends)
 		if backend != nil {
-			return backend, nil
+			return backend, syncShared, nil
 		}
 	}
 
@@ -137,28 +250,32 @@ func (b *Balancer) GetBackend(poolName string, clientIP net.IP, sessionID string
 	case RoundRobin:
 		backend = b.getRoundRobinBackend(pool, healthyBackends)
 	case LeastConn:
-		backend = b.getLeastConnBackend(healthyBackends)
+		backend = b.getLeastConnBackend(pool, healthyBackends)
+	case PowerOfTwoChoices:
+		backend = pool.powerOfTwoChoices(healthyBackends)
 	case IPHash:
 		backend = b.getIPHashBackend(clientIP, healthyBackends)
 	case WeightedRoundRobin:
 		backend = b.getWeightedRoundRobinBackend(pool, healthyBackends)
 	case GeoProximity:
 		backend = b.getGeoProximityBackend(r, healthyBackends)
+	case RingHash:
+		backend = b.getRingHashBackend(pool, hashKey(pool.HashPolicy, clientIP, r), healthyBackends)
+	case Maglev:
+		backend = b.getMaglevBackend(pool, hashKey(pool.HashPolicy, clientIP, r), healthyBackends)
 	default:
 		// Default to round robin
 		backend = b.getRoundRobinBackend(pool, healthyBackends)
 	}
 
-	// Record sticky mapping if needed
+	// Record sticky mapping if needed; it supersedes a failed over binding
 	if pool.StickySessions && sessionID != "" {
-		b.setStickyBackend(sessionID, backend.Address)
+		syncShared = b.setStickyBackend(pool, sessionID, backend.Address, shared)
 	}
 
-	// Increment connections count
-	backend.Connections.Add(1)
 	backend.LastUsed.Store(time.Now().UnixNano())
 
-	return backend, nil
+	return backend, syncShared, nil
 }
 
 func (p *Pool) selectBackend(clientIP net.IP, sessionID string) (*Backend, error) {
@@ -181,34 +298,29 @@ func (p *Pool) selectBackend(clientIP net.IP, sessionID string) (*Backend, error
 		return p.roundRobin(healthyBackends), nil
 	case LeastConn:
 		return p.leastConnections(healthyBackends), nil
+	case PowerOfTwoChoices:
+		return p.powerOfTwoChoices(healthyBackends), nil
 	case IPHash:
 		return p.ipHash(healthyBackends, clientIP), nil
 	case WeightedRoundRobin:
 		return p.weightedRoundRobin(healthyBackends), nil
+	case RingHash:
+		return p.hashTablesFor(healthyBackends).ring.get(hashKey(p.HashPolicy, clientIP, nil)), nil
+	case Maglev:
+		return p.hashTablesFor(healthyBackends).maglev.get(hashKey(p.HashPolicy, clientIP, nil)), nil
 	default:
 		return p.roundRobin(healthyBackends), nil
 	}
 }
 
 func (p *Pool) roundRobin(backends []*Backend) *Backend {
-	index := p.counter.Add(1) % uint64(len(backends))
-	backend := backends[index]
+	backend := p.nextRoundRobin(backends)
 	backend.LastUsed.Store(time.Now().Unix())
 	return backend
 }
 
 func (p *Pool) leastConnections(backends []*Backend) *Backend {
-	var selected *Backend
-	minConns := int64(^uint64(0) >> 1) // max int64
-
-	for _, backend := range backends {
-		conns := backend.Connections.Load()
-		if conns < minConns {
-			minConns = conns
-			selected = backend
-		}
-	}
-
+	selected := p.leastLoaded(backends)
 	selected.LastUsed.Store(time.Now().Unix())
 	return selected
 }
@@ -222,33 +334,9 @@ func (p *Pool) ipHash(backends []*Backend, clientIP net.IP) *Backend {
 }
 
 func (p *Pool) weightedRoundRobin(backends []*Backend) *Backend {
-	totalWeight := 0
-	for _, backend := range backends {
-		if backend.Weight > 0 {
-			totalWeight += backend.Weight
-		} else {
-			totalWeight += 1
-		}
-	}
-
-	if totalWeight == 0 {
-		return p.roundRobin(backends)
-	}
-
-	r := rand.Intn(totalWeight)
-	for _, backend := range backends {
-		weight := backend.Weight
-		if weight <= 0 {
-			weight = 1
-		}
-		r -= weight
-		if r < 0 {
-			backend.LastUsed.Store(time.Now().Unix())
-			return backend
-		}
-	}
-
-	return backends[0]
+	backend := p.weightedPick(backends)
+	backend.LastUsed.Store(time.Now().Unix())
+	return backend
 }
 
 func (b *Balancer) UpdateBackendHealth(poolName, backendAddress string, healthy bool) {
@@ -265,7 +353,12 @@ func (b *Balancer) UpdateBackendHealth(poolName, backendAddress string, healthy
 
 	for _, backend := range pool.Backends {
 		if backend.Address == backendAddress {
-			backend.Healthy.Store(healthy)
+			if backend.Healthy.Swap(healthy) != healthy {
+				if healthy {
+					backend.startSlowStart()
+				}
+				pool.invalidate()
+			}
 			break
 		}
 	}
@@ -311,51 +404,14 @@ func (b *Balancer) DecrementConnections(poolName, backendAddress string) {
 	}
 }
 
-func (b *Balancer) getStickyBackend(pool *Pool, sessionID string, healthyBackends []*Backend) *Backend {
-	b.stickyMu.RLock()
-	addr, ok := b.stickyMap[sessionID]
-	b.stickyMu.RUnlock()
-	if !ok {
-		return nil
-	}
-
-	for _, backend := range healthyBackends {
-		if backend.Address == addr {
-			return backend
-		}
-	}
-
-	b.stickyMu.Lock()
-	delete(b.stickyMap, sessionID)
-	b.stickyMu.Unlock()
-	return nil
-}
-
-func (b *Balancer) setStickyBackend(sessionID, address string) {
-	b.stickyMu.Lock()
-	b.stickyMap[sessionID] = address
-	b.stickyMu.Unlock()
-}
-
 func (b *Balancer) getRoundRobinBackend(pool *Pool, healthyBackends []*Backend) *Backend {
-	index := pool.counter.Add(1) % uint64(len(healthyBackends))
-	backend := healthyBackends[index]
+	backend := pool.nextRoundRobin(healthyBackends)
 	backend.LastUsed.Store(time.Now().UnixNano())
 	return backend
 }
 
-func (b *Balancer) getLeastConnBackend(healthyBackends []*Backend) *Backend {
-	var selected *Backend
-	minConns := int64(^uint64(0) >> 1) // max int64
-
-	for _, backend := range healthyBackends {
-		conns := backend.Connections.Load()
-		if conns < minConns {
-			minConns = conns
-			selected = backend
-		}
-	}
-
+func (b *Balancer) getLeastConnBackend(pool *Pool, healthyBackends []*Backend) *Backend {
+	selected := pool.leastLoaded(healthyBackends)
 	selected.LastUsed.Store(time.Now().UnixNano())
 	return selected
 }
@@ -369,33 +425,9 @@ func (b *Balancer) getIPHashBackend(clientIP net.IP, healthyBackends []*Backend)
 }
 
 func (b *Balancer) getWeightedRoundRobinBackend(pool *Pool, healthyBackends []*Backend) *Backend {
-	totalWeight := 0
-	for _, backend := range healthyBackends {
-		if backend.Weight > 0 {
-			totalWeight += backend.Weight
-		} else {
-			totalWeight += 1
-		}
-	}
-
-	if totalWeight == 0 {
-		return b.getRoundRobinBackend(pool, healthyBackends)
-	}
-
-	r := rand.Intn(totalWeight)
-	for _, backend := range healthyBackends {
-		weight := backend.Weight
-		if weight <= 0 {
-			weight = 1
-		}
-		r -= weight
-		if r < 0 {
-			backend.LastUsed.Store(time.Now().UnixNano())
-			return backend
-		}
-	}
-
-	return healthyBackends[0]
+	backend := pool.weightedPick(healthyBackends)
+	backend.LastUsed.Store(time.Now().UnixNano())
+	return backend
 }
 
 func (b *Balancer) getGeoProximityBackend(r *http.Request, healthyBackends []*Backend) *Backend {
@@ -445,6 +477,9 @@ func (b *Balancer) GetPools() []config.Pool {
 			Name:           pool.Name,
 			Algorithm:      string(pool.Algorithm),
 			StickySessions: pool.StickySessions,
+			HashPolicy:     pool.HashPolicy,
+			SlowStart:      pool.SlowStart,
+			Sticky:         pool.Sticky,
 			Backends:       backends,
 		})
 	}
@@ -471,6 +506,9 @@ func (b *Balancer) GetPool(name string) *config.Pool {
 		Name:           pool.Name,
 		Algorithm:      string(pool.Algorithm),
 		StickySessions: pool.StickySessions,
+		HashPolicy:     pool.HashPolicy,
+		SlowStart:      pool.SlowStart,
+		Sticky:         pool.Sticky,
 		Backends:       backends,
 	}
 }
@@ -486,69 +524,96 @@ func (b *Balancer) UpdatePool(cfg config.Pool) {
 	}
 
 	// Update properties that can change
+	pool.mu.Lock()
 	pool.Algorithm = Algorithm(cfg.Algorithm)
 	pool.StickySessions = cfg.StickySessions
+	pool.HashPolicy = cfg.HashPolicy
+	pool.SlowStart = cfg.SlowStart
+	pool.Sticky = cfg.Sticky
+	pool.affinity.resize(cfg.Sticky.MaxEntries)
+	pool.invalidate()
+	pool.mu.Unlock()
 }
 
 // RemovePool removes a pool
 func (b *Balancer) RemovePool(name string) {
 	b.mu.Lock()
-	defer b.mu.Unlock()
-
+	old := poolBackendConfigs(b.pools[name])
 	delete(b.pools, name)
+	b.mu.Unlock()
+
+	b.notify(membershipDiff(name, old, nil))
 }
 
 // AddBackend adds a backend to a pool
 func (b *Balancer) AddBackend(poolName string, cfg config.Backend) {
 	b.mu.Lock()
-	defer b.mu.Unlock()
 
 	pool, exists := b.pools[poolName]
 	if !exists {
+		b.mu.Unlock()
 		return
 	}
 
+	old := poolBackendConfigs(pool)
+
+	pool.mu.Lock()
+	updated := false
 	// Check if backend already exists
 	for _, backend := range pool.Backends {
 		if backend.Address == cfg.Address {
 			// Update existing backend
 			backend.Weight = cfg.Weight
 			backend.Config = cfg
-			return
+			updated = true
+			break
 		}
 	}
 
-	// Add new backend
-	backend := &Backend{
-		Address: cfg.Address,
-		Weight:  cfg.Weight,
-		Config:  cfg,
+	if !updated {
+		// Add new backend, ramping its traffic up if slow start is enabled
+		backend := newBackend(cfg)
+		backend.startSlowStart()
+		pool.Backends = append(pool.Backends, backend)
 	}
+	pool.invalidate()
+	pool.mu.Unlock()
+	current := poolBackendConfigs(pool)
+	b.mu.Unlock()
 
-	// Set as healthy by default
-	backend.Healthy.Store(true)
-
-	pool.Backends = append(pool.Backends, backend)
+	b.notify(membershipDiff(poolName, old, current))
 }
 
 // RemoveBackend removes a backend from a pool
 func (b *Balancer) RemoveBackend(poolName, address string) error {
 	b.mu.Lock()
-	defer b.mu.Unlock()
 
 	pool, exists := b.pools[poolName]
 	if !exists {
+		b.mu.Unlock()
 		return fmt.Errorf("pool not found: %s", poolName)
 	}
 
 	// Find and remove backend
+	pool.mu.Lock()
 	for i, backend := range pool.Backends {
 		if backend.Address == address {
-			// Remove this backend
-			pool.Backends = append(pool.Backends[:i], pool.Backends[i+1:]...)
+			// Remove this backend without sharing the backing array with
+			// slices handed out to readers
+			removed := backend.Config
+			backends := make([]*Backend, 0, len(pool.Backends)-1)
+			backends = append(backends, pool.Backends[:i]...)
+			pool.Backends = append(backends, pool.Backends[i+1:]...)
+			pool.invalidate()
+			pool.mu.Unlock()
+			b.mu.Unlock()
+
+			b.notify([]MembershipEvent{{Type: BackendRemoved, Pool: poolName, Backend: removed}})
 			return nil
 		}
 	}
+	pool.mu.Unlock()
+	b.mu.Unlock()
 
 	return fmt.Errorf("backend not found: %s", address)
 }
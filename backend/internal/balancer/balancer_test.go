@@ -234,3 +234,110 @@ func TestWeightedRoundRobin(t *testing.T) {
 		t.Errorf("Weight distribution incorrect: ratio %.2f, expected ~2.0", ratio)
 	}
 }
+
+func TestReloadPools(t *testing.T) {
+	b := New()
+	b.AddPool(config.Pool{
+		Name:      "web",
+		Algorithm: "round_robin",
+		Backends: []config.Backend{
+			{Address: "1.1.1.1:80", Weight: 100},
+			{Address: "2.2.2.2:80", Weight: 100},
+		},
+	})
+	b.AddPool(config.Pool{Name: "old", Algorithm: "round_robin"})
+
+	b.UpdateBackendHealth("web", "2.2.2.2:80", false)
+	b.IncrementConnections("web", "1.1.1.1:80")
+
+	b.ReloadPools([]config.Pool{
+		{
+			Name:      "web",
+			Algorithm: "least_conn",
+			Backends: []config.Backend{
+				{Address: "1.1.1.1:80", Weight: 50},
+				{Address: "2.2.2.2:80", Weight: 100},
+				{Address: "3.3.3.3:80", Weight: 100},
+			},
+		},
+		{Name: "api", Algorithm: "ip_hash"},
+	})
+
+	if b.GetPool("old") != nil {
+		t.Error("Expected removed pool to be deleted")
+	}
+	if b.GetPool("api") == nil {
+		t.Error("Expected new pool to be added")
+	}
+	if got := b.GetAlgorithm("web"); got != "least_conn" {
+		t.Errorf("Expected algorithm least_conn, got %s", got)
+	}
+
+	state := make(map[string]*Backend)
+	for _, backend := range b.GetAllBackends()["web"] {
+		state[backend.Address] = backend
+	}
+	if len(state) != 3 {
+		t.Fatalf("Expected 3 backends after reload, got %d", len(state))
+	}
+	if state["1.1.1.1:80"].Connections.Load() != 1 {
+		t.Error("Expected connection counter to carry over")
+	}
+	if state["1.1.1.1:80"].Weight != 50 {
+		t.Errorf("Expected weight to be updated to 50, got %d", state["1.1.1.1:80"].Weight)
+	}
+	if state["2.2.2.2:80"].Healthy.Load() {
+		t.Error("Expected health state to carry over")
+	}
+	if !state["3.3.3.3:80"].Healthy.Load() {
+		t.Error("Expected new backend to start healthy")
+	}
+}
+
+func TestMembershipEvents(t *testing.T) {
+	b := New()
+	var events []MembershipEvent
+	b.Subscribe(func(event MembershipEvent) {
+		events = append(events, event)
+	})
+
+	b.AddPool(config.Pool{
+		Name:      "web",
+		Algorithm: "round_robin",
+		Backends:  []config.Backend{{Address: "1.1.1.1:80", Weight: 100}},
+	})
+	b.AddBackend("web", config.Backend{Address: "2.2.2.2:80", Weight: 100})
+	if err := b.RemoveBackend("web", "1.1.1.1:80"); err != nil {
+		t.Fatalf("Unexpected error removing backend: %v", err)
+	}
+	b.ReloadPools([]config.Pool{{
+		Name:      "web",
+		Algorithm: "round_robin",
+		Backends: []config.Backend{
+			{Address: "2.2.2.2:80", Weight: 50},
+			{Address: "3.3.3.3:80", Weight: 100},
+		},
+	}})
+	b.RemovePool("web")
+
+	expected := []struct {
+		typ     MembershipEventType
+		address string
+	}{
+		{BackendAdded, "1.1.1.1:80"},
+		{BackendAdded, "2.2.2.2:80"},
+		{BackendRemoved, "1.1.1.1:80"},
+		{BackendUpdated, "2.2.2.2:80"},
+		{BackendAdded, "3.3.3.3:80"},
+		{BackendRemoved, "2.2.2.2:80"},
+		{BackendRemoved, "3.3.3.3:80"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %+v", len(expected), len(events), events)
+	}
+	for i, want := range expected {
+		if events[i].Type != want.typ || events[i].Backend.Address != want.address || events[i].Pool != "web" {
+			t.Errorf("Event %d: expected %s %s, got %s %s", i, want.typ, want.address, events[i].Type, events[i].Backend.Address)
+		}
+	}
+}
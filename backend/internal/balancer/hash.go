@@ -0,0 +1,259 @@
+package balancer
+
+import (
+	"hash/fnv"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+)
+
+// Consistent hashing defaults
+const (
+	defaultVirtualNodes    = 160
+	defaultMaglevTableSize = 65537
+)
+
+// hashTables caches the lookup structures of the consistent hashing
+// algorithms for one generation of the pool's healthy backends.
+type hashTables struct {
+	generation uint64
+	ring       *hashRing
+	maglev     *maglevTable
+}
+
+// hashKey extracts the value a request is hashed on
+func hashKey(policy config.HashPolicy, clientIP net.IP, r *http.Request) string {
+	if r != nil {
+		switch policy.Source {
+		case config.HashSourceHeader:
+			if value := r.Header.Get(policy.Name); value != "" {
+				return value
+			}
+		case config.HashSourceCookie:
+			if cookie, err := r.Cookie(policy.Name); err == nil && cookie.Value != "" {
+				return cookie.Value
+			}
+		case config.HashSourcePath:
+			segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+			if policy.Segment >= 0 && policy.Segment < len(segments) && segments[policy.Segment] != "" {
+				return segments[policy.Segment]
+			}
+		}
+	}
+	if clientIP == nil {
+		return ""
+	}
+	return clientIP.String()
+}
+
+// hash64 hashes a string with FNV-1a followed by a finalizer that spreads
+// similar inputs, such as consecutive virtual node names, across the space.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// backendWeight returns the weight used for hashing, treating unset weights as 1
+func backendWeight(backend *Backend) int {
+	if backend.Weight <= 0 {
+		return 1
+	}
+	return backend.Weight
+}
+
+// hashTablesFor returns the hashing tables for the current healthy backends,
+// rebuilding them when pool membership or health changed. Callers must hold
+// p.mu.RLock, which keeps the generation stable while the tables are built.
+func (p *Pool) hashTablesFor(healthyBackends []*Backend) *hashTables {
+	generation := p.generation.Load()
+
+	p.hashMu.Lock()
+	defer p.hashMu.Unlock()
+
+	if p.hashTables == nil || p.hashTables.generation != generation {
+		p.hashTables = &hashTables{generation: generation}
+	}
+	tables := p.hashTables
+
+	switch p.Algorithm {
+	case RingHash:
+		if tables.ring == nil {
+			tables.ring = newHashRing(healthyBackends, p.HashPolicy.VirtualNodes)
+		}
+	case Maglev:
+		if tables.maglev == nil {
+			tables.maglev = newMaglevTable(healthyBackends, p.HashPolicy.MaglevTableSize)
+		}
+	}
+	return tables
+}
+
+// invalidate discards cached selection state after membership, weight or
+// health changes. Callers must hold p.mu.
+func (p *Pool) invalidate() {
+	p.generation.Add(1)
+}
+
+// hashRing is a ketama style consistent hash ring. Each backend owns a number
+// of points proportional to its weight relative to the average, so with equal
+// weights a backend joining or leaving only moves its own 1/N of the keys.
+type hashRing struct {
+	points   []uint64
+	backends []*Backend
+}
+
+func newHashRing(backends []*Backend, virtualNodes int) *hashRing {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+
+	totalWeight := 0
+	for _, backend := range backends {
+		totalWeight += backendWeight(backend)
+	}
+
+	type point struct {
+		hash    uint64
+		backend *Backend
+	}
+	var points []point
+	for _, backend := range backends {
+		share := float64(backendWeight(backend)*len(backends)) / float64(totalWeight)
+		count := int(math.Ceil(share * float64(virtualNodes)))
+		for i := 0; i < count; i++ {
+			points = append(points, point{hash: hash64(backend.Address + "_" + strconv.Itoa(i)), backend: backend})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	ring := &hashRing{
+		points:   make([]uint64, len(points)),
+		backends: make([]*Backend, len(points)),
+	}
+	for i, pt := range points {
+		ring.points[i] = pt.hash
+		ring.backends[i] = pt.backend
+	}
+	return ring
+}
+
+// get returns the backend owning the first point at or after the key's hash
+func (r *hashRing) get(key string) *Backend {
+	if len(r.points) == 0 {
+		return nil
+	}
+	h := hash64(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.backends[i]
+}
+
+// maglevTable implements Maglev hashing: a lookup table filled from per
+// backend permutations, giving an even spread with minimal disruption and
+// constant time lookups.
+type maglevTable struct {
+	table []*Backend
+}
+
+// newMaglevTable fills a table of the given size. Sizes that are not primes
+// of at least the number of backends cannot be filled, and are replaced by
+// the default.
+func newMaglevTable(backends []*Backend, size int) *maglevTable {
+	if size < 2 || size < len(backends) || !big.NewInt(int64(size)).ProbablyPrime(0) {
+		size = defaultMaglevTableSize
+	}
+	m := uint64(size)
+
+	type entry struct {
+		backend *Backend
+		offset  uint64
+		skip    uint64
+		next    uint64
+		weight  float64
+		target  float64
+	}
+
+	maxWeight := 0.0
+	entries := make([]*entry, len(backends))
+	for i, backend := range backends {
+		e := &entry{
+			backend: backend,
+			offset:  hash64("offset:"+backend.Address) % m,
+			skip:    hash64("skip:"+backend.Address)%(m-1) + 1,
+			weight:  float64(backendWeight(backend)),
+		}
+		entries[i] = e
+		if e.weight > maxWeight {
+			maxWeight = e.weight
+		}
+	}
+
+	// A backend with the maximum weight takes a slot on every iteration, one
+	// with half of it on every second iteration, and so on
+	for _, e := range entries {
+		e.target = maxWeight
+	}
+
+	table := make([]*Backend, size)
+	filled := 0
+	for iteration := 1.0; filled < size && len(entries) > 0; iteration++ {
+		for _, e := range entries {
+			if filled == size {
+				break
+			}
+			if iteration*e.weight < e.target {
+				continue
+			}
+			e.target += maxWeight
+
+			c := (e.offset + e.skip*e.next) % m
+			for table[c] != nil {
+				e.next++
+				c = (e.offset + e.skip*e.next) % m
+			}
+			table[c] = e.backend
+			e.next++
+			filled++
+		}
+	}
+
+	return &maglevTable{table: table}
+}
+
+func (t *maglevTable) get(key string) *Backend {
+	if len(t.table) == 0 {
+		return nil
+	}
+	return t.table[hash64(key)%uint64(len(t.table))]
+}
+
+func (b *Balancer) getRingHashBackend(pool *Pool, key string, healthyBackends []*Backend) *Backend {
+	backend := pool.hashTablesFor(healthyBackends).ring.get(key)
+	if backend == nil {
+		return healthyBackends[0]
+	}
+	return backend
+}
+
+func (b *Balancer) getMaglevBackend(pool *Pool, key string, healthyBackends []*Backend) *Backend {
+	backend := pool.hashTablesFor(healthyBackends).maglev.get(key)
+	if backend == nil {
+		return healthyBackends[0]
+	}
+	return backend
+}
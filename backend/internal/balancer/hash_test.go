@@ -0,0 +1,154 @@
+package balancer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+)
+
+func hashPool(algorithm Algorithm, count int) config.Pool {
+	backends := make([]config.Backend, count)
+	for i := range backends {
+		backends[i] = config.Backend{Address: fmt.Sprintf("10.0.0.%d:80", i+1), Weight: 100}
+	}
+	return config.Pool{
+		Name:       "hash",
+		Algorithm:  string(algorithm),
+		Backends:   backends,
+		HashPolicy: config.HashPolicy{Source: config.HashSourceHeader, Name: "X-User"},
+	}
+}
+
+// assignments maps each key to the backend chosen for it
+func assignments(t *testing.T, b *Balancer, keys int) map[string]string {
+	t.Helper()
+	result := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", key)
+		backend, err := b.GetBackend("hash", net.ParseIP("192.0.2.1"), "", req)
+		if err != nil {
+			t.Fatalf("GetBackend failed: %v", err)
+		}
+		result[key] = backend.Address
+	}
+	return result
+}
+
+func TestConsistentHashingMinimalDisruption(t *testing.T) {
+	for _, algorithm := range []Algorithm{RingHash, Maglev} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			const keys = 10000
+			b := New()
+			b.AddPool(hashPool(algorithm, 10))
+			before := assignments(t, b, keys)
+
+			// Every backend gets a fair share
+			counts := make(map[string]int)
+			for _, addr := range before {
+				counts[addr]++
+			}
+			for addr, count := range counts {
+				if count < keys/10/2 || count > keys/10*2 {
+					t.Errorf("Backend %s got %d of %d keys", addr, count, keys)
+				}
+			}
+
+			b.AddBackend("hash", config.Backend{Address: "10.0.0.11:80", Weight: 100})
+			after := assignments(t, b, keys)
+
+			moved, between := 0, 0
+			for key, addr := range after {
+				if addr != before[key] {
+					moved++
+					if addr != "10.0.0.11:80" {
+						between++
+					}
+				}
+			}
+			if moved > keys*3/22 {
+				t.Errorf("Expected about 1/11 of the keys to move, %d of %d moved", moved, keys)
+			}
+			// Maglev trades a little disruption for its even spread
+			if algorithm == RingHash && between > 0 {
+				t.Errorf("%d keys moved between existing backends", between)
+			}
+
+			// Marking a backend unhealthy mostly moves its own keys
+			b.UpdateBackendHealth("hash", "10.0.0.3:80", false)
+			degraded := assignments(t, b, keys)
+			moved = 0
+			for key, addr := range degraded {
+				if addr != after[key] {
+					moved++
+				}
+			}
+			if moved > keys*3/20 {
+				t.Errorf("Expected about 1/11 of the keys to move, %d of %d moved", moved, keys)
+			}
+		})
+	}
+}
+
+func TestConsistentHashingWeights(t *testing.T) {
+	for _, algorithm := range []Algorithm{RingHash, Maglev} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			pool := hashPool(algorithm, 2)
+			pool.Backends[1].Weight = 300
+			b := New()
+			b.AddPool(pool)
+
+			counts := make(map[string]int)
+			for _, addr := range assignments(t, b, 10000) {
+				counts[addr]++
+			}
+			share := float64(counts["10.0.0.2:80"]) / 10000
+			if share < 0.65 || share > 0.85 {
+				t.Errorf("Expected the heavier backend to get about 75%% of the keys, got %.2f", share)
+			}
+		})
+	}
+}
+
+func TestMaglevTableSizeFallsBack(t *testing.T) {
+	backends := []*Backend{{Address: "10.0.0.1:80", Weight: 100}, {Address: "10.0.0.2:80", Weight: 100}, {Address: "10.0.0.3:80", Weight: 100}}
+	// A size of 1 divides by zero and composite or too small sizes never fill
+	for _, size := range []int{1, 9, 65536, 2} {
+		table := newMaglevTable(backends, size)
+		if len(table.table) != defaultMaglevTableSize {
+			t.Errorf("Expected size %d to fall back to %d slots, got %d", size, defaultMaglevTableSize, len(table.table))
+		}
+	}
+	if table := newMaglevTable(backends, 7); len(table.table) != 7 {
+		t.Errorf("Expected a prime size to be kept, got %d slots", len(table.table))
+	}
+}
+
+func TestHashKey(t *testing.T) {
+	ip := net.ParseIP("192.0.2.10")
+	req := httptest.NewRequest("GET", "/tenants/acme/orders", nil)
+	req.Header.Set("X-User", "alice")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "s1"})
+
+	tests := []struct {
+		policy   config.HashPolicy
+		expected string
+	}{
+		{config.HashPolicy{}, "192.0.2.10"},
+		{config.HashPolicy{Source: config.HashSourceHeader, Name: "X-User"}, "alice"},
+		{config.HashPolicy{Source: config.HashSourceCookie, Name: "session"}, "s1"},
+		{config.HashPolicy{Source: config.HashSourcePath, Segment: 1}, "acme"},
+		{config.HashPolicy{Source: config.HashSourceHeader, Name: "X-Missing"}, "192.0.2.10"},
+		{config.HashPolicy{Source: config.HashSourcePath, Segment: 5}, "192.0.2.10"},
+	}
+	for _, test := range tests {
+		if got := hashKey(test.policy, ip, req); got != test.expected {
+			t.Errorf("hashKey(%+v) = %q, expected %q", test.policy, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package balancer
+
+import (
+	"reflect"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+)
+
+// MembershipEventType identifies how pool membership changed
+type MembershipEventType string
+
+const (
+	BackendAdded   MembershipEventType = "added"
+	BackendUpdated MembershipEventType = "updated"
+	BackendRemoved MembershipEventType = "removed"
+)
+
+// MembershipEvent is emitted whenever a backend joins, changes or leaves a pool
+type MembershipEvent struct {
+	Type    MembershipEventType
+	Pool    string
+	Backend config.Backend
+}
+
+// Subscribe registers a listener for membership changes. Listeners run
+// synchronously after the change is applied and must not block.
+func (b *Balancer) Subscribe(listener func(MembershipEvent)) {
+	b.listenersMu.Lock()
+	defer b.listenersMu.Unlock()
+	b.listeners = append(b.listeners, listener)
+}
+
+func (b *Balancer) notify(events []MembershipEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	b.listenersMu.RLock()
+	listeners := b.listeners
+	b.listenersMu.RUnlock()
+
+	for _, event := range events {
+		for _, listener := range listeners {
+			listener(event)
+		}
+	}
+}
+
+// poolBackendConfigs snapshots the backend configuration of a pool
+func poolBackendConfigs(pool *Pool) []config.Backend {
+	if pool == nil {
+		return nil
+	}
+
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	backends := make([]config.Backend, len(pool.Backends))
+	for i, backend := range pool.Backends {
+		backends[i] = backend.Config
+	}
+	return backends
+}
+
+// membershipDiff returns the events that turn the old backend set into the new one
+func membershipDiff(poolName string, old, updated []config.Backend) []MembershipEvent {
+	previous := make(map[string]config.Backend, len(old))
+	for _, backend := range old {
+		previous[backend.Address] = backend
+	}
+
+	var events []MembershipEvent
+	for _, backend := range updated {
+		prev, existed := previous[backend.Address]
+		delete(previous, backend.Address)
+		switch {
+		case !existed:
+			events = append(events, MembershipEvent{Type: BackendAdded, Pool: poolName, Backend: backend})
+		case !reflect.DeepEqual(prev, backend):
+			events = append(events, MembershipEvent{Type: BackendUpdated, Pool: poolName, Backend: backend})
+		}
+	}
+
+	for _, backend := range old {
+		if _, removed := previous[backend.Address]; removed {
+			events = append(events, MembershipEvent{Type: BackendRemoved, Pool: poolName, Backend: backend})
+		}
+	}
+
+	return events
+}
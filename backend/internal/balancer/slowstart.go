@@ -0,0 +1,90 @@
+package balancer
+
+import (
+	"math"
+	"time"
+)
+
+// Slow start defaults
+const (
+	defaultSlowStartAggression = 1.0
+	defaultMinWeightPercent    = 10.0
+
+	// weightScale is the resolution of effective weights, so a ramping
+	// backend keeps a fractional share of its configured weight
+	weightScale = 1000
+)
+
+// startSlowStart marks the backend as just added or recovered. Whether it
+// actually ramps depends on the slow start settings of its pool.
+func (b *Backend) startSlowStart() {
+	b.rampStart.Store(time.Now().UnixNano())
+}
+
+// slowStartFactor returns the fraction of its weight a backend receives at
+// now, which is 1 once the ramp is over or when slow start is disabled.
+// Callers must hold p.mu.
+func (p *Pool) slowStartFactor(backend *Backend, now int64) float64 {
+	duration := p.SlowStart.Duration
+	since := backend.rampStart.Load()
+	if duration <= 0 || since == 0 {
+		return 1
+	}
+	elapsed := now - since
+	if elapsed >= int64(duration) {
+		return 1
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	aggression := p.SlowStart.Aggression
+	if aggression <= 0 {
+		aggression = defaultSlowStartAggression
+	}
+	minFraction := p.SlowStart.MinWeightPercent
+	if minFraction <= 0 {
+		minFraction = defaultMinWeightPercent
+	}
+	minFraction = math.Min(minFraction/100, 1)
+
+	progress := math.Pow(float64(elapsed)/float64(duration), 1/aggression)
+	return minFraction + (1-minFraction)*progress
+}
+
+// effectiveWeight scales a base weight by the backend's slow start ramp, in
+// units of 1/weightScale. Callers must hold p.mu.
+func (p *Pool) effectiveWeight(backend *Backend, base int, now int64) int64 {
+	weight := int64(float64(base*weightScale) * p.slowStartFactor(backend, now))
+	if weight < 1 {
+		return 1
+	}
+	return weight
+}
+
+// ramping reports whether any of the backends is still in its slow start
+// ramp. Callers must hold p.mu.
+func (p *Pool) ramping(backends []*Backend, now int64) bool {
+	if p.SlowStart.Duration <= 0 {
+		return false
+	}
+	for _, backend := range backends {
+		if p.slowStartFactor(backend, now) < 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// nextRoundRobin picks the next backend in turn. While a backend ramps up,
+// plain rotation would hand it a full share, so the pick goes through smooth
+// weighted round robin with equal base weights instead.
+func (p *Pool) nextRoundRobin(backends []*Backend) *Backend {
+	if now := time.Now().UnixNano(); p.ramping(backends, now) {
+		return p.smoothWeightedRoundRobin(backends, func(backend *Backend) int64 {
+			return p.effectiveWeight(backend, 1, now)
+		})
+	}
+	index := p.counter.Add(1) % uint64(len(backends))
+	return backends[index]
+}
@@ -0,0 +1,127 @@
+package balancer
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+)
+
+func TestSlowStartFactor(t *testing.T) {
+	duration := time.Minute
+	tests := []struct {
+		name      string
+		slowStart config.SlowStart
+		elapsed   time.Duration
+		want      float64
+	}{
+		{"disabled", config.SlowStart{}, 0, 1},
+		{"start", config.SlowStart{Duration: duration}, 0, 0.1},
+		{"linear halfway", config.SlowStart{Duration: duration}, duration / 2, 0.55},
+		{"custom minimum", config.SlowStart{Duration: duration, MinWeightPercent: 50}, duration / 2, 0.75},
+		{"aggressive curve", config.SlowStart{Duration: duration, Aggression: 2}, duration / 4, 0.55},
+		{"finished", config.SlowStart{Duration: duration}, duration, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := &Pool{SlowStart: tt.slowStart}
+			backend := &Backend{}
+			now := time.Now().UnixNano()
+			backend.rampStart.Store(now - int64(tt.elapsed))
+
+			if got := pool.slowStartFactor(backend, now); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("factor = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// share returns the fraction of n picks that went to addr. Unless released,
+// every pick keeps a connection open.
+func share(t *testing.T, b *Balancer, pool, addr string, n int, release bool) float64 {
+	t.Helper()
+	hits := 0
+	for i := 0; i < n; i++ {
+		backend, err := b.GetBackend(pool, net.IPv4(127, 0, 0, 1), "", &http.Request{})
+		if err != nil {
+			t.Fatalf("GetBackend returned error: %v", err)
+		}
+		if backend.Address == addr {
+			hits++
+		}
+		if !release {
+			b.IncrementConnections(pool, backend.Address)
+		}
+	}
+	return float64(hits) / float64(n)
+}
+
+func TestSlowStartRampsAddedBackend(t *testing.T) {
+	for _, algorithm := range []Algorithm{RoundRobin, WeightedRoundRobin, LeastConn} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			b := New()
+			b.AddPool(config.Pool{
+				Name:      "slow",
+				Algorithm: string(algorithm),
+				SlowStart: config.SlowStart{Duration: time.Hour},
+				Backends:  []config.Backend{{Address: "warm", Weight: 1}},
+			})
+			b.AddBackend("slow", config.Backend{Address: "cold", Weight: 1})
+
+			// Least connections only sees the weights once connections pile up
+			got := share(t, b, "slow", "cold", 1100, algorithm != LeastConn)
+			if got > 0.15 {
+				t.Errorf("new backend got %.2f of the traffic at the start of its ramp, want about 0.09", got)
+			}
+			if got == 0 {
+				t.Error("new backend got no traffic during its ramp")
+			}
+
+			// Once the ramp is over it gets its full share again
+			pool := b.pools["slow"]
+			for _, backend := range pool.Backends {
+				backend.rampStart.Store(time.Now().Add(-2 * time.Hour).UnixNano())
+				backend.Connections.Store(0)
+			}
+			if got := share(t, b, "slow", "cold", 1000, algorithm != LeastConn); math.Abs(got-0.5) > 0.05 {
+				t.Errorf("backend got %.2f of the traffic after its ramp, want 0.5", got)
+			}
+		})
+	}
+}
+
+func TestSlowStartOnRecovery(t *testing.T) {
+	b := New()
+	b.AddPool(config.Pool{
+		Name:      "recover",
+		Algorithm: string(WeightedRoundRobin),
+		SlowStart: config.SlowStart{Duration: time.Hour},
+		Backends: []config.Backend{
+			{Address: "a", Weight: 1},
+			{Address: "b", Weight: 1},
+		},
+	})
+
+	// Backends present when the pool is created start at full weight
+	if got := share(t, b, "recover", "b", 100, true); got != 0.5 {
+		t.Fatalf("b got %.2f of the traffic before failing, want 0.5", got)
+	}
+
+	b.UpdateBackendHealth("recover", "b", false)
+	b.UpdateBackendHealth("recover", "b", true)
+	if got := share(t, b, "recover", "b", 1100, true); got > 0.15 {
+		t.Errorf("recovered backend got %.2f of the traffic, want about 0.09", got)
+	}
+
+	// A health report that does not change the state keeps the ramp going
+	pool := b.pools["recover"]
+	rampStart := pool.Backends[1].rampStart.Load()
+	b.UpdateBackendHealth("recover", "b", true)
+	if pool.Backends[1].rampStart.Load() != rampStart {
+		t.Error("ramp restarted on a repeated healthy report")
+	}
+}
@@ -0,0 +1,283 @@
+package balancer
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/go-redis/redis/v8"
+)
+
+// Sticky session defaults
+const (
+	DefaultAffinityTTL        = time.Hour
+	DefaultAffinityMaxEntries = 100000
+
+	// affinityTimeout bounds requests to the shared affinity store, which
+	// happen while a backend is being selected
+	affinityTimeout = 100 * time.Millisecond
+)
+
+// AffinityStore is an affinity table shared by the nodes of a cluster. Get
+// returns an empty address when the key is unknown.
+type AffinityStore interface {
+	Get(ctx context.Context, pool, key string) (string, error)
+	Set(ctx context.Context, pool, key, address string, ttl time.Duration) error
+	Delete(ctx context.Context, pool, key string) error
+}
+
+// SetAffinityStore sets the store shared by pools with sticky.shared enabled
+func (b *Balancer) SetAffinityStore(store AffinityStore) {
+	b.stickyMu.Lock()
+	b.affinityStore = store
+	b.stickyMu.Unlock()
+}
+
+// sharedBinding returns the shared store of a pool with sticky.shared
+// enabled, with the backend address it binds a session to. It is read before
+// the pool is locked, so a slow store does not hold up the health updates and
+// reloads waiting for the pool.
+func (b *Balancer) sharedBinding(pool *Pool, sessionID string) (AffinityStore, string) {
+	pool.mu.RLock()
+	shared := pool.StickySessions && pool.Sticky.Shared
+	pool.mu.RUnlock()
+	if !shared || sessionID == "" {
+		return nil, ""
+	}
+
+	b.stickyMu.RLock()
+	store := b.affinityStore
+	b.stickyMu.RUnlock()
+	if store == nil {
+		return nil, ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), affinityTimeout)
+	defer cancel()
+	address, _ := store.Get(ctx, pool.Name, sessionID)
+	return store, address
+}
+
+// affinityTTL returns how long a session stays bound to its backend
+func affinityTTL(pool *Pool) time.Duration {
+	if pool.Sticky.TTL > 0 {
+		return pool.Sticky.TTL
+	}
+	return DefaultAffinityTTL
+}
+
+// affinityMaxEntries returns the size of the pool's local affinity table
+func affinityMaxEntries(cfg int) int {
+	if cfg > 0 {
+		return cfg
+	}
+	return DefaultAffinityMaxEntries
+}
+
+// getStickyBackend returns the healthy backend a session is bound to, given
+// the address the shared store binds it to. A binding to a backend that was
+// removed, drained or ejected is dropped so the session fails over to a
+// freshly selected backend. Changes to the shared binding are returned for
+// the caller to make once the pool is unlocked.
+func (b *Balancer) getStickyBackend(pool *Pool, sessionID string, shared AffinityStore, address string, healthyBackends []*Backend) (*Backend, func()) {
+	fromShared := address != ""
+	if !fromShared {
+		var ok bool
+		if address, ok = pool.affinity.get(sessionID, time.Now()); !ok {
+			return nil, nil
+		}
+	}
+
+	for _, backend := range healthyBackends {
+		if backend.Address == address {
+			if fromShared {
+				pool.affinity.set(sessionID, address, time.Now().Add(affinityTTL(pool)))
+				return backend, nil
+			}
+			// The shared binding expired or was lost; restore it
+			return backend, b.setStickyBackend(pool, sessionID, address, shared)
+		}
+	}
+
+	metrics.StickyFailoversTotal.WithLabelValues(pool.Name).Inc()
+	pool.affinity.remove(sessionID)
+	if shared == nil {
+		return nil, nil
+	}
+	return nil, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), affinityTimeout)
+		defer cancel()
+		shared.Delete(ctx, pool.Name, sessionID)
+	}
+}
+
+// setStickyBackend binds a session to a backend, refreshing its expiry. The
+// shared binding is returned for the caller to write once the pool is
+// unlocked.
+func (b *Balancer) setStickyBackend(pool *Pool, sessionID, address string, shared AffinityStore) func() {
+	ttl := affinityTTL(pool)
+	pool.affinity.set(sessionID, address, time.Now().Add(ttl))
+	if shared == nil {
+		return nil
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), affinityTimeout)
+		defer cancel()
+		shared.Set(ctx, pool.Name, sessionID, address, ttl)
+	}
+}
+
+// GetPinnedBackend returns the backend of a pool whose address satisfies
+// match, as long as it can take traffic. It serves requests whose affinity is
+// carried by the request itself, such as signed cookies.
+func (b *Balancer) GetPinnedBackend(poolName string, match func(address string) bool) (*Backend, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	pool, exists := b.pools[poolName]
+	if !exists {
+		return nil, false
+	}
+
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	for _, backend := range pool.Backends {
+		if match(backend.Address) {
+			if !backend.Healthy.Load() {
+				break
+			}
+			backend.LastUsed.Store(time.Now().UnixNano())
+			return backend, true
+		}
+	}
+	return nil, false
+}
+
+// affinityLRU is a bounded table of session bindings with per entry expiry.
+// The least recently used binding is evicted when the table is full.
+type affinityLRU struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List // front is most recently used
+}
+
+type affinityEntry struct {
+	key     string
+	address string
+	expires time.Time
+}
+
+func newAffinityLRU(maxEntries int) *affinityLRU {
+	return &affinityLRU{
+		maxEntries: affinityMaxEntries(maxEntries),
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// get returns the address bound to key. Bindings are not refreshed on reads,
+// so a session moves on to a new selection once its TTL runs out.
+func (l *affinityLRU) get(key string, now time.Time) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem, ok := l.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*affinityEntry)
+	if now.After(entry.expires) {
+		l.order.Remove(elem)
+		delete(l.entries, key)
+		return "", false
+	}
+	l.order.MoveToFront(elem)
+	return entry.address, true
+}
+
+func (l *affinityLRU) set(key, address string, expires time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.entries[key]; ok {
+		entry := elem.Value.(*affinityEntry)
+		entry.address = address
+		entry.expires = expires
+		l.order.MoveToFront(elem)
+		return
+	}
+
+	l.entries[key] = l.order.PushFront(&affinityEntry{key: key, address: address, expires: expires})
+	l.evict()
+}
+
+func (l *affinityLRU) remove(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.entries[key]; ok {
+		l.order.Remove(elem)
+		delete(l.entries, key)
+	}
+}
+
+// resize changes the capacity, evicting the oldest bindings if needed
+func (l *affinityLRU) resize(maxEntries int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.maxEntries = affinityMaxEntries(maxEntries)
+	l.evict()
+}
+
+func (l *affinityLRU) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
+
+// evict drops least recently used bindings above capacity. Callers must hold l.mu.
+func (l *affinityLRU) evict() {
+	for l.order.Len() > l.maxEntries {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*affinityEntry).key)
+	}
+}
+
+// RedisAffinityStore keeps session bindings in Redis so every node of a
+// cluster sends a session to the same backend.
+type RedisAffinityStore struct {
+	client *redis.Client
+}
+
+// NewRedisAffinityStore creates an affinity store backed by client
+func NewRedisAffinityStore(client *redis.Client) *RedisAffinityStore {
+	return &RedisAffinityStore{client: client}
+}
+
+func (s *RedisAffinityStore) key(pool, key string) string {
+	return "vf:affinity:" + pool + ":" + key
+}
+
+// Get implements AffinityStore
+func (s *RedisAffinityStore) Get(ctx context.Context, pool, key string) (string, error) {
+	address, err := s.client.Get(ctx, s.key(pool, key)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return address, err
+}
+
+// Set implements AffinityStore
+func (s *RedisAffinityStore) Set(ctx context.Context, pool, key, address string, ttl time.Duration) error {
+	return s.client.Set(ctx, s.key(pool, key), address, ttl).Err()
+}
+
+// Delete implements AffinityStore
+func (s *RedisAffinityStore) Delete(ctx context.Context, pool, key string) error {
+	return s.client.Del(ctx, s.key(pool, key)).Err()
+}
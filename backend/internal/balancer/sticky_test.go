@@ -0,0 +1,215 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestAffinityLRU(t *testing.T) {
+	lru := newAffinityLRU(3)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		lru.set(strconv.Itoa(i), "backend-"+strconv.Itoa(i), now.Add(time.Minute))
+	}
+
+	// Reading 0 makes 1 the least recently used binding
+	if _, ok := lru.get("0", now); !ok {
+		t.Fatal("binding 0 missing")
+	}
+	lru.set("3", "backend-3", now.Add(time.Minute))
+	if _, ok := lru.get("1", now); ok {
+		t.Error("least recently used binding was not evicted")
+	}
+	if lru.len() != 3 {
+		t.Errorf("table holds %d bindings, want 3", lru.len())
+	}
+
+	if _, ok := lru.get("0", now.Add(2*time.Minute)); ok {
+		t.Error("expired binding was returned")
+	}
+
+	lru.resize(1)
+	if lru.len() != 1 {
+		t.Errorf("table holds %d bindings after resize, want 1", lru.len())
+	}
+}
+
+func TestStickySessionFailover(t *testing.T) {
+	b := New()
+	b.AddPool(config.Pool{
+		Name:           "sticky",
+		Algorithm:      string(RoundRobin),
+		StickySessions: true,
+		Backends: []config.Backend{
+			{Address: "1.1.1.1:80"},
+			{Address: "2.2.2.2:80"},
+		},
+	})
+
+	ip := net.IPv4(127, 0, 0, 1)
+	first, err := b.GetBackend("sticky", ip, "session", &http.Request{})
+	if err != nil {
+		t.Fatalf("GetBackend returned error: %v", err)
+	}
+
+	b.UpdateBackendHealth("sticky", first.Address, false)
+	second, err := b.GetBackend("sticky", ip, "session", &http.Request{})
+	if err != nil {
+		t.Fatalf("GetBackend returned error: %v", err)
+	}
+	if second.Address == first.Address {
+		t.Fatal("session stayed on an unhealthy backend")
+	}
+
+	// The session stays on its new backend after the old one recovers
+	b.UpdateBackendHealth("sticky", first.Address, true)
+	for i := 0; i < 3; i++ {
+		backend, err := b.GetBackend("sticky", ip, "session", &http.Request{})
+		if err != nil {
+			t.Fatalf("GetBackend returned error: %v", err)
+		}
+		if backend.Address != second.Address {
+			t.Errorf("session moved back to %s", backend.Address)
+		}
+	}
+}
+
+func TestSharedAffinityStore(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	pool := config.Pool{
+		Name:           "shared",
+		Algorithm:      string(RoundRobin),
+		StickySessions: true,
+		Sticky:         config.StickyConfig{Shared: true, TTL: time.Minute},
+		Backends: []config.Backend{
+			{Address: "1.1.1.1:80"},
+			{Address: "2.2.2.2:80"},
+			{Address: "3.3.3.3:80"},
+		},
+	}
+
+	// Two nodes sharing the same Redis
+	nodes := make([]*Balancer, 2)
+	for i := range nodes {
+		nodes[i] = New()
+		nodes[i].AddPool(pool)
+		nodes[i].SetAffinityStore(NewRedisAffinityStore(client))
+	}
+
+	ip := net.IPv4(127, 0, 0, 1)
+	pinned, err := nodes[0].GetBackend("shared", ip, "user-42", &http.Request{})
+	if err != nil {
+		t.Fatalf("GetBackend returned error: %v", err)
+	}
+
+	// Move the second node's round robin so it would pick something else
+	nodes[1].GetBackend("shared", ip, "", &http.Request{})
+	for i := 0; i < 3; i++ {
+		backend, err := nodes[1].GetBackend("shared", ip, "user-42", &http.Request{})
+		if err != nil {
+			t.Fatalf("GetBackend returned error: %v", err)
+		}
+		if backend.Address != pinned.Address {
+			t.Errorf("second node sent the session to %s, want %s", backend.Address, pinned.Address)
+		}
+	}
+
+	if ttl := mr.TTL("vf:affinity:shared:user-42"); ttl != time.Minute {
+		t.Errorf("binding TTL = %v, want 1m", ttl)
+	}
+
+	// A failover on one node is seen by the other
+	nodes[0].UpdateBackendHealth("shared", pinned.Address, false)
+	moved, err := nodes[0].GetBackend("shared", ip, "user-42", &http.Request{})
+	if err != nil {
+		t.Fatalf("GetBackend returned error: %v", err)
+	}
+	address, err := NewRedisAffinityStore(client).Get(context.Background(), "shared", "user-42")
+	if err != nil || address != moved.Address {
+		t.Errorf("shared binding = %q, %v; want %q", address, err, moved.Address)
+	}
+	backend, err := nodes[1].GetBackend("shared", ip, "user-42", &http.Request{})
+	if err != nil {
+		t.Fatalf("GetBackend returned error: %v", err)
+	}
+	if backend.Address != moved.Address {
+		t.Errorf("second node sent the session to %s after failover, want %s", backend.Address, moved.Address)
+	}
+}
+
+// slowAffinityStore blocks every request until released
+type slowAffinityStore struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *slowAffinityStore) wait() {
+	s.started <- struct{}{}
+	<-s.release
+}
+
+func (s *slowAffinityStore) Get(ctx context.Context, pool, key string) (string, error) {
+	s.wait()
+	return "", nil
+}
+
+func (s *slowAffinityStore) Set(ctx context.Context, pool, key, address string, ttl time.Duration) error {
+	s.wait()
+	return nil
+}
+
+func (s *slowAffinityStore) Delete(ctx context.Context, pool, key string) error {
+	s.wait()
+	return nil
+}
+
+func TestSharedAffinityStoreOutsidePoolLock(t *testing.T) {
+	b := New()
+	b.AddPool(config.Pool{
+		Name:           "shared",
+		Algorithm:      string(RoundRobin),
+		StickySessions: true,
+		Sticky:         config.StickyConfig{Shared: true},
+		Backends:       []config.Backend{{Address: "1.1.1.1:80"}, {Address: "2.2.2.2:80"}},
+	})
+	store := &slowAffinityStore{started: make(chan struct{}), release: make(chan struct{})}
+	b.SetAffinityStore(store)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.GetBackend("shared", net.IPv4(127, 0, 0, 1), "user-42", &http.Request{})
+	}()
+
+	// Health updates go through while the store is read and then written
+	for i := 0; i < 2; i++ {
+		<-store.started
+		updated := make(chan struct{})
+		go func() {
+			b.UpdateBackendHealth("shared", "2.2.2.2:80", i == 0)
+			close(updated)
+		}()
+		select {
+		case <-updated:
+		case <-time.After(time.Second):
+			t.Fatal("health update blocked behind the shared affinity store")
+		}
+		store.release <- struct{}{}
+	}
+	<-done
+}
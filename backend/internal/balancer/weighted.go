@@ -0,0 +1,83 @@
+package balancer
+
+import (
+	"math/rand"
+	"time"
+)
+
+// smoothWeightedRoundRobin implements nginx's smooth weighted round robin.
+// Every pick raises each backend's current weight by its weight and lowers
+// the chosen one by the total, which interleaves backends deterministically:
+// weights 5, 1 and 1 yield a a b a c a a instead of a a a a a b c.
+func (p *Pool) smoothWeightedRoundRobin(backends []*Backend, weightOf func(*Backend) int64) *Backend {
+	p.wrrMu.Lock()
+	defer p.wrrMu.Unlock()
+
+	var total int64
+	var best *Backend
+	for _, backend := range backends {
+		weight := weightOf(backend)
+		backend.currentWeight += weight
+		total += weight
+		if best == nil || backend.currentWeight > best.currentWeight {
+			best = backend
+		}
+	}
+
+	best.currentWeight -= total
+	return best
+}
+
+// weightedPick runs smooth weighted round robin over the configured weights,
+// scaled down for backends that are still ramping up
+func (p *Pool) weightedPick(backends []*Backend) *Backend {
+	now := time.Now().UnixNano()
+	return p.smoothWeightedRoundRobin(backends, func(backend *Backend) int64 {
+		return p.effectiveWeight(backend, backendWeight(backend), now)
+	})
+}
+
+// lighter reports whether backend a is less loaded than b. Load is the number
+// of connections plus the one about to be added, per unit of effective
+// weight, so weights still count while backends are idle.
+func (p *Pool) lighter(a, b *Backend, now int64) bool {
+	loadA := (a.Connections.Load() + 1) * p.effectiveWeight(b, backendWeight(b), now)
+	loadB := (b.Connections.Load() + 1) * p.effectiveWeight(a, backendWeight(a), now)
+	return loadA < loadB
+}
+
+// leastLoaded scans every backend for the fewest connections per unit of
+// weight. The scan starts at a rotating offset so ties are spread across
+// backends instead of always going to the first one.
+func (p *Pool) leastLoaded(backends []*Backend) *Backend {
+	now := time.Now().UnixNano()
+	start := int(p.counter.Add(1) % uint64(len(backends)))
+	selected := backends[start]
+	for i := 1; i < len(backends); i++ {
+		backend := backends[(start+i)%len(backends)]
+		if p.lighter(backend, selected, now) {
+			selected = backend
+		}
+	}
+	return selected
+}
+
+// powerOfTwoChoices picks two distinct backends at random and keeps the one
+// with fewer connections per unit of weight. It avoids the herding of a full
+// least-connections scan when many requests are balanced at once.
+func (p *Pool) powerOfTwoChoices(backends []*Backend) *Backend {
+	if len(backends) == 1 {
+		return backends[0]
+	}
+
+	i := rand.Intn(len(backends))
+	j := rand.Intn(len(backends) - 1)
+	if j >= i {
+		j++
+	}
+
+	if p.lighter(backends[j], backends[i], time.Now().UnixNano()) {
+		return backends[j]
+	}
+	return backends[i]
+}
@@ -0,0 +1,239 @@
+package balancer
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+)
+
+func TestSmoothWeightedRoundRobinSequence(t *testing.T) {
+	b := New()
+	b.AddPool(config.Pool{
+		Name:      "swrr",
+		Algorithm: string(WeightedRoundRobin),
+		Backends: []config.Backend{
+			{Address: "a", Weight: 5},
+			{Address: "b", Weight: 1},
+			{Address: "c", Weight: 1},
+		},
+	})
+
+	var picks []string
+	for i := 0; i < 14; i++ {
+		backend, err := b.GetBackend("swrr", net.IPv4(127, 0, 0, 1), "", &http.Request{})
+		if err != nil {
+			t.Fatalf("GetBackend returned error: %v", err)
+		}
+		picks = append(picks, backend.Address)
+	}
+
+	// The nginx sequence interleaves the light backends and repeats every
+	// total weight picks
+	want := "a a b a c a a a a b a c a a"
+	if got := strings.Join(picks, " "); got != want {
+		t.Errorf("sequence = %q, want %q", got, want)
+	}
+}
+
+func TestLeastConnectionsSpreadsTies(t *testing.T) {
+	b := New()
+	b.AddPool(config.Pool{
+		Name:      "lc",
+		Algorithm: string(LeastConn),
+		Backends: []config.Backend{
+			{Address: "1.1.1.1:80"},
+			{Address: "2.2.2.2:80"},
+			{Address: "3.3.3.3:80"},
+		},
+	})
+
+	counts := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		backend, err := b.GetBackend("lc", net.IPv4(127, 0, 0, 1), "", &http.Request{})
+		if err != nil {
+			t.Fatalf("GetBackend returned error: %v", err)
+		}
+		counts[backend.Address]++
+	}
+
+	for _, addr := range []string{"1.1.1.1:80", "2.2.2.2:80", "3.3.3.3:80"} {
+		if counts[addr] != 10 {
+			t.Errorf("backend %s picked %d times with no load, want 10", addr, counts[addr])
+		}
+	}
+}
+
+func TestLeastConnectionsHonoursWeight(t *testing.T) {
+	b := New()
+	b.AddPool(config.Pool{
+		Name:      "lc-weighted",
+		Algorithm: string(LeastConn),
+		Backends: []config.Backend{
+			{Address: "small", Weight: 1},
+			{Address: "large", Weight: 4},
+		},
+	})
+
+	// 2 connections on a weight 1 backend weigh more than 4 on a weight 4 one
+	for i := 0; i < 2; i++ {
+		b.IncrementConnections("lc-weighted", "small")
+	}
+	for i := 0; i < 4; i++ {
+		b.IncrementConnections("lc-weighted", "large")
+	}
+
+	backend, err := b.GetBackend("lc-weighted", net.IPv4(127, 0, 0, 1), "", &http.Request{})
+	if err != nil {
+		t.Fatalf("GetBackend returned error: %v", err)
+	}
+	if backend.Address != "large" {
+		t.Errorf("expected large, got %s", backend.Address)
+	}
+}
+
+func TestPowerOfTwoChoices(t *testing.T) {
+	b := New()
+	b.AddPool(config.Pool{
+		Name:      "p2c",
+		Algorithm: string(PowerOfTwoChoices),
+		Backends: []config.Backend{
+			{Address: "busy"},
+			{Address: "idle"},
+		},
+	})
+	for i := 0; i < 5; i++ {
+		b.IncrementConnections("p2c", "busy")
+	}
+
+	// With two backends both are always sampled, so the idle one must win
+	for i := 0; i < 20; i++ {
+		backend, err := b.GetBackend("p2c", net.IPv4(127, 0, 0, 1), "", &http.Request{})
+		if err != nil {
+			t.Fatalf("GetBackend returned error: %v", err)
+		}
+		if backend.Address != "idle" {
+			t.Fatalf("expected idle, got %s", backend.Address)
+		}
+	}
+
+	// A single backend is returned as is
+	if got := (&Pool{}).powerOfTwoChoices([]*Backend{{Address: "only"}}); got.Address != "only" {
+		t.Errorf("expected only, got %s", got.Address)
+	}
+}
+
+func TestPowerOfTwoChoicesBalancesLoad(t *testing.T) {
+	b := New()
+	var backends []config.Backend
+	for _, addr := range []string{"a", "b", "c", "d"} {
+		backends = append(backends, config.Backend{Address: addr})
+	}
+	b.AddPool(config.Pool{Name: "p2c-load", Algorithm: string(PowerOfTwoChoices), Backends: backends})
+
+	// Requests that never finish pile up; p2c keeps the spread within a few
+	// connections of each other
+	for i := 0; i < 400; i++ {
+		backend, err := b.GetBackend("p2c-load", net.IPv4(127, 0, 0, 1), "", &http.Request{})
+		if err != nil {
+			t.Fatalf("GetBackend returned error: %v", err)
+		}
+		b.IncrementConnections("p2c-load", backend.Address)
+	}
+
+	pool := b.pools["p2c-load"]
+	min, max := int64(math.MaxInt64), int64(0)
+	for _, backend := range pool.Backends {
+		conns := backend.Connections.Load()
+		if conns < min {
+			min = conns
+		}
+		if conns > max {
+			max = conns
+		}
+	}
+	if max-min > 10 {
+		t.Errorf("connections spread %d..%d, want within 10", min, max)
+	}
+}
+
+func benchmarkAlgorithm(b *testing.B, algorithm Algorithm, parallel bool) {
+	bal := New()
+	var backends []config.Backend
+	for i, addr := range []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.4:80"} {
+		backends = append(backends, config.Backend{Address: addr, Weight: i + 1})
+	}
+	bal.AddPool(config.Pool{Name: "bench", Algorithm: string(algorithm), Backends: backends})
+
+	pool := bal.pools["bench"]
+	picks := make(map[*Backend]*atomic.Int64)
+	for _, backend := range pool.Backends {
+		picks[backend] = new(atomic.Int64)
+	}
+
+	pick := func() {
+		backend, err := bal.GetBackend("bench", net.IPv4(127, 0, 0, 1), "", &http.Request{})
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		// The request finishes right away, so no connection is counted
+		picks[backend].Add(1)
+	}
+
+	b.ResetTimer()
+	if parallel {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				pick()
+			}
+		})
+	} else {
+		for i := 0; i < b.N; i++ {
+			pick()
+		}
+	}
+	b.StopTimer()
+
+	// Report how far each backend's share strays from its weighted share
+	total, totalWeight := 0.0, 0.0
+	for _, backend := range pool.Backends {
+		total += float64(picks[backend].Load())
+		totalWeight += float64(backendWeight(backend))
+	}
+	worst := 0.0
+	for _, backend := range pool.Backends {
+		want := float64(backendWeight(backend)) / totalWeight
+		got := float64(picks[backend].Load()) / total
+		worst = math.Max(worst, math.Abs(got-want))
+	}
+	b.ReportMetric(worst*100, "max-skew-%")
+}
+
+func BenchmarkSmoothWeightedRoundRobin(b *testing.B) {
+	benchmarkAlgorithm(b, WeightedRoundRobin, false)
+}
+
+func BenchmarkSmoothWeightedRoundRobinParallel(b *testing.B) {
+	benchmarkAlgorithm(b, WeightedRoundRobin, true)
+}
+
+func BenchmarkLeastConnections(b *testing.B) {
+	benchmarkAlgorithm(b, LeastConn, false)
+}
+
+func BenchmarkLeastConnectionsParallel(b *testing.B) {
+	benchmarkAlgorithm(b, LeastConn, true)
+}
+
+func BenchmarkPowerOfTwoChoices(b *testing.B) {
+	benchmarkAlgorithm(b, PowerOfTwoChoices, false)
+}
+
+func BenchmarkPowerOfTwoChoicesParallel(b *testing.B) {
+	benchmarkAlgorithm(b, PowerOfTwoChoices, true)
+}
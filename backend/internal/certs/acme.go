@@ -0,0 +1,98 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACME certificate caches
+const (
+	CacheDir   = "dir"
+	CacheRedis = "redis"
+)
+
+// redisCachePrefix namespaces the ACME account key and certificates in Redis
+const redisCachePrefix = "vf:acme:"
+
+// NewManager returns the ACME manager of a TLS configuration. Certificates
+// are issued for the names policy accepts, any name when it is nil, and kept
+// in the cache the configuration selects; the Redis one needs client.
+func NewManager(cfg config.TLSConfig, client *redis.Client, policy autocert.HostPolicy) (*autocert.Manager, error) {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Email:      cfg.ACMEEmail,
+		HostPolicy: policy,
+	}
+
+	switch cfg.ACMECache {
+	case "", CacheDir:
+		manager.Cache = autocert.DirCache(cfg.CertDir)
+	case CacheRedis:
+		if client == nil {
+			return nil, errors.New("the redis ACME cache needs a Redis client")
+		}
+		manager.Cache = NewRedisCache(client)
+	default:
+		return nil, fmt.Errorf("unknown ACME cache %q", cfg.ACMECache)
+	}
+
+	if cfg.ACMEDirectory != "" || cfg.ACMERootCA != "" {
+		manager.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectory}
+	}
+	if cfg.ACMERootCA != "" {
+		pem, err := os.ReadFile(cfg.ACMERootCA)
+		if err != nil {
+			return nil, fmt.Errorf("reading ACME root CA: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in ACME root CA %s", cfg.ACMERootCA)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		manager.Client.HTTPClient = &http.Client{Transport: transport}
+	}
+	return manager, nil
+}
+
+// RedisCache is an ACME certificate cache in Redis, so every node of a
+// cluster serves the certificates one of them obtained
+type RedisCache struct {
+	client *redis.Client
+}
+
+var _ autocert.Cache = (*RedisCache)(nil)
+
+// NewRedisCache returns an ACME certificate cache backed by client
+func NewRedisCache(client *redis.Client) *RedisCache {
+	return &RedisCache{client: client}
+}
+
+// Get returns the data stored under key, autocert.ErrCacheMiss when there is none
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.client.Get(ctx, redisCachePrefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, err
+}
+
+// Put stores data under key
+func (c *RedisCache) Put(ctx context.Context, key string, data []byte) error {
+	return c.client.Set(ctx, redisCachePrefix+key, data, 0).Err()
+}
+
+// Delete removes the data stored under key
+func (c *RedisCache) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, redisCachePrefix+key).Err()
+}
@@ -0,0 +1,97 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestRedisCache(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer client.Close()
+	cache := NewRedisCache(client)
+
+	_, err := cache.Get(ctx, "shop.example.com")
+	assert.ErrorIs(t, err, autocert.ErrCacheMiss)
+
+	require.NoError(t, cache.Put(ctx, "shop.example.com", []byte("certificate")))
+	data, err := cache.Get(ctx, "shop.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "certificate", string(data))
+
+	// Every node reading the same Redis sees it
+	data, err = NewRedisCache(client).Get(ctx, "shop.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "certificate", string(data))
+
+	require.NoError(t, cache.Delete(ctx, "shop.example.com"))
+	_, err = cache.Get(ctx, "shop.example.com")
+	assert.ErrorIs(t, err, autocert.ErrCacheMiss)
+}
+
+func TestNewManager(t *testing.T) {
+	dir := t.TempDir()
+	manager, err := NewManager(config.TLSConfig{CertDir: dir}, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, autocert.DirCache(dir), manager.Cache)
+	assert.Nil(t, manager.Client, "the default directory is used")
+
+	_, err = NewManager(config.TLSConfig{ACMECache: CacheRedis}, nil, nil)
+	assert.Error(t, err)
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer client.Close()
+	manager, err = NewManager(config.TLSConfig{ACMECache: CacheRedis}, client, nil)
+	require.NoError(t, err)
+	assert.IsType(t, &RedisCache{}, manager.Cache)
+
+	_, err = NewManager(config.TLSConfig{ACMECache: "memcached"}, nil, nil)
+	assert.Error(t, err)
+
+	// A test directory and the CA its certificate is signed by
+	caFile := filepath.Join(dir, "ca.pem")
+	writeCert(t, caFile, "", time.Now().Add(time.Hour), "ca.test")
+	manager, err = NewManager(config.TLSConfig{ACMEDirectory: "https://localhost:14000/dir", ACMERootCA: caFile}, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "https://localhost:14000/dir", manager.Client.DirectoryURL)
+	assert.NotNil(t, manager.Client.HTTPClient)
+
+	_, err = NewManager(config.TLSConfig{ACMERootCA: filepath.Join(dir, "missing.pem")}, nil, nil)
+	assert.Error(t, err)
+}
+
+// TestIssueWithPebble obtains a certificate from a local Pebble server,
+// started with PEBBLE_VA_ALWAYS_VALID=1 so no challenge has to be reachable:
+//
+//	VELOFLUX_TEST_ACME_DIRECTORY=https://localhost:14000/dir \
+//	VELOFLUX_TEST_ACME_ROOT_CA=test/certs/pebble.minica.pem go test ./internal/certs
+func TestIssueWithPebble(t *testing.T) {
+	directory := os.Getenv("VELOFLUX_TEST_ACME_DIRECTORY")
+	if directory == "" {
+		t.Skip("VELOFLUX_TEST_ACME_DIRECTORY is not set")
+	}
+
+	manager, err := NewManager(config.TLSConfig{
+		ACMEDirectory: directory,
+		ACMERootCA:    os.Getenv("VELOFLUX_TEST_ACME_ROOT_CA"),
+		CertDir:       t.TempDir(),
+	}, nil, autocert.HostWhitelist("shop.example.com"))
+	require.NoError(t, err)
+
+	cert, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "shop.example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shop.example.com"}, cert.Leaf.DNSNames)
+
+	_, err = manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	assert.Error(t, err, "hosts the policy refuses get no certificate")
+}
@@ -0,0 +1,190 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+)
+
+// DefaultOCSPInterval is how often OCSP responses are fetched again
+const DefaultOCSPInterval = time.Hour
+
+// maxOCSPResponseSize bounds the OCSP responses read from responders
+const maxOCSPResponseSize = 1 << 20
+
+var ocspClient = &http.Client{Timeout: 10 * time.Second}
+
+// StapleOCSP fetches the OCSP responses of the certificates from the
+// responders they name and staples them to the handshakes serving them. A
+// certificate whose response cannot be fetched keeps the one it had.
+func (s *Store) StapleOCSP(ctx context.Context) error {
+	var errs []error
+	fetched := make(map[string][]byte)
+	for _, cert := range s.set.Load().certificates() {
+		staple, err := fetchOCSP(ctx, cert)
+		if err != nil {
+			metrics.OCSPFetchesTotal.WithLabelValues(reloadFailure).Inc()
+			errs = append(errs, fmt.Errorf("fetching OCSP response for %s: %w", cert.Leaf.Subject, err))
+			continue
+		}
+		if staple != nil {
+			metrics.OCSPFetchesTotal.WithLabelValues(reloadSuccess).Inc()
+			fetched[string(cert.Certificate[0])] = staple
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := s.set.Load()
+	// Only the certificates still loaded keep a response
+	staples := make(map[string][]byte)
+	for _, cert := range set.certificates() {
+		key := string(cert.Certificate[0])
+		if staple, ok := fetched[key]; ok {
+			staples[key] = staple
+		} else if staple, ok := s.staples[key]; ok {
+			staples[key] = staple
+		}
+	}
+	s.staples = staples
+	s.set.Store(set.withStaples(staples))
+	return errors.Join(errs...)
+}
+
+// WatchOCSP keeps OCSP responses stapled until ctx is done
+func (s *Store) WatchOCSP(ctx context.Context) {
+	ticker := time.NewTicker(DefaultOCSPInterval)
+	defer ticker.Stop()
+
+	for {
+		s.staple(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Store) staple(ctx context.Context) {
+	if err := s.StapleOCSP(ctx); err != nil && ctx.Err() == nil {
+		s.logger.Warn("Failed to staple OCSP responses", zap.Error(err))
+	}
+}
+
+// fetchOCSP returns the OCSP response for a certificate, nil when it names
+// no responder or comes without its issuer
+func fetchOCSP(ctx context.Context, cert *tls.Certificate) ([]byte, error) {
+	if len(cert.Leaf.OCSPServer) == 0 || len(cert.Certificate) < 2 {
+		return nil, nil
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, err
+	}
+	body, err := ocsp.CreateRequest(cert.Leaf, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cert.Leaf.OCSPServer[0], bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	resp, err := ocspClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder returned %s", resp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxOCSPResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := ocsp.ParseResponseForCert(raw, cert.Leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Status != ocsp.Good {
+		return nil, fmt.Errorf("certificate status is %s", ocspStatus(parsed.Status))
+	}
+	if !parsed.NextUpdate.IsZero() && parsed.NextUpdate.Before(time.Now()) {
+		return nil, errors.New("OCSP response is stale")
+	}
+	return raw, nil
+}
+
+func ocspStatus(status int) string {
+	switch status {
+	case ocsp.Revoked:
+		return "revoked"
+	case ocsp.Unknown:
+		return "unknown"
+	}
+	return "good"
+}
+
+// certificates returns every certificate of the set once
+func (set *certSet) certificates() []*tls.Certificate {
+	seen := make(map[*tls.Certificate]bool)
+	var certs []*tls.Certificate
+	add := func(cert *tls.Certificate) {
+		if cert != nil && !seen[cert] {
+			seen[cert] = true
+			certs = append(certs, cert)
+		}
+	}
+	for _, cert := range set.exact {
+		add(cert)
+	}
+	for _, cert := range set.wildcard {
+		add(cert)
+	}
+	add(set.fallback)
+	return certs
+}
+
+// withStaples returns a copy of the set whose certificates carry the OCSP
+// responses; certificates in use by handshakes are never modified
+func (set *certSet) withStaples(staples map[string][]byte) *certSet {
+	copies := make(map[*tls.Certificate]*tls.Certificate)
+	stapled := func(cert *tls.Certificate) *tls.Certificate {
+		if cert == nil {
+			return nil
+		}
+		if c, ok := copies[cert]; ok {
+			return c
+		}
+		c := *cert
+		c.OCSPStaple = staples[string(cert.Certificate[0])]
+		copies[cert] = &c
+		return &c
+	}
+
+	stapledSet := &certSet{
+		exact:    make(map[string]*tls.Certificate, len(set.exact)),
+		wildcard: make(map[string]*tls.Certificate, len(set.wildcard)),
+		fallback: stapled(set.fallback),
+	}
+	for name, cert := range set.exact {
+		stapledSet.exact[name] = stapled(cert)
+	}
+	for name, cert := range set.wildcard {
+		stapledSet.wildcard[name] = stapled(cert)
+	}
+	return stapledSet
+}
@@ -0,0 +1,130 @@
+package certs
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspResponder answers OCSP requests for certificates of a test CA with the
+// status it is set to
+func ocspResponder(t *testing.T, ca *x509.Certificate, key crypto.Signer, status *atomic.Int64) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       int(status.Load()),
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now().Add(-time.Minute),
+		}, key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestStapleOCSP(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	var status atomic.Int64
+	status.Store(ocsp.Good)
+	responder := ocspResponder(t, ca, caKey, &status)
+
+	// The certificate file holds the chain up to the CA
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "site.example.com"},
+		DNSNames:     []string{"site.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{responder},
+	}, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "site.crt"), filepath.Join(dir, "site.key")
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...)
+	require.NoError(t, os.WriteFile(certFile, chain, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	store, err := NewStore(config.TLSConfig{
+		Certificates: []config.CertificateFiles{{CertFile: certFile, KeyFile: keyFile}},
+		OCSPStapling: true,
+	}, zap.NewNop())
+	require.NoError(t, err)
+	assert.Empty(t, store.Match("site.example.com").OCSPStaple)
+
+	successes := testutil.ToFloat64(metrics.OCSPFetchesTotal.WithLabelValues(reloadSuccess))
+	require.NoError(t, store.StapleOCSP(context.Background()))
+	staple := store.Match("site.example.com").OCSPStaple
+	require.NotEmpty(t, staple)
+	resp, err := ocsp.ParseResponse(staple, ca)
+	require.NoError(t, err)
+	assert.Equal(t, ocsp.Good, resp.Status)
+	assert.Equal(t, successes+1, testutil.ToFloat64(metrics.OCSPFetchesTotal.WithLabelValues(reloadSuccess)))
+
+	// A revoked certificate keeps the response fetched before
+	status.Store(ocsp.Revoked)
+	assert.Error(t, store.StapleOCSP(context.Background()))
+	assert.Equal(t, staple, store.Match("site.example.com").OCSPStaple)
+
+	// Certificates loaded again keep their response
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	reloaded, err := store.Reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	assert.Equal(t, staple, store.Match("site.example.com").OCSPStaple)
+}
@@ -0,0 +1,83 @@
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var curves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// ServerConfig returns the TLS settings of a server for a TLS configuration,
+// serving the certificates getCertificate returns. Only cipher suites without
+// known weaknesses can be selected.
+func ServerConfig(cfg config.TLSConfig, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		GetCertificate: getCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	var err error
+	if cfg.MinVersion != "" {
+		if tlsConfig.MinVersion, err = parseVersion(cfg.MinVersion); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.MaxVersion != "" {
+		if tlsConfig.MaxVersion, err = parseVersion(cfg.MaxVersion); err != nil {
+			return nil, err
+		}
+		if tlsConfig.MaxVersion < tlsConfig.MinVersion {
+			return nil, fmt.Errorf("TLS max version %s is below the min version", cfg.MaxVersion)
+		}
+	}
+
+	for _, name := range cfg.CipherSuites {
+		id, ok := cipherSuite(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+	}
+
+	for _, name := range cfg.CurvePreferences {
+		id, ok := curves[strings.ToUpper(strings.ReplaceAll(name, "-", ""))]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		tlsConfig.CurvePreferences = append(tlsConfig.CurvePreferences, id)
+	}
+	return tlsConfig, nil
+}
+
+func parseVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(version), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", version)
+	}
+	return v, nil
+}
+
+func cipherSuite(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,40 @@
+package certs
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerConfig(t *testing.T) {
+	tlsConfig, err := ServerConfig(config.TLSConfig{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+	assert.Zero(t, tlsConfig.MaxVersion)
+	assert.Equal(t, []string{"h2", "http/1.1"}, tlsConfig.NextProtos)
+
+	tlsConfig, err = ServerConfig(config.TLSConfig{
+		MinVersion:       "1.2",
+		MaxVersion:       "TLS1.3",
+		CipherSuites:     []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"},
+		CurvePreferences: []string{"X25519", "P-256"},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MaxVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}, tlsConfig.CipherSuites)
+	assert.Equal(t, []tls.CurveID{tls.X25519, tls.CurveP256}, tlsConfig.CurvePreferences)
+
+	for name, cfg := range map[string]config.TLSConfig{
+		"unknown version": {MinVersion: "1.4"},
+		"inverted range":  {MinVersion: "1.3", MaxVersion: "1.2"},
+		"insecure suite":  {CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		"unknown suite":   {CipherSuites: []string{"TLS_NULL"}},
+		"unknown curve":   {CurvePreferences: []string{"P192"}},
+	} {
+		_, err := ServerConfig(cfg, nil)
+		assert.Error(t, err, name)
+	}
+}
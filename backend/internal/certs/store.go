@@ -0,0 +1,314 @@
+// Package certs serves TLS certificates loaded from files, choosing them by
+// the server name clients ask for and reloading them when they change.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// DefaultReloadInterval is how often certificate files are checked for
+// changes when the configuration does not say otherwise
+const DefaultReloadInterval = 30 * time.Second
+
+// Results of certificate reloads
+const (
+	reloadSuccess = "success"
+	reloadFailure = "failure"
+)
+
+// Store holds the certificates of a TLS configuration
+type Store struct {
+	cfg    config.TLSConfig
+	logger *zap.Logger
+
+	set atomic.Pointer[certSet]
+
+	mu      sync.Mutex        // serializes reloads
+	version string            // fingerprint of the files the set was loaded from
+	staples map[string][]byte // OCSP responses by DER certificate
+}
+
+// certSet is an immutable snapshot of loaded certificates
+type certSet struct {
+	exact    map[string]*tls.Certificate // by lower case name
+	wildcard map[string]*tls.Certificate // *.example.com by example.com
+	fallback *tls.Certificate
+}
+
+// pair is a certificate and key file pair to load
+type pair struct {
+	cert string
+	key  string
+}
+
+// NewStore loads the certificates of a TLS configuration. It fails when any
+// of them cannot be loaded.
+func NewStore(cfg config.TLSConfig, logger *zap.Logger) (*Store, error) {
+	s := &Store{cfg: cfg, logger: logger}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Configured reports whether a TLS configuration has certificate files
+func Configured(cfg config.TLSConfig) bool {
+	return len(cfg.Certificates) > 0 || cfg.CertificatesDir != ""
+}
+
+// Match returns the certificate for a server name, or nil when none of the
+// certificates covers it
+func (s *Store) Match(serverName string) *tls.Certificate {
+	set := s.set.Load()
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if cert, ok := set.exact[name]; ok {
+		return cert
+	}
+	// A wildcard covers a single label
+	if _, parent, found := strings.Cut(name, "."); found {
+		if cert, ok := set.wildcard[parent]; ok {
+			return cert
+		}
+	}
+	return nil
+}
+
+// GetCertificate returns the certificate for the server name of a TLS
+// handshake, the default certificate when none covers it
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := s.Match(hello.ServerName); cert != nil {
+		return cert, nil
+	}
+	return s.set.Load().fallback, nil
+}
+
+// Reload loads the certificates again when their files changed since the last
+// load. It reports whether it did; on errors the certificates loaded before
+// stay in use.
+func (s *Store) Reload() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reloaded, err := s.reload()
+	if err != nil {
+		metrics.CertificateReloadsTotal.WithLabelValues(reloadFailure).Inc()
+		return false, err
+	}
+	if reloaded {
+		metrics.CertificateReloadsTotal.WithLabelValues(reloadSuccess).Inc()
+	}
+	return reloaded, nil
+}
+
+func (s *Store) reload() (bool, error) {
+	pairs, err := s.pairs()
+	if err != nil {
+		return false, err
+	}
+	version, err := fingerprint(pairs)
+	if err != nil {
+		return false, err
+	}
+	if s.set.Load() != nil && version == s.version {
+		return false, nil
+	}
+	set, err := s.load(pairs)
+	if err != nil {
+		return false, err
+	}
+	s.set.Store(set)
+	s.version = version
+	return true, nil
+}
+
+// Watch reloads changed certificates until ctx is done
+func (s *Store) Watch(ctx context.Context) {
+	interval := s.cfg.ReloadInterval
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := s.Reload()
+		if err != nil {
+			s.logger.Error("Failed to reload TLS certificates", zap.Error(err))
+		} else if reloaded {
+			s.logger.Info("Reloaded TLS certificates")
+			if s.cfg.OCSPStapling {
+				s.staple(ctx)
+			}
+		}
+	}
+}
+
+// pairs lists the certificate files of the configuration
+func (s *Store) pairs() ([]pair, error) {
+	pairs := make([]pair, 0, len(s.cfg.Certificates))
+	for _, files := range s.cfg.Certificates {
+		pairs = append(pairs, pair{cert: files.CertFile, key: files.KeyFile})
+	}
+	if s.cfg.CertificatesDir == "" {
+		return pairs, nil
+	}
+
+	entries, err := os.ReadDir(s.cfg.CertificatesDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".crt" && ext != ".pem") {
+			continue
+		}
+		cert := filepath.Join(s.cfg.CertificatesDir, entry.Name())
+		key := strings.TrimSuffix(cert, ext) + ".key"
+		if _, err := os.Stat(key); err != nil {
+			// The key is in the certificate file
+			key = cert
+		}
+		pairs = append(pairs, pair{cert: cert, key: key})
+	}
+	return pairs, nil
+}
+
+// fingerprint identifies the state of the files, changing whenever one of
+// them is written, replaced, added or removed
+func fingerprint(pairs []pair) (string, error) {
+	var b strings.Builder
+	for _, p := range pairs {
+		for _, path := range []string{p.cert, p.key} {
+			info, err := os.Stat(path)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return b.String(), nil
+}
+
+func (s *Store) load(pairs []pair) (*certSet, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("no TLS certificates found")
+	}
+
+	set := &certSet{
+		exact:    make(map[string]*tls.Certificate),
+		wildcard: make(map[string]*tls.Certificate),
+	}
+	type expiry struct {
+		source, name string
+		notAfter     time.Time
+	}
+	expiries := make([]expiry, 0, len(pairs))
+	foundDefault := false
+
+	for _, p := range pairs {
+		cert, err := tls.LoadX509KeyPair(p.cert, p.key)
+		if err != nil {
+			return nil, fmt.Errorf("loading certificate %s: %w", p.cert, err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate %s: %w", p.cert, err)
+		}
+		cert.Leaf = leaf
+		cert.OCSPStaple = s.staples[string(cert.Certificate[0])]
+
+		names := leaf.DNSNames
+		if len(names) == 0 && leaf.Subject.CommonName != "" {
+			names = []string{leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if parent, ok := strings.CutPrefix(name, "*."); ok {
+				addName(set.wildcard, parent, &cert)
+			} else {
+				addName(set.exact, name, &cert)
+			}
+		}
+
+		if p.cert == s.cfg.DefaultCertificate {
+			set.fallback = &cert
+			foundDefault = true
+		} else if set.fallback == nil {
+			set.fallback = &cert
+		}
+		primary := leaf.Subject.CommonName
+		if len(names) > 0 {
+			primary = names[0]
+		}
+		expiries = append(expiries, expiry{source: p.cert, name: primary, notAfter: leaf.NotAfter})
+	}
+
+	if s.cfg.DefaultCertificate != "" && !foundDefault {
+		return nil, fmt.Errorf("default certificate %s is not among the certificates", s.cfg.DefaultCertificate)
+	}
+
+	// Certificates that are gone must not be reported
+	metrics.CertificateExpiry.Reset()
+	for _, e := range expiries {
+		metrics.CertificateExpiry.WithLabelValues(e.source, e.name).Set(float64(e.notAfter.Unix()))
+	}
+	return set, nil
+}
+
+// addName keeps the certificate for a name that expires last when several
+// cover it, so a renewed certificate wins over the one it replaces
+func addName(names map[string]*tls.Certificate, name string, cert *tls.Certificate) {
+	if current, ok := names[name]; ok && current.Leaf.NotAfter.After(cert.Leaf.NotAfter) {
+		return
+	}
+	names[name] = cert
+}
+
+// Source returns the certificate callback of a TLS server serving the
+// certificates of the store and, for the names they do not cover, the ones
+// the ACME manager issues. Either may be nil; Source returns nil when both are.
+func Source(store *Store, manager *autocert.Manager) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	switch {
+	case store == nil && manager == nil:
+		return nil
+	case manager == nil:
+		return store.GetCertificate
+	case store == nil:
+		return manager.GetCertificate
+	}
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		// ACME TLS-ALPN challenges are answered by the manager
+		if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+			return manager.GetCertificate(hello)
+		}
+		if cert := store.Match(hello.ServerName); cert != nil {
+			return cert, nil
+		}
+		if cert, err := manager.GetCertificate(hello); err == nil {
+			return cert, nil
+		}
+		return store.GetCertificate(hello)
+	}
+}
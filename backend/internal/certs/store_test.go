@@ -0,0 +1,163 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// writeCert writes a self-signed certificate for the names expiring at
+// notAfter. An empty keyFile puts the key in the certificate file.
+func writeCert(t *testing.T, certFile, keyFile string, notAfter time.Time, names ...string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if keyFile == "" {
+		require.NoError(t, os.WriteFile(certFile, append(certPEM, keyPEM...), 0o600))
+		return
+	}
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+}
+
+func serverName(t *testing.T, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error), name string) string {
+	cert, err := getCertificate(&tls.ClientHelloInfo{ServerName: name})
+	require.NoError(t, err)
+	require.NotNil(t, cert)
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestStoreSelectsBySNI(t *testing.T) {
+	dir := t.TempDir()
+	expiry := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)
+	files := map[string][]string{
+		"api":      {"api.example.com"},
+		"wildcard": {"*.example.com", "example.com"},
+		"other":    {"other.test"},
+	}
+	cfg := config.TLSConfig{DefaultCertificate: filepath.Join(dir, "other.crt")}
+	for name, names := range files {
+		certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+		writeCert(t, certFile, keyFile, expiry, names...)
+		cfg.Certificates = append(cfg.Certificates, config.CertificateFiles{CertFile: certFile, KeyFile: keyFile})
+	}
+
+	store, err := NewStore(cfg, zap.NewNop())
+	require.NoError(t, err)
+
+	assert.Equal(t, "api.example.com", serverName(t, store.GetCertificate, "API.example.com"))
+	assert.Equal(t, "*.example.com", serverName(t, store.GetCertificate, "www.example.com"))
+	assert.Equal(t, "*.example.com", serverName(t, store.GetCertificate, "example.com"))
+	// Wildcards cover a single label
+	assert.Nil(t, store.Match("a.b.example.com"))
+	assert.Equal(t, "other.test", serverName(t, store.GetCertificate, "a.b.example.com"))
+	assert.Equal(t, "other.test", serverName(t, store.GetCertificate, ""))
+
+	source := filepath.Join(dir, "api.crt")
+	assert.Equal(t, float64(expiry.Unix()), testutil.ToFloat64(metrics.CertificateExpiry.WithLabelValues(source, "api.example.com")))
+
+	cfg.DefaultCertificate = filepath.Join(dir, "missing.crt")
+	_, err = NewStore(cfg, zap.NewNop())
+	assert.Error(t, err)
+}
+
+func TestStoreLoadsDirectory(t *testing.T) {
+	dir := t.TempDir()
+	expiry := time.Now().Add(time.Hour)
+	writeCert(t, filepath.Join(dir, "a.crt"), filepath.Join(dir, "a.key"), expiry, "a.example.com")
+	writeCert(t, filepath.Join(dir, "b.pem"), "", expiry, "b.example.com")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a certificate"), 0o600))
+
+	store, err := NewStore(config.TLSConfig{CertificatesDir: dir}, zap.NewNop())
+	require.NoError(t, err)
+	assert.NotNil(t, store.Match("a.example.com"))
+	assert.NotNil(t, store.Match("b.example.com"))
+
+	_, err = NewStore(config.TLSConfig{CertificatesDir: t.TempDir()}, zap.NewNop())
+	assert.Error(t, err)
+}
+
+func TestStoreReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "site.crt"), filepath.Join(dir, "site.key")
+	writeCert(t, certFile, keyFile, time.Now().Add(time.Hour), "old.example.com")
+
+	store, err := NewStore(config.TLSConfig{
+		Certificates: []config.CertificateFiles{{CertFile: certFile, KeyFile: keyFile}},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	reloaded, err := store.Reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+
+	// A renewed certificate is picked up
+	writeCert(t, certFile, keyFile, time.Now().Add(2*time.Hour), "new.example.com")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+	reloaded, err = store.Reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.NotNil(t, store.Match("new.example.com"))
+	assert.Nil(t, store.Match("old.example.com"))
+	// Only the certificates loaded last are reported
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.CertificateExpiry))
+
+	// A broken file leaves the loaded certificates in place
+	failures := testutil.ToFloat64(metrics.CertificateReloadsTotal.WithLabelValues(reloadFailure))
+	require.NoError(t, os.WriteFile(keyFile, []byte("garbage"), 0o600))
+	_, err = store.Reload()
+	assert.Error(t, err)
+	assert.NotNil(t, store.Match("new.example.com"))
+	assert.Equal(t, failures+1, testutil.ToFloat64(metrics.CertificateReloadsTotal.WithLabelValues(reloadFailure)))
+}
+
+func TestSource(t *testing.T) {
+	assert.Nil(t, Source(nil, nil))
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "site.crt"), filepath.Join(dir, "site.key")
+	writeCert(t, certFile, keyFile, time.Now().Add(time.Hour), "site.example.com")
+	store, err := NewStore(config.TLSConfig{
+		Certificates: []config.CertificateFiles{{CertFile: certFile, KeyFile: keyFile}},
+	}, zap.NewNop())
+	require.NoError(t, err)
+
+	// Names the files cover never reach the ACME manager
+	manager := &autocert.Manager{Prompt: autocert.AcceptTOS, HostPolicy: autocert.HostWhitelist("acme.example.com")}
+	getCertificate := Source(store, manager)
+	assert.Equal(t, "site.example.com", serverName(t, getCertificate, "site.example.com"))
+
+	// Names the manager refuses get the default certificate
+	assert.Equal(t, "site.example.com", serverName(t, getCertificate, "unknown.example.com"))
+}
@@ -0,0 +1,95 @@
+package certs
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// ticketKeyPrefix namespaces the session ticket key of every period in Redis
+const ticketKeyPrefix = "vf:tls:ticket_key:"
+
+// TicketKeys rotates the keys encrypting TLS session tickets. Every node
+// derives the same keys from Redis, so a session resumes on any of them.
+type TicketKeys struct {
+	client   *redis.Client
+	interval time.Duration
+	logger   *zap.Logger
+}
+
+// NewTicketKeys returns session ticket keys replaced every interval
+func NewTicketKeys(client *redis.Client, interval time.Duration, logger *zap.Logger) *TicketKeys {
+	return &TicketKeys{client: client, interval: interval, logger: logger}
+}
+
+// Keys returns the keys for a time: the key of its period, which encrypts new
+// tickets, then the keys of the previous and next periods, which still
+// decrypt tickets issued before the rotation or by nodes whose clocks run
+// ahead. The first node asking for the key of a period creates it.
+func (k *TicketKeys) Keys(ctx context.Context, now time.Time) ([][32]byte, error) {
+	period := now.UnixNano() / int64(k.interval)
+	keys := make([][32]byte, 0, 3)
+	for _, p := range []int64{period, period - 1, period + 1} {
+		key, err := k.key(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (k *TicketKeys) key(ctx context.Context, period int64) ([32]byte, error) {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return key, err
+	}
+
+	// Keys outlive the periods they decrypt tickets for
+	name := fmt.Sprintf("%s%d", ticketKeyPrefix, period)
+	if err := k.client.SetNX(ctx, name, key[:], 4*k.interval).Err(); err != nil {
+		return key, err
+	}
+	stored, err := k.client.Get(ctx, name).Bytes()
+	if err != nil {
+		return key, err
+	}
+	if len(stored) != len(key) {
+		return key, fmt.Errorf("session ticket key %s has %d bytes", name, len(stored))
+	}
+	copy(key[:], stored)
+	return key, nil
+}
+
+// Update sets the current keys on a TLS configuration
+func (k *TicketKeys) Update(ctx context.Context, tlsConfig *tls.Config) error {
+	keys, err := k.Keys(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	tlsConfig.SetSessionTicketKeys(keys)
+	return nil
+}
+
+// Rotate updates the keys of a TLS configuration at the start of every period
+// until ctx is done
+func (k *TicketKeys) Rotate(ctx context.Context, tlsConfig *tls.Config) {
+	for {
+		next := (time.Now().UnixNano()/int64(k.interval) + 1) * int64(k.interval)
+		timer := time.NewTimer(time.Until(time.Unix(0, next)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := k.Update(ctx, tlsConfig); err != nil && ctx.Err() == nil {
+			k.logger.Error("Failed to rotate TLS session ticket keys", zap.Error(err))
+		}
+	}
+}
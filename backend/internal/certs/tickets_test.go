@@ -0,0 +1,43 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestTicketKeysAreShared(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer client.Close()
+	interval := time.Hour
+	node1 := NewTicketKeys(client, interval, zap.NewNop())
+	node2 := NewTicketKeys(client, interval, zap.NewNop())
+
+	now := time.Unix(0, 0).Add(100 * interval)
+	keys, err := node1.Keys(ctx, now)
+	require.NoError(t, err)
+	require.Len(t, keys, 3)
+	assert.NotEqual(t, keys[0], keys[1])
+
+	// Another node gets the same keys in the same period
+	other, err := node2.Keys(ctx, now.Add(interval/2))
+	require.NoError(t, err)
+	assert.Equal(t, keys, other)
+
+	// After a rotation the next key encrypts and the former one still decrypts
+	rotated, err := node2.Keys(ctx, now.Add(interval))
+	require.NoError(t, err)
+	assert.Equal(t, keys[2], rotated[0])
+	assert.Equal(t, keys[0], rotated[1])
+
+	tlsConfig := &tls.Config{}
+	require.NoError(t, node1.Update(ctx, tlsConfig))
+}
@@ -0,0 +1,201 @@
+// Package clientip resolves the address of the client behind a request. The
+// headers proxies use to pass it on are only believed when the proxy that
+// sent them is trusted, so clients cannot pick the address they are seen with.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Resolver finds the client address of requests, walking the proxy chain of
+// the forwarding headers from the nearest hop for as long as the hops are
+// trusted. A nil Resolver trusts no proxy.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// New returns a resolver trusting the proxies in the given CIDRs or addresses
+func New(trustedProxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			r.trusted = append(r.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		r.trusted = append(r.trusted, network)
+	}
+	return r, nil
+}
+
+// Trusts reports whether an address belongs to a trusted proxy
+func (r *Resolver) Trusts(ip net.IP) bool {
+	if r == nil || ip == nil {
+		return false
+	}
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustsAddr reports whether the peer of a connection is a trusted proxy
+func (r *Resolver) TrustsAddr(addr net.Addr) bool {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return r.Trusts(a.IP)
+	case *net.UDPAddr:
+		return r.Trusts(a.IP)
+	}
+	return false
+}
+
+// Resolve returns the client address of a request. Starting from the peer,
+// it moves one hop up the Forwarded header, or X-Forwarded-For without one,
+// while the current hop is a trusted proxy. A trusted peer sending neither
+// may name the client in X-Real-IP. Hops that are not addresses, such as
+// obfuscated identifiers, end the walk at the proxy that sent them.
+func (r *Resolver) Resolve(req *http.Request) net.IP {
+	ip := RemoteIP(req)
+	if !r.Trusts(ip) {
+		return ip
+	}
+
+	hops := forwardedFor(req.Header)
+	if hops == nil {
+		hops = forwardedHops(req.Header.Values("X-Forwarded-For"))
+	}
+	if hops == nil {
+		if realIP := parseNode(req.Header.Get("X-Real-IP")); realIP != nil {
+			return realIP
+		}
+		return ip
+	}
+
+	for i := len(hops) - 1; i >= 0 && r.Trusts(ip); i-- {
+		hop := parseNode(hops[i])
+		if hop == nil {
+			break
+		}
+		ip = hop
+	}
+	return ip
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying a resolved client address
+func NewContext(ctx context.Context, ip net.IP) context.Context {
+	return context.WithValue(ctx, contextKey{}, ip)
+}
+
+// FromContext returns the client address a context carries
+func FromContext(ctx context.Context) (net.IP, bool) {
+	ip, ok := ctx.Value(contextKey{}).(net.IP)
+	return ip, ok && ip != nil
+}
+
+// FromRequest returns the client address resolved for a request, or its
+// peer address when it was not resolved, never one taken from its headers
+func FromRequest(req *http.Request) net.IP {
+	if ip, ok := FromContext(req.Context()); ok {
+		return ip
+	}
+	return RemoteIP(req)
+}
+
+// RemoteIP returns the address of the peer that sent a request
+func RemoteIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return net.ParseIP(req.RemoteAddr)
+	}
+	return net.ParseIP(host)
+}
+
+// forwardedHops splits the values of a list header into its entries, from
+// the client to the nearest proxy
+func forwardedHops(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// forwardedFor returns the "for" parameters of the RFC 7239 Forwarded
+// header, one per proxy element and empty for elements without one
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values("Forwarded") {
+		for _, element := range splitQuoted(value, ',') {
+			var node string
+			for _, pair := range splitQuoted(element, ';') {
+				name, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(strings.TrimSpace(name), "for") {
+					node = strings.TrimSpace(val)
+				}
+			}
+			hops = append(hops, node)
+		}
+	}
+	return hops
+}
+
+// splitQuoted splits s at sep outside of quoted strings
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseNode returns the address of a hop written as an IP, optionally with a
+// port, IPv6 ones bracketed then, and quoted in Forwarded headers
+func parseNode(node string) net.IP {
+	node = strings.TrimSpace(node)
+	if len(node) >= 2 && node[0] == '"' && node[len(node)-1] == '"' {
+		node = strings.ReplaceAll(node[1:len(node)-1], `\`, "")
+	}
+	if strings.HasPrefix(node, "[") {
+		end := strings.IndexByte(node, ']')
+		if end < 0 {
+			return nil
+		}
+		return net.ParseIP(node[1:end])
+	}
+	if strings.Count(node, ":") == 1 {
+		node, _, _ = strings.Cut(node, ":")
+	}
+	return net.ParseIP(node)
+}
@@ -0,0 +1,88 @@
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	r, err := New([]string{"10.0.0.0/8", " 192.0.2.1 ", "2001:db8::/32", "::1"})
+	require.NoError(t, err)
+	assert.True(t, r.Trusts(net.ParseIP("10.1.2.3")))
+	assert.True(t, r.Trusts(net.ParseIP("192.0.2.1")))
+	assert.False(t, r.Trusts(net.ParseIP("192.0.2.2")))
+	assert.True(t, r.Trusts(net.ParseIP("2001:db8::7")))
+	assert.True(t, r.Trusts(net.ParseIP("::1")))
+	assert.True(t, r.TrustsAddr(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80}))
+	assert.False(t, r.TrustsAddr(&net.UnixAddr{Name: "/tmp/socket"}))
+
+	_, err = New([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = New([]string{"proxy.internal"})
+	assert.Error(t, err)
+
+	var none *Resolver
+	assert.False(t, none.Trusts(net.ParseIP("10.0.0.1")))
+}
+
+func TestResolve(t *testing.T) {
+	r, err := New([]string{"10.0.0.0/8", "2001:db8::/32"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		{"direct client", "198.51.100.1:1234", nil, "198.51.100.1"},
+		{"untrusted peer naming another client", "198.51.100.1:1234", map[string][]string{
+			"X-Forwarded-For": {"203.0.113.9"},
+			"Forwarded":       {"for=203.0.113.9"},
+			"X-Real-IP":       {"203.0.113.9"},
+		}, "198.51.100.1"},
+		{"trusted proxy", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"203.0.113.9"}}, "203.0.113.9"},
+		{"chain of trusted proxies", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"203.0.113.9, 10.0.0.2", "10.0.0.3"}}, "203.0.113.9"},
+		{"client prepending a spoofed hop", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"1.1.1.1, 203.0.113.9, 10.0.0.2"}}, "203.0.113.9"},
+		{"only trusted hops", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"hop with a port", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"203.0.113.9:5555"}}, "203.0.113.9"},
+		{"garbage hop", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"203.0.113.9, nonsense"}}, "10.0.0.1"},
+		{"X-Real-IP", "10.0.0.1:1234", map[string][]string{"X-Real-IP": {"203.0.113.9"}}, "203.0.113.9"},
+		{"Forwarded", "10.0.0.1:1234", map[string][]string{"Forwarded": {`for=203.0.113.9;proto=https, for="[2001:db8::1]:4711";by=10.0.0.1`}}, "203.0.113.9"},
+		{"Forwarded over X-Forwarded-For", "10.0.0.1:1234", map[string][]string{
+			"Forwarded":       {"for=203.0.113.9"},
+			"X-Forwarded-For": {"198.51.100.1"},
+		}, "203.0.113.9"},
+		{"Forwarded IPv6 client", "[2001:db8::2]:443", map[string][]string{"Forwarded": {`For="[2001:db9::1]"`}}, "2001:db9::1"},
+		{"Forwarded quoted separators", "10.0.0.1:1234", map[string][]string{"Forwarded": {`for=203.0.113.9;host="a,b;c"`}}, "203.0.113.9"},
+		{"Forwarded obfuscated client", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=_hidden, for=10.0.0.2"}}, "10.0.0.2"},
+		{"Forwarded element without for", "10.0.0.1:1234", map[string][]string{"Forwarded": {"proto=https"}}, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			for name, values := range tt.headers {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+			assert.Equal(t, tt.want, r.Resolve(req).String())
+		})
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+	assert.Equal(t, "10.0.0.1", FromRequest(req).String())
+
+	req = req.WithContext(NewContext(req.Context(), net.ParseIP("203.0.113.9")))
+	assert.Equal(t, "203.0.113.9", FromRequest(req).String())
+}
@@ -5,6 +5,10 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
 	"os"
 	"time"
 
@@ -21,8 +25,9 @@ type Config struct {
 	Tenant        TenantConfig        `yaml:"tenant"`
 	Billing       BillingConfig       `yaml:"billing"`
 	Orchestration OrchestrationConfig `yaml:"orchestration"`
-	Tenants       []Tenant            `yaml:"tenants"` // Tenant-specific configurations
-	API           APIConfig           `yaml:"api"`     // API server configuration
+	Tenants       []Tenant            `yaml:"tenants"`   // Tenant-specific configurations
+	API           APIConfig           `yaml:"api"`       // API server configuration
+	Listeners     []Listener          `yaml:"listeners"` // TCP and UDP load balancing
 }
 
 // APIConfig holds configuration for the API server
@@ -38,6 +43,8 @@ type GlobalConfig struct {
 	TLSBindAddress string          `yaml:"tls_bind_address"`
 	MetricsAddress string          `yaml:"metrics_address"`
 	TLS            TLSConfig       `yaml:"tls"`
+	HTTP3          HTTP3Config     `yaml:"http3"`
+	ClientIP       ClientIPConfig  `yaml:"client_ip"`
 	HealthCheck    HealthConfig    `yaml:"health_check"`
 	RateLimit      RateLimitConfig `yaml:"rate_limit"`
 	WAF            WAFConfig       `yaml:"waf"`
@@ -67,12 +74,61 @@ type TLSConfig struct {
 	AutoCert  bool   `yaml:"auto_cert"`
 	ACMEEmail string `yaml:"acme_email"`
 	CertDir   string `yaml:"cert_dir"`
+
+	// ACME issuance settings, also used for tenants' custom domains. Pointing
+	// them at a test server such as Pebble exercises issuance locally.
+	ACMEDirectory string `yaml:"acme_directory"` // directory URL, defaults to Let's Encrypt
+	ACMERootCA    string `yaml:"acme_root_ca"`   // PEM file of CAs trusted for the directory, the system ones when empty
+	ACMECache     string `yaml:"acme_cache"`     // "dir" to keep certificates in cert_dir, or "redis" to share them across nodes
+	CustomDomains bool   `yaml:"custom_domains"` // issue certificates on demand for domains registered by tenants, refusing other names
+
+	// Certificates served by the name clients ask for (SNI), alongside or
+	// instead of ACME ones. Files are reloaded when they change on disk.
+	Certificates       []CertificateFiles `yaml:"certificates"`
+	CertificatesDir    string             `yaml:"certificates_dir"`    // name.crt or name.pem files, each with name.key unless the key is in the same file
+	DefaultCertificate string             `yaml:"default_certificate"` // cert file served to clients without SNI or with unknown names, defaults to the first certificate
+	ReloadInterval     time.Duration      `yaml:"reload_interval"`     // how often files are checked for changes, defaults to 30s
+
+	// Protocol settings offered to clients
+	MinVersion            string        `yaml:"min_version"`             // "1.0" to "1.3", defaults to 1.2
+	MaxVersion            string        `yaml:"max_version"`             // defaults to the highest supported
+	CipherSuites          []string      `yaml:"cipher_suites"`           // names such as TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, for TLS 1.2 and below
+	CurvePreferences      []string      `yaml:"curve_preferences"`       // X25519, P256, P384 or P521, in order of preference
+	SessionTicketRotation time.Duration `yaml:"session_ticket_rotation"` // rotates session ticket keys shared by the nodes through Redis, per node keys when unset
+	OCSPStapling          bool          `yaml:"ocsp_stapling"`           // staple OCSP responses to the certificate files
+}
+
+// HTTP3Config serves HTTP/3 over QUIC next to the HTTPS listener, with the
+// same routes and certificates. HTTPS responses advertise it with Alt-Svc.
+type HTTP3Config struct {
+	Enabled        bool          `yaml:"enabled"`
+	Address        string        `yaml:"address"`         // UDP address, defaults to tls_bind_address
+	AdvertisedPort int           `yaml:"advertised_port"` // port announced in Alt-Svc when clients reach the listener on another one
+	IdleTimeout    time.Duration `yaml:"idle_timeout"`    // closes connections without requests, defaults to 60s
+}
+
+// ClientIPConfig names the proxies in front of VeloFlux. Only they can tell
+// the address of the client they forward with the X-Forwarded-For, Forwarded
+// and X-Real-IP headers or a PROXY protocol header; any other peer is taken
+// to be the client. Rate limits, WAF rules, geo routing and access logs use
+// the resolved address.
+type ClientIPConfig struct {
+	TrustedProxies []string `yaml:"trusted_proxies"` // CIDRs or addresses, none by default
+	ProxyProtocol  bool     `yaml:"proxy_protocol"`  // read PROXY protocol v1 or v2 headers from trusted proxies on the HTTP and HTTPS listeners
+}
+
+// CertificateFiles is a PEM certificate chain and its private key
+type CertificateFiles struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
 }
 
 type HealthConfig struct {
-	Interval time.Duration `yaml:"interval"`
-	Timeout  time.Duration `yaml:"timeout"`
-	Retries  int           `yaml:"retries"`
+	Interval           time.Duration `yaml:"interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+	Retries            int           `yaml:"retries"`             // deprecated, used as unhealthy_threshold when unset
+	HealthyThreshold   int           `yaml:"healthy_threshold"`   // consecutive successes to mark a backend healthy
+	UnhealthyThreshold int           `yaml:"unhealthy_threshold"` // consecutive failures to mark a backend unhealthy
 }
 
 type RateLimitConfig struct {
@@ -105,10 +161,145 @@ type ClusterConfig struct {
 }
 
 type Pool struct {
-	Name           string    `yaml:"name"`
-	Algorithm      string    `yaml:"algorithm"`
-	StickySessions bool      `yaml:"sticky_sessions"`
-	Backends       []Backend `yaml:"backends"`
+	Name             string           `yaml:"name"`
+	Algorithm        string           `yaml:"algorithm"`
+	StickySessions   bool             `yaml:"sticky_sessions"`
+	Backends         []Backend        `yaml:"backends"`
+	OutlierDetection OutlierDetection `yaml:"outlier_detection"`
+	Upstream         UpstreamConfig   `yaml:"upstream"`
+	HashPolicy       HashPolicy       `yaml:"hash_policy"` // used by the ring_hash and maglev algorithms
+	SlowStart        SlowStart        `yaml:"slow_start"`
+	Sticky           StickyConfig     `yaml:"sticky"` // used when sticky_sessions is enabled
+	Upgrades         UpgradeConfig    `yaml:"upgrades"`
+}
+
+// UpgradeConfig limits the WebSocket and other upgraded connections proxied
+// to a pool. Upgraded connections last until either side closes them.
+type UpgradeConfig struct {
+	MaxConnections int           `yaml:"max_connections"` // zero means no limit
+	IdleTimeout    time.Duration `yaml:"idle_timeout"`    // close connections without traffic for this long, zero means never
+	DrainTimeout   time.Duration `yaml:"drain_timeout"`   // how long connections may outlive a drain, defaults to 10s
+}
+
+// Listener protocols
+const (
+	ListenerTCP = "tcp"
+	ListenerUDP = "udp"
+)
+
+// Listener balances the TCP connections or UDP flows it accepts over a pool,
+// picking a backend per connection or flow with the pool's algorithm. Pools
+// served by listeners should use tcp health checks.
+type Listener struct {
+	Name           string        `yaml:"name"`     // defaults to the address
+	Protocol       string        `yaml:"protocol"` // tcp (default) or udp
+	Address        string        `yaml:"address"`
+	Pool           string        `yaml:"pool"`
+	ProxyProtocol  int           `yaml:"proxy_protocol"`  // PROXY protocol header sent to the backend: 0 (none), 1 or 2; UDP supports only 2
+	AcceptProxy    bool          `yaml:"accept_proxy"`    // read PROXY protocol headers sent by the trusted proxies of client_ip, TCP only
+	IdleTimeout    time.Duration `yaml:"idle_timeout"`    // close connections and flows without traffic for this long, defaults to 5m for TCP and 30s for UDP
+	ConnectTimeout time.Duration `yaml:"connect_timeout"` // defaults to 5s
+	MaxConnections int           `yaml:"max_connections"` // open connections or flows, zero means no limit
+	DrainTimeout   time.Duration `yaml:"drain_timeout"`   // how long connections may outlive a drain, defaults to 10s
+}
+
+// Sticky session affinity sources
+const (
+	AffinityCookie    = "cookie"     // signed cookie issued by the proxy
+	AffinityHeader    = "header"     // request header set by the client
+	AffinityAppCookie = "app_cookie" // cookie set by the application
+)
+
+// StickyConfig controls how requests are pinned to a backend. Issued cookies
+// carry an opaque, signed reference to the backend, so every node sharing the
+// secret honours them. Header and application cookie keys are looked up in a
+// bounded per-node table, optionally shared through the cluster Redis.
+type StickyConfig struct {
+	Source     string        `yaml:"source"`      // cookie (default), header or app_cookie
+	Name       string        `yaml:"name"`        // cookie or header name, default veloflux for issued cookies
+	TTL        time.Duration `yaml:"ttl"`         // default 1h
+	Secret     string        `yaml:"secret"`      // HMAC key of issued cookies, random per process when unset
+	MaxEntries int           `yaml:"max_entries"` // affinity table size per node, default 100000
+	Shared     bool          `yaml:"shared"`      // share the affinity table through Redis
+}
+
+// SlowStart ramps the share of traffic a backend receives after it is added
+// or recovers, from MinWeightPercent of its weight up to the full weight.
+// The share after a fraction t of the duration is
+// min + (1 - min) * t^(1/aggression), so an aggression of 1 is linear and
+// higher values ramp faster early on.
+type SlowStart struct {
+	Duration         time.Duration `yaml:"duration"`           // 0 disables slow start
+	Aggression       float64       `yaml:"aggression"`         // default 1
+	MinWeightPercent float64       `yaml:"min_weight_percent"` // default 10
+}
+
+// Hash key sources
+const (
+	HashSourceIP     = "ip"
+	HashSourceHeader = "header"
+	HashSourceCookie = "cookie"
+	HashSourcePath   = "path"
+)
+
+// HashPolicy selects the request attribute that consistent hashing keys on.
+// Requests without the attribute fall back to the client IP.
+type HashPolicy struct {
+	Source          string `yaml:"source"`            // ip (default), header, cookie or path
+	Name            string `yaml:"name"`              // header or cookie name
+	Segment         int    `yaml:"segment"`           // zero-based URL path segment
+	VirtualNodes    int    `yaml:"virtual_nodes"`     // ring_hash points of a backend of average weight, default 160
+	MaglevTableSize int    `yaml:"maglev_table_size"` // a prime of at least the backend count, default 65537
+}
+
+// Upstream schemes
+const (
+	UpstreamHTTP  = "http"
+	UpstreamHTTPS = "https"
+	UpstreamH2C   = "h2c" // HTTP/2 without TLS, as gRPC backends commonly speak
+)
+
+// UpstreamConfig controls how the proxy and the health checker connect to
+// the backends of a pool. Zero values select the transport defaults.
+type UpstreamConfig struct {
+	Scheme string      `yaml:"scheme"` // http (default), https or h2c
+	TLS    UpstreamTLS `yaml:"tls"`
+
+	MaxIdleConns          int           `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost   int           `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int           `yaml:"max_conns_per_host"` // zero means no limit
+	IdleConnTimeout       time.Duration `yaml:"idle_conn_timeout"`
+	DialTimeout           time.Duration `yaml:"dial_timeout"`
+	KeepAlive             time.Duration `yaml:"keep_alive"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"` // zero means no timeout
+	DisableKeepAlives     bool          `yaml:"disable_keep_alives"`
+	DisableHTTP2          bool          `yaml:"disable_http2"`
+}
+
+// UpstreamTLS holds the client TLS settings used towards backends. Setting a
+// certificate and key enables mTLS.
+type UpstreamTLS struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"` // SNI and verification name, defaults to the backend host
+	MinVersion         string `yaml:"min_version"` // 1.0, 1.1, 1.2 (default) or 1.3
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// OutlierDetection configures passive health checking for a pool. Backends are
+// ejected after consecutive failures observed on live traffic and restored
+// once their ejection time has elapsed.
+type OutlierDetection struct {
+	Enabled                  bool          `yaml:"enabled"`
+	Consecutive5xx           int           `yaml:"consecutive_5xx"`
+	ConsecutiveGatewayErrors int           `yaml:"consecutive_gateway_errors"`
+	ConsecutiveSlow          int           `yaml:"consecutive_slow"`
+	LatencyThreshold         time.Duration `yaml:"latency_threshold"` // zero disables latency ejection
+	BaseEjectionTime         time.Duration `yaml:"base_ejection_time"`
+	MaxEjectionTime          time.Duration `yaml:"max_ejection_time"`
+	MaxEjectionPercent       int           `yaml:"max_ejection_percent"`
 }
 
 type Backend struct {
@@ -117,17 +308,182 @@ type Backend struct {
 	HealthCheck HealthCheck `yaml:"health_check"`
 }
 
+// Health check probe types
+const (
+	ProbeHTTP  = "http"
+	ProbeHTTPS = "https"
+	ProbeTCP   = "tcp"
+	ProbeGRPC  = "grpc"
+)
+
 type HealthCheck struct {
-	Path           string        `yaml:"path"`
-	Interval       time.Duration `yaml:"interval"`
-	Timeout        time.Duration `yaml:"timeout"`
-	ExpectedStatus int           `yaml:"expected_status"`
+	Type               string            `yaml:"type"` // http (default, grpc for h2c pools), https, tcp or grpc
+	Path               string            `yaml:"path"`
+	Interval           time.Duration     `yaml:"interval"`
+	Timeout            time.Duration     `yaml:"timeout"`
+	ExpectedStatus     int               `yaml:"expected_status"`
+	Headers            map[string]string `yaml:"headers"`
+	Host               string            `yaml:"host"`       // Host header or gRPC authority override
+	BodyMatch          string            `yaml:"body_match"` // regular expression the response body must match
+	GRPCService        string            `yaml:"grpc_service"`
+	TLS                bool              `yaml:"tls"` // use TLS for tcp and grpc probes
+	TLSServerName      string            `yaml:"tls_server_name"`
+	TLSSkipVerify      bool              `yaml:"tls_skip_verify"`
+	TLSCAFile          string            `yaml:"tls_ca_file"`
+	TLSCertFile        string            `yaml:"tls_cert_file"`
+	TLSKeyFile         string            `yaml:"tls_key_file"`
+	HealthyThreshold   int               `yaml:"healthy_threshold"`
+	UnhealthyThreshold int               `yaml:"unhealthy_threshold"`
 }
 
+// Route sends the requests matching all of its conditions to a pool. Routes
+// are tried from the highest priority down, in configuration order among
+// routes of the same priority.
 type Route struct {
-	Host       string `yaml:"host"`
-	Pool       string `yaml:"pool"`
-	PathPrefix string `yaml:"path_prefix"`
+	ID          string          `yaml:"id"` // stable identifier, derived from the match conditions when unset
+	Host        string          `yaml:"host"`
+	Pool        string          `yaml:"pool"`
+	PathPrefix  string          `yaml:"path_prefix"`
+	PathRegex   string          `yaml:"path_regex"` // must match the whole URL path
+	Methods     []string        `yaml:"methods"`
+	Headers     []ValueMatch    `yaml:"headers"`
+	Query       []ValueMatch    `yaml:"query"`
+	Cookies     []ValueMatch    `yaml:"cookies"`
+	SourceCIDRs []string        `yaml:"source_cidrs"`
+	Priority    int             `yaml:"priority"` // higher priorities are tried first
+	Tenant      string          `yaml:"tenant"`   // tenant owning the route, exposed to transformations
+	Retry       RetryPolicy     `yaml:"retry"`
+	Hedge       HedgePolicy     `yaml:"hedge"`
+	Transform   Transform       `yaml:"transform"`
+	Split       TrafficSplit    `yaml:"split"` // spreads requests over several pools instead of Pool
+	Mirror      MirrorPolicy    `yaml:"mirror"`
+	Streaming   StreamingPolicy `yaml:"streaming"`
+	GRPC        GRPCRoute       `yaml:"grpc"`
+	ClientAuth  ClientAuth      `yaml:"client_auth"`
+}
+
+// Client certificate verification modes
+const (
+	ClientAuthOptional = "optional"
+	ClientAuthRequired = "required"
+)
+
+// ClientAuth verifies the TLS client certificates of the requests of a route
+// against a CA bundle. Verified identities are forwarded to backends in the
+// X-Client-Cert-Subject, X-Client-Cert-SAN and X-Client-Cert-SPIFFE-ID headers.
+type ClientAuth struct {
+	Mode   string `yaml:"mode"`    // "optional" verifies certificates clients present, "required" rejects requests without one
+	CAFile string `yaml:"ca_file"` // PEM bundle of the CAs client certificates must chain to
+}
+
+// GRPCRoute makes a route proxy gRPC calls over HTTP/2, balancing every call
+// on its own. The route then only matches gRPC requests for its services and
+// methods, and its pool must use the h2c or https upstream scheme.
+type GRPCRoute struct {
+	Enabled  bool     `yaml:"enabled"`
+	Services []string `yaml:"services"` // fully qualified service names such as helloworld.Greeter, any when empty
+	Methods  []string `yaml:"methods"`  // method names within those services, any when empty
+}
+
+// ValueMatch matches a named request header, query parameter or cookie. With
+// Regex set one of its values must match the expression, with Value set one
+// of them must equal it, and with neither it only has to be present.
+type ValueMatch struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+	Regex string `yaml:"regex"`
+}
+
+// TrafficSplit spreads the requests of a route over several pools, for
+// example to send a small share of the traffic to a canary release.
+type TrafficSplit struct {
+	Variants []SplitVariant `yaml:"variants"`
+	Sticky   bool           `yaml:"sticky"` // keep clients on their variant with a cookie
+	Cookie   string         `yaml:"cookie"` // name of that cookie, defaults to veloflux_split
+	TTL      time.Duration  `yaml:"ttl"`    // lifetime of that cookie, defaults to 24h
+}
+
+// SplitVariant is a pool receiving a share of a route's requests proportional
+// to its weight. Requests matching all of its headers and cookies are always
+// sent to it, whatever its weight.
+type SplitVariant struct {
+	Pool    string       `yaml:"pool"`
+	Weight  int          `yaml:"weight"`
+	Headers []ValueMatch `yaml:"headers"`
+	Cookies []ValueMatch `yaml:"cookies"`
+}
+
+// Transform rewrites requests of a route before they are proxied and their
+// responses before they are returned. Host, query and header values may
+// reference the variables ${client_ip}, ${request_id}, ${geo_country},
+// ${tenant}, ${route}, ${host}, ${scheme}, ${method} and ${path}, which
+// describe the request as received.
+type Transform struct {
+	StripPrefix     string            `yaml:"strip_prefix"`     // removed from the start of the path, whole segments only
+	AddPrefix       string            `yaml:"add_prefix"`       // prepended after stripping; both together replace a prefix
+	PathRegex       string            `yaml:"path_regex"`       // rewrites paths matching the expression
+	PathReplacement string            `yaml:"path_replacement"` // may reference capture groups as $1 or ${name}
+	Host            string            `yaml:"host"`             // overrides the Host header sent upstream
+	Query           map[string]string `yaml:"query"`            // parameters set on the upstream query
+	RequestHeaders  HeaderRules       `yaml:"request_headers"`
+	ResponseHeaders HeaderRules       `yaml:"response_headers"`
+}
+
+// HeaderRules edits headers. Remove runs first, then Set replaces any values
+// and Add appends one.
+type HeaderRules struct {
+	Set    map[string]string `yaml:"set"`
+	Add    map[string]string `yaml:"add"`
+	Remove []string          `yaml:"remove"`
+}
+
+// Retry conditions
+const (
+	RetryOnConnectFailure = "connect-failure"
+	RetryOnReset          = "reset"
+	RetryOn502            = "502"
+	RetryOn503            = "503"
+	RetryOn504            = "504"
+)
+
+// RetryPolicy retries failed requests on other backends of the pool. Retries
+// are limited by a budget so they cannot amplify an outage.
+type RetryPolicy struct {
+	Attempts            int           `yaml:"attempts"` // retries after the first attempt, zero disables retries
+	RetryOn             []string      `yaml:"retry_on"` // defaults to every retry condition
+	BackoffBase         time.Duration `yaml:"backoff_base"`
+	BackoffMax          time.Duration `yaml:"backoff_max"`
+	BudgetRatio         float64       `yaml:"budget_ratio"` // retries allowed per request over the last 10 seconds
+	MinRetriesPerSecond int           `yaml:"min_retries_per_second"`
+	MaxBodyBytes        int64         `yaml:"max_body_bytes"` // requests with larger bodies are not retried
+}
+
+// HedgePolicy sends extra copies of slow idempotent requests without a body
+// to other backends and serves whichever response arrives first.
+type HedgePolicy struct {
+	Enabled     bool          `yaml:"enabled"`
+	Delay       time.Duration `yaml:"delay"`        // wait before sending each hedged request
+	MaxAttempts int           `yaml:"max_attempts"` // total requests sent, including the first
+}
+
+// MirrorPolicy copies a sample of a route's requests to another pool, for
+// example to try a new release on production traffic. Copies are sent in the
+// background and their responses are discarded.
+type MirrorPolicy struct {
+	Pool         string        `yaml:"pool"`           // mirroring is enabled when set
+	Percent      float64       `yaml:"percent"`        // share of requests copied, defaults to 100
+	MaxBodyBytes int64         `yaml:"max_body_bytes"` // requests with larger bodies are not copied, defaults to 64KiB
+	Timeout      time.Duration `yaml:"timeout"`        // defaults to 5s
+	MaxInFlight  int           `yaml:"max_in_flight"`  // copies beyond this many pending ones are dropped, defaults to 100
+}
+
+// StreamingPolicy tunes how the responses of a route reach clients, for
+// Server-Sent Events and other long-lived or chunked responses. Event streams
+// and responses of unknown length are flushed as they arrive unless buffered.
+type StreamingPolicy struct {
+	FlushInterval       time.Duration `yaml:"flush_interval"`        // flush other responses this often, negative after every write
+	Buffering           bool          `yaml:"buffering"`             // hold responses in the write buffer instead of flushing them
+	DisableWriteTimeout bool          `yaml:"disable_write_timeout"` // lift the server write timeout so responses can stream indefinitely
 }
 
 // RedisConfig holds Redis configuration
@@ -223,6 +579,12 @@ func Load(path string) (*Config, error) {
 	if cfg.Global.TLSBindAddress == "" {
 		cfg.Global.TLSBindAddress = "0.0.0.0:443"
 	}
+	if cfg.Global.HTTP3.Address == "" {
+		cfg.Global.HTTP3.Address = cfg.Global.TLSBindAddress
+	}
+	if cfg.Global.HTTP3.IdleTimeout == 0 {
+		cfg.Global.HTTP3.IdleTimeout = 60 * time.Second
+	}
 	if cfg.Global.MetricsAddress == "" {
 		cfg.Global.MetricsAddress = "0.0.0.0:8080"
 	}
@@ -235,11 +597,27 @@ func Load(path string) (*Config, error) {
 	if cfg.Global.HealthCheck.Retries == 0 {
 		cfg.Global.HealthCheck.Retries = 3
 	}
+	if cfg.Global.HealthCheck.UnhealthyThreshold == 0 {
+		cfg.Global.HealthCheck.UnhealthyThreshold = cfg.Global.HealthCheck.Retries
+	}
+	if cfg.Global.HealthCheck.HealthyThreshold == 0 {
+		cfg.Global.HealthCheck.HealthyThreshold = 1
+	}
 
 	if cfg.Global.WAF.RulesetPath == "" {
 		cfg.Global.WAF.Enabled = false
 	}
 
+	if err := assignRouteIDs(cfg.Routes); err != nil {
+		return nil, err
+	}
+	if err := validatePools(cfg.Pools); err != nil {
+		return nil, err
+	}
+	if err := validateListeners(cfg.Listeners); err != nil {
+		return nil, err
+	}
+
 	// Set cluster defaults
 	if cfg.Cluster.HeartbeatInterval == 0 {
 		cfg.Cluster.HeartbeatInterval = 5 * time.Second
@@ -250,3 +628,103 @@ func Load(path string) (*Config, error) {
 
 	return &cfg, nil
 }
+
+// RouteID derives a stable identifier for a route from its match conditions,
+// so the same route keeps its ID across reloads, restarts and cluster nodes.
+func RouteID(route Route) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%q\x00%d\x00%q", route.Host, route.PathPrefix, route.PathRegex,
+		route.Methods, route.Priority, route.SourceCIDRs)
+	for _, matches := range [][]ValueMatch{route.Headers, route.Query, route.Cookies} {
+		fmt.Fprintf(h, "\x00%q", matches)
+	}
+	if route.GRPC.Enabled {
+		// Only gRPC routes hash it, so the IDs of other routes stay the same
+		fmt.Fprintf(h, "\x00grpc\x00%q\x00%q", route.GRPC.Services, route.GRPC.Methods)
+	}
+	return "route-" + hex.EncodeToString(h.Sum(nil)[:6])
+}
+
+// assignRouteIDs gives every route without an ID a derived one. Routes with
+// identical conditions get a numeric suffix in configuration order.
+func assignRouteIDs(routes []Route) error {
+	seen := make(map[string]bool, len(routes))
+	for i := range routes {
+		if routes[i].ID == "" {
+			continue
+		}
+		if seen[routes[i].ID] {
+			return fmt.Errorf("duplicate route id %q", routes[i].ID)
+		}
+		seen[routes[i].ID] = true
+	}
+
+	for i := range routes {
+		if routes[i].ID != "" {
+			continue
+		}
+		id := RouteID(routes[i])
+		for n := 2; seen[id]; n++ {
+			id = fmt.Sprintf("%s-%d", RouteID(routes[i]), n)
+		}
+		routes[i].ID = id
+		seen[id] = true
+	}
+	return nil
+}
+
+// validatePools checks the settings of the pools
+func validatePools(pools []Pool) error {
+	for _, pool := range pools {
+		size := pool.HashPolicy.MaglevTableSize
+		if size == 0 {
+			continue
+		}
+		if size < 2 || !big.NewInt(int64(size)).ProbablyPrime(0) {
+			return fmt.Errorf("pool %s: maglev_table_size %d is not a prime", pool.Name, size)
+		}
+		if size < len(pool.Backends) {
+			return fmt.Errorf("pool %s: maglev_table_size %d is smaller than its %d backends", pool.Name, size, len(pool.Backends))
+		}
+	}
+	return nil
+}
+
+// validateListeners checks the listeners and names the unnamed ones after
+// their address
+func validateListeners(listeners []Listener) error {
+	seen := make(map[string]bool, len(listeners))
+	for i := range listeners {
+		l := &listeners[i]
+		if l.Address == "" {
+			return fmt.Errorf("listener %d has no address", i)
+		}
+		if l.Pool == "" {
+			return fmt.Errorf("listener %s has no pool", l.Address)
+		}
+		if l.Name == "" {
+			l.Name = l.Address
+		}
+		if seen[l.Name] {
+			return fmt.Errorf("duplicate listener name %q", l.Name)
+		}
+		seen[l.Name] = true
+
+		switch l.Protocol {
+		case "":
+			l.Protocol = ListenerTCP
+		case ListenerTCP, ListenerUDP:
+		default:
+			return fmt.Errorf("listener %s: unknown protocol %q", l.Name, l.Protocol)
+		}
+		switch {
+		case l.ProxyProtocol < 0 || l.ProxyProtocol > 2:
+			return fmt.Errorf("listener %s: unknown PROXY protocol version %d", l.Name, l.ProxyProtocol)
+		case l.ProxyProtocol == 1 && l.Protocol == ListenerUDP:
+			return fmt.Errorf("listener %s: PROXY protocol v1 does not support UDP", l.Name)
+		case l.AcceptProxy && l.Protocol == ListenerUDP:
+			return fmt.Errorf("listener %s: PROXY protocol headers are only accepted over TCP", l.Name)
+		}
+	}
+	return nil
+}
@@ -237,3 +237,62 @@ global:
 	assert.Equal(t, "blocking", config.Global.WAF.Level)
 	assert.Equal(t, "/etc/waf/rules.conf", config.Global.WAF.RulesetPath)
 }
+
+func TestRouteIDs(t *testing.T) {
+	route := Route{Host: "example.com", PathPrefix: "/api", Pool: "web"}
+	assert.Equal(t, RouteID(route), RouteID(route))
+
+	// The pool is not part of the match, so repointing a route keeps its ID
+	repointed := route
+	repointed.Pool = "other"
+	assert.Equal(t, RouteID(route), RouteID(repointed))
+
+	narrowed := route
+	narrowed.Methods = []string{"GET"}
+	assert.NotEqual(t, RouteID(route), RouteID(narrowed))
+
+	rpc := route
+	rpc.GRPC = GRPCRoute{Enabled: true, Services: []string{"helloworld.Greeter"}}
+	assert.NotEqual(t, RouteID(route), RouteID(rpc))
+
+	routes := []Route{route, {ID: "explicit", Host: "example.com"}, route}
+	require.NoError(t, assignRouteIDs(routes))
+	assert.Equal(t, RouteID(route), routes[0].ID)
+	assert.Equal(t, "explicit", routes[1].ID)
+	assert.Equal(t, RouteID(route)+"-2", routes[2].ID)
+
+	assert.Error(t, assignRouteIDs([]Route{{ID: "dup"}, {ID: "dup"}}))
+}
+
+func TestValidatePools(t *testing.T) {
+	pool := func(size, backends int) Pool {
+		return Pool{Name: "hash", Backends: make([]Backend, backends), HashPolicy: HashPolicy{MaglevTableSize: size}}
+	}
+	require.NoError(t, validatePools([]Pool{pool(0, 3), pool(65537, 3), pool(3, 3)}))
+	for _, invalid := range []Pool{pool(1, 0), pool(-7, 0), pool(65536, 3), pool(9, 3), pool(2, 3)} {
+		assert.Error(t, validatePools([]Pool{invalid}), "%+v", invalid.HashPolicy)
+	}
+}
+
+func TestValidateListeners(t *testing.T) {
+	listeners := []Listener{
+		{Address: ":5432", Pool: "postgres"},
+		{Name: "dns", Protocol: ListenerUDP, Address: ":53", Pool: "dns", ProxyProtocol: 2},
+	}
+	require.NoError(t, validateListeners(listeners))
+	assert.Equal(t, ":5432", listeners[0].Name)
+	assert.Equal(t, ListenerTCP, listeners[0].Protocol)
+
+	invalid := []Listener{
+		{Pool: "postgres"},
+		{Address: ":5432"},
+		{Address: ":5432", Pool: "postgres", Protocol: "sctp"},
+		{Address: ":5432", Pool: "postgres", ProxyProtocol: 3},
+		{Address: ":53", Pool: "dns", Protocol: ListenerUDP, ProxyProtocol: 1},
+		{Address: ":53", Pool: "dns", Protocol: ListenerUDP, AcceptProxy: true},
+	}
+	for _, listener := range invalid {
+		assert.Error(t, validateListeners([]Listener{listener}), "%+v", listener)
+	}
+	assert.Error(t, validateListeners([]Listener{{Address: ":1", Pool: "a"}, {Name: ":1", Address: ":2", Pool: "a"}}))
+}
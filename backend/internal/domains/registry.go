@@ -0,0 +1,200 @@
+// Package domains keeps the custom domains tenants registered, so their
+// requests reach the tenant's routes and certificates are only issued for
+// registered names.
+package domains
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"golang.org/x/net/idna"
+)
+
+// DefaultRefreshInterval is how often the domains registered through other
+// nodes are picked up
+const DefaultRefreshInterval = 10 * time.Second
+
+// registryKey is the Redis hash of tenant IDs by domain
+const registryKey = "vf:domains"
+
+var (
+	// ErrInvalidDomain is returned for names that cannot be a custom domain
+	ErrInvalidDomain = errors.New("invalid domain")
+	// ErrDomainTaken is returned when another tenant registered the domain
+	ErrDomainTaken = errors.New("domain registered by another tenant")
+	// ErrDomainNotFound is returned when the tenant did not register the domain
+	ErrDomainNotFound = errors.New("domain not registered")
+)
+
+// Registry holds the custom domains of every tenant. Writes go to Redis;
+// lookups on the request path use a local copy refreshed periodically.
+type Registry struct {
+	client *redis.Client
+	logger *zap.Logger
+
+	mu      sync.Mutex // serializes updates of the local copy
+	tenants atomic.Pointer[map[string]string]
+}
+
+// New returns a registry stored in Redis
+func New(client *redis.Client, logger *zap.Logger) *Registry {
+	r := &Registry{client: client, logger: logger}
+	r.tenants.Store(&map[string]string{})
+	return r
+}
+
+// Normalize returns the canonical form of a custom domain: lower case ASCII
+// without a trailing dot. Addresses, wildcards and single labels are invalid.
+func Normalize(domain string) (string, error) {
+	name, err := idna.Lookup.ToASCII(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidDomain, err)
+	}
+	if name == "" || !strings.Contains(name, ".") || strings.ContainsAny(name, "*:") || net.ParseIP(name) != nil {
+		return "", fmt.Errorf("%w: %q", ErrInvalidDomain, domain)
+	}
+	return name, nil
+}
+
+// Register assigns a domain to a tenant. Registering a domain the tenant
+// already has is not an error.
+func (r *Registry) Register(ctx context.Context, tenantID, domain string) error {
+	name, err := Normalize(domain)
+	if err != nil {
+		return err
+	}
+	added, err := r.client.HSetNX(ctx, registryKey, name, tenantID).Result()
+	if err != nil {
+		return err
+	}
+	if !added {
+		owner, err := r.client.HGet(ctx, registryKey, name).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if owner != tenantID {
+			return ErrDomainTaken
+		}
+	}
+	r.update(func(tenants map[string]string) { tenants[name] = tenantID })
+	return nil
+}
+
+// Remove takes a domain away from the tenant that registered it
+func (r *Registry) Remove(ctx context.Context, tenantID, domain string) error {
+	name, err := Normalize(domain)
+	if err != nil {
+		return err
+	}
+	owner, err := r.client.HGet(ctx, registryKey, name).Result()
+	if err == redis.Nil || (err == nil && owner != tenantID) {
+		return ErrDomainNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := r.client.HDel(ctx, registryKey, name).Err(); err != nil {
+		return err
+	}
+	r.update(func(tenants map[string]string) { delete(tenants, name) })
+	return nil
+}
+
+// Domains returns the domains a tenant registered, sorted
+func (r *Registry) Domains(ctx context.Context, tenantID string) ([]string, error) {
+	all, err := r.client.HGetAll(ctx, registryKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	domains := []string{}
+	for name, owner := range all {
+		if owner == tenantID {
+			domains = append(domains, name)
+		}
+	}
+	sort.Strings(domains)
+	return domains, nil
+}
+
+// TenantForHost returns the tenant that registered the host of a request,
+// which may carry a port
+func (r *Registry) TenantForHost(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	tenantID, ok := (*r.tenants.Load())[strings.ToLower(strings.TrimSuffix(host, "."))]
+	return tenantID, ok
+}
+
+// HostPolicy accepts certificate requests for registered domains only. It
+// reads Redis so a domain can be served by any node as soon as it is added.
+func (r *Registry) HostPolicy(ctx context.Context, host string) error {
+	name, err := Normalize(host)
+	if err != nil {
+		metrics.ACMERefusedHostsTotal.Inc()
+		return err
+	}
+	err = r.client.HGet(ctx, registryKey, name).Err()
+	if err == redis.Nil {
+		metrics.ACMERefusedHostsTotal.Inc()
+		return fmt.Errorf("host %q is not a registered domain", name)
+	}
+	return err
+}
+
+// Refresh replaces the local copy with the domains stored in Redis
+func (r *Registry) Refresh(ctx context.Context) error {
+	all, err := r.client.HGetAll(ctx, registryKey).Result()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tenants.Store(&all)
+	metrics.CustomDomains.Set(float64(len(all)))
+	return nil
+}
+
+// Watch refreshes the local copy every interval until ctx is done
+func (r *Registry) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := r.Refresh(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Error("Failed to refresh custom domains", zap.Error(err))
+		}
+	}
+}
+
+// update changes a copy of the local domains and swaps it in
+func (r *Registry) update(change func(map[string]string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current := *r.tenants.Load()
+	tenants := make(map[string]string, len(current)+1)
+	for name, owner := range current {
+		tenants[name] = owner
+	}
+	change(tenants)
+	r.tenants.Store(&tenants)
+	metrics.CustomDomains.Set(float64(len(tenants)))
+}
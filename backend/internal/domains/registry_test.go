@@ -0,0 +1,97 @@
+package domains
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestRegistry(t *testing.T) (*Registry, *redis.Client) {
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { client.Close() })
+	return New(client, zap.NewNop()), client
+}
+
+func TestNormalize(t *testing.T) {
+	for input, want := range map[string]string{
+		"Shop.Example.COM":  "shop.example.com",
+		"shop.example.com.": "shop.example.com",
+		"bücher.example":    "xn--bcher-kva.example",
+	} {
+		name, err := Normalize(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, want, name)
+	}
+
+	for _, input := range []string{"", "localhost", "*.example.com", "192.0.2.1", "example.com:443", "bad_name.example.com"} {
+		_, err := Normalize(input)
+		assert.ErrorIs(t, err, ErrInvalidDomain, input)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	registry, _ := newTestRegistry(t)
+
+	require.NoError(t, registry.Register(ctx, "acme", "Shop.Example.com"))
+	require.NoError(t, registry.Register(ctx, "acme", "shop.example.com"), "registering again is not an error")
+	require.NoError(t, registry.Register(ctx, "acme", "www.acme.test"))
+	assert.ErrorIs(t, registry.Register(ctx, "other", "shop.example.com"), ErrDomainTaken)
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.CustomDomains))
+
+	names, err := registry.Domains(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shop.example.com", "www.acme.test"}, names)
+	names, err = registry.Domains(ctx, "other")
+	require.NoError(t, err)
+	assert.Empty(t, names)
+
+	tenantID, ok := registry.TenantForHost("SHOP.example.com:8443")
+	assert.True(t, ok)
+	assert.Equal(t, "acme", tenantID)
+	_, ok = registry.TenantForHost("example.com")
+	assert.False(t, ok)
+
+	assert.ErrorIs(t, registry.Remove(ctx, "other", "shop.example.com"), ErrDomainNotFound)
+	require.NoError(t, registry.Remove(ctx, "acme", "shop.example.com"))
+	assert.ErrorIs(t, registry.Remove(ctx, "acme", "shop.example.com"), ErrDomainNotFound)
+	_, ok = registry.TenantForHost("shop.example.com")
+	assert.False(t, ok)
+}
+
+func TestRegistryRefresh(t *testing.T) {
+	ctx := context.Background()
+	registry, client := newTestRegistry(t)
+	// Another node shares the same Redis
+	other := New(client, zap.NewNop())
+
+	require.NoError(t, other.Register(ctx, "acme", "shop.example.com"))
+	_, ok := registry.TenantForHost("shop.example.com")
+	assert.False(t, ok, "lookups use the local copy")
+
+	require.NoError(t, registry.Refresh(ctx))
+	tenantID, ok := registry.TenantForHost("shop.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "acme", tenantID)
+}
+
+func TestHostPolicy(t *testing.T) {
+	ctx := context.Background()
+	registry, client := newTestRegistry(t)
+	require.NoError(t, New(client, zap.NewNop()).Register(ctx, "acme", "shop.example.com"))
+
+	// Domains registered on any node are accepted before the next refresh
+	assert.NoError(t, registry.HostPolicy(ctx, "shop.example.com"))
+
+	refused := testutil.ToFloat64(metrics.ACMERefusedHostsTotal)
+	assert.Error(t, registry.HostPolicy(ctx, "unknown.example.com"))
+	assert.Error(t, registry.HostPolicy(ctx, "192.0.2.1"))
+	assert.Equal(t, refused+2, testutil.ToFloat64(metrics.ACMERefusedHostsTotal))
+}
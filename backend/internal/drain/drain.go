@@ -4,15 +4,25 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
+// redisTimeout bounds the Redis calls made off the request path, so a slow
+// Redis does not hold up connections waiting on them.
+const redisTimeout = time.Second
+
 // Manager coordinates draining of a node.
 type Manager struct {
-	redis  *redis.Client
-	nodeID string
+	redis    *redis.Client
+	nodeID   string
+	draining atomic.Bool // drain flag last read by Watch
+
+	mu      sync.Mutex
+	onDrain []func(ctx context.Context)
 }
 
 func New(client *redis.Client, nodeID string) *Manager {
@@ -53,6 +63,117 @@ func (m *Manager) RefuseIfDraining(next http.Handler) http.Handler {
 	})
 }
 
+// Draining reports whether the node is draining.
+func (m *Manager) Draining(ctx context.Context) bool {
+	if m == nil {
+		return false
+	}
+	exists, _ := m.redis.Exists(ctx, m.keyDrain()).Result()
+	return exists == 1
+}
+
+// Watch reads the drain flag every interval until ctx is done, for
+// DrainingCached to report it without a Redis round trip and to run the
+// functions registered with OnDrain once the node starts draining.
+func (m *Manager) Watch(ctx context.Context, interval time.Duration) {
+	if m == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh reads the drain flag, keeping the last one read when Redis fails
+func (m *Manager) refresh(ctx context.Context) {
+	readCtx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	// The expiry of the flag is the deadline of the drain
+	ttl, err := m.redis.PTTL(readCtx, m.keyDrain()).Result()
+	if err != nil {
+		return
+	}
+	draining := ttl != -2 // -2 when the flag is not set
+	if m.draining.Swap(draining) || !draining {
+		return
+	}
+
+	m.mu.Lock()
+	onDrain := m.onDrain
+	m.mu.Unlock()
+	for _, fn := range onDrain {
+		go func(fn func(ctx context.Context)) {
+			drainCtx, cancel := drainContext(ctx, ttl)
+			defer cancel()
+			fn(drainCtx)
+		}(fn)
+	}
+}
+
+// drainContext returns the context of a drain whose flag has ttl left to
+// live, or none when it does not expire. Stopping Watch does not end it.
+func drainContext(ctx context.Context, ttl time.Duration) (context.Context, context.CancelFunc) {
+	ctx = context.WithoutCancel(ctx)
+	if ttl > 0 {
+		return context.WithTimeout(ctx, ttl)
+	}
+	return context.WithCancel(ctx)
+}
+
+// OnDrain registers fn to run once Watch sees the node start draining. The
+// context passed to fn expires with the drain flag.
+func (m *Manager) OnDrain(fn func(ctx context.Context)) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onDrain = append(m.onDrain, fn)
+}
+
+// DrainingCached reports the drain flag last read by Watch.
+func (m *Manager) DrainingCached() bool {
+	if m == nil {
+		return false
+	}
+	return m.draining.Load()
+}
+
+// Open counts a connection that is not an HTTP request, such as a proxied
+// TCP connection, as active until the returned function is called. The count
+// is updated in the background, so that neither call waits on Redis.
+func (m *Manager) Open(ctx context.Context) (done func()) {
+	if m == nil {
+		return func() {}
+	}
+	counted := make(chan struct{})
+	go func() {
+		defer close(counted)
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), redisTimeout)
+		defer cancel()
+		m.redis.Incr(ctx, m.keyActive())
+	}()
+	return func() {
+		go func() {
+			// Decrementing first could let the count drop below the
+			// connections still open
+			<-counted
+			ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+			defer cancel()
+			m.redis.Decr(ctx, m.keyActive())
+		}()
+	}
+}
+
 // SetDrain marks the node for draining for the given duration.
 func (m *Manager) SetDrain(ctx context.Context, ttl time.Duration) error {
 	if m == nil {
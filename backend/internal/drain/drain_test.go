@@ -2,6 +2,7 @@ package drain
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -162,6 +163,119 @@ func TestSetDrain(t *testing.T) {
 	assert.NoError(t, nilManager.SetDrain(ctx, ttl))
 }
 
+func TestDrainingAndOpen(t *testing.T) {
+	client, _, cleanup := setupTestRedis(t)
+	defer cleanup()
+
+	manager := New(client, "test-node")
+	require.NotNil(t, manager)
+
+	ctx := context.Background()
+	assert.False(t, manager.Draining(ctx))
+	require.NoError(t, manager.SetDrain(ctx, 5*time.Second))
+	assert.True(t, manager.Draining(ctx))
+
+	// Open connections count as active until they are done
+	activeIs := func(want int) func() bool {
+		return func() bool {
+			active, err := manager.Active(ctx)
+			return err == nil && active == want
+		}
+	}
+	done := manager.Open(ctx)
+	assert.Eventually(t, activeIs(1), time.Second, 10*time.Millisecond)
+	done()
+	assert.Eventually(t, activeIs(0), time.Second, 10*time.Millisecond)
+
+	// Test nil manager
+	var nilManager *Manager
+	assert.False(t, nilManager.Draining(ctx))
+	assert.False(t, nilManager.DrainingCached())
+	nilManager.Open(ctx)()
+	nilManager.Watch(ctx, time.Millisecond)
+}
+
+func TestWatch(t *testing.T) {
+	client, mr, cleanup := setupTestRedis(t)
+	defer cleanup()
+
+	manager := New(client, "test-node")
+	require.NotNil(t, manager)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go manager.Watch(ctx, 10*time.Millisecond)
+
+	assert.False(t, manager.DrainingCached())
+	require.NoError(t, mr.Set(manager.keyDrain(), "1"))
+	assert.Eventually(t, manager.DrainingCached, time.Second, 10*time.Millisecond)
+
+	// A failing Redis keeps the last flag read
+	mr.SetError("unavailable")
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, manager.DrainingCached())
+
+	mr.SetError("")
+	mr.Del(manager.keyDrain())
+	assert.Eventually(t, func() bool { return !manager.DrainingCached() }, time.Second, 10*time.Millisecond)
+}
+
+func TestOnDrain(t *testing.T) {
+	client, _, cleanup := setupTestRedis(t)
+	defer cleanup()
+
+	manager := New(client, "test-node")
+	require.NotNil(t, manager)
+
+	deadlines := make(chan time.Time, 2)
+	manager.OnDrain(func(ctx context.Context) {
+		deadline, _ := ctx.Deadline()
+		deadlines <- deadline
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go manager.Watch(ctx, 10*time.Millisecond)
+
+	// The drain runs once, until the flag expires
+	require.NoError(t, manager.SetDrain(ctx, time.Minute))
+	select {
+	case deadline := <-deadlines:
+		assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+	case <-time.After(time.Second):
+		t.Fatal("drain functions did not run")
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, deadlines)
+
+	var nilManager *Manager
+	nilManager.OnDrain(func(context.Context) {})
+}
+
+func TestOpenDoesNotWaitOnRedis(t *testing.T) {
+	// A server that accepts connections and never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client := redis.NewClient(&redis.Options{Addr: ln.Addr().String()})
+	defer client.Close()
+	manager := New(client, "test-node")
+
+	start := time.Now()
+	manager.Open(context.Background())()
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
 func TestActive(t *testing.T) {
 	client, mr, cleanup := setupTestRedis(t)
 	defer cleanup()
@@ -5,9 +5,9 @@ import (
 	"math"
 	"net"
 	"net/http"
-	"strings"
 	"sync"
 
+	"github.com/eltonciatto/veloflux/internal/clientip"
 	"github.com/eltonciatto/veloflux/internal/config"
 	"github.com/oschwald/geoip2-golang"
 	"go.uber.org/zap"
@@ -110,12 +110,12 @@ func (m *Manager) GetClientLocation(r *http.Request) (Location, error) {
 		return Location{}, fmt.Errorf("GeoIP not enabled")
 	}
 
-	// Get the client IP address
-	ip := getClientIP(r)
-	ipAddr := net.ParseIP(ip)
+	// Get the client IP address resolved by the router
+	ipAddr := clientip.FromRequest(r)
 	if ipAddr == nil {
-		return Location{}, fmt.Errorf("invalid IP address: %s", ip)
+		return Location{}, fmt.Errorf("invalid IP address: %s", r.RemoteAddr)
 	}
+	ip := ipAddr.String()
 
 	// Check the cache first
 	m.mu.RLock()
@@ -314,53 +314,3 @@ func toRadians(deg float64) float64 {
 	return deg * math.Pi / 180
 }
 
-// getClientIP gets the client's IP address from the request
-func getClientIP(r *http.Request) string {
-	// Check for X-Forwarded-For header
-	xForwardedFor := r.Header.Get("X-Forwarded-For")
-	if xForwardedFor != "" {
-		// X-Forwarded-For can contain multiple IPs, use the first one
-		ips := splitString(xForwardedFor, ',')
-		if len(ips) > 0 {
-			return trimString(ips[0])
-		}
-	}
-
-	// Check for X-Real-IP header
-	xRealIP := r.Header.Get("X-Real-IP")
-	if xRealIP != "" {
-		return xRealIP
-	}
-
-	// Fall back to RemoteAddr
-	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-	return ip
-}
-
-// splitString splits a string by a separator and trims spaces
-func splitString(s string, sep rune) []string {
-	// Handle empty string specially
-	if s == "" {
-		return []string{""}
-	}
-	
-	var result []string
-	var builder strings.Builder
-	for _, r := range s {
-		if r == sep {
-			result = append(result, trimString(builder.String()))
-			builder.Reset()
-		} else {
-			builder.WriteRune(r)
-		}
-	}
-	if builder.Len() > 0 {
-		result = append(result, trimString(builder.String()))
-	}
-	return result
-}
-
-// trimString removes leading and trailing spaces
-func trimString(s string) string {
-	return strings.TrimSpace(s)
-}
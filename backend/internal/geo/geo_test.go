@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/eltonciatto/veloflux/internal/clientip"
 	"github.com/eltonciatto/veloflux/internal/config"
 	"github.com/oschwald/geoip2-golang"
 	"github.com/stretchr/testify/assert"
@@ -643,87 +644,34 @@ func TestToRadians(t *testing.T) {
 	}
 }
 
-func TestGetClientIP(t *testing.T) {
-	testCases := []struct {
-		name         string
-		setupRequest func() *http.Request
-		expectedIP   string
-	}{
-		{
-			name: "XForwardedFor",
-			setupRequest: func() *http.Request {
-				req := httptest.NewRequest("GET", "/", nil)
-				req.Header.Set("X-Forwarded-For", "192.168.1.1, 10.0.0.1, 172.16.0.1")
-				return req
-			},
-			expectedIP: "192.168.1.1",
-		},
-		{
-			name: "XRealIP",
-			setupRequest: func() *http.Request {
-				req := httptest.NewRequest("GET", "/", nil)
-				req.Header.Set("X-Real-IP", "192.168.1.2")
-				return req
-			},
-			expectedIP: "192.168.1.2",
-		},
-		{
-			name: "RemoteAddr",
-			setupRequest: func() *http.Request {
-				req := httptest.NewRequest("GET", "/", nil)
-				req.RemoteAddr = "192.168.1.3:12345"
-				return req
+func TestGetClientLocationResolvedIP(t *testing.T) {
+	manager := &Manager{
+		enabled:    true,
+		locations:  map[string]Location{"203.0.113.7": {Region: "EU"}},
+		backendLoc: make(map[string]Location),
+		logger:     zap.NewNop(),
+		reader: &mockGeoIP2Reader{
+			cityFunc: func(ip net.IP) (*geoip2.City, error) {
+				return nil, fmt.Errorf("unexpected lookup of %s", ip)
 			},
-			expectedIP: "192.168.1.3",
 		},
 	}
 
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			req := tc.setupRequest()
-			ip := getClientIP(req)
-			assert.Equal(t, tc.expectedIP, ip)
-		})
-	}
-}
-
-func TestSplitString(t *testing.T) {
-	testCases := []struct {
-		input    string
-		sep      rune
-		expected []string
-	}{
-		{"a,b,c", ',', []string{"a", "b", "c"}},
-		{"a, b, c", ',', []string{"a", "b", "c"}},
-		{"", ',', []string{""}},
-		{" a ", ',', []string{"a"}},
-	}
-
-	for i, tc := range testCases {
-		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
-			result := splitString(tc.input, tc.sep)
-			assert.Equal(t, tc.expected, result)
-		})
-	}
-}
-
-func TestTrimString(t *testing.T) {
-	testCases := []struct {
-		input    string
-		expected string
-	}{
-		{"  hello  ", "hello"},
-		{"hello", "hello"},
-		{"", ""},
-		{" ", ""},
-	}
+	// Forwarding headers alone do not move the client
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	loc, err := manager.GetClientLocation(req)
+	require.NoError(t, err)
+	assert.Equal(t, "EU", loc.Region)
 
-	for i, tc := range testCases {
-		t.Run(fmt.Sprintf("Case_%d", i), func(t *testing.T) {
-			result := trimString(tc.input)
-			assert.Equal(t, tc.expected, result)
-		})
-	}
+	// The address resolved behind trusted proxies is used
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req = req.WithContext(clientip.NewContext(req.Context(), net.ParseIP("203.0.113.7")))
+	loc, err = manager.GetClientLocation(req)
+	require.NoError(t, err)
+	assert.Equal(t, "EU", loc.Region)
 }
 
 func TestManagerClose(t *testing.T) {
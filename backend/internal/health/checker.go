@@ -2,11 +2,12 @@ package health
 
 import (
 	"context"
-	"fmt"
-	"net/http"
+	"reflect"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/eltonciatto/veloflux/internal/balancer"
 	"github.com/eltonciatto/veloflux/internal/config"
 	"go.uber.org/zap"
 )
@@ -15,6 +16,11 @@ type BackendHealthUpdater interface {
 	UpdateBackendHealth(poolName, backendAddress string, healthy bool)
 }
 
+// MembershipSource notifies subscribers when backends join or leave pools
+type MembershipSource interface {
+	Subscribe(listener func(balancer.MembershipEvent))
+}
+
 type Checker struct {
 	config   *config.Config
 	logger   *zap.Logger
@@ -23,25 +29,112 @@ type Checker struct {
 	wg       sync.WaitGroup
 	mu       sync.Mutex // Mutex to protect against concurrent access to internal state
 	started  bool       // Track if the checker is already started
+	ctx      context.Context
+	members  map[string]member        // backends that should be probed, keyed by pool and address
+	checks   map[string]*backendCheck // running probes, keyed like members
+	outliers *outlierDetector
+	statusMu sync.RWMutex
+	statuses map[string]*BackendStatus
+}
+
+type member struct {
+	pool     string
+	backend  config.Backend
+	upstream config.UpstreamConfig
+}
+
+// backendCheck tracks the probe goroutine running for a single backend.
+type backendCheck struct {
+	pool     string
+	backend  config.Backend
+	upstream config.UpstreamConfig
+	global   config.HealthConfig
+	stop     chan struct{}
+	healthy  bool // verdict the probe starts from
+	failures int  // consecutive failures the probe starts from
 }
 
 type BackendStatus struct {
-	Address      string
-	Pool         string
-	Healthy      bool
-	LastCheck    time.Time
-	FailureCount int
-	ResponseTime time.Duration
+	Address      string        `json:"address"`
+	Pool         string        `json:"pool"`
+	Healthy      bool          `json:"healthy"`
+	Ejected      bool          `json:"ejected"`
+	LastCheck    time.Time     `json:"last_check"`
+	FailureCount int           `json:"failure_count"`
+	ResponseTime time.Duration `json:"response_time"`
+
+	probeHealthy bool // verdict of the active probe, before outlier ejection
 }
 
 func New(cfg *config.Config, logger *zap.Logger, updater BackendHealthUpdater) *Checker {
-	return &Checker{
+	c := &Checker{
 		config:   cfg,
 		logger:   logger,
 		updater:  updater,
 		stopChan: make(chan struct{}),
 		started:  false,
+		members:  membersFromConfig(cfg),
+		checks:   make(map[string]*backendCheck),
+		outliers: newOutlierDetector(),
+		statuses: make(map[string]*BackendStatus),
+	}
+	c.outliers.setPools(cfg.Pools)
+	return c
+}
+
+func checkKey(poolName, address string) string {
+	return poolName + "/" + address
+}
+
+func membersFromConfig(cfg *config.Config) map[string]member {
+	members := make(map[string]member)
+	for _, pool := range cfg.Pools {
+		for _, backend := range pool.Backends {
+			members[checkKey(pool.Name, backend.Address)] = member{pool: pool.Name, backend: backend, upstream: pool.Upstream}
+		}
+	}
+	return members
+}
+
+// poolUpstream returns the upstream settings of a configured pool. Callers
+// must hold c.mu.
+func (c *Checker) poolUpstream(poolName string) config.UpstreamConfig {
+	for _, pool := range c.config.Pools {
+		if pool.Name == poolName {
+			return pool.Upstream
+		}
+	}
+	return config.UpstreamConfig{}
+}
+
+// Watch subscribes the checker to membership changes so backends added or
+// removed at runtime start or stop being probed.
+func (c *Checker) Watch(source MembershipSource) {
+	source.Subscribe(c.handleMembershipEvent)
+}
+
+func (c *Checker) handleMembershipEvent(event balancer.MembershipEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := checkKey(event.Pool, event.Backend.Address)
+	switch event.Type {
+	case balancer.BackendAdded, balancer.BackendUpdated:
+		c.members[key] = member{pool: event.Pool, backend: event.Backend, upstream: c.poolUpstream(event.Pool)}
+		c.outliers.addHost(event.Pool, event.Backend.Address)
+	case balancer.BackendRemoved:
+		delete(c.members, key)
+		c.outliers.removeHost(event.Pool, event.Backend.Address)
 	}
+
+	if c.started {
+		c.syncCheck(key)
+	}
+
+	c.logger.Debug("Health checker membership changed",
+		zap.String("event", string(event.Type)),
+		zap.String("pool", event.Pool),
+		zap.String("backend", event.Backend.Address))
 }
 
 func (c *Checker) Start(ctx context.Context) {
@@ -66,12 +159,97 @@ func (c *Checker) Start(ctx context.Context) {
 		// Channel is still open, do nothing
 	}
 
-	for _, pool := range c.config.Pools {
-		for _, backend := range pool.Backends {
-			c.wg.Add(1)
-			go c.checkBackend(ctx, pool.Name, backend)
+	c.ctx = ctx
+	c.checks = make(map[string]*backendCheck)
+	for key := range c.members {
+		c.syncCheck(key)
+	}
+}
+
+// Reload applies a new configuration to a running checker. Probes are started
+// for new backends and stopped for removed ones; backends whose health check
+// settings did not change keep their probe goroutine and failure count.
+func (c *Checker) Reload(cfg *config.Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config = cfg
+	c.members = membersFromConfig(cfg)
+	for _, host := range c.outliers.setPools(cfg.Pools) {
+		c.reportRestored(host)
+	}
+	if !c.started {
+		return
+	}
+
+	for key := range c.checks {
+		c.syncCheck(key)
+	}
+	for key := range c.members {
+		c.syncCheck(key)
+	}
+
+	c.logger.Info("Health checker reloaded", zap.Int("backends", len(c.checks)))
+}
+
+// syncCheck starts, restarts or stops the probe for a key so that it matches
+// the desired membership. Callers must hold c.mu.
+func (c *Checker) syncCheck(key string) {
+	m, wanted := c.members[key]
+	check, running := c.checks[key]
+
+	if running {
+		if wanted && check.probes(m, c.config.Global.HealthCheck) {
+			return
 		}
+		close(check.stop)
+		delete(c.checks, key)
 	}
+
+	if !wanted {
+		c.statusMu.Lock()
+		delete(c.statuses, key)
+		c.statusMu.Unlock()
+		return
+	}
+
+	c.startCheck(m)
+}
+
+// probes reports whether a running check already probes a member the way
+// its settings ask. Weights and connection pooling settings do not matter.
+func (check *backendCheck) probes(m member, global config.HealthConfig) bool {
+	return reflect.DeepEqual(check.backend.HealthCheck, m.backend.HealthCheck) &&
+		check.upstream.Scheme == m.upstream.Scheme && check.upstream.TLS == m.upstream.TLS &&
+		check.global == global
+}
+
+// startCheck launches a probe goroutine for a backend. A restarted probe
+// resumes from the verdict and failure count of the previous one, so a dead
+// backend stays out of rotation. Callers must hold c.mu.
+func (c *Checker) startCheck(m member) {
+	poolName, backend := m.pool, m.backend
+	key := checkKey(poolName, backend.Address)
+	check := &backendCheck{
+		pool:     poolName,
+		backend:  backend,
+		upstream: m.upstream,
+		global:   c.config.Global.HealthCheck,
+		stop:     make(chan struct{}),
+	}
+	c.checks[key] = check
+
+	c.statusMu.Lock()
+	status, ok := c.statuses[key]
+	if !ok {
+		status = &BackendStatus{Address: backend.Address, Pool: poolName, Healthy: true, probeHealthy: true}
+		c.statuses[key] = status
+	}
+	check.healthy, check.failures = status.probeHealthy, status.FailureCount
+	c.statusMu.Unlock()
+
+	c.wg.Add(1)
+	go c.checkBackend(c.ctx, check)
 }
 
 func (c *Checker) Stop() {
@@ -91,36 +269,113 @@ func (c *Checker) Stop() {
 
 	// Wait for all goroutines to finish
 	c.wg.Wait()
+	c.checks = make(map[string]*backendCheck)
+}
+
+// Statuses returns a snapshot of the health of every probed backend, ordered
+// by pool and address.
+func (c *Checker) Statuses() []BackendStatus {
+	c.statusMu.RLock()
+	statuses := make([]BackendStatus, 0, len(c.statuses))
+	for _, status := range c.statuses {
+		statuses = append(statuses, *status)
+	}
+	c.statusMu.RUnlock()
+
+	for i := range statuses {
+		statuses[i].Ejected = c.IsEjected(statuses[i].Pool, statuses[i].Address)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Pool != statuses[j].Pool {
+			return statuses[i].Pool < statuses[j].Pool
+		}
+		return statuses[i].Address < statuses[j].Address
+	})
+	return statuses
 }
 
-func (c *Checker) checkBackend(ctx context.Context, poolName string, backend config.Backend) {
+// Status returns the health of a single backend
+func (c *Checker) Status(poolName, backendAddress string) (BackendStatus, bool) {
+	c.statusMu.RLock()
+	status, ok := c.statuses[checkKey(poolName, backendAddress)]
+	if !ok {
+		c.statusMu.RUnlock()
+		return BackendStatus{}, false
+	}
+	snapshot := *status
+	c.statusMu.RUnlock()
+
+	snapshot.Ejected = c.IsEjected(poolName, backendAddress)
+	return snapshot, true
+}
+
+func (c *Checker) recordStatus(check *backendCheck, probeHealthy, healthy bool, failureCount int, responseTime time.Duration) {
+	key := checkKey(check.pool, check.backend.Address)
+
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	// The backend may have been removed while the probe was running
+	status, ok := c.statuses[key]
+	if !ok {
+		return
+	}
+	status.Healthy = healthy
+	status.probeHealthy = probeHealthy
+	status.LastCheck = time.Now()
+	status.FailureCount = failureCount
+	status.ResponseTime = responseTime
+}
+
+func (c *Checker) checkBackend(ctx context.Context, check *backendCheck) {
 	defer c.wg.Done()
 
+	poolName := check.pool
+	backend := check.backend
+
 	interval := backend.HealthCheck.Interval
 	if interval == 0 {
-		interval = c.config.Global.HealthCheck.Interval
+		interval = check.global.Interval
 	}
 
 	timeout := backend.HealthCheck.Timeout
 	if timeout == 0 {
-		timeout = c.config.Global.HealthCheck.Timeout
+		timeout = check.global.Timeout
 	}
 
-	path := backend.HealthCheck.Path
-	if path == "" {
-		path = "/health"
+	healthyThreshold := backend.HealthCheck.HealthyThreshold
+	if healthyThreshold == 0 {
+		healthyThreshold = check.global.HealthyThreshold
+	}
+	if healthyThreshold == 0 {
+		healthyThreshold = 1
 	}
 
-	expectedStatus := backend.HealthCheck.ExpectedStatus
-	if expectedStatus == 0 {
-		expectedStatus = 200
+	unhealthyThreshold := backend.HealthCheck.UnhealthyThreshold
+	if unhealthyThreshold == 0 {
+		unhealthyThreshold = check.global.UnhealthyThreshold
+	}
+	if unhealthyThreshold == 0 {
+		unhealthyThreshold = check.global.Retries
+	}
+
+	prober, err := NewPoolProber(backend.HealthCheck, check.upstream)
+	if err != nil {
+		c.logger.Error("Invalid health check configuration, backend will be reported unhealthy",
+			zap.String("pool", poolName),
+			zap.String("backend", backend.Address),
+			zap.Error(err))
+	} else {
+		defer prober.Close()
 	}
 
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	failureCount := 0
-	maxFailures := c.config.Global.HealthCheck.Retries
+	healthy := check.healthy
+	failureCount := check.failures
+	successCount := 0
 
 	for {
 		select {
@@ -128,73 +383,74 @@ func (c *Checker) checkBackend(ctx context.Context, poolName string, backend con
 			return
 		case <-c.stopChan:
 			return
+		case <-check.stop:
+			return
 		case <-ticker.C:
-			healthy := c.performHealthCheck(backend.Address, path, timeout, expectedStatus)
+			ok, responseTime := false, time.Duration(0)
+			if prober != nil {
+				ok, responseTime = c.probe(ctx, prober, backend.Address, timeout)
+			}
 
-			if healthy {
-				if failureCount > 0 {
+			if ok {
+				failureCount = 0
+				successCount++
+				if !healthy && successCount >= healthyThreshold {
+					healthy = true
 					c.logger.Info("Backend recovered",
 						zap.String("pool", poolName),
 						zap.String("backend", backend.Address))
 				}
-				failureCount = 0
 			} else {
+				successCount = 0
 				failureCount++
 				c.logger.Warn("Backend health check failed",
 					zap.String("pool", poolName),
 					zap.String("backend", backend.Address),
 					zap.Int("failure_count", failureCount))
+				if healthy && failureCount >= unhealthyThreshold {
+					healthy = false
+				}
 			}
 
-			// Mark as unhealthy only after consecutive failures
-			backendHealthy := failureCount < maxFailures
+			// Keep ejected outliers out of rotation until they are restored
+			backendHealthy := c.outliers.recordActive(poolName, backend.Address, healthy)
 			c.updater.UpdateBackendHealth(poolName, backend.Address, backendHealthy)
+			c.recordStatus(check, healthy, backendHealthy, failureCount, responseTime)
 		}
 	}
 }
 
-func (c *Checker) performHealthCheck(address, path string, timeout time.Duration, expectedStatus int) bool {
-	client := &http.Client{
-		Timeout: timeout,
-	}
-
-	url := fmt.Sprintf("http://%s%s", address, path)
+// probe runs a single probe bounded by timeout and reports whether it passed
+// along with how long it took
+func (c *Checker) probe(ctx context.Context, prober Prober, address string, timeout time.Duration) (bool, time.Duration) {
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
 	start := time.Now()
-	resp, err := client.Get(url)
+	err := prober.Probe(probeCtx, address)
 	duration := time.Since(start)
 
 	if err != nil {
-		c.logger.Debug("Health check request failed",
-			zap.String("url", url),
+		c.logger.Debug("Health check failed",
+			zap.String("backend", address),
 			zap.Error(err),
 			zap.Duration("duration", duration))
-		return false
+		return false, duration
 	}
-	defer resp.Body.Close()
-
-	healthy := resp.StatusCode == expectedStatus
 
 	c.logger.Debug("Health check completed",
-		zap.String("url", url),
-		zap.Int("status_code", resp.StatusCode),
-		zap.Int("expected_status", expectedStatus),
-		zap.Bool("healthy", healthy),
+		zap.String("backend", address),
 		zap.Duration("duration", duration))
 
-	return healthy
+	return true, duration
 }
 
-func (c *Checker) PassiveCheck(poolName, backendAddress string, statusCode int, responseTime time.Duration) {
-	// Passive health checking based on response codes and latency
-	if statusCode >= 500 || responseTime > 10*time.Second {
-		c.logger.Warn("Passive health check detected issue",
-			zap.String("pool", poolName),
-			zap.String("backend", backendAddress),
-			zap.Int("status_code", statusCode),
-			zap.Duration("response_time", responseTime))
-
-		// Could implement more sophisticated passive checking logic here
-		// For now, just log the issue
+func (c *Checker) performHealthCheck(address, path string, timeout time.Duration, expectedStatus int) bool {
+	prober, err := NewProber(config.HealthCheck{Path: path, ExpectedStatus: expectedStatus})
+	if err != nil {
+		return false
 	}
+	defer prober.Close()
+	healthy, _ := c.probe(context.Background(), prober, address, timeout)
+	return healthy
 }
@@ -7,9 +7,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/eltonciatto/veloflux/internal/balancer"
 	"github.com/eltonciatto/veloflux/internal/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -173,3 +175,151 @@ func TestStartAndStop(t *testing.T) {
 		t.Fatal("Test timed out")
 	}
 }
+
+func TestReload(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	address := testServer.URL[7:]
+	backend := func(addr string) config.Backend {
+		return config.Backend{
+			Address: addr,
+			HealthCheck: config.HealthCheck{
+				Path:     "/",
+				Timeout:  time.Second,
+				Interval: time.Hour,
+			},
+		}
+	}
+
+	cfg := &config.Config{
+		Global: config.GlobalConfig{HealthCheck: config.HealthConfig{Retries: 3}},
+		Pools: []config.Pool{
+			{Name: "web", Backends: []config.Backend{backend(address), backend("127.0.0.1:1")}},
+		},
+	}
+
+	checker := New(cfg, zap.NewNop(), &MockBackendHealthUpdater{})
+	checker.Start(context.Background())
+	defer checker.Stop()
+
+	unchanged := checker.checks[checkKey("web", address)]
+	assert.Len(t, checker.checks, 2)
+
+	newCfg := &config.Config{
+		Global: cfg.Global,
+		Pools: []config.Pool{
+			{Name: "web", Backends: []config.Backend{backend(address)}},
+			{Name: "api", Backends: []config.Backend{backend("127.0.0.1:2")}},
+		},
+	}
+	checker.Reload(newCfg)
+
+	assert.Len(t, checker.checks, 2)
+	assert.Same(t, unchanged, checker.checks[checkKey("web", address)])
+	assert.NotContains(t, checker.checks, checkKey("web", "127.0.0.1:1"))
+	assert.Contains(t, checker.checks, checkKey("api", "127.0.0.1:2"))
+}
+
+func TestRuntimeMembership(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	address := testServer.URL[7:]
+	cfg := &config.Config{
+		Global: config.GlobalConfig{HealthCheck: config.HealthConfig{Retries: 3}},
+		Pools:  []config.Pool{{Name: "web", Algorithm: "round_robin"}},
+	}
+
+	bal := balancer.New()
+	for _, pool := range cfg.Pools {
+		bal.AddPool(pool)
+	}
+
+	checker := New(cfg, zap.NewNop(), bal)
+	checker.Watch(bal)
+	checker.Start(context.Background())
+	defer checker.Stop()
+
+	bal.AddBackend("web", config.Backend{
+		Address: address,
+		Weight:  100,
+		HealthCheck: config.HealthCheck{
+			Path:     "/",
+			Timeout:  time.Second,
+			Interval: 10 * time.Millisecond,
+		},
+	})
+
+	require.Eventually(t, func() bool {
+		status, ok := checker.Status("web", address)
+		return ok && !status.LastCheck.IsZero()
+	}, time.Second, 5*time.Millisecond)
+
+	statuses := checker.Statuses()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "web", statuses[0].Pool)
+	assert.Equal(t, address, statuses[0].Address)
+	assert.True(t, statuses[0].Healthy)
+	assert.False(t, statuses[0].Ejected)
+
+	require.NoError(t, bal.RemoveBackend("web", address))
+
+	checker.mu.Lock()
+	running := len(checker.checks)
+	checker.mu.Unlock()
+	assert.Zero(t, running)
+	assert.Empty(t, checker.Statuses())
+}
+
+func TestReloadKeepsUnhealthyBackendOut(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer testServer.Close()
+
+	address := testServer.URL[7:]
+	backend := config.Backend{
+		Address: address,
+		Weight:  100,
+		HealthCheck: config.HealthCheck{
+			Path:     "/",
+			Timeout:  time.Second,
+			Interval: 10 * time.Millisecond,
+		},
+	}
+	pools := func(backend config.Backend) []config.Pool {
+		return []config.Pool{{Name: "web", Algorithm: "round_robin", Backends: []config.Backend{backend}}}
+	}
+	cfg := &config.Config{
+		Global: config.GlobalConfig{HealthCheck: config.HealthConfig{Retries: 2}},
+		Pools:  pools(backend),
+	}
+
+	bal := balancer.New()
+	bal.AddPool(cfg.Pools[0])
+	checker := New(cfg, zap.NewNop(), bal)
+	checker.Start(context.Background())
+	defer checker.Stop()
+
+	healthy := func() bool {
+		return bal.GetAllBackends()["web"][0].Healthy.Load()
+	}
+	require.Eventually(t, func() bool { return !healthy() }, time.Second, 5*time.Millisecond)
+
+	// A weight change keeps the probe running
+	running := checker.checks[checkKey("web", address)]
+	backend.Weight = 50
+	checker.Reload(&config.Config{Global: cfg.Global, Pools: pools(backend)})
+	assert.Same(t, running, checker.checks[checkKey("web", address)])
+
+	// A restarted probe resumes from the backend being down
+	backend.HealthCheck.Path = "/health"
+	checker.Reload(&config.Config{Global: cfg.Global, Pools: pools(backend)})
+	assert.NotSame(t, running, checker.checks[checkKey("web", address)])
+	assert.Never(t, healthy, 100*time.Millisecond, 2*time.Millisecond)
+}
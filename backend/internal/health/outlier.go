@@ -0,0 +1,307 @@
+package health
+
+import (
+	"sync"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Ejection reasons reported in logs and metrics
+const (
+	ReasonConsecutive5xx     = "consecutive_5xx"
+	ReasonConsecutiveGateway = "consecutive_gateway_errors"
+	ReasonLatency            = "latency"
+)
+
+// outlierDetector keeps the passive health state of every known backend.
+// It never takes Checker.mu, so probe goroutines can consult it while Stop
+// is waiting for them.
+type outlierDetector struct {
+	mu    sync.Mutex
+	pools map[string]config.OutlierDetection
+	hosts map[string]*outlierHost // keyed by pool and backend address
+}
+
+type outlierHost struct {
+	pool               string
+	address            string
+	consecutive5xx     int
+	consecutiveGateway int
+	consecutiveSlow    int
+	ejected            bool
+	ejections          int
+	lastRestore        time.Time
+	activeUnhealthy    bool
+	timer              *time.Timer
+}
+
+func newOutlierDetector() *outlierDetector {
+	return &outlierDetector{
+		pools: make(map[string]config.OutlierDetection),
+		hosts: make(map[string]*outlierHost),
+	}
+}
+
+// outlierDefaults fills in the Envoy-like defaults for unset fields
+func outlierDefaults(od config.OutlierDetection) config.OutlierDetection {
+	if od.Consecutive5xx == 0 {
+		od.Consecutive5xx = 5
+	}
+	if od.ConsecutiveGatewayErrors == 0 {
+		od.ConsecutiveGatewayErrors = 5
+	}
+	if od.ConsecutiveSlow == 0 {
+		od.ConsecutiveSlow = 5
+	}
+	if od.BaseEjectionTime == 0 {
+		od.BaseEjectionTime = 30 * time.Second
+	}
+	if od.MaxEjectionTime == 0 {
+		od.MaxEjectionTime = 300 * time.Second
+	}
+	if od.MaxEjectionPercent == 0 {
+		od.MaxEjectionPercent = 10
+	}
+	return od
+}
+
+// setPools registers the configured pools and their backends. Hosts that are
+// no longer configured are forgotten, and ejected hosts in pools where outlier
+// detection was turned off are returned so the caller can restore them.
+func (d *outlierDetector) setPools(pools []config.Pool) []*outlierHost {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pools = make(map[string]config.OutlierDetection, len(pools))
+	wanted := make(map[string]bool)
+	for _, pool := range pools {
+		if pool.OutlierDetection.Enabled {
+			d.pools[pool.Name] = outlierDefaults(pool.OutlierDetection)
+		}
+		for _, backend := range pool.Backends {
+			key := checkKey(pool.Name, backend.Address)
+			wanted[key] = true
+			if _, ok := d.hosts[key]; !ok {
+				d.hosts[key] = &outlierHost{pool: pool.Name, address: backend.Address}
+			}
+		}
+	}
+
+	var restored []*outlierHost
+	for key, host := range d.hosts {
+		if !wanted[key] {
+			if host.timer != nil {
+				host.timer.Stop()
+			}
+			delete(d.hosts, key)
+			continue
+		}
+		if _, enabled := d.pools[host.pool]; !enabled && host.ejected {
+			if host.timer != nil {
+				host.timer.Stop()
+			}
+			host.ejected = false
+			host.lastRestore = time.Now()
+			restored = append(restored, host)
+		}
+	}
+
+	return restored
+}
+
+// addHost registers a backend added at runtime
+func (d *outlierDetector) addHost(poolName, address string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := checkKey(poolName, address)
+	if _, ok := d.hosts[key]; !ok {
+		d.hosts[key] = &outlierHost{pool: poolName, address: address}
+	}
+}
+
+// removeHost forgets a backend removed at runtime
+func (d *outlierDetector) removeHost(poolName, address string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := checkKey(poolName, address)
+	if host, ok := d.hosts[key]; ok {
+		if host.timer != nil {
+			host.timer.Stop()
+		}
+		delete(d.hosts, key)
+	}
+}
+
+// recordActive stores the result of an active probe and returns the health
+// that should be reported to the balancer, which stays false while ejected.
+func (d *outlierDetector) recordActive(poolName, address string, healthy bool) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	host, ok := d.hosts[checkKey(poolName, address)]
+	if !ok {
+		return healthy
+	}
+	host.activeUnhealthy = !healthy
+	return healthy && !host.ejected
+}
+
+// ejectionAllowed reports whether one more host of the pool may be ejected.
+// Like Envoy, a single ejection is always allowed. Callers must hold d.mu.
+func (d *outlierDetector) ejectionAllowed(poolName string, maxPercent int) bool {
+	size, ejected := 0, 0
+	for _, host := range d.hosts {
+		if host.pool != poolName {
+			continue
+		}
+		size++
+		if host.ejected {
+			ejected++
+		}
+	}
+	if ejected == 0 {
+		return true
+	}
+	return (ejected+1)*100 <= maxPercent*size
+}
+
+// PassiveCheck feeds the outcome of a proxied request into outlier detection.
+// A status code of 0 means the request never got a response from the backend.
+func (c *Checker) PassiveCheck(poolName, backendAddress string, statusCode int, responseTime time.Duration) {
+	// Passive health checking based on response codes and latency
+	if statusCode == 0 || statusCode >= 500 || responseTime > 10*time.Second {
+		c.logger.Warn("Passive health check detected issue",
+			zap.String("pool", poolName),
+			zap.String("backend", backendAddress),
+			zap.Int("status_code", statusCode),
+			zap.Duration("response_time", responseTime))
+	}
+
+	d := c.outliers
+	d.mu.Lock()
+
+	od, enabled := d.pools[poolName]
+	if !enabled {
+		d.mu.Unlock()
+		return
+	}
+
+	key := checkKey(poolName, backendAddress)
+	host, ok := d.hosts[key]
+	if !ok {
+		host = &outlierHost{pool: poolName, address: backendAddress}
+		d.hosts[key] = host
+	}
+	if host.ejected {
+		d.mu.Unlock()
+		return
+	}
+
+	if statusCode == 0 || statusCode >= 500 {
+		host.consecutive5xx++
+	} else {
+		host.consecutive5xx = 0
+	}
+
+	switch statusCode {
+	case 0, 502, 503, 504:
+		host.consecutiveGateway++
+	default:
+		host.consecutiveGateway = 0
+	}
+
+	if od.LatencyThreshold > 0 && responseTime > od.LatencyThreshold {
+		host.consecutiveSlow++
+	} else {
+		host.consecutiveSlow = 0
+	}
+
+	var reason string
+	switch {
+	case host.consecutiveGateway >= od.ConsecutiveGatewayErrors:
+		reason = ReasonConsecutiveGateway
+	case host.consecutive5xx >= od.Consecutive5xx:
+		reason = ReasonConsecutive5xx
+	case od.LatencyThreshold > 0 && host.consecutiveSlow >= od.ConsecutiveSlow:
+		reason = ReasonLatency
+	}
+
+	if reason == "" || !d.ejectionAllowed(poolName, od.MaxEjectionPercent) {
+		d.mu.Unlock()
+		return
+	}
+
+	// Forget earlier ejections once the host behaved for a full max ejection time
+	if !host.lastRestore.IsZero() && time.Since(host.lastRestore) > od.MaxEjectionTime {
+		host.ejections = 0
+	}
+	host.ejections++
+	duration := od.BaseEjectionTime * time.Duration(host.ejections)
+	if duration > od.MaxEjectionTime {
+		duration = od.MaxEjectionTime
+	}
+
+	host.ejected = true
+	host.consecutive5xx = 0
+	host.consecutiveGateway = 0
+	host.consecutiveSlow = 0
+	host.timer = time.AfterFunc(duration, func() { c.restoreOutlier(key) })
+	ejections := host.ejections
+	d.mu.Unlock()
+
+	c.updater.UpdateBackendHealth(poolName, backendAddress, false)
+	metrics.OutlierEjectionsTotal.WithLabelValues(poolName, backendAddress, reason).Inc()
+	metrics.OutlierEjected.WithLabelValues(poolName, backendAddress).Set(1)
+
+	c.logger.Warn("Backend ejected by outlier detection",
+		zap.String("pool", poolName),
+		zap.String("backend", backendAddress),
+		zap.String("reason", reason),
+		zap.Int("ejections", ejections),
+		zap.Duration("ejection_time", duration))
+}
+
+// restoreOutlier brings an ejected host back once its ejection time elapsed
+func (c *Checker) restoreOutlier(key string) {
+	d := c.outliers
+	d.mu.Lock()
+	host, ok := d.hosts[key]
+	if !ok || !host.ejected {
+		d.mu.Unlock()
+		return
+	}
+	host.ejected = false
+	host.lastRestore = time.Now()
+	d.mu.Unlock()
+
+	c.reportRestored(host)
+}
+
+func (c *Checker) reportRestored(host *outlierHost) {
+	c.outliers.mu.Lock()
+	healthy := !host.activeUnhealthy
+	c.outliers.mu.Unlock()
+
+	c.updater.UpdateBackendHealth(host.pool, host.address, healthy)
+	metrics.OutlierRestoresTotal.WithLabelValues(host.pool, host.address).Inc()
+	metrics.OutlierEjected.WithLabelValues(host.pool, host.address).Set(0)
+
+	c.logger.Info("Backend restored after outlier ejection",
+		zap.String("pool", host.pool),
+		zap.String("backend", host.address),
+		zap.Bool("healthy", healthy))
+}
+
+// IsEjected reports whether outlier detection currently ejects a backend
+func (c *Checker) IsEjected(poolName, backendAddress string) bool {
+	c.outliers.mu.Lock()
+	defer c.outliers.mu.Unlock()
+
+	host, ok := c.outliers.hosts[checkKey(poolName, backendAddress)]
+	return ok && host.ejected
+}
@@ -0,0 +1,137 @@
+package health
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+func outlierConfig(od config.OutlierDetection, addresses ...string) *config.Config {
+	backends := make([]config.Backend, len(addresses))
+	for i, addr := range addresses {
+		backends[i] = config.Backend{Address: addr}
+	}
+	return &config.Config{
+		Pools: []config.Pool{{Name: "web", Backends: backends, OutlierDetection: od}},
+	}
+}
+
+func TestPassiveCheckEjectsAfterConsecutive5xx(t *testing.T) {
+	cfg := outlierConfig(config.OutlierDetection{
+		Enabled:          true,
+		Consecutive5xx:   3,
+		BaseEjectionTime: time.Hour,
+	}, "backend1", "backend2")
+
+	updater := &MockBackendHealthUpdater{}
+	updater.On("UpdateBackendHealth", "web", "backend1", false).Return().Once()
+	checker := New(cfg, zap.NewNop(), updater)
+
+	checker.PassiveCheck("web", "backend1", http.StatusInternalServerError, time.Millisecond)
+	checker.PassiveCheck("web", "backend1", http.StatusInternalServerError, time.Millisecond)
+	assert.False(t, checker.IsEjected("web", "backend1"))
+
+	// A success resets the streak
+	checker.PassiveCheck("web", "backend1", http.StatusOK, time.Millisecond)
+	checker.PassiveCheck("web", "backend1", http.StatusInternalServerError, time.Millisecond)
+	checker.PassiveCheck("web", "backend1", http.StatusInternalServerError, time.Millisecond)
+	assert.False(t, checker.IsEjected("web", "backend1"))
+
+	checker.PassiveCheck("web", "backend1", http.StatusInternalServerError, time.Millisecond)
+	assert.True(t, checker.IsEjected("web", "backend1"))
+	updater.AssertExpectations(t)
+
+	// Ejected backends stay unhealthy for active checks
+	assert.False(t, checker.outliers.recordActive("web", "backend1", true))
+	assert.True(t, checker.outliers.recordActive("web", "backend2", true))
+}
+
+func TestPassiveCheckGatewayErrorsAndLatency(t *testing.T) {
+	cfg := outlierConfig(config.OutlierDetection{
+		Enabled:                  true,
+		ConsecutiveGatewayErrors: 2,
+		ConsecutiveSlow:          2,
+		LatencyThreshold:         time.Second,
+		BaseEjectionTime:         time.Hour,
+		MaxEjectionPercent:       100,
+	}, "backend1", "backend2")
+
+	updater := &MockBackendHealthUpdater{}
+	updater.On("UpdateBackendHealth", "web", mock.Anything, false).Return()
+	checker := New(cfg, zap.NewNop(), updater)
+
+	checker.PassiveCheck("web", "backend1", 0, time.Millisecond)
+	checker.PassiveCheck("web", "backend1", http.StatusBadGateway, time.Millisecond)
+	assert.True(t, checker.IsEjected("web", "backend1"))
+
+	checker.PassiveCheck("web", "backend2", http.StatusOK, 2*time.Second)
+	checker.PassiveCheck("web", "backend2", http.StatusOK, 2*time.Second)
+	assert.True(t, checker.IsEjected("web", "backend2"))
+}
+
+func TestPassiveCheckMaxEjectionPercent(t *testing.T) {
+	cfg := outlierConfig(config.OutlierDetection{
+		Enabled:            true,
+		Consecutive5xx:     1,
+		BaseEjectionTime:   time.Hour,
+		MaxEjectionPercent: 50,
+	}, "backend1", "backend2", "backend3", "backend4")
+
+	updater := &MockBackendHealthUpdater{}
+	updater.On("UpdateBackendHealth", "web", mock.Anything, false).Return()
+	checker := New(cfg, zap.NewNop(), updater)
+
+	for _, addr := range []string{"backend1", "backend2", "backend3", "backend4"} {
+		checker.PassiveCheck("web", addr, http.StatusServiceUnavailable, time.Millisecond)
+	}
+
+	ejected := 0
+	for _, addr := range []string{"backend1", "backend2", "backend3", "backend4"} {
+		if checker.IsEjected("web", addr) {
+			ejected++
+		}
+	}
+	assert.Equal(t, 2, ejected)
+}
+
+func TestPassiveCheckRestoreAndGrowingEjectionTime(t *testing.T) {
+	cfg := outlierConfig(config.OutlierDetection{
+		Enabled:          true,
+		Consecutive5xx:   1,
+		BaseEjectionTime: 20 * time.Millisecond,
+		MaxEjectionTime:  time.Hour,
+	}, "backend1")
+
+	updater := &MockBackendHealthUpdater{}
+	updater.On("UpdateBackendHealth", "web", "backend1", false).Return()
+	updater.On("UpdateBackendHealth", "web", "backend1", true).Return()
+	checker := New(cfg, zap.NewNop(), updater)
+
+	checker.PassiveCheck("web", "backend1", http.StatusInternalServerError, time.Millisecond)
+	assert.True(t, checker.IsEjected("web", "backend1"))
+	assert.Eventually(t, func() bool { return !checker.IsEjected("web", "backend1") },
+		time.Second, 5*time.Millisecond)
+	updater.AssertCalled(t, "UpdateBackendHealth", "web", "backend1", true)
+
+	// The second ejection lasts twice the base ejection time
+	checker.PassiveCheck("web", "backend1", http.StatusInternalServerError, time.Millisecond)
+	start := time.Now()
+	assert.Eventually(t, func() bool { return !checker.IsEjected("web", "backend1") },
+		time.Second, 5*time.Millisecond)
+	assert.GreaterOrEqual(t, time.Since(start), 35*time.Millisecond)
+}
+
+func TestPassiveCheckDisabled(t *testing.T) {
+	cfg := outlierConfig(config.OutlierDetection{}, "backend1")
+	checker := New(cfg, zap.NewNop(), &MockBackendHealthUpdater{})
+
+	for i := 0; i < 10; i++ {
+		checker.PassiveCheck("web", "backend1", http.StatusInternalServerError, time.Millisecond)
+	}
+	assert.False(t, checker.IsEjected("web", "backend1"))
+}
@@ -0,0 +1,397 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/upstream"
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// maxProbeBody limits how much of a probe response is read
+const maxProbeBody = 64 * 1024
+
+// Prober performs a single health probe against a backend address. A nil
+// error means the backend is healthy.
+type Prober interface {
+	Probe(ctx context.Context, address string) error
+	// Close releases the connections the prober keeps between probes
+	Close()
+}
+
+// NewProber builds the prober for a health check configuration
+func NewProber(hc config.HealthCheck) (Prober, error) {
+	return newProber(hc, nil)
+}
+
+// NewPoolProber builds the prober for a backend of a pool. When the pool
+// reaches its backends over TLS, probes use the pool's upstream TLS settings
+// unless the health check configures its own certificates. Pools of h2c
+// backends, which serve gRPC, are probed with the gRPC health protocol by
+// default.
+func NewPoolProber(hc config.HealthCheck, upstreamCfg config.UpstreamConfig) (Prober, error) {
+	if hc.Type == "" && upstreamCfg.Scheme == config.UpstreamH2C {
+		hc.Type = config.ProbeGRPC
+	}
+	if !upstream.UsesTLS(upstreamCfg) || hasProbeTLSFiles(hc) {
+		return NewProber(hc)
+	}
+
+	tlsConfig, err := upstream.ClientTLSConfig(upstreamCfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	return newProber(hc, tlsConfig)
+}
+
+// newProber builds a prober. A non-nil tlsConfig forces TLS for every probe type.
+func newProber(hc config.HealthCheck, tlsConfig *tls.Config) (Prober, error) {
+	switch hc.Type {
+	case "", config.ProbeHTTP, config.ProbeHTTPS:
+		return newHTTPProber(hc, tlsConfig)
+	case config.ProbeTCP:
+		return newTCPProber(hc, tlsConfig)
+	case config.ProbeGRPC:
+		return newGRPCProber(hc, tlsConfig)
+	default:
+		return nil, fmt.Errorf("unknown health check type: %s", hc.Type)
+	}
+}
+
+func hasProbeTLSFiles(hc config.HealthCheck) bool {
+	return hc.TLSCAFile != "" || hc.TLSCertFile != "" || hc.TLSKeyFile != ""
+}
+
+// probeTLSConfig builds the client TLS configuration used by probes
+func probeTLSConfig(hc config.HealthCheck) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         hc.TLSServerName,
+		InsecureSkipVerify: hc.TLSSkipVerify,
+	}
+
+	if hc.TLSCAFile != "" {
+		pem, err := os.ReadFile(hc.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", hc.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if hc.TLSCertFile != "" || hc.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(hc.TLSCertFile, hc.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+type httpProber struct {
+	scheme         string
+	path           string
+	host           string
+	headers        map[string]string
+	expectedStatus int
+	bodyMatch      *regexp.Regexp
+	client         *http.Client
+}
+
+func newHTTPProber(hc config.HealthCheck, tlsConfig *tls.Config) (*httpProber, error) {
+	p := &httpProber{
+		scheme:         "http",
+		path:           hc.Path,
+		host:           hc.Host,
+		headers:        hc.Headers,
+		expectedStatus: hc.ExpectedStatus,
+	}
+	if p.path == "" {
+		p.path = "/health"
+	}
+	if p.expectedStatus == 0 {
+		p.expectedStatus = http.StatusOK
+	}
+
+	if hc.BodyMatch != "" {
+		re, err := regexp.Compile(hc.BodyMatch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body_match expression: %w", err)
+		}
+		p.bodyMatch = re
+	}
+
+	transport := &http.Transport{DisableKeepAlives: true}
+	if tlsConfig == nil && hc.Type == config.ProbeHTTPS {
+		var err error
+		if tlsConfig, err = probeTLSConfig(hc); err != nil {
+			return nil, err
+		}
+	}
+	if tlsConfig != nil {
+		p.scheme = "https"
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	p.client = &http.Client{
+		Transport: transport,
+		// Redirects are reported as-is so they can be matched by expected_status
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return p, nil
+}
+
+func (p *httpProber) Probe(ctx context.Context, address string) error {
+	url := fmt.Sprintf("%s://%s%s", p.scheme, address, p.path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for name, value := range p.headers {
+		req.Header.Set(name, value)
+	}
+	if p.host != "" {
+		req.Host = p.host
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != p.expectedStatus {
+		return fmt.Errorf("unexpected status code %d, expected %d", resp.StatusCode, p.expectedStatus)
+	}
+
+	if p.bodyMatch != nil {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+		if err != nil {
+			return fmt.Errorf("failed to read response body: %w", err)
+		}
+		if !p.bodyMatch.Match(body) {
+			return fmt.Errorf("response body does not match %q", p.bodyMatch.String())
+		}
+	}
+
+	return nil
+}
+
+func (p *httpProber) Close() {
+	p.client.CloseIdleConnections()
+}
+
+type tcpProber struct {
+	tlsConfig *tls.Config
+}
+
+func newTCPProber(hc config.HealthCheck, tlsConfig *tls.Config) (*tcpProber, error) {
+	if tlsConfig == nil && hc.TLS {
+		var err error
+		if tlsConfig, err = probeTLSConfig(hc); err != nil {
+			return nil, err
+		}
+	}
+	return &tcpProber{tlsConfig: tlsConfig}, nil
+}
+
+func (p *tcpProber) Close() {}
+
+func (p *tcpProber) Probe(ctx context.Context, address string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if p.tlsConfig == nil {
+		return nil
+	}
+
+	tlsConn := tls.Client(conn, tlsConfigFor(p.tlsConfig, address))
+	return tlsConn.HandshakeContext(ctx)
+}
+
+// tlsConfigFor defaults the SNI to the backend host when none is configured
+func tlsConfigFor(tlsConfig *tls.Config, address string) *tls.Config {
+	if tlsConfig.ServerName != "" {
+		return tlsConfig
+	}
+	cfg := tlsConfig.Clone()
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		cfg.ServerName = host
+	} else {
+		cfg.ServerName = address
+	}
+	return cfg
+}
+
+// grpcProber implements the grpc.health.v1.Health/Check protocol over HTTP/2,
+// either cleartext (h2c) or TLS.
+type grpcProber struct {
+	scheme  string
+	service string
+	host    string
+	headers map[string]string
+	client  *http.Client
+}
+
+// gRPC health serving status as defined by grpc.health.v1.HealthCheckResponse
+const grpcHealthServing = 1
+
+func newGRPCProber(hc config.HealthCheck, tlsConfig *tls.Config) (*grpcProber, error) {
+	p := &grpcProber{
+		scheme:  "http",
+		service: hc.GRPCService,
+		host:    hc.Host,
+		headers: hc.Headers,
+	}
+
+	transport := &http2.Transport{}
+	if tlsConfig == nil && hc.TLS {
+		var err error
+		if tlsConfig, err = probeTLSConfig(hc); err != nil {
+			return nil, err
+		}
+	}
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.NextProtos = []string{"h2"}
+		transport.TLSClientConfig = tlsConfig
+		p.scheme = "https"
+	} else {
+		transport.AllowHTTP = true
+		transport.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+
+	p.client = &http.Client{Transport: transport}
+	return p, nil
+}
+
+func (p *grpcProber) Close() {
+	p.client.CloseIdleConnections()
+}
+
+func (p *grpcProber) Probe(ctx context.Context, address string) error {
+	// HealthCheckRequest{service = 1}, framed as an uncompressed gRPC message
+	msg := protowire.AppendTag(nil, 1, protowire.BytesType)
+	msg = protowire.AppendString(msg, p.service)
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	frame = append(frame, msg...)
+
+	url := fmt.Sprintf("%s://%s/grpc.health.v1.Health/Check", p.scheme, address)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+	for name, value := range p.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if p.host != "" {
+		req.Host = p.host
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status code %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// Trailers-only responses carry the status in the headers
+	grpcStatus := resp.Trailer.Get("Grpc-Status")
+	if grpcStatus == "" {
+		grpcStatus = resp.Header.Get("Grpc-Status")
+	}
+	if grpcStatus != "0" {
+		msg := resp.Trailer.Get("Grpc-Message")
+		if msg == "" {
+			msg = resp.Header.Get("Grpc-Message")
+		}
+		return fmt.Errorf("grpc-status %s: %s", grpcStatus, msg)
+	}
+
+	status, err := parseHealthCheckResponse(body)
+	if err != nil {
+		return err
+	}
+	if status != grpcHealthServing {
+		return fmt.Errorf("service %q is not serving (status %d)", p.service, status)
+	}
+
+	return nil
+}
+
+// parseHealthCheckResponse extracts the status field of a framed
+// grpc.health.v1.HealthCheckResponse message.
+func parseHealthCheckResponse(frame []byte) (int, error) {
+	if len(frame) < 5 {
+		return 0, fmt.Errorf("short gRPC response")
+	}
+	if frame[0] != 0 {
+		return 0, fmt.Errorf("compressed gRPC responses are not supported")
+	}
+	size := binary.BigEndian.Uint32(frame[1:5])
+	if uint32(len(frame)-5) < size {
+		return 0, fmt.Errorf("truncated gRPC response")
+	}
+
+	msg := frame[5 : 5+size]
+	status := 0
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		msg = msg[n:]
+		if num == 1 && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(msg)
+			if n < 0 {
+				return 0, protowire.ParseError(n)
+			}
+			status = int(v)
+			msg = msg[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, msg)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		msg = msg[n:]
+	}
+
+	return status, nil
+}
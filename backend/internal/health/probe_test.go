@@ -0,0 +1,329 @@
+package health
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func probe(t *testing.T, hc config.HealthCheck, address string) error {
+	t.Helper()
+	prober, err := NewProber(hc)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return prober.Probe(ctx, address)
+}
+
+func TestHTTPProberHeadersHostAndBodyMatch(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "status.internal" || r.Header.Get("X-Probe") != "veloflux" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"status":"UP"}`))
+	}))
+	defer testServer.Close()
+
+	address := testServer.URL[7:]
+	hc := config.HealthCheck{
+		Path:      "/status",
+		Host:      "status.internal",
+		Headers:   map[string]string{"X-Probe": "veloflux"},
+		BodyMatch: `"status":\s*"UP"`,
+	}
+	assert.NoError(t, probe(t, hc, address))
+
+	hc.BodyMatch = `"status":\s*"DOWN"`
+	assert.Error(t, probe(t, hc, address))
+
+	hc.Host = ""
+	hc.BodyMatch = ""
+	assert.Error(t, probe(t, hc, address))
+}
+
+func TestHTTPSProber(t *testing.T) {
+	testServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	address := strings.TrimPrefix(testServer.URL, "https://")
+
+	// The test certificate is self-signed, so verification must fail
+	assert.Error(t, probe(t, config.HealthCheck{Type: config.ProbeHTTPS}, address))
+	assert.NoError(t, probe(t, config.HealthCheck{Type: config.ProbeHTTPS, TLSSkipVerify: true}, address))
+}
+
+func TestTCPProber(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	assert.NoError(t, probe(t, config.HealthCheck{Type: config.ProbeTCP}, address))
+
+	listener.Close()
+	assert.Error(t, probe(t, config.HealthCheck{Type: config.ProbeTCP}, address))
+}
+
+func TestNewProberInvalidConfig(t *testing.T) {
+	_, err := NewProber(config.HealthCheck{Type: "icmp"})
+	assert.Error(t, err)
+
+	_, err = NewProber(config.HealthCheck{BodyMatch: "("})
+	assert.Error(t, err)
+
+	_, err = NewProber(config.HealthCheck{Type: config.ProbeHTTPS, TLSCertFile: "/nonexistent.pem"})
+	assert.Error(t, err)
+}
+
+// grpcHealthHandler answers grpc.health.v1.Health/Check with the given status per service
+func grpcHealthHandler(statuses map[string]int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/grpc.health.v1.Health/Check" {
+			w.Header().Set("Grpc-Status", "12")
+			return
+		}
+
+		frame, _ := io.ReadAll(r.Body)
+		msg := frame[5:]
+		var service string
+		for len(msg) > 0 {
+			num, typ, n := protowire.ConsumeTag(msg)
+			msg = msg[n:]
+			if num == 1 && typ == protowire.BytesType {
+				v, n := protowire.ConsumeString(msg)
+				service = v
+				msg = msg[n:]
+				continue
+			}
+			msg = msg[protowire.ConsumeFieldValue(num, typ, msg):]
+		}
+
+		status, ok := statuses[service]
+		if !ok {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "unknown service")
+			return
+		}
+
+		resp := protowire.AppendTag(nil, 1, protowire.VarintType)
+		resp = protowire.AppendVarint(resp, uint64(status))
+		out := make([]byte, 5, 5+len(resp))
+		binary.BigEndian.PutUint32(out[1:], uint32(len(resp)))
+		out = append(out, resp...)
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write(out)
+		w.Header().Set("Grpc-Status", "0")
+	})
+}
+
+func TestGRPCProber(t *testing.T) {
+	handler := grpcHealthHandler(map[string]int{"": 1, "payments": 1, "search": 2})
+	testServer := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer testServer.Close()
+
+	address := testServer.URL[7:]
+	assert.NoError(t, probe(t, config.HealthCheck{Type: config.ProbeGRPC}, address))
+	assert.NoError(t, probe(t, config.HealthCheck{Type: config.ProbeGRPC, GRPCService: "payments"}, address))
+	assert.Error(t, probe(t, config.HealthCheck{Type: config.ProbeGRPC, GRPCService: "search"}, address))
+	assert.Error(t, probe(t, config.HealthCheck{Type: config.ProbeGRPC, GRPCService: "missing"}, address))
+}
+
+func TestPoolProberDefaultsToGRPCForH2C(t *testing.T) {
+	handler := grpcHealthHandler(map[string]int{"": 1})
+	testServer := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer testServer.Close()
+
+	prober, err := NewPoolProber(config.HealthCheck{}, config.UpstreamConfig{Scheme: config.UpstreamH2C})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.NoError(t, prober.Probe(ctx, testServer.URL[7:]))
+	assert.IsType(t, &grpcProber{}, prober)
+}
+
+func TestParseHealthCheckResponse(t *testing.T) {
+	_, err := parseHealthCheckResponse([]byte{0, 0})
+	assert.Error(t, err)
+
+	_, err = parseHealthCheckResponse([]byte{0, 0, 0, 0, 10, 8})
+	assert.Error(t, err)
+
+	status, err := parseHealthCheckResponse([]byte{0, 0, 0, 0, 2, 8, 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, status)
+}
+
+type recordingUpdater struct {
+	mu      sync.Mutex
+	results []bool
+}
+
+func (u *recordingUpdater) UpdateBackendHealth(poolName, backendAddress string, healthy bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.results = append(u.results, healthy)
+}
+
+func (u *recordingUpdater) snapshot() []bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]bool(nil), u.results...)
+}
+
+func TestHealthyAndUnhealthyThresholds(t *testing.T) {
+	var mu sync.Mutex
+	status := http.StatusInternalServerError
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	defer testServer.Close()
+
+	cfg := &config.Config{
+		Pools: []config.Pool{{
+			Name: "web",
+			Backends: []config.Backend{{
+				Address: testServer.URL[7:],
+				HealthCheck: config.HealthCheck{
+					Path:               "/",
+					Interval:           10 * time.Millisecond,
+					Timeout:            time.Second,
+					HealthyThreshold:   3,
+					UnhealthyThreshold: 2,
+				},
+			}},
+		}},
+	}
+
+	updater := &recordingUpdater{}
+	checker := New(cfg, zap.NewNop(), updater)
+	checker.Start(context.Background())
+	defer checker.Stop()
+
+	require.Eventually(t, func() bool { return len(updater.snapshot()) >= 2 }, time.Second, 5*time.Millisecond)
+	results := updater.snapshot()
+	assert.Equal(t, []bool{true, false}, results[:2], "backend becomes unhealthy after the second failure")
+
+	mu.Lock()
+	status = http.StatusOK
+	mu.Unlock()
+
+	require.Eventually(t, func() bool {
+		results := updater.snapshot()
+		return results[len(results)-1]
+	}, time.Second, 5*time.Millisecond)
+
+	results = updater.snapshot()
+	recovered := len(results) - 1
+	assert.False(t, results[recovered-1])
+	assert.False(t, results[recovered-2], "backend needs three consecutive successes to recover")
+}
+
+func TestPoolProberUsesUpstreamTLS(t *testing.T) {
+	testServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer testServer.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: testServer.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, caPEM, 0o600))
+
+	address := strings.TrimPrefix(testServer.URL, "https://")
+	upstreamCfg := config.UpstreamConfig{
+		Scheme: config.UpstreamHTTPS,
+		TLS:    config.UpstreamTLS{CAFile: caFile},
+	}
+
+	for _, hc := range []config.HealthCheck{{Path: "/"}, {Type: config.ProbeTCP}} {
+		prober, err := NewPoolProber(hc, upstreamCfg)
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		assert.NoError(t, prober.Probe(ctx, address), "probe type %q", hc.Type)
+		cancel()
+	}
+
+	// Plain HTTP probes fail against a TLS backend
+	prober, err := NewPoolProber(config.HealthCheck{Path: "/"}, config.UpstreamConfig{})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.Error(t, prober.Probe(ctx, address))
+}
+
+// closeCountingListener counts the accepted connections that were closed
+type closeCountingListener struct {
+	net.Listener
+	closed atomic.Int32
+}
+
+func (l *closeCountingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &closeCountingConn{Conn: conn, listener: l}, nil
+}
+
+type closeCountingConn struct {
+	net.Conn
+	listener *closeCountingListener
+	once     sync.Once
+}
+
+func (c *closeCountingConn) Close() error {
+	c.once.Do(func() { c.listener.closed.Add(1) })
+	return c.Conn.Close()
+}
+
+func TestGRPCProberCloseReleasesConnections(t *testing.T) {
+	testServer := httptest.NewUnstartedServer(h2c.NewHandler(grpcHealthHandler(map[string]int{"": 1}), &http2.Server{}))
+	listener := &closeCountingListener{Listener: testServer.Listener}
+	testServer.Listener = listener
+	testServer.Start()
+	defer testServer.Close()
+
+	prober, err := NewProber(config.HealthCheck{Type: config.ProbeGRPC})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, prober.Probe(ctx, testServer.URL[7:]))
+	assert.Zero(t, listener.closed.Load(), "the connection is kept between probes")
+
+	prober.Close()
+	assert.Eventually(t, func() bool { return listener.closed.Load() == 1 }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,399 @@
+// Package l4 balances TCP connections and UDP flows over the backends of
+// pools, for protocols such as Postgres, Redis, MQTT or DNS that the HTTP
+// router does not proxy.
+package l4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/balancer"
+	"github.com/eltonciatto/veloflux/internal/clientip"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/drain"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Defaults of listener settings left unset
+const (
+	defaultTCPIdleTimeout = 5 * time.Minute
+	defaultUDPIdleTimeout = 30 * time.Second
+	defaultConnectTimeout = 5 * time.Second
+	defaultDrainTimeout   = 10 * time.Second
+)
+
+// drainPollInterval is how often the drain flag of the node is read, so that
+// new connections and flows check it without a Redis round trip
+const drainPollInterval = time.Second
+
+// Reasons connections and flows are refused
+const (
+	rejectLimit     = "limit"
+	rejectDraining  = "draining"
+	rejectNoBackend = "no_backend"
+	rejectDial      = "dial_error"
+)
+
+// Reasons connections and flows end
+const (
+	closedNormally = "closed"
+	closedIdle     = "idle_timeout"
+	closedDrain    = "drain"
+)
+
+// Directions of proxied bytes
+const (
+	upstream   = "upstream"
+	downstream = "downstream"
+)
+
+// Manager runs the TCP and UDP listeners of the configuration
+type Manager struct {
+	balancer *balancer.Balancer
+	drain    *drain.Manager
+	logger   *zap.Logger
+	trusted  atomic.Pointer[clientip.Resolver] // proxies whose PROXY protocol headers are read
+
+	mu        sync.Mutex
+	listeners map[string]listener
+	stopWatch context.CancelFunc // stops reading the drain flag
+}
+
+// listener is a bound TCP or UDP listener
+type listener interface {
+	// settings returns the current configuration of the listener
+	settings() *config.Listener
+	// update applies a configuration that keeps the listener bound
+	update(cfg config.Listener)
+	// shutdown stops taking new connections and flows and closes the open
+	// ones once the drain timeout or ctx expires, unless they end first
+	shutdown(ctx context.Context)
+	addr() net.Addr
+}
+
+// New creates a manager picking backends from the balancer. The drain manager
+// may be nil.
+func New(bal *balancer.Balancer, drainer *drain.Manager, logger *zap.Logger) *Manager {
+	return &Manager{
+		balancer:  bal,
+		drain:     drainer,
+		logger:    logger,
+		listeners: make(map[string]listener),
+	}
+}
+
+// SetTrustedProxies sets the proxies TCP listeners with accept_proxy read
+// PROXY protocol headers from. Listeners trust no proxy until it is set.
+func (m *Manager) SetTrustedProxies(resolver *clientip.Resolver) {
+	m.trusted.Store(resolver)
+}
+
+// Start binds the listeners and starts reading the drain flag of the node
+func (m *Manager) Start(listeners []config.Listener) error {
+	m.mu.Lock()
+	if m.stopWatch == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		m.stopWatch = cancel
+		go m.drain.Watch(ctx, drainPollInterval)
+	}
+	m.mu.Unlock()
+	return m.Reload(listeners)
+}
+
+// Reload binds new listeners and drains removed ones. Listeners keeping their
+// protocol and address stay bound and apply the new settings to the
+// connections and flows they accept from then on. Listeners that fail to bind
+// are reported and skipped.
+func (m *Manager) Reload(listeners []config.Listener) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	wanted := make(map[string]config.Listener, len(listeners))
+	for _, cfg := range listeners {
+		wanted[cfg.Name] = cfg
+	}
+
+	for name, l := range m.listeners {
+		cfg, keep := wanted[name]
+		current := l.settings()
+		if keep && cfg.Protocol == current.Protocol && cfg.Address == current.Address {
+			continue
+		}
+		delete(m.listeners, name)
+		go l.shutdown(context.Background())
+	}
+
+	var errs []error
+	for _, cfg := range listeners {
+		if l, exists := m.listeners[cfg.Name]; exists {
+			l.update(cfg)
+			continue
+		}
+		l, err := m.listen(cfg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("listener %s: %w", cfg.Name, err))
+			continue
+		}
+		m.listeners[cfg.Name] = l
+		m.logger.Info("Started listener",
+			zap.String("listener", cfg.Name),
+			zap.String("protocol", cfg.Protocol),
+			zap.String("address", l.addr().String()),
+			zap.String("pool", cfg.Pool))
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Manager) listen(cfg config.Listener) (listener, error) {
+	b := &base{balancer: m.balancer, drain: m.drain, logger: m.logger, trusted: &m.trusted}
+	b.cfg.Store(&cfg)
+	if cfg.Protocol == config.ListenerUDP {
+		return listenUDP(b)
+	}
+	return listenTCP(b)
+}
+
+// Addr returns the address a listener is bound to, or nil
+func (m *Manager) Addr(name string) net.Addr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l, exists := m.listeners[name]; exists {
+		return l.addr()
+	}
+	return nil
+}
+
+// Shutdown stops every listener and returns once their connections and flows
+// are closed
+func (m *Manager) Shutdown(ctx context.Context) {
+	m.mu.Lock()
+	listeners := m.listeners
+	m.listeners = make(map[string]listener)
+	if m.stopWatch != nil {
+		m.stopWatch()
+		m.stopWatch = nil
+	}
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l listener) {
+			defer wg.Done()
+			l.shutdown(ctx)
+		}(l)
+	}
+	wg.Wait()
+}
+
+// base holds what TCP and UDP listeners share: their settings, the backend
+// choice and the sessions they proxy
+type base struct {
+	cfg      atomic.Pointer[config.Listener]
+	balancer *balancer.Balancer
+	drain    *drain.Manager
+	logger   *zap.Logger
+	trusted  *atomic.Pointer[clientip.Resolver]
+
+	mu       sync.Mutex
+	draining bool
+	sessions map[*session]bool
+}
+
+func (b *base) settings() *config.Listener {
+	return b.cfg.Load()
+}
+
+func (b *base) update(cfg config.Listener) {
+	b.cfg.Store(&cfg)
+}
+
+// open picks a backend for a new connection or flow and reserves its place
+// among the open sessions. It returns the reason to refuse it otherwise.
+func (b *base) open(clientIP net.IP) (*session, *balancer.Backend, string) {
+	cfg := b.settings()
+	if b.drain.DrainingCached() {
+		return nil, nil, rejectDraining
+	}
+
+	s := &session{base: b, listener: cfg.Name, pool: cfg.Pool, closed: make(chan struct{})}
+	b.mu.Lock()
+	switch {
+	case b.draining:
+		b.mu.Unlock()
+		return nil, nil, rejectDraining
+	case cfg.MaxConnections > 0 && len(b.sessions) >= cfg.MaxConnections:
+		b.mu.Unlock()
+		return nil, nil, rejectLimit
+	}
+	if b.sessions == nil {
+		b.sessions = make(map[*session]bool)
+	}
+	b.sessions[s] = true
+	b.mu.Unlock()
+
+	backend, err := b.balancer.GetBackend(cfg.Pool, clientIP, "", nil)
+	if err != nil {
+		b.remove(s)
+		b.logger.Debug("No backend for listener",
+			zap.String("listener", cfg.Name),
+			zap.Error(err))
+		return nil, nil, rejectNoBackend
+	}
+	// Released by abandon, when the session closes or fails to connect
+	b.balancer.IncrementConnections(cfg.Pool, backend.Address)
+	s.backend = backend.Address
+	return s, backend, ""
+}
+
+// abandon releases a session whose backend could not be reached
+func (b *base) abandon(s *session) {
+	b.remove(s)
+	b.balancer.DecrementConnections(s.pool, s.backend)
+}
+
+func (b *base) remove(s *session) {
+	b.mu.Lock()
+	delete(b.sessions, s)
+	b.mu.Unlock()
+}
+
+func (b *base) reject(reason string) {
+	metrics.L4RejectedTotal.WithLabelValues(b.settings().Name, reason).Inc()
+}
+
+// drainSessions refuses new sessions and closes the open ones once the drain
+// timeout or ctx expires, unless they end first
+func (b *base) drainSessions(ctx context.Context) {
+	b.mu.Lock()
+	b.draining = true
+	sessions := make([]*session, 0, len(b.sessions))
+	for s := range b.sessions {
+		// Sessions still connecting to their backend see the drain on start
+		if s.started.Load() {
+			sessions = append(sessions, s)
+		}
+	}
+	b.mu.Unlock()
+
+	timer := time.NewTimer(drainTimeout(b.settings()))
+	defer timer.Stop()
+
+	for _, s := range sessions {
+		select {
+		case <-s.closed:
+			continue
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		// Out of time: close the sessions still open
+		for _, s := range sessions {
+			s.closeWith(closedDrain)
+		}
+		return
+	}
+}
+
+// session is a proxied TCP connection or UDP flow
+type session struct {
+	base       *base
+	listener   string
+	pool       string
+	backend    string
+	release    func() // closes the sockets of the session
+	drainDone  func()
+	started    atomic.Bool
+	lastActive atomic.Int64 // unix nanoseconds of the last traffic
+	closeOnce  sync.Once
+	closed     chan struct{}
+}
+
+// start records a session that reached its backend and closes it once it
+// saw no traffic for the idle timeout
+func (s *session) start(release func(), idleTimeout time.Duration) {
+	s.release = release
+	s.drainDone = s.base.drain.Open(context.Background())
+	s.touch()
+	metrics.L4ConnectionsTotal.WithLabelValues(s.listener, s.pool, s.backend).Inc()
+	metrics.L4OpenConnections.WithLabelValues(s.listener).Inc()
+	go s.watchIdle(idleTimeout)
+
+	s.base.mu.Lock()
+	s.started.Store(true)
+	draining := s.base.draining
+	s.base.mu.Unlock()
+	if draining {
+		// Connected after the drain started
+		time.AfterFunc(drainTimeout(s.base.settings()), func() { s.closeWith(closedDrain) })
+	}
+}
+
+func (s *session) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+func (s *session) watchIdle(timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-timer.C:
+		}
+		idle := time.Since(time.Unix(0, s.lastActive.Load()))
+		if idle >= timeout {
+			s.closeWith(closedIdle)
+			return
+		}
+		timer.Reset(timeout - idle)
+	}
+}
+
+// closeWith closes a started session, recording why it ended
+func (s *session) closeWith(reason string) {
+	s.closeOnce.Do(func() {
+		s.release()
+		close(s.closed)
+		s.base.abandon(s)
+		s.drainDone()
+		metrics.L4OpenConnections.WithLabelValues(s.listener).Dec()
+		metrics.L4ClosedTotal.WithLabelValues(s.listener, reason).Inc()
+	})
+}
+
+// idleTimeout returns the idle timeout of a listener
+func idleTimeout(cfg *config.Listener) time.Duration {
+	switch {
+	case cfg.IdleTimeout > 0:
+		return cfg.IdleTimeout
+	case cfg.Protocol == config.ListenerUDP:
+		return defaultUDPIdleTimeout
+	default:
+		return defaultTCPIdleTimeout
+	}
+}
+
+// drainTimeout returns how long the sessions of a listener may outlive a drain
+func drainTimeout(cfg *config.Listener) time.Duration {
+	if cfg.DrainTimeout > 0 {
+		return cfg.DrainTimeout
+	}
+	return defaultDrainTimeout
+}
+
+// connectTimeout returns the backend connect timeout of a listener
+func connectTimeout(cfg *config.Listener) time.Duration {
+	if cfg.ConnectTimeout > 0 {
+		return cfg.ConnectTimeout
+	}
+	return defaultConnectTimeout
+}
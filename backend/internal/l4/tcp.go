@@ -0,0 +1,170 @@
+package l4
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/eltonciatto/veloflux/internal/proxyproto"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// tcpListener proxies every accepted connection to a backend of its pool
+type tcpListener struct {
+	*base
+	ln net.Listener
+}
+
+func listenTCP(b *base) (*tcpListener, error) {
+	ln, err := net.Listen("tcp", b.settings().Address)
+	if err != nil {
+		return nil, err
+	}
+	l := &tcpListener{base: b}
+	// Connections then report the client addresses behind the proxy, for
+	// the balancing, limits and headers sent to backends
+	l.ln = &proxyproto.Listener{Listener: ln, Trusted: l.acceptsProxy}
+	go l.serve()
+	return l, nil
+}
+
+func (l *tcpListener) addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// acceptsProxy reports whether a peer may start its connection with a PROXY
+// protocol header
+func (l *tcpListener) acceptsProxy(addr net.Addr) bool {
+	return l.settings().AcceptProxy && l.trusted.Load().TrustsAddr(addr)
+}
+
+func (l *tcpListener) serve() {
+	var delay time.Duration
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Back off on errors such as running out of file descriptors,
+			// as net/http does
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			l.logger.Warn("Listener accept error",
+				zap.String("listener", l.settings().Name),
+				zap.Error(err))
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go l.handle(conn)
+	}
+}
+
+func (l *tcpListener) handle(client net.Conn) {
+	cfg := l.settings()
+	var clientIP net.IP
+	if addr, ok := client.RemoteAddr().(*net.TCPAddr); ok {
+		clientIP = addr.IP
+	}
+
+	s, backend, reason := l.open(clientIP)
+	if reason != "" {
+		l.reject(reason)
+		client.Close()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout(cfg))
+	defer cancel()
+	server, err := (&net.Dialer{}).DialContext(ctx, "tcp", backend.Address)
+	if err == nil && cfg.ProxyProtocol > 0 {
+		err = writeProxyHeader(server, cfg.ProxyProtocol, client)
+	}
+	if err != nil {
+		l.logger.Warn("Failed to connect listener backend",
+			zap.String("listener", cfg.Name),
+			zap.String("backend", backend.Address),
+			zap.Error(err))
+		if server != nil {
+			server.Close()
+		}
+		l.abandon(s)
+		l.reject(rejectDial)
+		client.Close()
+		return
+	}
+
+	s.start(func() {
+		client.Close()
+		server.Close()
+	}, idleTimeout(cfg))
+
+	// Each side may stop sending while still reading what the other has to
+	// say, so the end of one direction only half-closes the other connection
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		pipe(s, server, client, metrics.L4BytesTotal.WithLabelValues(cfg.Name, upstream))
+	}()
+	go func() {
+		defer wg.Done()
+		pipe(s, client, server, metrics.L4BytesTotal.WithLabelValues(cfg.Name, downstream))
+	}()
+	wg.Wait()
+	s.closeWith(closedNormally)
+}
+
+// writeProxyHeader sends the addresses of the client connection to the backend
+func writeProxyHeader(server net.Conn, version int, client net.Conn) error {
+	header, err := proxyproto.Header{Source: client.RemoteAddr(), Destination: client.LocalAddr()}.Format(version)
+	if err != nil {
+		return err
+	}
+	_, err = server.Write(header)
+	return err
+}
+
+// pipe copies from src to dst until src ends, then closes the write side of
+// dst. Errors end the whole session.
+func pipe(s *session, dst, src net.Conn, bytes prometheus.Counter) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			s.touch()
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				s.closeWith(closedNormally)
+				return
+			}
+			bytes.Add(float64(n))
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			s.closeWith(closedNormally)
+			return
+		}
+	}
+	if tcp, ok := dst.(interface{ CloseWrite() error }); ok {
+		tcp.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
+
+// shutdown stops accepting connections and drains the open ones
+func (l *tcpListener) shutdown(ctx context.Context) {
+	l.ln.Close()
+	l.drainSessions(ctx)
+}
@@ -0,0 +1,234 @@
+package l4
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/balancer"
+	"github.com/eltonciatto/veloflux/internal/clientip"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// tcpBackend greets every connection with its name and the first line it
+// received, then echoes the rest
+func tcpBackend(t *testing.T, name string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				io.WriteString(conn, name+" "+line)
+				io.Copy(conn, reader)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// newTestManager builds a manager balancing over a pool of the given backends
+func newTestManager(t *testing.T, algorithm string, backends ...string) *Manager {
+	pool := config.Pool{Name: "tcp-pool", Algorithm: algorithm}
+	for _, address := range backends {
+		pool.Backends = append(pool.Backends, config.Backend{Address: address})
+	}
+	bal := balancer.New()
+	bal.AddPool(pool)
+
+	m := New(bal, nil, zap.NewNop())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+	})
+	return m
+}
+
+func startListener(t *testing.T, m *Manager, cfg config.Listener) string {
+	cfg.Address = "127.0.0.1:0"
+	cfg.Pool = "tcp-pool"
+	if cfg.Protocol == "" {
+		cfg.Protocol = config.ListenerTCP
+	}
+	require.NoError(t, m.Start([]config.Listener{cfg}))
+	return m.Addr(cfg.Name).String()
+}
+
+// greet opens a connection through the listener and returns it with the
+// backend's greeting
+func greet(t *testing.T, addr, line string) (net.Conn, *bufio.Reader, string) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = io.WriteString(conn, line+"\n")
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	return conn, reader, strings.TrimSpace(reply)
+}
+
+// waitClosed waits until the listener closes the connection
+func waitClosed(t *testing.T, conn net.Conn, reader *bufio.Reader) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestTCPListenerBalancesConnections(t *testing.T) {
+	m := newTestManager(t, "round_robin", tcpBackend(t, "one"), tcpBackend(t, "two"))
+	addr := startListener(t, m, config.Listener{Name: "tcp-rr"})
+
+	seen := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		conn, reader, reply := greet(t, addr, "hello")
+		seen[strings.Fields(reply)[0]]++
+
+		// The rest of the stream goes to the same backend
+		_, err := io.WriteString(conn, "more\n")
+		require.NoError(t, err)
+		echoed, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "more\n", echoed)
+		conn.Close()
+	}
+	assert.Equal(t, map[string]int{"one": 2, "two": 2}, seen)
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.L4OpenConnections.WithLabelValues("tcp-rr")) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 4.0, testutil.ToFloat64(metrics.L4ClosedTotal.WithLabelValues("tcp-rr", closedNormally)))
+	assert.Equal(t, 4*len("hello\nmore\n"), int(testutil.ToFloat64(metrics.L4BytesTotal.WithLabelValues("tcp-rr", upstream))))
+}
+
+func TestTCPListenerHashesClients(t *testing.T) {
+	m := newTestManager(t, "ip_hash", tcpBackend(t, "one"), tcpBackend(t, "two"), tcpBackend(t, "three"))
+	addr := startListener(t, m, config.Listener{Name: "tcp-hash"})
+
+	_, _, first := greet(t, addr, "hello")
+	for i := 0; i < 3; i++ {
+		_, _, reply := greet(t, addr, "hello")
+		assert.Equal(t, first, reply)
+	}
+}
+
+func TestTCPListenerProxyProtocol(t *testing.T) {
+	m := newTestManager(t, "", tcpBackend(t, "backend"))
+	addr := startListener(t, m, config.Listener{Name: "tcp-proxy", ProxyProtocol: 1})
+
+	conn, _, reply := greet(t, addr, "hello")
+	client := conn.LocalAddr().(*net.TCPAddr)
+	listener := conn.RemoteAddr().(*net.TCPAddr)
+
+	// The backend reads the PROXY protocol line before the client's
+	want := fmt.Sprintf("backend PROXY TCP4 127.0.0.1 127.0.0.1 %d %d", client.Port, listener.Port)
+	assert.Equal(t, want, reply)
+}
+
+func TestTCPListenerAcceptsProxyProtocol(t *testing.T) {
+	m := newTestManager(t, "", tcpBackend(t, "backend"))
+	addr := startListener(t, m, config.Listener{Name: "tcp-accept-proxy", ProxyProtocol: 1, AcceptProxy: true})
+	header := "PROXY TCP4 192.0.2.1 198.51.100.7 56324 5432\r\n"
+
+	// Until the proxy is trusted its header is passed on as data
+	_, _, reply := greet(t, addr, header+"hello")
+	assert.Contains(t, reply, "backend PROXY TCP4 127.0.0.1 127.0.0.1")
+
+	// The addresses a trusted proxy sends are forwarded to the backend
+	trusted, err := clientip.New([]string{"127.0.0.1"})
+	require.NoError(t, err)
+	m.SetTrustedProxies(trusted)
+	_, _, reply = greet(t, addr, header+"hello")
+	assert.Equal(t, "backend "+strings.TrimSpace(header), reply)
+}
+
+func TestTCPListenerIdleTimeout(t *testing.T) {
+	m := newTestManager(t, "", tcpBackend(t, "backend"))
+	addr := startListener(t, m, config.Listener{Name: "tcp-idle", IdleTimeout: 200 * time.Millisecond})
+
+	conn, reader, _ := greet(t, addr, "hello")
+	waitClosed(t, conn, reader)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.L4ClosedTotal.WithLabelValues("tcp-idle", closedIdle)))
+}
+
+func TestTCPListenerLimitAndDrain(t *testing.T) {
+	m := newTestManager(t, "", tcpBackend(t, "backend"))
+	addr := startListener(t, m, config.Listener{Name: "tcp-limit", MaxConnections: 1, DrainTimeout: 100 * time.Millisecond})
+
+	conn, reader, _ := greet(t, addr, "hello")
+
+	refused, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer refused.Close()
+	waitClosed(t, refused, bufio.NewReader(refused))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.L4RejectedTotal.WithLabelValues("tcp-limit", rejectLimit)))
+
+	start := time.Now()
+	m.Shutdown(context.Background())
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	waitClosed(t, conn, reader)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.L4ClosedTotal.WithLabelValues("tcp-limit", closedDrain)))
+
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+}
+
+func TestTCPListenerWithoutBackends(t *testing.T) {
+	m := newTestManager(t, "")
+	addr := startListener(t, m, config.Listener{Name: "tcp-empty"})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	waitClosed(t, conn, bufio.NewReader(conn))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.L4RejectedTotal.WithLabelValues("tcp-empty", rejectNoBackend)))
+}
+
+func TestReload(t *testing.T) {
+	m := newTestManager(t, "", tcpBackend(t, "backend"))
+	addr := startListener(t, m, config.Listener{Name: "tcp-reload"})
+	cfg := config.Listener{Name: "tcp-reload", Protocol: config.ListenerTCP, Address: "127.0.0.1:0", Pool: "tcp-pool"}
+
+	// Settings change without rebinding
+	cfg.ProxyProtocol = 1
+	require.NoError(t, m.Reload([]config.Listener{cfg}))
+	assert.Equal(t, addr, m.Addr("tcp-reload").String())
+	_, _, reply := greet(t, addr, "hello")
+	assert.True(t, strings.HasPrefix(reply, "backend PROXY TCP4"), reply)
+
+	require.NoError(t, m.Reload(nil))
+	assert.Nil(t, m.Addr("tcp-reload"))
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Error(t, m.Reload([]config.Listener{{Name: "bad", Protocol: config.ListenerTCP, Address: "256.0.0.1:0", Pool: "tcp-pool"}}))
+}
@@ -0,0 +1,159 @@
+package l4
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/eltonciatto/veloflux/internal/proxyproto"
+	"go.uber.org/zap"
+)
+
+// maxDatagramSize fits any UDP payload
+const maxDatagramSize = 64 * 1024
+
+// udpListener proxies the datagrams of every client address, a flow, to a
+// backend of its pool over a socket of its own, so replies can be told apart
+type udpListener struct {
+	*base
+	conn *net.UDPConn
+
+	mu    sync.Mutex
+	flows map[string]*udpFlow // by client address
+}
+
+// udpFlow is the datagram exchange between a client address and a backend
+type udpFlow struct {
+	session *session
+	client  *net.UDPAddr
+	server  *net.UDPConn
+	header  []byte // PROXY protocol header sent ahead of every datagram
+}
+
+func listenUDP(b *base) (*udpListener, error) {
+	addr, err := net.ResolveUDPAddr("udp", b.settings().Address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &udpListener{base: b, conn: conn, flows: make(map[string]*udpFlow)}
+	go l.serve()
+	return l, nil
+}
+
+func (l *udpListener) addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *udpListener) serve() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, client, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		l.mu.Lock()
+		flow := l.flows[client.String()]
+		l.mu.Unlock()
+		if flow == nil {
+			if flow = l.openFlow(client); flow == nil {
+				continue
+			}
+		}
+
+		datagram := buf[:n]
+		if flow.header != nil {
+			datagram = append(append(make([]byte, 0, len(flow.header)+n), flow.header...), buf[:n]...)
+		}
+		flow.session.touch()
+		if _, err := flow.server.Write(datagram); err == nil {
+			metrics.L4BytesTotal.WithLabelValues(flow.session.listener, upstream).Add(float64(n))
+		}
+	}
+}
+
+// openFlow connects a new client address to a backend, or returns nil when
+// its datagrams are dropped
+func (l *udpListener) openFlow(client *net.UDPAddr) *udpFlow {
+	cfg := l.settings()
+	s, backend, reason := l.open(client.IP)
+	if reason != "" {
+		l.reject(reason)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout(cfg))
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", backend.Address)
+	if err != nil {
+		l.logger.Warn("Failed to connect listener backend",
+			zap.String("listener", cfg.Name),
+			zap.String("backend", backend.Address),
+			zap.Error(err))
+		l.abandon(s)
+		l.reject(rejectDial)
+		return nil
+	}
+
+	flow := &udpFlow{session: s, client: client, server: conn.(*net.UDPConn)}
+	if cfg.ProxyProtocol > 0 {
+		header := proxyproto.Header{Source: client, Destination: l.conn.LocalAddr()}
+		if flow.header, err = header.Format(cfg.ProxyProtocol); err != nil {
+			conn.Close()
+			l.abandon(s)
+			l.reject(rejectDial)
+			return nil
+		}
+	}
+
+	key := client.String()
+	l.mu.Lock()
+	l.flows[key] = flow
+	l.mu.Unlock()
+	s.start(func() {
+		l.mu.Lock()
+		delete(l.flows, key)
+		l.mu.Unlock()
+		conn.Close()
+	}, idleTimeout(cfg))
+
+	go l.reply(flow)
+	return flow
+}
+
+// reply sends the datagrams of the backend back to the client until the flow
+// is closed
+func (l *udpListener) reply(flow *udpFlow) {
+	bytes := metrics.L4BytesTotal.WithLabelValues(flow.session.listener, downstream)
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := flow.server.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Such as the ICMP port unreachable of a backend that went away
+			continue
+		}
+		flow.session.touch()
+		if _, err := l.conn.WriteToUDP(buf[:n], flow.client); err == nil {
+			bytes.Add(float64(n))
+		}
+	}
+}
+
+// shutdown stops opening flows and closes the socket once the open ones are
+// drained; until then it keeps relaying their datagrams
+func (l *udpListener) shutdown(ctx context.Context) {
+	l.drainSessions(ctx)
+	l.conn.Close()
+}
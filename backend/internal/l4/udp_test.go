@@ -0,0 +1,104 @@
+package l4
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// udpBackend answers every datagram with its name followed by the datagram
+func udpBackend(t *testing.T, name string) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(append([]byte(name+" "), buf[:n]...), addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// exchange sends a datagram through the listener and returns the reply
+func exchange(t *testing.T, conn *net.UDPConn, message string) string {
+	_, err := conn.Write([]byte(message))
+	require.NoError(t, err)
+	buf := make([]byte, maxDatagramSize)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+// backendName returns the name a backend prefixed its reply with
+func backendName(reply string) string {
+	name, _, _ := strings.Cut(reply, " ")
+	return name
+}
+
+func dialUDP(t *testing.T, addr string) *net.UDPConn {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	require.NoError(t, err)
+	conn, err := net.DialUDP("udp", nil, raddr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestUDPListenerBalancesFlows(t *testing.T) {
+	m := newTestManager(t, "round_robin", udpBackend(t, "one"), udpBackend(t, "two"))
+	addr := startListener(t, m, config.Listener{Name: "udp-rr", Protocol: config.ListenerUDP})
+
+	// Every datagram of a client address belongs to the same flow
+	first, second := dialUDP(t, addr), dialUDP(t, addr)
+	one := backendName(exchange(t, first, "query"))
+	two := backendName(exchange(t, second, "query"))
+	assert.NotEqual(t, one, two)
+	assert.Equal(t, one+" again", exchange(t, first, "again"))
+	assert.Equal(t, two+" again", exchange(t, second, "again"))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.L4OpenConnections.WithLabelValues("udp-rr")))
+	assert.Equal(t, float64(4*len("one query")), testutil.ToFloat64(metrics.L4BytesTotal.WithLabelValues("udp-rr", downstream)))
+}
+
+func TestUDPListenerIdleFlows(t *testing.T) {
+	m := newTestManager(t, "round_robin", udpBackend(t, "one"), udpBackend(t, "two"))
+	addr := startListener(t, m, config.Listener{Name: "udp-idle", Protocol: config.ListenerUDP, IdleTimeout: 100 * time.Millisecond})
+
+	conn := dialUDP(t, addr)
+	first := backendName(exchange(t, conn, "query"))
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.L4ClosedTotal.WithLabelValues("udp-idle", closedIdle)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The next datagram opens a new flow
+	assert.NotEqual(t, first, backendName(exchange(t, conn, "query")))
+}
+
+func TestUDPListenerProxyProtocol(t *testing.T) {
+	m := newTestManager(t, "", udpBackend(t, "backend"))
+	addr := startListener(t, m, config.Listener{Name: "udp-proxy", Protocol: config.ListenerUDP, ProxyProtocol: 2})
+
+	conn := dialUDP(t, addr)
+	reply := []byte(exchange(t, conn, "query"))
+
+	// Every datagram carries a version 2 header for UDP over IPv4
+	require.True(t, bytes.HasPrefix(reply, []byte("backend \r\n\r\n\x00\r\nQUIT\n\x21\x12\x00\x0c")))
+	header := reply[len("backend "):]
+	assert.Equal(t, net.IPv4(127, 0, 0, 1).To4(), net.IP(header[16:20]))
+	assert.Equal(t, "query", string(header[28:]))
+}
@@ -39,6 +39,284 @@ var (
 		},
 		[]string{"pool", "backend"},
 	)
+
+	OutlierEjectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_outlier_ejections_total",
+			Help: "Total number of backends ejected by outlier detection",
+		},
+		[]string{"pool", "backend", "reason"},
+	)
+
+	OutlierRestoresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_outlier_restores_total",
+			Help: "Total number of backends restored after an outlier ejection",
+		},
+		[]string{"pool", "backend"},
+	)
+
+	OutlierEjected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "veloflux_outlier_ejected",
+			Help: "Backend ejection status (1 = ejected, 0 = serving)",
+		},
+		[]string{"pool", "backend"},
+	)
+
+	UpstreamConnectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_upstream_connections_total",
+			Help: "Total number of upstream connections used by requests, by whether they were reused",
+		},
+		[]string{"pool", "state"},
+	)
+
+	UpstreamOpenConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "veloflux_upstream_open_connections",
+			Help: "Number of open connections to the backends of a pool",
+		},
+		[]string{"pool"},
+	)
+
+	UpstreamDialErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_upstream_dial_errors_total",
+			Help: "Total number of failed connection attempts to the backends of a pool",
+		},
+		[]string{"pool"},
+	)
+
+	RetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_retries_total",
+			Help: "Total number of proxied requests retried on another backend",
+		},
+		[]string{"pool", "reason"},
+	)
+
+	RetryBudgetExhaustedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_retry_budget_exhausted_total",
+			Help: "Total number of retries skipped because the retry budget was exhausted",
+		},
+		[]string{"pool"},
+	)
+
+	HedgedRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_hedged_requests_total",
+			Help: "Total number of hedged requests sent to another backend",
+		},
+		[]string{"pool"},
+	)
+
+	RouteRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_route_requests_total",
+			Help: "Total number of requests by the route that matched them",
+		},
+		[]string{"route", "status_code"},
+	)
+
+	StickyFailoversTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_sticky_failovers_total",
+			Help: "Total number of sticky sessions moved because their backend could not take traffic",
+		},
+		[]string{"pool"},
+	)
+
+	SplitRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_split_requests_total",
+			Help: "Total number of requests of split routes by the pool variant that served them",
+		},
+		[]string{"route", "pool", "status_code"},
+	)
+
+	SplitRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "veloflux_split_request_duration_seconds",
+			Help: "Request duration of split routes in seconds by pool variant",
+		},
+		[]string{"route", "pool"},
+	)
+
+	SplitWeight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "veloflux_split_weight",
+			Help: "Current weight of each pool variant of split routes",
+		},
+		[]string{"route", "pool"},
+	)
+
+	MirrorRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_mirror_requests_total",
+			Help: "Total number of mirrored requests by the status code of their discarded response",
+		},
+		[]string{"route", "pool", "status_code"},
+	)
+
+	MirrorRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "veloflux_mirror_request_duration_seconds",
+			Help: "Duration of mirrored requests in seconds",
+		},
+		[]string{"route", "pool"},
+	)
+
+	MirrorDroppedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_mirror_dropped_total",
+			Help: "Total number of sampled requests that were not mirrored",
+		},
+		[]string{"route", "pool", "reason"},
+	)
+
+	UpgradedConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "veloflux_upgraded_connections",
+			Help: "Number of open WebSocket and other upgraded connections",
+		},
+		[]string{"pool"},
+	)
+
+	UpgradeRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_upgrade_rejected_total",
+			Help: "Total number of upgrade requests refused before reaching a backend",
+		},
+		[]string{"pool", "reason"},
+	)
+
+	UpgradedConnectionsClosedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_upgraded_connections_closed_total",
+			Help: "Total number of upgraded connections closed by the reason they ended",
+		},
+		[]string{"pool", "reason"},
+	)
+
+	GRPCRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_grpc_requests_total",
+			Help: "Total number of proxied gRPC calls by grpc-status",
+		},
+		[]string{"route", "service", "method", "grpc_status"},
+	)
+
+	GRPCRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "veloflux_grpc_request_duration_seconds",
+			Help: "Duration of proxied gRPC calls in seconds",
+		},
+		[]string{"route", "service", "method"},
+	)
+
+	L4ConnectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_l4_connections_total",
+			Help: "Total number of TCP connections and UDP flows proxied by listeners",
+		},
+		[]string{"listener", "pool", "backend"},
+	)
+
+	L4OpenConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "veloflux_l4_open_connections",
+			Help: "Number of open TCP connections and UDP flows of listeners",
+		},
+		[]string{"listener"},
+	)
+
+	L4RejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_l4_rejected_total",
+			Help: "Total number of TCP connections and UDP flows refused before reaching a backend",
+		},
+		[]string{"listener", "reason"},
+	)
+
+	L4ClosedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_l4_closed_total",
+			Help: "Total number of TCP connections and UDP flows closed by the reason they ended",
+		},
+		[]string{"listener", "reason"},
+	)
+
+	L4BytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_l4_bytes_total",
+			Help: "Total number of bytes proxied by listeners, upstream to backends or downstream to clients",
+		},
+		[]string{"listener", "direction"},
+	)
+
+	CertificateExpiry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "veloflux_tls_certificate_expiry_timestamp_seconds",
+			Help: "Expiry of loaded TLS certificates as a Unix timestamp",
+		},
+		[]string{"source", "name"},
+	)
+
+	CertificateReloadsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_tls_certificate_reloads_total",
+			Help: "Total number of TLS certificate reloads by result",
+		},
+		[]string{"result"},
+	)
+
+	OCSPFetchesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_tls_ocsp_fetches_total",
+			Help: "Total number of OCSP responses fetched for stapling by result",
+		},
+		[]string{"result"},
+	)
+
+	ClientCertificatesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_tls_client_certificates_total",
+			Help: "Total number of client certificate checks by route and result",
+		},
+		[]string{"route", "result"},
+	)
+
+	DownstreamConnectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "veloflux_downstream_connections_total",
+			Help: "Total number of client connections accepted by listener",
+		},
+		[]string{"listener"},
+	)
+
+	DownstreamOpenConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "veloflux_downstream_open_connections",
+			Help: "Number of open client connections by listener",
+		},
+		[]string{"listener"},
+	)
+
+	CustomDomains = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "veloflux_custom_domains",
+			Help: "Number of custom domains registered by tenants",
+		},
+	)
+
+	ACMERefusedHostsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "veloflux_acme_refused_hosts_total",
+			Help: "Total number of certificate requests refused for hosts that are not registered",
+		},
+	)
 )
 
 func init() {
@@ -46,6 +324,41 @@ func init() {
 	prometheus.MustRegister(RequestDuration)
 	prometheus.MustRegister(ActiveConnections)
 	prometheus.MustRegister(BackendHealth)
+	prometheus.MustRegister(OutlierEjectionsTotal)
+	prometheus.MustRegister(OutlierRestoresTotal)
+	prometheus.MustRegister(OutlierEjected)
+	prometheus.MustRegister(UpstreamConnectionsTotal)
+	prometheus.MustRegister(UpstreamOpenConnections)
+	prometheus.MustRegister(UpstreamDialErrorsTotal)
+	prometheus.MustRegister(RetriesTotal)
+	prometheus.MustRegister(RetryBudgetExhaustedTotal)
+	prometheus.MustRegister(HedgedRequestsTotal)
+	prometheus.MustRegister(RouteRequestsTotal)
+	prometheus.MustRegister(StickyFailoversTotal)
+	prometheus.MustRegister(SplitRequestsTotal)
+	prometheus.MustRegister(SplitRequestDuration)
+	prometheus.MustRegister(SplitWeight)
+	prometheus.MustRegister(MirrorRequestsTotal)
+	prometheus.MustRegister(MirrorRequestDuration)
+	prometheus.MustRegister(MirrorDroppedTotal)
+	prometheus.MustRegister(UpgradedConnections)
+	prometheus.MustRegister(UpgradeRejectedTotal)
+	prometheus.MustRegister(UpgradedConnectionsClosedTotal)
+	prometheus.MustRegister(GRPCRequestsTotal)
+	prometheus.MustRegister(GRPCRequestDuration)
+	prometheus.MustRegister(L4ConnectionsTotal)
+	prometheus.MustRegister(L4OpenConnections)
+	prometheus.MustRegister(L4RejectedTotal)
+	prometheus.MustRegister(L4ClosedTotal)
+	prometheus.MustRegister(L4BytesTotal)
+	prometheus.MustRegister(CertificateExpiry)
+	prometheus.MustRegister(CertificateReloadsTotal)
+	prometheus.MustRegister(OCSPFetchesTotal)
+	prometheus.MustRegister(ClientCertificatesTotal)
+	prometheus.MustRegister(DownstreamConnectionsTotal)
+	prometheus.MustRegister(DownstreamOpenConnections)
+	prometheus.MustRegister(CustomDomains)
+	prometheus.MustRegister(ACMERefusedHostsTotal)
 }
 
 func Handler() http.Handler {
@@ -181,3 +181,27 @@ func TestMetricsReset(t *testing.T) {
 	// (Note: In a real scenario, you might want more sophisticated testing)
 	assert.NotContains(t, body, `veloflux_requests_total{method="GET",pool="test",status_code="200"} 1`)
 }
+
+func TestResponseWriterWrapperHijack(t *testing.T) {
+	status := make(chan int, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wrapper := NewResponseWriterWrapper(w)
+		conn, _, err := http.NewResponseController(wrapper).Hijack()
+		if assert.NoError(t, err) {
+			conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"))
+			conn.Close()
+		}
+		status <- wrapper.StatusCode()
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	assert.Equal(t, http.StatusSwitchingProtocols, <-status)
+
+	// Writers that cannot be hijacked report it
+	_, _, err = NewResponseWriterWrapper(httptest.NewRecorder()).Hijack()
+	assert.ErrorIs(t, err, http.ErrNotSupported)
+}
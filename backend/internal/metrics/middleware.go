@@ -5,6 +5,8 @@
 package metrics
 
 import (
+	"bufio"
+	"net"
 	"net/http"
 	"strconv"
 	"time"
@@ -77,6 +79,36 @@ func (w *ResponseWriterWrapper) StatusCode() int {
 	return w.statusCode
 }
 
+// Flush envia ao cliente os dados em buffer, para respostas em streaming
+func (w *ResponseWriterWrapper) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack assume a conexão do cliente em upgrades como WebSocket, registrando
+// o status 101
+func (w *ResponseWriterWrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && !w.written {
+		w.statusCode = http.StatusSwitchingProtocols
+		w.written = true
+	}
+	return conn, rw, err
+}
+
+// CloseNotify repassa http.CloseNotifier para handlers que ainda o utilizam;
+// o contexto da requisição é cancelado ao mesmo tempo
+func (w *ResponseWriterWrapper) CloseNotify() <-chan bool {
+	if notifier, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// Unwrap retorna o http.ResponseWriter original para http.ResponseController
+func (w *ResponseWriterWrapper) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // UpdateBackendHealth atualiza a métrica de saúde do backend
 func UpdateBackendHealth(poolName, backendAddress string, isHealthy bool) {
 	healthValue := 0.0
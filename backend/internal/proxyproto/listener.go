@@ -0,0 +1,105 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout bounds the wait for the header of a connection
+const DefaultHeaderTimeout = 5 * time.Second
+
+// Listener accepts connections whose peers, when trusted, may start them
+// with a PROXY protocol header. The connections then report the addresses of
+// the header. Peers that are not trusted cannot send one: their bytes go to
+// the server unread.
+type Listener struct {
+	net.Listener
+	Trusted func(net.Addr) bool // reports whether a peer is a trusted proxy
+	Timeout time.Duration       // defaults to DefaultHeaderTimeout
+}
+
+// Accept returns the next connection. Its header is read by the first call
+// to Read, RemoteAddr or LocalAddr, in the goroutine serving it, so a slow
+// proxy does not hold back other connections.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if l.Trusted == nil || !l.Trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	timeout := l.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHeaderTimeout
+	}
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+// Conn is a connection from a trusted proxy, which may have sent a header
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	header Header
+	err    error
+}
+
+// readHeader consumes the header the connection starts with, if any
+func (c *Conn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	c.header, c.err = Read(c.reader)
+	if errors.Is(c.err, ErrNoHeader) {
+		c.err = nil
+	}
+}
+
+// Header returns the header the proxy sent, without addresses when it sent
+// none
+func (c *Conn) Header() (Header, error) {
+	c.once.Do(c.readHeader)
+	return c.header, c.err
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	// Bytes read past the header are served before the connection's own
+	if c.reader.Buffered() > 0 {
+		return c.reader.Read(b)
+	}
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr returns the client address of the header, or the proxy's
+func (c *Conn) RemoteAddr() net.Addr {
+	if header, err := c.Header(); err == nil && header.Source != nil {
+		return header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to at the proxy, or
+// the one the proxy connected to
+func (c *Conn) LocalAddr() net.Addr {
+	if header, err := c.Header(); err == nil && header.Destination != nil {
+		return header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// CloseWrite half-closes the connection when it supports it
+func (c *Conn) CloseWrite() error {
+	if conn, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return conn.CloseWrite()
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,64 @@
+package proxyproto
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// accept sends data over a connection to the listener and returns the
+// server side of it, with everything read from it
+func accept(t *testing.T, ln net.Listener, data []byte) (net.Conn, string) {
+	client, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	_, err = client.Write(data)
+	require.NoError(t, err)
+	client.(*net.TCPConn).CloseWrite()
+	t.Cleanup(func() { client.Close() })
+
+	conn, err := ln.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	body, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return conn, string(body)
+}
+
+func TestListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer inner.Close()
+	trusted := true
+	ln := &Listener{Listener: inner, Trusted: func(net.Addr) bool { return trusted }}
+
+	header, _ := Header{Source: tcpAddr("192.0.2.1", 56324), Destination: tcpAddr("198.51.100.7", 443)}.Format(2)
+	conn, body := accept(t, ln, append(header, "hello"...))
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, "192.0.2.1:56324", conn.RemoteAddr().String())
+	assert.Equal(t, "198.51.100.7:443", conn.LocalAddr().String())
+
+	// Trusted proxies may also connect without a header
+	conn, body = accept(t, ln, []byte("hello"))
+	assert.Equal(t, "hello", body)
+	assert.Contains(t, conn.RemoteAddr().String(), "127.0.0.1:")
+
+	// Malformed headers fail the connection
+	client, err := net.Dial("tcp", inner.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	client.Write([]byte("PROXY TCP4 nonsense\r\nhello"))
+	conn, err = ln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Read(make([]byte, 5))
+	assert.Error(t, err)
+
+	// Other peers cannot claim an address
+	trusted = false
+	conn, body = accept(t, ln, append(header, "hello"...))
+	assert.Equal(t, string(header)+"hello", body)
+	assert.Contains(t, conn.RemoteAddr().String(), "127.0.0.1:")
+}
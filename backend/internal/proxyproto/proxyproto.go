@@ -0,0 +1,129 @@
+// Package proxyproto implements the PROXY protocol, which carries the
+// addresses of a client connection to the server behind a proxy.
+package proxyproto
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// signature starts every version 2 header
+var signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Version 2 command, address family and transport bytes
+const (
+	v2Local = 0x20
+	v2Proxy = 0x21
+
+	v2Unspec    = 0x00
+	v2TCP4      = 0x11
+	v2UDP4      = 0x12
+	v2TCP6      = 0x21
+	v2UDP6      = 0x22
+	v2LenInet4  = 12
+	v2LenInet6  = 36
+	v2HeaderLen = 16
+)
+
+// Header holds the addresses of a proxied connection: where the client
+// connected from and the proxy address it connected to. Both are *net.TCPAddr
+// for TCP connections or *net.UDPAddr for UDP flows.
+type Header struct {
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// Format encodes the header in version 1 or 2 of the protocol. Addresses the
+// protocol cannot describe are sent as unknown, telling the server to use the
+// connection's own addresses.
+func (h Header) Format(version int) ([]byte, error) {
+	switch version {
+	case 1:
+		return h.formatV1(), nil
+	case 2:
+		return h.formatV2(), nil
+	default:
+		return nil, fmt.Errorf("unknown PROXY protocol version %d", version)
+	}
+}
+
+// addresses returns the IPs and ports of the header, both in the same family
+func (h Header) addresses() (src, dst net.IP, srcPort, dstPort int, udp, ok bool) {
+	switch s := h.Source.(type) {
+	case *net.TCPAddr:
+		d, match := h.Destination.(*net.TCPAddr)
+		if !match {
+			return nil, nil, 0, 0, false, false
+		}
+		src, dst, srcPort, dstPort = s.IP, d.IP, s.Port, d.Port
+	case *net.UDPAddr:
+		d, match := h.Destination.(*net.UDPAddr)
+		if !match {
+			return nil, nil, 0, 0, false, false
+		}
+		src, dst, srcPort, dstPort, udp = s.IP, d.IP, s.Port, d.Port, true
+	default:
+		return nil, nil, 0, 0, false, false
+	}
+	if src == nil || dst == nil {
+		return nil, nil, 0, 0, false, false
+	}
+
+	// A v4 client of a dual stack listener is described in the IPv6 family
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		return src4, dst4, srcPort, dstPort, udp, true
+	}
+	return src.To16(), dst.To16(), srcPort, dstPort, udp, true
+}
+
+func (h Header) formatV1() []byte {
+	src, dst, srcPort, dstPort, udp, ok := h.addresses()
+	if !ok || udp {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	if len(src) == net.IPv4len {
+		return []byte(fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", src, dst, srcPort, dstPort))
+	}
+	return []byte(fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", ipv6String(src), ipv6String(dst), srcPort, dstPort))
+}
+
+// ipv6String formats an IPv6 address, writing v4-mapped ones in IPv6 notation
+// where net.IP would print the IPv4 address
+func ipv6String(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return "::ffff:" + v4.String()
+	}
+	return ip.String()
+}
+
+func (h Header) formatV2() []byte {
+	buf := make([]byte, v2HeaderLen, v2HeaderLen+v2LenInet6)
+	copy(buf, signature)
+	buf[12] = v2Proxy
+
+	src, dst, srcPort, dstPort, udp, ok := h.addresses()
+	if !ok {
+		buf[13] = v2Unspec
+		return buf
+	}
+
+	var length int
+	if len(src) == net.IPv4len {
+		buf[13], length = v2TCP4, v2LenInet4
+		if udp {
+			buf[13] = v2UDP4
+		}
+	} else {
+		buf[13], length = v2TCP6, v2LenInet6
+		if udp {
+			buf[13] = v2UDP6
+		}
+	}
+	binary.BigEndian.PutUint16(buf[14:], uint16(length))
+	buf = append(buf, src...)
+	buf = append(buf, dst...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(srcPort))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(dstPort))
+	return buf
+}
@@ -0,0 +1,126 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tcpAddr(ip string, port int) *net.TCPAddr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: port}
+}
+
+func TestFormatV1(t *testing.T) {
+	header, err := Header{Source: tcpAddr("192.0.2.1", 56324), Destination: tcpAddr("198.51.100.7", 5432)}.Format(1)
+	require.NoError(t, err)
+	assert.Equal(t, "PROXY TCP4 192.0.2.1 198.51.100.7 56324 5432\r\n", string(header))
+
+	header, err = Header{Source: tcpAddr("2001:db8::1", 56324), Destination: tcpAddr("2001:db8::2", 5432)}.Format(1)
+	require.NoError(t, err)
+	assert.Equal(t, "PROXY TCP6 2001:db8::1 2001:db8::2 56324 5432\r\n", string(header))
+
+	// A v4 client of an IPv6 listener is described in the IPv6 family
+	header, err = Header{Source: tcpAddr("192.0.2.1", 56324), Destination: tcpAddr("2001:db8::2", 5432)}.Format(1)
+	require.NoError(t, err)
+	assert.Equal(t, "PROXY TCP6 ::ffff:192.0.2.1 2001:db8::2 56324 5432\r\n", string(header))
+
+	header, err = Header{Source: &net.UnixAddr{Name: "/tmp/socket"}, Destination: tcpAddr("198.51.100.7", 5432)}.Format(1)
+	require.NoError(t, err)
+	assert.Equal(t, "PROXY UNKNOWN\r\n", string(header))
+}
+
+func TestFormatV2(t *testing.T) {
+	header, err := Header{Source: tcpAddr("192.0.2.1", 56324), Destination: tcpAddr("198.51.100.7", 5432)}.Format(2)
+	require.NoError(t, err)
+	want := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x21, 0x11, 0, 12)
+	want = append(want, 192, 0, 2, 1, 198, 51, 100, 7, 0xdc, 0x04, 0x15, 0x38)
+	assert.Equal(t, want, header)
+
+	udp := Header{
+		Source:      &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 53000},
+		Destination: &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 53},
+	}
+	header, err = udp.Format(2)
+	require.NoError(t, err)
+	require.Len(t, header, 16+36)
+	assert.Equal(t, []byte{0x21, 0x22, 0, 36}, header[12:16])
+	assert.Equal(t, net.ParseIP("2001:db8::1").To16(), net.IP(header[16:32]))
+
+	// Unknown addresses leave the family unspecified
+	header, err = Header{}.Format(2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x21, 0x00, 0, 0}, header[12:16])
+
+	_, err = Header{}.Format(3)
+	assert.Error(t, err)
+}
+
+func TestRead(t *testing.T) {
+	headers := []Header{
+		{Source: tcpAddr("192.0.2.1", 56324), Destination: tcpAddr("198.51.100.7", 443)},
+		{Source: tcpAddr("2001:db8::1", 56324), Destination: tcpAddr("2001:db8::2", 443)},
+		{Source: tcpAddr("192.0.2.1", 56324), Destination: tcpAddr("2001:db8::2", 443)},
+	}
+	for _, version := range []int{1, 2} {
+		for _, want := range headers {
+			raw, err := want.Format(version)
+			require.NoError(t, err)
+			r := bufio.NewReader(io.MultiReader(bytes.NewReader(raw), strings.NewReader("GET / HTTP/1.1\r\n")))
+
+			got, err := Read(r)
+			require.NoError(t, err, "v%d %s", version, raw)
+			assert.Equal(t, want.Source.String(), got.Source.String())
+			assert.Equal(t, want.Destination.String(), got.Destination.String())
+			rest, _ := io.ReadAll(r)
+			assert.Equal(t, "GET / HTTP/1.1\r\n", string(rest))
+		}
+	}
+
+	// UDP flows, and headers without addresses
+	udp := Header{
+		Source:      &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53000},
+		Destination: &net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 53},
+	}
+	raw, _ := udp.Format(2)
+	got, err := Read(bufio.NewReader(bytes.NewReader(raw)))
+	require.NoError(t, err)
+	assert.IsType(t, &net.UDPAddr{}, got.Source)
+	assert.Equal(t, "192.0.2.1:53000", got.Source.String())
+
+	local := append(bytes.Clone(signature), v2Local, v2Unspec, 0, 0)
+	for _, raw := range [][]byte{[]byte("PROXY UNKNOWN\r\n"), local} {
+		got, err := Read(bufio.NewReader(bytes.NewReader(raw)))
+		require.NoError(t, err)
+		assert.Nil(t, got.Source)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	for _, raw := range []string{
+		"GET / HTTP/1.1\r\n",
+		"POST / HTTP/1.1\r\n",
+		"\x16\x03\x01",
+	} {
+		_, err := Read(bufio.NewReader(strings.NewReader(raw)))
+		assert.ErrorIs(t, err, ErrNoHeader, raw)
+	}
+
+	for _, raw := range []string{
+		"PROXY TCP4 192.0.2.1 198.51.100.7 56324\r\n",
+		"PROXY TCP4 2001:db8::1 198.51.100.7 56324 443\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.7 65536 443\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.7 56324 443" + strings.Repeat(" ", 100),
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x04\xc0\x00\x02\x01",
+		"\r\n\r\n\x00\r\nQUIT\n\x31\x11\x00\x00",
+	} {
+		_, err := Read(bufio.NewReader(strings.NewReader(raw)))
+		assert.Error(t, err, raw)
+		assert.NotErrorIs(t, err, ErrNoHeader, raw)
+	}
+}
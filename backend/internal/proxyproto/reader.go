@@ -0,0 +1,135 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// v1Prefix starts every version 1 header
+var v1Prefix = []byte("PROXY ")
+
+// v1MaxLen is the longest version 1 header the protocol allows
+const v1MaxLen = 107
+
+// ErrNoHeader is returned when a connection does not start with a header
+var ErrNoHeader = errors.New("no PROXY protocol header")
+
+// Read reads a version 1 or 2 header from the start of a connection. Headers
+// of health checks sent by the proxy itself (LOCAL) and of addresses the
+// protocol cannot describe (UNKNOWN, unspecified or unix families) have no
+// addresses, telling the server to use those of the connection.
+func Read(r *bufio.Reader) (Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return Header{}, err
+	}
+	switch first[0] {
+	case v1Prefix[0]:
+		prefix, err := r.Peek(len(v1Prefix))
+		if err != nil || !bytes.Equal(prefix, v1Prefix) {
+			return Header{}, ErrNoHeader
+		}
+		return readV1(r)
+	case signature[0]:
+		prefix, err := r.Peek(len(signature))
+		if err != nil || !bytes.Equal(prefix, signature) {
+			return Header{}, ErrNoHeader
+		}
+		return readV2(r)
+	}
+	return Header{}, ErrNoHeader
+}
+
+func readV1(r *bufio.Reader) (Header, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == v1MaxLen {
+			return Header{}, errors.New("PROXY protocol v1 header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return Header{}, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return Header{}, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return Header{}, fmt.Errorf("invalid PROXY protocol v1 header %q", line)
+	}
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, srcErr := parsePort(fields[4])
+	dstPort, dstErr := parsePort(fields[5])
+	// IPv6 addresses, v4-mapped ones included, are written with colons
+	v6 := fields[1] == "TCP6"
+	if src == nil || dst == nil || srcErr != nil || dstErr != nil ||
+		strings.Contains(fields[2], ":") != v6 || strings.Contains(fields[3], ":") != v6 {
+		return Header{}, fmt.Errorf("invalid PROXY protocol v1 header %q", line)
+	}
+	return Header{
+		Source:      &net.TCPAddr{IP: src, Port: srcPort},
+		Destination: &net.TCPAddr{IP: dst, Port: dstPort},
+	}, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	return int(port), err
+}
+
+func readV2(r *bufio.Reader) (Header, error) {
+	head := make([]byte, v2HeaderLen)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return Header{}, err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return Header{}, err
+	}
+
+	switch head[12] {
+	case v2Local:
+		return Header{}, nil
+	case v2Proxy:
+	default:
+		return Header{}, fmt.Errorf("unknown PROXY protocol v2 version and command %#x", head[12])
+	}
+
+	// Type-length-value extensions after the addresses are ignored
+	var ipLen int
+	switch head[13] {
+	case v2TCP4, v2UDP4:
+		ipLen = net.IPv4len
+	case v2TCP6, v2UDP6:
+		ipLen = net.IPv6len
+	default:
+		return Header{}, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return Header{}, errors.New("PROXY protocol v2 addresses truncated")
+	}
+	src := net.IP(bytes.Clone(body[:ipLen]))
+	dst := net.IP(bytes.Clone(body[ipLen : 2*ipLen]))
+	srcPort := int(binary.BigEndian.Uint16(body[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(body[2*ipLen+2:]))
+	if head[13] == v2UDP4 || head[13] == v2UDP6 {
+		return Header{
+			Source:      &net.UDPAddr{IP: src, Port: srcPort},
+			Destination: &net.UDPAddr{IP: dst, Port: dstPort},
+		}, nil
+	}
+	return Header{
+		Source:      &net.TCPAddr{IP: src, Port: srcPort},
+		Destination: &net.TCPAddr{IP: dst, Port: dstPort},
+	}, nil
+}
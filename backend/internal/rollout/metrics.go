@@ -0,0 +1,142 @@
+package rollout
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Metric families the rollout controller reads
+const (
+	requestsMetric = "veloflux_requests_total"
+	durationMetric = "veloflux_request_duration_seconds"
+)
+
+// Stats are the cumulative request counts and latency distribution of a pool
+type Stats struct {
+	Requests float64
+	Errors   float64  // responses with a 5xx status
+	Buckets  []Bucket // cumulative latency buckets sorted by upper bound, ending with +Inf
+}
+
+// Bucket counts the requests that took at most UpperBound seconds
+type Bucket struct {
+	UpperBound float64
+	Count      float64
+}
+
+// MetricsSource provides the statistics a rollout step is judged on
+type MetricsSource interface {
+	PoolStats(pool string) (Stats, error)
+}
+
+// GathererSource reads pool statistics from the request counter and duration
+// histogram of a Prometheus gatherer, summed over request methods.
+type GathererSource struct {
+	gatherer prometheus.Gatherer
+}
+
+// NewGathererSource creates a source reading from gatherer
+func NewGathererSource(gatherer prometheus.Gatherer) *GathererSource {
+	return &GathererSource{gatherer: gatherer}
+}
+
+// PoolStats implements MetricsSource
+func (s *GathererSource) PoolStats(pool string) (Stats, error) {
+	families, err := s.gatherer.Gather()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	var stats Stats
+	buckets := make(map[float64]float64)
+	for _, family := range families {
+		switch family.GetName() {
+		case requestsMetric:
+			for _, metric := range family.GetMetric() {
+				labels := labelMap(metric)
+				if labels["pool"] != pool {
+					continue
+				}
+				count := metric.GetCounter().GetValue()
+				stats.Requests += count
+				if code, err := strconv.Atoi(labels["status_code"]); err == nil && code >= 500 {
+					stats.Errors += count
+				}
+			}
+		case durationMetric:
+			for _, metric := range family.GetMetric() {
+				if labelMap(metric)["pool"] != pool {
+					continue
+				}
+				histogram := metric.GetHistogram()
+				for _, bucket := range histogram.GetBucket() {
+					buckets[bucket.GetUpperBound()] += float64(bucket.GetCumulativeCount())
+				}
+				// The +Inf bucket is implied by the sample count
+				buckets[math.Inf(1)] += float64(histogram.GetSampleCount())
+			}
+		}
+	}
+
+	for bound, count := range buckets {
+		stats.Buckets = append(stats.Buckets, Bucket{UpperBound: bound, Count: count})
+	}
+	sort.Slice(stats.Buckets, func(i, j int) bool {
+		return stats.Buckets[i].UpperBound < stats.Buckets[j].UpperBound
+	})
+	return stats, nil
+}
+
+func labelMap(metric *dto.Metric) map[string]string {
+	labels := make(map[string]string, len(metric.GetLabel()))
+	for _, label := range metric.GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	return labels
+}
+
+// sub returns the statistics of the requests seen since before
+func (s Stats) sub(before Stats) Stats {
+	delta := Stats{Requests: s.Requests - before.Requests, Errors: s.Errors - before.Errors}
+	previous := make(map[float64]float64, len(before.Buckets))
+	for _, bucket := range before.Buckets {
+		previous[bucket.UpperBound] = bucket.Count
+	}
+	for _, bucket := range s.Buckets {
+		delta.Buckets = append(delta.Buckets, Bucket{UpperBound: bucket.UpperBound, Count: bucket.Count - previous[bucket.UpperBound]})
+	}
+	return delta
+}
+
+// quantile estimates the q-quantile of the latency in seconds the same way
+// PromQL's histogram_quantile does, interpolating linearly within buckets.
+func (s Stats) quantile(q float64) float64 {
+	if len(s.Buckets) == 0 {
+		return math.NaN()
+	}
+	total := s.Buckets[len(s.Buckets)-1].Count
+	if total <= 0 {
+		return math.NaN()
+	}
+
+	rank := q * total
+	lowerBound, lowerCount := 0.0, 0.0
+	for _, bucket := range s.Buckets {
+		if bucket.Count >= rank {
+			if math.IsInf(bucket.UpperBound, 1) {
+				// Slower than every finite bucket
+				return lowerBound
+			}
+			if bucket.Count == lowerCount {
+				return bucket.UpperBound
+			}
+			return lowerBound + (bucket.UpperBound-lowerBound)*(rank-lowerCount)/(bucket.Count-lowerCount)
+		}
+		lowerBound, lowerCount = bucket.UpperBound, bucket.Count
+	}
+	return lowerBound
+}
@@ -0,0 +1,516 @@
+// Package rollout moves the traffic of a route from a stable pool to a
+// candidate pool step by step, judging every step on the candidate's error
+// rate and latency and promoting or rolling back automatically.
+package rollout
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// Rollout phases
+const (
+	PhaseProgressing = "progressing"
+	PhasePromoted    = "promoted"
+	PhaseRolledBack  = "rolled_back"
+)
+
+// Rollout defaults
+const (
+	DefaultInterval    = time.Minute
+	DefaultMinRequests = 100
+
+	// finishedTTL is how long finished rollouts are kept in Redis
+	finishedTTL  = 7 * 24 * time.Hour
+	defaultTick  = time.Second
+	redisTimeout = 2 * time.Second
+)
+
+// DefaultSteps are the candidate weights, in percent, of a rollout without steps
+var DefaultSteps = []int{5, 10, 25, 50, 100}
+
+// Errors returned by the controller
+var (
+	ErrNotFound      = errors.New("rollout not found")
+	ErrRouteBusy     = errors.New("route already has a rollout in progress")
+	ErrNotInProgress = errors.New("rollout is not in progress")
+)
+
+// Duration is a time.Duration written as a string such as "30s" in JSON
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Spec describes how a route moves from its stable pool to a candidate pool
+type Spec struct {
+	Route         string   `json:"route"`
+	StablePool    string   `json:"stable_pool"`
+	CandidatePool string   `json:"candidate_pool"`
+	Steps         []int    `json:"steps,omitempty"`           // increasing candidate weights in percent, ending the rollout at the last one
+	Interval      Duration `json:"interval,omitempty"`        // time spent at each step before it is judged
+	MaxErrorRate  float64  `json:"max_error_rate,omitempty"`  // highest share of 5xx responses, 0 disables the check
+	MaxP99Latency Duration `json:"max_p99_latency,omitempty"` // highest p99 latency, 0 disables the check
+	MinRequests   int      `json:"min_requests,omitempty"`    // candidate requests needed to judge a step
+}
+
+// Rollout is the state of a rollout, shared by the cluster through Redis
+type Rollout struct {
+	ID string `json:"id"`
+	Spec
+	Phase          string      `json:"phase"`
+	Step           int         `json:"step"`   // index of the current step
+	Weight         int         `json:"weight"` // current candidate weight in percent
+	StepStarted    time.Time   `json:"step_started"`
+	LastEvaluation *Evaluation `json:"last_evaluation,omitempty"`
+	Reason         string      `json:"reason,omitempty"` // why the rollout finished or is waiting
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+}
+
+// Evaluation is the judgement of a step on the candidate's traffic
+type Evaluation struct {
+	Step       int       `json:"step"`
+	Requests   float64   `json:"requests"`
+	ErrorRate  float64   `json:"error_rate"`
+	P99Latency Duration  `json:"p99_latency"`
+	Passed     bool      `json:"passed"`
+	At         time.Time `json:"at"`
+}
+
+// Splitter applies the pool weights of a route
+type Splitter interface {
+	SetSplitWeights(routeID string, weights map[string]int) error
+}
+
+// Leader reports whether this node drives rollouts
+type Leader interface {
+	IsLeader() bool
+}
+
+// Broadcaster publishes rollout changes, such as the WebSocket hub
+type Broadcaster interface {
+	Broadcast(messageType string, data interface{})
+}
+
+// baseline is the candidate's statistics at the start of a step, as seen by this node
+type baseline struct {
+	step  int
+	stats Stats
+}
+
+// Controller drives rollouts. Every node applies the weights of rollouts in
+// progress; only the leader judges steps and advances them, so metrics are
+// those of the leader's own traffic.
+type Controller struct {
+	redis    *redis.Client
+	splitter Splitter
+	source   MetricsSource
+	logger   *zap.Logger
+	tick     time.Duration
+
+	mu          sync.Mutex
+	leader      Leader
+	broadcaster Broadcaster
+	baselines   map[string]baseline
+	applied     map[string]string // last phase and weight applied per rollout
+}
+
+// New creates a rollout controller
+func New(client *redis.Client, splitter Splitter, source MetricsSource, logger *zap.Logger) *Controller {
+	return &Controller{
+		redis:     client,
+		splitter:  splitter,
+		source:    source,
+		logger:    logger,
+		tick:      defaultTick,
+		baselines: make(map[string]baseline),
+		applied:   make(map[string]string),
+	}
+}
+
+// SetLeader sets the leader election rollouts follow. Without one this node drives them.
+func (c *Controller) SetLeader(leader Leader) {
+	c.mu.Lock()
+	c.leader = leader
+	c.mu.Unlock()
+}
+
+// SetBroadcaster sets where rollout changes are published
+func (c *Controller) SetBroadcaster(broadcaster Broadcaster) {
+	c.mu.Lock()
+	c.broadcaster = broadcaster
+	c.mu.Unlock()
+}
+
+func (c *Controller) isLeader() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leader == nil || c.leader.IsLeader()
+}
+
+func (c *Controller) key(id string) string { return "vf:rollout:" + id }
+
+const indexKey = "vf:rollouts"
+
+// Create validates a spec and starts its rollout at the first step
+func (c *Controller) Create(ctx context.Context, spec Spec) (*Rollout, error) {
+	if err := normalize(&spec); err != nil {
+		return nil, err
+	}
+
+	rollouts, err := c.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rollouts {
+		if r.Route == spec.Route && r.Phase == PhaseProgressing {
+			return nil, ErrRouteBusy
+		}
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	r := &Rollout{
+		ID:          hex.EncodeToString(id),
+		Spec:        spec,
+		Phase:       PhaseProgressing,
+		Weight:      spec.Steps[0],
+		StepStarted: now,
+		CreatedAt:   now,
+	}
+
+	// Applying the first step checks the route and pools exist
+	if err := c.apply(r); err != nil {
+		return nil, err
+	}
+	if err := c.save(ctx, r); err != nil {
+		return nil, err
+	}
+	c.logger.Info("Rollout started",
+		zap.String("rollout", r.ID),
+		zap.String("route", r.Route),
+		zap.String("candidate", r.CandidatePool),
+		zap.Int("weight", r.Weight))
+	return r, nil
+}
+
+// normalize checks a spec and fills in its defaults
+func normalize(spec *Spec) error {
+	if spec.Route == "" || spec.StablePool == "" || spec.CandidatePool == "" {
+		return fmt.Errorf("route, stable_pool and candidate_pool are required")
+	}
+	if spec.StablePool == spec.CandidatePool {
+		return fmt.Errorf("stable and candidate pools must differ")
+	}
+	if len(spec.Steps) == 0 {
+		spec.Steps = DefaultSteps
+	}
+	previous := 0
+	for _, step := range spec.Steps {
+		if step <= previous || step > 100 {
+			return fmt.Errorf("steps must increase between 1 and 100")
+		}
+		previous = step
+	}
+	if spec.Interval <= 0 {
+		spec.Interval = Duration(DefaultInterval)
+	}
+	if spec.MaxErrorRate < 0 || spec.MaxErrorRate > 1 {
+		return fmt.Errorf("max_error_rate must be between 0 and 1")
+	}
+	if spec.MaxP99Latency < 0 {
+		return fmt.Errorf("max_p99_latency must not be negative")
+	}
+	if spec.MinRequests <= 0 {
+		spec.MinRequests = DefaultMinRequests
+	}
+	return nil
+}
+
+// Get returns a rollout
+func (c *Controller) Get(ctx context.Context, id string) (*Rollout, error) {
+	data, err := c.redis.Get(ctx, c.key(id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var r Rollout
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// List returns every known rollout, dropping index entries of expired ones
+func (c *Controller) List(ctx context.Context) ([]*Rollout, error) {
+	ids, err := c.redis.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	rollouts := make([]*Rollout, 0, len(ids))
+	for _, id := range ids {
+		r, err := c.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			c.redis.SRem(ctx, indexKey, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rollouts = append(rollouts, r)
+	}
+	return rollouts, nil
+}
+
+// Promote ends a rollout in progress by sending all traffic to the candidate
+func (c *Controller) Promote(ctx context.Context, id, reason string) (*Rollout, error) {
+	return c.finish(ctx, id, PhasePromoted, reason)
+}
+
+// Rollback ends a rollout in progress by sending all traffic back to the stable pool
+func (c *Controller) Rollback(ctx context.Context, id, reason string) (*Rollout, error) {
+	return c.finish(ctx, id, PhaseRolledBack, reason)
+}
+
+func (c *Controller) finish(ctx context.Context, id, phase, reason string) (*Rollout, error) {
+	r, err := c.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if r.Phase != PhaseProgressing {
+		return nil, ErrNotInProgress
+	}
+	if err := c.end(ctx, r, phase, reason); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// end moves a rollout to a final phase and applies its final weights
+func (c *Controller) end(ctx context.Context, r *Rollout, phase, reason string) error {
+	r.Phase = phase
+	r.Reason = reason
+	if phase == PhasePromoted {
+		r.Weight = 100
+	} else {
+		r.Weight = 0
+	}
+	if err := c.apply(r); err != nil {
+		c.logger.Error("Failed to apply final rollout weights", zap.Error(err), zap.String("rollout", r.ID))
+	}
+	c.mu.Lock()
+	delete(c.baselines, r.ID)
+	c.mu.Unlock()
+
+	c.logger.Info("Rollout finished",
+		zap.String("rollout", r.ID),
+		zap.String("route", r.Route),
+		zap.String("phase", phase),
+		zap.String("reason", reason))
+	return c.save(ctx, r)
+}
+
+// save stores a rollout and publishes the change
+func (c *Controller) save(ctx context.Context, r *Rollout) error {
+	r.UpdatedAt = time.Now()
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	var ttl time.Duration
+	if r.Phase != PhaseProgressing {
+		ttl = finishedTTL
+	}
+	pipe := c.redis.TxPipeline()
+	pipe.Set(ctx, c.key(r.ID), data, ttl)
+	pipe.SAdd(ctx, indexKey, r.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	broadcaster := c.broadcaster
+	c.mu.Unlock()
+	if broadcaster != nil {
+		broadcaster.Broadcast("rollout_update", r)
+	}
+	return nil
+}
+
+// apply sets the route weights of a rollout on this node
+func (c *Controller) apply(r *Rollout) error {
+	weights := map[string]int{
+		r.StablePool:    100 - r.Weight,
+		r.CandidatePool: r.Weight,
+	}
+	if err := c.splitter.SetSplitWeights(r.Route, weights); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.applied[r.ID] = fmt.Sprintf("%s/%d", r.Phase, r.Weight)
+	c.mu.Unlock()
+	return nil
+}
+
+// Run reconciles rollouts until ctx is done
+func (c *Controller) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.reconcile(ctx)
+		}
+	}
+}
+
+// reconcile applies the weights of every rollout that changed and, on the
+// leader, advances rollouts in progress whose step is due.
+func (c *Controller) reconcile(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	rollouts, err := c.List(ctx)
+	if err != nil {
+		c.logger.Warn("Failed to load rollouts", zap.Error(err))
+		return
+	}
+	leader := c.isLeader()
+
+	for _, r := range rollouts {
+		c.mu.Lock()
+		applied := c.applied[r.ID]
+		c.mu.Unlock()
+		// Rollouts in progress are reapplied every time, so route table
+		// rebuilds do not undo them
+		if r.Phase == PhaseProgressing || applied != fmt.Sprintf("%s/%d", r.Phase, r.Weight) {
+			if err := c.apply(r); err != nil {
+				c.logger.Warn("Failed to apply rollout weights", zap.Error(err), zap.String("rollout", r.ID))
+			}
+		}
+
+		if leader && r.Phase == PhaseProgressing {
+			if err := c.advance(ctx, r, time.Now()); err != nil {
+				c.logger.Warn("Failed to advance rollout", zap.Error(err), zap.String("rollout", r.ID))
+			}
+		}
+	}
+}
+
+// advance judges the current step of a rollout once it has lasted its
+// interval, then moves to the next step, promotes or rolls back.
+func (c *Controller) advance(ctx context.Context, r *Rollout, now time.Time) error {
+	current, err := c.source.PoolStats(r.CandidatePool)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	base, ok := c.baselines[r.ID]
+	if !ok || base.step != r.Step {
+		c.baselines[r.ID] = baseline{step: r.Step, stats: current}
+	}
+	c.mu.Unlock()
+	if !ok || base.step != r.Step {
+		// This node has not watched the step from its start, for example
+		// after taking over leadership; the step starts over
+		r.StepStarted = now
+		return c.save(ctx, r)
+	}
+
+	if now.Sub(r.StepStarted) < time.Duration(r.Interval) {
+		return nil
+	}
+
+	eval := evaluate(r, current.sub(base.stats), now)
+	r.LastEvaluation = &eval
+	if eval.Requests < float64(r.MinRequests) {
+		r.Reason = fmt.Sprintf("waiting for %d candidate requests", r.MinRequests)
+		return c.save(ctx, r)
+	}
+	r.Reason = ""
+
+	if !eval.Passed {
+		return c.end(ctx, r, PhaseRolledBack, failureReason(r, eval))
+	}
+	if r.Step == len(r.Steps)-1 {
+		return c.end(ctx, r, PhasePromoted, "all steps passed")
+	}
+
+	r.Step++
+	r.Weight = r.Steps[r.Step]
+	r.StepStarted = now
+	c.mu.Lock()
+	c.baselines[r.ID] = baseline{step: r.Step, stats: current}
+	c.mu.Unlock()
+	if err := c.apply(r); err != nil {
+		return err
+	}
+	c.logger.Info("Rollout advanced",
+		zap.String("rollout", r.ID),
+		zap.String("route", r.Route),
+		zap.Int("weight", r.Weight))
+	return c.save(ctx, r)
+}
+
+func evaluate(r *Rollout, delta Stats, now time.Time) Evaluation {
+	eval := Evaluation{Step: r.Step, Requests: delta.Requests, At: now, Passed: true}
+	if delta.Requests > 0 {
+		eval.ErrorRate = delta.Errors / delta.Requests
+	}
+	if p99 := delta.quantile(0.99); !math.IsNaN(p99) {
+		eval.P99Latency = Duration(time.Duration(p99 * float64(time.Second)))
+	}
+
+	if r.MaxErrorRate > 0 && eval.ErrorRate > r.MaxErrorRate {
+		eval.Passed = false
+	}
+	if r.MaxP99Latency > 0 && eval.P99Latency > r.MaxP99Latency {
+		eval.Passed = false
+	}
+	return eval
+}
+
+func failureReason(r *Rollout, eval Evaluation) string {
+	if r.MaxErrorRate > 0 && eval.ErrorRate > r.MaxErrorRate {
+		return fmt.Sprintf("error rate %.4f above %.4f at %d%%", eval.ErrorRate, r.MaxErrorRate, r.Weight)
+	}
+	return fmt.Sprintf("p99 latency %s above %s at %d%%",
+		time.Duration(eval.P99Latency), time.Duration(r.MaxP99Latency), r.Weight)
+}
@@ -0,0 +1,286 @@
+package rollout
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeSplitter struct {
+	mu      sync.Mutex
+	weights map[string]map[string]int
+}
+
+func (s *fakeSplitter) SetSplitWeights(routeID string, weights map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.weights == nil {
+		s.weights = make(map[string]map[string]int)
+	}
+	s.weights[routeID] = weights
+	return nil
+}
+
+func (s *fakeSplitter) get(routeID string) map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.weights[routeID]
+}
+
+var testBounds = []float64{0.05, 0.1, 0.5, 1, math.Inf(1)}
+
+// fakeSource accumulates candidate requests with a fixed latency
+type fakeSource struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+func (s *fakeSource) add(requests, errors int, latency float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats.Buckets == nil {
+		for _, bound := range testBounds {
+			s.stats.Buckets = append(s.stats.Buckets, Bucket{UpperBound: bound})
+		}
+	}
+	s.stats.Requests += float64(requests)
+	s.stats.Errors += float64(errors)
+	for i := range s.stats.Buckets {
+		if latency <= s.stats.Buckets[i].UpperBound {
+			s.stats.Buckets[i].Count += float64(requests)
+		}
+	}
+}
+
+func (s *fakeSource) PoolStats(pool string) (Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Buckets = append([]Bucket(nil), s.stats.Buckets...)
+	return stats, nil
+}
+
+type fakeLeader bool
+
+func (l fakeLeader) IsLeader() bool { return bool(l) }
+
+func newTestController(t *testing.T, mr *miniredis.Miniredis) (*Controller, *fakeSplitter, *fakeSource) {
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	splitter, source := &fakeSplitter{}, &fakeSource{}
+	return New(client, splitter, source, zap.NewNop()), splitter, source
+}
+
+func testSpec() Spec {
+	return Spec{
+		Route:         "web",
+		StablePool:    "stable",
+		CandidatePool: "candidate",
+		Steps:         []int{10, 50, 100},
+		Interval:      Duration(time.Minute),
+		MaxErrorRate:  0.05,
+		MaxP99Latency: Duration(200 * time.Millisecond),
+		MinRequests:   100,
+	}
+}
+
+// advanceAt runs the leader logic of a rollout at a given time
+func advanceAt(t *testing.T, c *Controller, id string, now time.Time) *Rollout {
+	t.Helper()
+	ctx := context.Background()
+	r, err := c.Get(ctx, id)
+	require.NoError(t, err)
+	require.NoError(t, c.advance(ctx, r, now))
+	r, err = c.Get(ctx, id)
+	require.NoError(t, err)
+	return r
+}
+
+func TestRolloutPromotes(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c, splitter, source := newTestController(t, mr)
+
+	r, err := c.Create(context.Background(), testSpec())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"stable": 90, "candidate": 10}, splitter.get("web"))
+
+	now := time.Now()
+	advanceAt(t, c, r.ID, now) // the step is watched from here
+
+	for i, weight := range []int{50, 100} {
+		source.add(200, 2, 0.03)
+		now = now.Add(time.Minute)
+		r = advanceAt(t, c, r.ID, now)
+		assert.Equal(t, PhaseProgressing, r.Phase)
+		assert.Equal(t, i+1, r.Step)
+		assert.Equal(t, weight, r.Weight)
+		assert.Equal(t, map[string]int{"stable": 100 - weight, "candidate": weight}, splitter.get("web"))
+		require.NotNil(t, r.LastEvaluation)
+		assert.True(t, r.LastEvaluation.Passed)
+		assert.InDelta(t, 0.01, r.LastEvaluation.ErrorRate, 1e-9)
+	}
+
+	source.add(200, 0, 0.03)
+	r = advanceAt(t, c, r.ID, now.Add(time.Minute))
+	assert.Equal(t, PhasePromoted, r.Phase)
+	assert.Equal(t, map[string]int{"stable": 0, "candidate": 100}, splitter.get("web"))
+
+	// Finished rollouts expire
+	assert.Greater(t, mr.TTL(c.key(r.ID)), time.Duration(0))
+}
+
+func TestRolloutRollsBack(t *testing.T) {
+	tests := []struct {
+		name    string
+		errors  int
+		latency float64
+		reason  string
+	}{
+		{"error rate", 20, 0.03, "error rate"},
+		{"p99 latency", 0, 0.8, "p99 latency"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, splitter, source := newTestController(t, miniredis.RunT(t))
+			r, err := c.Create(context.Background(), testSpec())
+			require.NoError(t, err)
+
+			now := time.Now()
+			advanceAt(t, c, r.ID, now)
+			source.add(200, tt.errors, tt.latency)
+			r = advanceAt(t, c, r.ID, now.Add(time.Minute))
+
+			assert.Equal(t, PhaseRolledBack, r.Phase)
+			assert.Contains(t, r.Reason, tt.reason)
+			assert.False(t, r.LastEvaluation.Passed)
+			assert.Equal(t, map[string]int{"stable": 100, "candidate": 0}, splitter.get("web"))
+		})
+	}
+}
+
+func TestRolloutWaitsForTraffic(t *testing.T) {
+	c, _, source := newTestController(t, miniredis.RunT(t))
+	r, err := c.Create(context.Background(), testSpec())
+	require.NoError(t, err)
+
+	now := time.Now()
+	advanceAt(t, c, r.ID, now)
+
+	// Too early to judge
+	source.add(500, 0, 0.03)
+	r = advanceAt(t, c, r.ID, now.Add(30*time.Second))
+	assert.Equal(t, 0, r.Step)
+	assert.Nil(t, r.LastEvaluation)
+
+	c2, _, source2 := newTestController(t, miniredis.RunT(t))
+	r, err = c2.Create(context.Background(), testSpec())
+	require.NoError(t, err)
+	advanceAt(t, c2, r.ID, now)
+	source2.add(10, 0, 0.03)
+	r = advanceAt(t, c2, r.ID, now.Add(time.Minute))
+	assert.Equal(t, PhaseProgressing, r.Phase)
+	assert.Equal(t, 0, r.Step)
+	assert.Contains(t, r.Reason, "waiting")
+}
+
+func TestRolloutFollowers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	leader, _, source := newTestController(t, mr)
+	follower, followerSplits, _ := newTestController(t, mr)
+	follower.SetLeader(fakeLeader(false))
+
+	r, err := leader.Create(context.Background(), testSpec())
+	require.NoError(t, err)
+
+	// Followers apply the weights but never advance the rollout
+	source.add(1000, 0, 0.03)
+	follower.reconcile(context.Background())
+	assert.Equal(t, map[string]int{"stable": 90, "candidate": 10}, followerSplits.get("web"))
+	got, err := follower.Get(context.Background(), r.ID)
+	require.NoError(t, err)
+	assert.Equal(t, r.StepStarted.Unix(), got.StepStarted.Unix())
+
+	_, err = leader.Rollback(context.Background(), r.ID, "manual")
+	require.NoError(t, err)
+	follower.reconcile(context.Background())
+	assert.Equal(t, map[string]int{"stable": 100, "candidate": 0}, followerSplits.get("web"))
+}
+
+func TestRolloutLifecycle(t *testing.T) {
+	c, _, _ := newTestController(t, miniredis.RunT(t))
+	ctx := context.Background()
+
+	r, err := c.Create(ctx, Spec{Route: "web", StablePool: "stable", CandidatePool: "candidate"})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultSteps, r.Steps)
+	assert.Equal(t, Duration(DefaultInterval), r.Interval)
+
+	_, err = c.Create(ctx, Spec{Route: "web", StablePool: "stable", CandidatePool: "other"})
+	assert.ErrorIs(t, err, ErrRouteBusy)
+
+	r, err = c.Promote(ctx, r.ID, "looks good")
+	require.NoError(t, err)
+	assert.Equal(t, PhasePromoted, r.Phase)
+	_, err = c.Rollback(ctx, r.ID, "")
+	assert.ErrorIs(t, err, ErrNotInProgress)
+	_, err = c.Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	rollouts, err := c.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, rollouts, 1)
+
+	invalid := []Spec{
+		{StablePool: "a", CandidatePool: "b"},
+		{Route: "web", StablePool: "a", CandidatePool: "a"},
+		{Route: "web", StablePool: "a", CandidatePool: "b", Steps: []int{50, 20}},
+		{Route: "web", StablePool: "a", CandidatePool: "b", Steps: []int{150}},
+		{Route: "web", StablePool: "a", CandidatePool: "b", MaxErrorRate: 2},
+	}
+	for _, spec := range invalid {
+		_, err := c.Create(ctx, spec)
+		assert.Error(t, err)
+	}
+}
+
+func TestGathererSource(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: requestsMetric}, []string{"method", "status_code", "pool"})
+	durations := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: durationMetric, Buckets: []float64{0.1, 0.5, 1}}, []string{"method", "pool"})
+	registry.MustRegister(requests, durations)
+
+	requests.WithLabelValues("GET", "200", "candidate").Add(90)
+	requests.WithLabelValues("POST", "503", "candidate").Add(10)
+	requests.WithLabelValues("GET", "500", "stable").Add(50)
+	for i := 0; i < 98; i++ {
+		durations.WithLabelValues("GET", "candidate").Observe(0.05)
+	}
+	durations.WithLabelValues("POST", "candidate").Observe(0.7)
+	durations.WithLabelValues("POST", "candidate").Observe(5)
+
+	stats, err := NewGathererSource(registry).PoolStats("candidate")
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, stats.Requests)
+	assert.Equal(t, 10.0, stats.Errors)
+	require.Len(t, stats.Buckets, 4)
+	assert.True(t, math.IsInf(stats.Buckets[3].UpperBound, 1))
+	assert.Equal(t, 100.0, stats.Buckets[3].Count)
+
+	// 99 of 100 requests took at most 1s, the 99th falling in the 0.5-1s bucket
+	assert.InDelta(t, 1.0, stats.quantile(0.99), 1e-9)
+	assert.InDelta(t, 0.1*0.5/0.98, stats.quantile(0.5), 1e-9)
+
+	delta := stats.sub(Stats{Requests: 50, Errors: 10, Buckets: []Bucket{{UpperBound: 0.1, Count: 48}}})
+	assert.Equal(t, 50.0, delta.Requests)
+	assert.Equal(t, 0.0, delta.Errors)
+	assert.Equal(t, 50.0, delta.Buckets[0].Count)
+}
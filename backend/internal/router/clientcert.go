@@ -0,0 +1,126 @@
+package router
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+)
+
+// Headers carrying the verified client certificate identity to backends
+const (
+	ClientCertSubjectHeader = "X-Client-Cert-Subject"
+	ClientCertSANHeader     = "X-Client-Cert-SAN"
+	ClientCertSPIFFEHeader  = "X-Client-Cert-SPIFFE-ID"
+)
+
+// Outcomes of client certificate checks
+const (
+	clientCertVerified = "verified"
+	clientCertMissing  = "missing"
+	clientCertInvalid  = "invalid"
+)
+
+var clientCertHeaders = []string{ClientCertSubjectHeader, ClientCertSANHeader, ClientCertSPIFFEHeader}
+
+// clientAuth verifies the client certificates of a route's requests
+type clientAuth struct {
+	required bool
+	roots    *x509.CertPool
+}
+
+// compileClientAuth loads the CA bundle of a route, returning nil when the
+// route does not verify client certificates
+func compileClientAuth(route config.Route) (*clientAuth, error) {
+	var required bool
+	switch route.ClientAuth.Mode {
+	case "":
+		return nil, nil
+	case config.ClientAuthOptional:
+	case config.ClientAuthRequired:
+		required = true
+	default:
+		return nil, fmt.Errorf("unknown client auth mode %q", route.ClientAuth.Mode)
+	}
+
+	if route.ClientAuth.CAFile == "" {
+		return nil, errors.New("client auth without a CA file")
+	}
+	pem, err := os.ReadFile(route.ClientAuth.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA file: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in client CA file %s", route.ClientAuth.CAFile)
+	}
+	return &clientAuth{required: required, roots: roots}, nil
+}
+
+// RequestsClientCertificates reports whether any of the routes verifies
+// client certificates, which the TLS server then has to ask clients for
+func RequestsClientCertificates(routes []config.Route) bool {
+	for _, route := range routes {
+		if route.ClientAuth.Mode != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks the certificate a client presented in the TLS handshake,
+// returning the verified leaf, nil when an optional one is missing, and the
+// outcome of the check
+func (c *clientAuth) verify(req *http.Request) (*x509.Certificate, string, error) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		if c.required {
+			return nil, clientCertMissing, errors.New("client certificate required")
+		}
+		return nil, clientCertMissing, nil
+	}
+
+	leaf := req.TLS.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range req.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         c.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, clientCertInvalid, err
+	}
+	return leaf, clientCertVerified, nil
+}
+
+// setClientCertHeaders describes a verified client certificate to backends
+func setClientCertHeaders(header http.Header, cert *x509.Certificate) {
+	header.Set(ClientCertSubjectHeader, cert.Subject.String())
+
+	var sans []string
+	for _, name := range cert.DNSNames {
+		sans = append(sans, "DNS:"+name)
+	}
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, "URI:"+uri.String())
+		// A SPIFFE verifiable identity document has exactly one such URI
+		if uri.Scheme == "spiffe" {
+			header.Set(ClientCertSPIFFEHeader, uri.String())
+		}
+	}
+	if len(sans) > 0 {
+		header.Set(ClientCertSANHeader, strings.Join(sans, ","))
+	}
+}
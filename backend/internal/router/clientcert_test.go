@@ -0,0 +1,166 @@
+package router
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA signs client certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+// writeFile writes the CA certificate as a PEM bundle
+func (ca *testCA) writeFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600))
+	return path
+}
+
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+// headerServer answers with the client certificate headers it received
+func headerServer(t *testing.T) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := make(map[string]string)
+		for _, name := range clientCertHeaders {
+			headers[name] = r.Header.Get(name)
+		}
+		json.NewEncoder(w).Encode(headers)
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestClientCertificateVerification(t *testing.T) {
+	ca := newTestCA(t, "Client CA")
+	backend := headerServer(t)
+	route := func(mode string) http.Handler {
+		return routeHandler(config.Route{
+			ID:         "mtls-" + mode,
+			Pool:       "mtls",
+			ClientAuth: config.ClientAuth{Mode: mode, CAFile: ca.writeFile(t)},
+		}, backend)
+	}
+	call := func(handler http.Handler, certs ...*x509.Certificate) (*httptest.ResponseRecorder, map[string]string) {
+		req := httptest.NewRequest(http.MethodGet, "https://api.example.com/", nil)
+		req.Header.Set(ClientCertSubjectHeader, "CN=forged")
+		if certs != nil {
+			req.TLS = &tls.ConnectionState{PeerCertificates: certs}
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		headers := make(map[string]string)
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &headers))
+		}
+		return w, headers
+	}
+
+	spiffe, _ := url.Parse("spiffe://example.org/ns/prod/sa/web")
+	workload := ca.issue(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "web", Organization: []string{"Example"}},
+		DNSNames: []string{"web.internal"},
+		URIs:     []*url.URL{spiffe},
+	})
+	stranger := newTestCA(t, "Other CA").issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "web"}})
+
+	required := route(config.ClientAuthRequired)
+	w, headers := call(required, workload)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "CN=web,O=Example", headers[ClientCertSubjectHeader])
+	assert.Equal(t, "DNS:web.internal,URI:spiffe://example.org/ns/prod/sa/web", headers[ClientCertSANHeader])
+	assert.Equal(t, "spiffe://example.org/ns/prod/sa/web", headers[ClientCertSPIFFEHeader])
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ClientCertificatesTotal.WithLabelValues("mtls-required", clientCertVerified)))
+
+	w, _ = call(required)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, _ = call(required, stranger)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ClientCertificatesTotal.WithLabelValues("mtls-required", clientCertInvalid)))
+
+	// Optional verification lets clients without a certificate through, but
+	// never the identity they claim
+	optional := route(config.ClientAuthOptional)
+	w, headers = call(optional)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, headers[ClientCertSubjectHeader])
+	w, _ = call(optional, stranger)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Routes without verification drop the headers too
+	w, headers = call(routeHandler(config.Route{ID: "plain", Pool: "mtls"}, backend), workload)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, headers[ClientCertSubjectHeader])
+}
+
+func TestCompileClientAuth(t *testing.T) {
+	caFile := newTestCA(t, "Client CA").writeFile(t)
+	auth, err := compileClientAuth(config.Route{})
+	require.NoError(t, err)
+	assert.Nil(t, auth)
+
+	for _, clientAuth := range []config.ClientAuth{
+		{Mode: "sometimes", CAFile: caFile},
+		{Mode: config.ClientAuthRequired},
+		{Mode: config.ClientAuthRequired, CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+	} {
+		assert.Error(t, ValidateRoute(config.Route{Pool: "mtls", ClientAuth: clientAuth}), clientAuth.Mode)
+	}
+
+	assert.True(t, RequestsClientCertificates([]config.Route{{}, {ClientAuth: config.ClientAuth{Mode: config.ClientAuthOptional}}}))
+	assert.False(t, RequestsClientCertificates([]config.Route{{}}))
+}
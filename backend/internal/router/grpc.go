@@ -0,0 +1,127 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+)
+
+// gRPC status codes the proxy reports itself
+const (
+	grpcUnknown          = 2
+	grpcInternal         = 13
+	grpcUnavailable      = 14
+	grpcUnauthenticated  = 16
+	grpcPermissionDenied = 7
+	grpcUnimplemented    = 12
+)
+
+// grpcRoute is the compiled gRPC configuration of a route
+type grpcRoute struct {
+	route    string
+	services map[string]bool // nil matches any service
+	methods  map[string]bool // nil matches any method
+}
+
+// compileGRPC returns the gRPC settings of a route, nil for other routes
+func compileGRPC(route config.Route) (*grpcRoute, error) {
+	if !route.GRPC.Enabled {
+		return nil, nil
+	}
+
+	g := &grpcRoute{route: route.ID}
+	if len(route.GRPC.Services) > 0 {
+		g.services = make(map[string]bool, len(route.GRPC.Services))
+		for _, service := range route.GRPC.Services {
+			if service == "" || strings.Contains(service, "/") {
+				return nil, fmt.Errorf("invalid gRPC service name %q", service)
+			}
+			g.services[service] = true
+		}
+	}
+	if len(route.GRPC.Methods) > 0 {
+		g.methods = make(map[string]bool, len(route.GRPC.Methods))
+		for _, method := range route.GRPC.Methods {
+			if method == "" || strings.Contains(method, "/") {
+				return nil, fmt.Errorf("invalid gRPC method name %q", method)
+			}
+			g.methods[method] = true
+		}
+	}
+	return g, nil
+}
+
+// isGRPC reports whether a request is a gRPC call
+func isGRPC(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc")
+}
+
+// grpcMethod splits the path of a gRPC call, /package.Service/Method, into
+// its service and method names
+func grpcMethod(path string) (service, method string, ok bool) {
+	service, method, found := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !found || service == "" || method == "" || strings.Contains(method, "/") {
+		return "", "", false
+	}
+	return service, method, true
+}
+
+// match reports whether a request is a call to one of the route's methods
+func (g *grpcRoute) match(req *http.Request) bool {
+	if req.Method != http.MethodPost || !isGRPC(req) {
+		return false
+	}
+	service, method, ok := grpcMethod(req.URL.Path)
+	if !ok {
+		return false
+	}
+	return (g.services == nil || g.services[service]) && (g.methods == nil || g.methods[method])
+}
+
+// observe records the outcome of a call from the grpc-status trailer, or
+// from the HTTP status when the call did not reach a gRPC server
+func (g *grpcRoute) observe(req *http.Request, header http.Header, statusCode int, duration time.Duration) {
+	service, method, _ := grpcMethod(req.URL.Path)
+	status := grpcStatus(header, statusCode)
+	metrics.GRPCRequestsTotal.WithLabelValues(g.route, service, method, strconv.Itoa(status)).Inc()
+	metrics.GRPCRequestDuration.WithLabelValues(g.route, service, method).Observe(duration.Seconds())
+}
+
+// grpcStatus returns the status of a proxied call. The reverse proxy copies
+// trailers into the header map once the body was sent: declared trailers
+// under their own name, others with the http.TrailerPrefix. Trailers-only
+// responses carry the status in the headers.
+func grpcStatus(header http.Header, statusCode int) int {
+	for _, name := range []string{"Grpc-Status", http.TrailerPrefix + "Grpc-Status"} {
+		if value := header.Get(name); value != "" {
+			if status, err := strconv.Atoi(value); err == nil {
+				return status
+			}
+			return grpcUnknown
+		}
+	}
+	return grpcStatusFromHTTP(statusCode)
+}
+
+// grpcStatusFromHTTP maps an HTTP status to a gRPC status as gRPC clients do
+func grpcStatusFromHTTP(statusCode int) int {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return grpcInternal
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcUnimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return grpcUnavailable
+	default:
+		return grpcUnknown
+	}
+}
@@ -0,0 +1,155 @@
+package router
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eltonciatto/veloflux/internal/balancer"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// grpcServer is an h2c backend answering calls with its name and the
+// grpc-status trailer; calls to the Fail method end with status 5
+func grpcServer(t *testing.T, name string) string {
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			http.Error(w, "HTTP/2 required", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte(name))
+		if strings.HasSuffix(r.URL.Path, "/Fail") {
+			w.Header().Set("Grpc-Status", "5")
+			return
+		}
+		w.Header().Set("Grpc-Status", "0")
+	}), &http2.Server{}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// grpcRouter routes to a pool of two h2c gRPC backends
+func grpcRouter(t *testing.T, scheme string) *Router {
+	pools := []config.Pool{{
+		Name:     "rpc",
+		Backends: []config.Backend{{Address: grpcServer(t, "one")}, {Address: grpcServer(t, "two")}},
+		Upstream: config.UpstreamConfig{Scheme: scheme},
+	}}
+	bal := balancer.New()
+	bal.AddPool(pools[0])
+	return &Router{
+		config:   &config.Config{Pools: pools},
+		balancer: bal,
+		logger:   zap.NewNop(),
+	}
+}
+
+func grpcCall(path string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("message"))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	return req
+}
+
+func TestGRPCCallsBalancedOverOneConnection(t *testing.T) {
+	router := grpcRouter(t, config.UpstreamH2C)
+	route := config.Route{ID: "rpc", Pool: "rpc", GRPC: config.GRPCRoute{Enabled: true}}
+	proxy := httptest.NewServer(h2c.NewHandler(router.middleware(router.createRouteHandler(route)), &http2.Server{}))
+	defer proxy.Close()
+
+	// Every call of a single client connection may go to another backend
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	seen := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		req, err := http.NewRequest(http.MethodPost, proxy.URL+"/echo.Echo/Say", strings.NewReader("message"))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/grpc")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+		seen[string(body)]++
+	}
+	assert.Equal(t, map[string]int{"one": 2, "two": 2}, seen)
+	assert.Equal(t, 4.0, testutil.ToFloat64(metrics.GRPCRequestsTotal.WithLabelValues("rpc", "echo.Echo", "Say", "0")))
+}
+
+func TestGRPCStatusMetrics(t *testing.T) {
+	router := grpcRouter(t, config.UpstreamH2C)
+	handler := router.createRouteHandler(config.Route{ID: "rpc-status", Pool: "rpc", GRPC: config.GRPCRoute{Enabled: true}})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, grpcCall("/echo.Echo/Fail"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.GRPCRequestsTotal.WithLabelValues("rpc-status", "echo.Echo", "Fail", "5")))
+	assert.GreaterOrEqual(t, testutil.CollectAndCount(metrics.GRPCRequestDuration), 1)
+}
+
+func TestGRPCRouteRequiresHTTP2Pool(t *testing.T) {
+	router := grpcRouter(t, "")
+	handler := router.createRouteHandler(config.Route{ID: "rpc-http1", Pool: "rpc", GRPC: config.GRPCRoute{Enabled: true}})
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, grpcCall("/echo.Echo/Say"))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
+
+func TestGRPCRouteMatch(t *testing.T) {
+	m, err := compileRoute(config.Route{GRPC: config.GRPCRoute{
+		Enabled:  true,
+		Services: []string{"payments.Payments"},
+		Methods:  []string{"Charge", "Refund"},
+	}})
+	require.NoError(t, err)
+
+	assert.True(t, m.match(grpcCall("/payments.Payments/Charge"), nil))
+	assert.True(t, m.match(grpcCall("/payments.Payments/Refund"), nil))
+	assert.False(t, m.match(grpcCall("/payments.Payments/List"), nil))
+	assert.False(t, m.match(grpcCall("/search.Search/Charge"), nil))
+	assert.False(t, m.match(grpcCall("/payments.Payments"), nil))
+
+	// Only gRPC calls match
+	req := grpcCall("/payments.Payments/Charge")
+	req.Header.Set("Content-Type", "application/json")
+	assert.False(t, m.match(req, nil))
+	req = grpcCall("/payments.Payments/Charge")
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	assert.True(t, m.match(req, nil))
+
+	assert.Error(t, ValidateRoute(config.Route{GRPC: config.GRPCRoute{Enabled: true, Services: []string{"a/b"}}}))
+}
+
+func TestGRPCStatus(t *testing.T) {
+	header := http.Header{}
+	header.Set(http.TrailerPrefix+"Grpc-Status", "7")
+	assert.Equal(t, 7, grpcStatus(header, http.StatusOK))
+
+	header = http.Header{}
+	header.Set("Grpc-Status", "bogus")
+	assert.Equal(t, grpcUnknown, grpcStatus(header, http.StatusOK))
+
+	assert.Equal(t, grpcUnavailable, grpcStatus(http.Header{}, http.StatusServiceUnavailable))
+	assert.Equal(t, grpcUnimplemented, grpcStatus(http.Header{}, http.StatusNotFound))
+	assert.Equal(t, grpcUnknown, grpcStatus(http.Header{}, http.StatusTeapot))
+}
@@ -0,0 +1,201 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// errHedgeLost aborts a hedged response that arrived after another one won
+var errHedgeLost = errors.New("hedged request lost the race")
+
+// hedgeable reports whether a request is idempotent and has no body, so
+// copies of it can safely be sent to several backends. Upgrades are never
+// hedged, as only one backend can take over the connection.
+func hedgeable(req *http.Request) bool {
+	if isUpgrade(req) {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		return false
+	}
+	return req.ContentLength == 0 && (req.Body == nil || req.Body == http.NoBody)
+}
+
+// hedgeRace lets the first hedged attempt with an acceptable response deliver
+// it to the client and cancels the other attempts.
+type hedgeRace struct {
+	mu       sync.Mutex
+	winner   *hedgeAttempt
+	attempts []*hedgeAttempt
+	claimed  chan struct{} // closed once an attempt wins
+}
+
+// add registers a new attempt writing to w
+func (race *hedgeRace) add(w http.ResponseWriter, cancel context.CancelFunc) *hedgeAttempt {
+	race.mu.Lock()
+	defer race.mu.Unlock()
+
+	attempt := &hedgeAttempt{w: w, race: race, header: make(http.Header), cancel: cancel}
+	race.attempts = append(race.attempts, attempt)
+	return attempt
+}
+
+func (race *hedgeRace) isWinner(attempt *hedgeAttempt) bool {
+	race.mu.Lock()
+	defer race.mu.Unlock()
+	return race.winner == attempt
+}
+
+// won reports whether an attempt already won the race
+func (race *hedgeRace) won() bool {
+	race.mu.Lock()
+	defer race.mu.Unlock()
+	return race.winner != nil
+}
+
+// hedgeAttempt is the response writer of one hedged attempt. Only the winner
+// of the race reaches the client; everything else is discarded.
+type hedgeAttempt struct {
+	w      http.ResponseWriter
+	race   *hedgeRace
+	header http.Header
+	cancel context.CancelFunc
+}
+
+// claim makes the attempt the winner unless another attempt already won,
+// and cancels the remaining attempts.
+func (a *hedgeAttempt) claim() bool {
+	a.race.mu.Lock()
+	defer a.race.mu.Unlock()
+
+	if a.race.winner != nil {
+		return a.race.winner == a
+	}
+	a.race.winner = a
+	close(a.race.claimed)
+	for _, other := range a.race.attempts {
+		if other != a {
+			other.cancel()
+		}
+	}
+	return true
+}
+
+func (a *hedgeAttempt) Header() http.Header {
+	if a.race.isWinner(a) {
+		return a.w.Header()
+	}
+	return a.header
+}
+
+func (a *hedgeAttempt) WriteHeader(statusCode int) {
+	if a.race.isWinner(a) {
+		a.w.WriteHeader(statusCode)
+	}
+}
+
+func (a *hedgeAttempt) Write(b []byte) (int, error) {
+	if a.race.isWinner(a) {
+		return a.w.Write(b)
+	}
+	return len(b), nil
+}
+
+func (a *hedgeAttempt) Flush() {
+	if flusher, ok := a.w.(http.Flusher); ok && a.race.isWinner(a) {
+		flusher.Flush()
+	}
+}
+
+// serveHedged sends the request to one backend and, while no response has
+// arrived, sends copies to other backends every delay. The first acceptable
+// response is served; failed attempts are replaced while attempts remain.
+func (r *Router) serveHedged(w http.ResponseWriter, req *http.Request, p *proxyRequest, policy config.HedgePolicy) {
+	delay := policy.Delay
+	if delay == 0 {
+		delay = defaultHedgeDelay
+	}
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultHedgeAttempts
+	}
+
+	race := &hedgeRace{claimed: make(chan struct{})}
+	results := make(chan attemptResult, maxAttempts)
+	var wg sync.WaitGroup
+	launched := 0
+
+	// Any failure of a hedged attempt is reported rather than written
+	reportFailure := func(string) bool { return true }
+
+	launch := func() {
+		ctx, cancel := context.WithCancel(req.Context())
+		attempt := race.add(w, cancel)
+		attemptReq := req.Clone(ctx)
+		launched++
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+			results <- r.proxyAttempt(attempt, attemptReq, p, reportFailure, attempt)
+		}()
+	}
+
+	// hedge sends one more copy if the policy and the retry budget allow it
+	hedge := func() bool {
+		if launched >= maxAttempts || req.Context().Err() != nil || race.won() {
+			return false
+		}
+		if !p.retry.budget.allowRetry() {
+			metrics.RetryBudgetExhaustedTotal.WithLabelValues(p.pool).Inc()
+			return false
+		}
+		metrics.HedgedRequestsTotal.WithLabelValues(p.pool).Inc()
+		r.logger.Debug("Sending hedged request",
+			zap.String("pool", p.pool),
+			zap.Int("attempt", launched+1))
+		launch()
+		return true
+	}
+
+	launch()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	claimed := race.claimed
+
+	for pending := 1; pending > 0; {
+		select {
+		case <-claimed:
+			// The winner is streaming its response: no more copies are sent
+			timer.Stop()
+			claimed = nil
+		case result := <-results:
+			pending--
+			if result.done {
+				wg.Wait()
+				return
+			}
+			if pending == 0 && hedge() {
+				pending++
+			}
+		case <-timer.C:
+			if hedge() {
+				pending++
+				timer.Reset(delay)
+			}
+		}
+	}
+
+	wg.Wait()
+	http.Error(w, "Bad gateway", http.StatusBadGateway)
+}
@@ -0,0 +1,234 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/gorilla/mux"
+)
+
+// unmatchedRoute labels requests that did not match any route
+const unmatchedRoute = "none"
+
+// routeMatcher holds the compiled conditions of a route beyond the host and
+// path prefix, which gorilla mux matches itself.
+type routeMatcher struct {
+	methods map[string]bool
+	path    *regexp.Regexp
+	headers []valueMatcher
+	query   []valueMatcher
+	cookies []valueMatcher
+	cidrs   []*net.IPNet
+	grpc    *grpcRoute
+}
+
+type valueMatcher struct {
+	name  string
+	value string
+	regex *regexp.Regexp
+}
+
+// ValidateRoute reports whether the match conditions, transformation and
+// traffic split of a route are valid
+func ValidateRoute(route config.Route) error {
+	_, err := compileRoute(route)
+	return err
+}
+
+func compileRoute(route config.Route) (*routeMatcher, error) {
+	m := &routeMatcher{}
+
+	if len(route.Methods) > 0 {
+		m.methods = make(map[string]bool, len(route.Methods))
+		for _, method := range route.Methods {
+			m.methods[strings.ToUpper(method)] = true
+		}
+	}
+
+	if route.PathRegex != "" {
+		path, err := regexp.Compile("^(?:" + route.PathRegex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid path regex: %w", err)
+		}
+		m.path = path
+	}
+
+	var err error
+	if m.headers, err = compileValueMatches("header", route.Headers); err != nil {
+		return nil, err
+	}
+	if m.query, err = compileValueMatches("query parameter", route.Query); err != nil {
+		return nil, err
+	}
+	if m.cookies, err = compileValueMatches("cookie", route.Cookies); err != nil {
+		return nil, err
+	}
+
+	for _, cidr := range route.SourceCIDRs {
+		if !strings.Contains(cidr, "/") {
+			// A bare address matches only itself
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source CIDR: %w", err)
+		}
+		m.cidrs = append(m.cidrs, network)
+	}
+
+	if m.grpc, err = compileGRPC(route); err != nil {
+		return nil, err
+	}
+
+	// The transformation, split, mirror, streaming and client certificate
+	// settings are compiled by the route handler; rejecting them here keeps
+	// routes with invalid ones out of the route table
+	if _, err := compileTransform(route.Transform); err != nil {
+		return nil, err
+	}
+	if _, err := compileMirror(route); err != nil {
+		return nil, err
+	}
+	if _, err := compileStreaming(route.Streaming); err != nil {
+		return nil, err
+	}
+	if _, err := compileClientAuth(route); err != nil {
+		return nil, err
+	}
+	if len(route.Split.Variants) > 0 {
+		if _, err := compileSplit(route); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func compileValueMatches(kind string, matches []config.ValueMatch) ([]valueMatcher, error) {
+	compiled := make([]valueMatcher, 0, len(matches))
+	for _, match := range matches {
+		if match.Name == "" {
+			return nil, fmt.Errorf("%s match without a name", kind)
+		}
+		vm := valueMatcher{name: match.Name, value: match.Value}
+		if match.Regex != "" {
+			regex, err := regexp.Compile(match.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s regex for %s: %w", kind, match.Name, err)
+			}
+			vm.regex = regex
+		}
+		compiled = append(compiled, vm)
+	}
+	return compiled, nil
+}
+
+// matches reports whether any of the values of a present attribute satisfies the matcher
+func (vm valueMatcher) matches(values []string, present bool) bool {
+	if !present {
+		return false
+	}
+	if vm.regex == nil && vm.value == "" {
+		return true
+	}
+	for _, value := range values {
+		if vm.regex != nil && vm.regex.MatchString(value) {
+			return true
+		}
+		if vm.regex == nil && value == vm.value {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether a request satisfies every condition of the route
+func (m *routeMatcher) match(req *http.Request, clientIP func(*http.Request) net.IP) bool {
+	if m.methods != nil && !m.methods[req.Method] {
+		return false
+	}
+	if m.path != nil && !m.path.MatchString(req.URL.Path) {
+		return false
+	}
+	if m.grpc != nil && !m.grpc.match(req) {
+		return false
+	}
+
+	for _, vm := range m.headers {
+		values, present := req.Header[http.CanonicalHeaderKey(vm.name)]
+		if !vm.matches(values, present) {
+			return false
+		}
+	}
+
+	if len(m.query) > 0 {
+		query := req.URL.Query()
+		for _, vm := range m.query {
+			values, present := query[vm.name]
+			if !vm.matches(values, present) {
+				return false
+			}
+		}
+	}
+
+	for _, vm := range m.cookies {
+		var values []string
+		for _, cookie := range req.Cookies() {
+			if cookie.Name == vm.name {
+				values = append(values, cookie.Value)
+			}
+		}
+		if !vm.matches(values, len(values) > 0) {
+			return false
+		}
+	}
+
+	if len(m.cidrs) > 0 {
+		ip := clientIP(req)
+		if ip == nil {
+			return false
+		}
+		for _, network := range m.cidrs {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// orderRoutes returns the routes in the order they are tried: highest
+// priority first, configuration order among equal priorities. Routes without
+// an ID get their derived one.
+func orderRoutes(routes []config.Route) []config.Route {
+	ordered := make([]config.Route, len(routes))
+	copy(ordered, routes)
+	for i := range ordered {
+		if ordered[i].ID == "" {
+			ordered[i].ID = config.RouteID(ordered[i])
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Priority > ordered[j].Priority
+	})
+	return ordered
+}
+
+// matchedRoute returns the ID of the route that matched a request
+func matchedRoute(req *http.Request) string {
+	if route := mux.CurrentRoute(req); route != nil && route.GetName() != "" {
+		return route.GetName()
+	}
+	return unmatchedRoute
+}
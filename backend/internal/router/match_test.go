@@ -0,0 +1,178 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eltonciatto/veloflux/internal/balancer"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRouteMatcher(t *testing.T) {
+	route := config.Route{
+		Methods:     []string{"get", "POST"},
+		PathRegex:   `/api/v[0-9]+/users`,
+		Headers:     []config.ValueMatch{{Name: "x-tenant", Value: "acme"}, {Name: "User-Agent", Regex: "^curl/"}},
+		Query:       []config.ValueMatch{{Name: "debug"}},
+		Cookies:     []config.ValueMatch{{Name: "beta", Value: "on"}},
+		SourceCIDRs: []string{"10.0.0.0/8", "192.168.1.7"},
+	}
+	m, err := compileRoute(route)
+	require.NoError(t, err)
+
+	request := func(modify func(req *http.Request)) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/api/v2/users?debug", nil)
+		req.RemoteAddr = "10.1.2.3:4567"
+		req.Header.Set("X-Tenant", "acme")
+		req.Header.Set("User-Agent", "curl/8.0")
+		req.AddCookie(&http.Cookie{Name: "beta", Value: "on"})
+		if modify != nil {
+			modify(req)
+		}
+		return req
+	}
+	router := &Router{}
+
+	tests := []struct {
+		name   string
+		modify func(req *http.Request)
+		want   bool
+	}{
+		{"all conditions", nil, true},
+		{"method", func(req *http.Request) { req.Method = http.MethodDelete }, false},
+		{"path regex is anchored", func(req *http.Request) { req.URL.Path = "/api/v2/users/1" }, false},
+		{"exact header", func(req *http.Request) { req.Header.Set("X-Tenant", "other") }, false},
+		{"header regex", func(req *http.Request) { req.Header.Set("User-Agent", "Mozilla/5.0") }, false},
+		{"query presence", func(req *http.Request) { req.URL.RawQuery = "" }, false},
+		{"empty query value is present", func(req *http.Request) { req.URL.RawQuery = "debug=" }, true},
+		{"cookie", func(req *http.Request) { req.Header.Set("Cookie", "beta=off") }, false},
+		{"bare source address", func(req *http.Request) { req.RemoteAddr = "192.168.1.7:1" }, true},
+		{"source outside CIDRs", func(req *http.Request) { req.RemoteAddr = "192.168.1.8:1" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, m.match(request(tt.modify), router.getClientIP))
+		})
+	}
+}
+
+func TestValidateRoute(t *testing.T) {
+	assert.NoError(t, ValidateRoute(config.Route{Pool: "web", SourceCIDRs: []string{"::1", "fd00::/8"}}))
+	assert.Error(t, ValidateRoute(config.Route{PathRegex: "("}))
+	assert.Error(t, ValidateRoute(config.Route{Headers: []config.ValueMatch{{Value: "x"}}}))
+	assert.Error(t, ValidateRoute(config.Route{Query: []config.ValueMatch{{Name: "q", Regex: "["}}}))
+	assert.Error(t, ValidateRoute(config.Route{SourceCIDRs: []string{"10.0.0.0/33"}}))
+}
+
+func TestRoutePriority(t *testing.T) {
+	general, special := echoServer(t, "general"), echoServer(t, "special")
+	cfg := &config.Config{
+		Pools: []config.Pool{
+			{Name: "general", Backends: []config.Backend{{Address: general}}},
+			{Name: "special", Backends: []config.Backend{{Address: special}}},
+		},
+		Routes: []config.Route{
+			{Host: "example.com", Pool: "general", PathPrefix: "/"},
+			{ID: "beta", Host: "example.com", Pool: "special", PathPrefix: "/",
+				Headers: []config.ValueMatch{{Name: "X-Beta"}}, Priority: 10},
+			{Pool: "general", PathRegex: "(", Priority: 20},
+		},
+	}
+	bal := balancer.New()
+	for _, pool := range cfg.Pools {
+		bal.AddPool(pool)
+	}
+	router := New(cfg, bal, "node1", zap.NewNop())
+
+	serve := func(beta bool) string {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		if beta {
+			req.Header.Set("X-Beta", "1")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	// The later, higher priority route wins when its conditions hold; the
+	// invalid route is skipped rather than breaking the table
+	before := testutil.ToFloat64(metrics.RouteRequestsTotal.WithLabelValues("beta", "200"))
+	assert.Equal(t, "special", serve(true))
+	assert.Equal(t, "general", serve(false))
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.RouteRequestsTotal.WithLabelValues("beta", "200")))
+
+	generalID := config.RouteID(cfg.Routes[0])
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.RouteRequestsTotal.WithLabelValues(generalID, "200")))
+}
+
+func TestOrderRoutes(t *testing.T) {
+	routes := []config.Route{
+		{ID: "a"},
+		{ID: "b", Priority: 5},
+		{ID: "c"},
+		{Host: "d.example.com", Priority: 5},
+	}
+	ordered := orderRoutes(routes)
+
+	ids := make([]string, len(ordered))
+	for i, route := range ordered {
+		ids[i] = route.ID
+	}
+	assert.Equal(t, []string{"b", config.RouteID(routes[3]), "a", "c"}, ids)
+	assert.Empty(t, routes[3].ID, "ordering must not modify the configuration")
+}
+
+// fakeDomains maps custom domains to the tenants that registered them
+type fakeDomains map[string]string
+
+func (f fakeDomains) TenantForHost(host string) (string, bool) {
+	tenantID, ok := f[host]
+	return tenantID, ok
+}
+
+func TestCustomDomainRouting(t *testing.T) {
+	acme, other, shared := echoServer(t, "acme"), echoServer(t, "other"), echoServer(t, "shared")
+	cfg := &config.Config{
+		Pools: []config.Pool{
+			{Name: "acme", Backends: []config.Backend{{Address: acme}}},
+			{Name: "other", Backends: []config.Backend{{Address: other}}},
+			{Name: "shared", Backends: []config.Backend{{Address: shared}}},
+		},
+		Routes: []config.Route{
+			{ID: "acme", Host: "acme.veloflux.io", Pool: "acme", PathPrefix: "/", Tenant: "acme"},
+			{ID: "other", Pool: "other", PathPrefix: "/", Tenant: "other", Priority: 5},
+			{ID: "shared", Pool: "shared", PathPrefix: "/"},
+		},
+	}
+	bal := balancer.New()
+	for _, pool := range cfg.Pools {
+		bal.AddPool(pool)
+	}
+	router := New(cfg, bal, "node1", zap.NewNop())
+
+	serve := func(host string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+	// The higher priority route for any host wins
+	assert.Equal(t, "other", serve("acme.veloflux.io"))
+	assert.Equal(t, "other", serve("shop.example.com"))
+
+	router.SetDomainResolver(fakeDomains{"shop.example.com": "acme", "www.other.test": "other"})
+
+	// A custom domain reaches the routes of its tenant only
+	assert.Equal(t, "acme", serve("shop.example.com"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.RouteRequestsTotal.WithLabelValues("acme", "200")))
+	assert.Equal(t, "other", serve("www.other.test"))
+	// Other hosts are routed as before
+	assert.Equal(t, "other", serve("acme.veloflux.io"))
+	assert.Equal(t, "other", serve("unregistered.example.com"))
+}
@@ -0,0 +1,192 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Request mirroring defaults
+const (
+	defaultMirrorPercent  = 100
+	defaultMirrorBody     = 64 * 1024
+	defaultMirrorTimeout  = 5 * time.Second
+	defaultMirrorInFlight = 100
+)
+
+// Reasons a sampled request is not mirrored
+const (
+	mirrorDropBodyTooLarge = "body_too_large"
+	mirrorDropOverloaded   = "overloaded"
+	mirrorDropNoBackend    = "no_backend"
+)
+
+// mirrorPolicy is the compiled mirror configuration of a route
+type mirrorPolicy struct {
+	route        string
+	pool         string
+	percent      float64
+	maxBodyBytes int64
+	timeout      time.Duration
+	slots        chan struct{} // holds a token per mirrored request in flight
+}
+
+// compileMirror returns the mirror policy of a route, nil when the route is
+// not mirrored
+func compileMirror(route config.Route) (*mirrorPolicy, error) {
+	cfg := route.Mirror
+	if cfg.Pool == "" {
+		return nil, nil
+	}
+	if cfg.Percent < 0 || cfg.Percent > 100 {
+		return nil, fmt.Errorf("mirror percent must be between 0 and 100")
+	}
+	if cfg.MaxBodyBytes < 0 || cfg.Timeout < 0 || cfg.MaxInFlight < 0 {
+		return nil, fmt.Errorf("mirror limits must not be negative")
+	}
+
+	m := &mirrorPolicy{
+		route:        route.ID,
+		pool:         cfg.Pool,
+		percent:      cfg.Percent,
+		maxBodyBytes: cfg.MaxBodyBytes,
+		timeout:      cfg.Timeout,
+	}
+	if m.percent == 0 {
+		m.percent = defaultMirrorPercent
+	}
+	if m.maxBodyBytes == 0 {
+		m.maxBodyBytes = defaultMirrorBody
+	}
+	if m.timeout == 0 {
+		m.timeout = defaultMirrorTimeout
+	}
+	inFlight := cfg.MaxInFlight
+	if inFlight == 0 {
+		inFlight = defaultMirrorInFlight
+	}
+	m.slots = make(chan struct{}, inFlight)
+	return m, nil
+}
+
+// sample reports whether a request should be mirrored
+func (m *mirrorPolicy) sample() bool {
+	if m == nil {
+		return false
+	}
+	return m.percent >= 100 || rand.Float64()*100 < m.percent
+}
+
+func (m *mirrorPolicy) drop(reason string) {
+	metrics.MirrorDroppedTotal.WithLabelValues(m.route, m.pool, reason).Inc()
+}
+
+// discardResponse swallows the response of a mirrored request, keeping only
+// its status code
+type discardResponse struct {
+	header     http.Header
+	statusCode int
+}
+
+func (d *discardResponse) Header() http.Header {
+	return d.header
+}
+
+func (d *discardResponse) WriteHeader(statusCode int) {
+	if d.statusCode == 0 {
+		d.statusCode = statusCode
+	}
+}
+
+func (d *discardResponse) Write(b []byte) (int, error) {
+	d.WriteHeader(http.StatusOK)
+	return len(b), nil
+}
+
+// mirror sends a copy of a request to the mirror pool in the background. The
+// request must be fully prepared for the upstream, with its body buffered in
+// body. The copy outlives the client request, bounded by the mirror timeout.
+func (r *Router) mirror(req *http.Request, body []byte, clientIP net.IP, m *mirrorPolicy) {
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		m.drop(mirrorDropOverloaded)
+		return
+	}
+
+	// Copy the request now, before proxying the original changes it
+	copied := req.Clone(context.Background())
+	setBody(copied, body)
+
+	go func() {
+		defer func() { <-m.slots }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		defer cancel()
+		r.sendMirror(copied.WithContext(ctx), clientIP, m)
+	}()
+}
+
+func (r *Router) sendMirror(req *http.Request, clientIP net.IP, m *mirrorPolicy) {
+	upstreamTransport, err := r.transportFor(m.pool)
+	if err != nil {
+		r.logger.Warn("Invalid mirror upstream configuration",
+			zap.Error(err),
+			zap.String("pool", m.pool))
+		m.drop(mirrorDropNoBackend)
+		return
+	}
+	backend, err := r.balancer.GetBackend(m.pool, clientIP, "", req)
+	if err != nil {
+		r.logger.Debug("No backend to mirror request to",
+			zap.Error(err),
+			zap.String("pool", m.pool))
+		m.drop(mirrorDropNoBackend)
+		return
+	}
+	// Picking the backend counted nothing; the copy holds one connection
+	r.balancer.IncrementConnections(m.pool, backend.Address)
+	defer r.balancer.DecrementConnections(m.pool, backend.Address)
+
+	target, err := url.Parse(fmt.Sprintf("%s://%s", upstreamTransport.scheme, backend.Address))
+	if err != nil {
+		r.logger.Error("Invalid backend URL", zap.Error(err))
+		m.drop(mirrorDropNoBackend)
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = upstreamTransport.transport
+	failed := false
+	proxy.ErrorHandler = func(_ http.ResponseWriter, _ *http.Request, err error) {
+		failed = true
+		if !errors.Is(err, context.DeadlineExceeded) {
+			r.logger.Debug("Mirrored request failed",
+				zap.Error(err),
+				zap.String("pool", m.pool),
+				zap.String("backend", backend.Address))
+		}
+	}
+
+	start := time.Now()
+	response := &discardResponse{header: make(http.Header)}
+	proxy.ServeHTTP(response, req)
+
+	status := "error"
+	if !failed {
+		status = strconv.Itoa(response.statusCode)
+	}
+	metrics.MirrorRequestsTotal.WithLabelValues(m.route, m.pool, status).Inc()
+	metrics.MirrorRequestDuration.WithLabelValues(m.route, m.pool).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,168 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/balancer"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type mirroredRequest struct {
+	method string
+	path   string
+	body   string
+	header http.Header
+}
+
+// mirrorRouter builds a router with a primary pool echoing requests and a
+// shadow pool reporting the requests it receives
+func mirrorRouter(t *testing.T, shadow http.HandlerFunc) (*Router, chan mirroredRequest) {
+	received := make(chan mirroredRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- mirroredRequest{method: r.Method, path: r.URL.Path, body: string(body), header: r.Header}
+		shadow(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	pools := []config.Pool{
+		{Name: "primary", Backends: []config.Backend{{Address: echoServer(t, "primary:")}}},
+		{Name: "shadow", Backends: []config.Backend{{Address: strings.TrimPrefix(server.URL, "http://")}}},
+	}
+	bal := balancer.New()
+	for _, pool := range pools {
+		bal.AddPool(pool)
+	}
+	return &Router{
+		config:   &config.Config{Pools: pools},
+		balancer: bal,
+		logger:   zap.NewNop(),
+	}, received
+}
+
+func TestMirrorCopiesRequests(t *testing.T) {
+	router, received := mirrorRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	handler := router.createRouteHandler(config.Route{
+		ID:     "mirrored",
+		Pool:   "primary",
+		Mirror: config.MirrorPolicy{Pool: "shadow"},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("payload"))
+	req.RemoteAddr = "1.2.3.4:5678"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	// The client only sees the primary response
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "primary:payload", w.Body.String())
+
+	select {
+	case copied := <-received:
+		assert.Equal(t, http.MethodPost, copied.method)
+		assert.Equal(t, "/orders", copied.path)
+		assert.Equal(t, "payload", copied.body)
+		assert.Equal(t, "1.2.3.4", copied.header.Get("X-Real-IP"))
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not mirrored")
+	}
+
+	errors := metrics.MirrorRequestsTotal.WithLabelValues("mirrored", "shadow", "500")
+	assert.Eventually(t, func() bool { return testutil.ToFloat64(errors) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// Neither pool keeps counting the connections of the request and its copy
+	assertConnectionsReleased(t, router, "primary")
+	assert.Eventually(t, func() bool {
+		return router.balancer.GetAllBackends()["shadow"][0].Connections.Load() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestMirrorSkipsLargeBodies(t *testing.T) {
+	router, received := mirrorRouter(t, func(w http.ResponseWriter, r *http.Request) {})
+	handler := router.createRouteHandler(config.Route{
+		ID:     "small-bodies",
+		Pool:   "primary",
+		Mirror: config.MirrorPolicy{Pool: "shadow", MaxBodyBytes: 4},
+	})
+
+	dropped := metrics.MirrorDroppedTotal.WithLabelValues("small-bodies", "shadow", mirrorDropBodyTooLarge)
+	before := testutil.ToFloat64(dropped)
+
+	w := serve(handler, http.MethodPost, "too large")
+	assert.Equal(t, "primary:too large", w.Body.String())
+	assert.Equal(t, before+1, testutil.ToFloat64(dropped))
+
+	w = serve(handler, http.MethodPost, "ok")
+	assert.Equal(t, "primary:ok", w.Body.String())
+	select {
+	case copied := <-received:
+		assert.Equal(t, "ok", copied.body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not mirrored")
+	}
+}
+
+func TestMirrorBoundsInFlightCopies(t *testing.T) {
+	release := make(chan struct{})
+	router, received := mirrorRouter(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	defer close(release)
+	handler := router.createRouteHandler(config.Route{
+		ID:     "bounded",
+		Pool:   "primary",
+		Mirror: config.MirrorPolicy{Pool: "shadow", MaxInFlight: 1},
+	})
+
+	dropped := metrics.MirrorDroppedTotal.WithLabelValues("bounded", "shadow", mirrorDropOverloaded)
+	before := testutil.ToFloat64(dropped)
+
+	// A slow mirror pool does not hold up clients
+	assert.Equal(t, "primary:", serve(handler, http.MethodGet, "").Body.String())
+	<-received
+	assert.Equal(t, "primary:", serve(handler, http.MethodGet, "").Body.String())
+	assert.Equal(t, before+1, testutil.ToFloat64(dropped))
+}
+
+func TestCompileMirror(t *testing.T) {
+	m, err := compileMirror(config.Route{Pool: "primary"})
+	require.NoError(t, err)
+	assert.Nil(t, m)
+	assert.False(t, m.sample())
+
+	m, err = compileMirror(config.Route{Mirror: config.MirrorPolicy{Pool: "shadow"}})
+	require.NoError(t, err)
+	assert.Equal(t, float64(defaultMirrorPercent), m.percent)
+	assert.Equal(t, int64(defaultMirrorBody), m.maxBodyBytes)
+	assert.Equal(t, defaultMirrorInFlight, cap(m.slots))
+
+	m, err = compileMirror(config.Route{Mirror: config.MirrorPolicy{Pool: "shadow", Percent: 25}})
+	require.NoError(t, err)
+	sampled := 0
+	for i := 0; i < 4000; i++ {
+		if m.sample() {
+			sampled++
+		}
+	}
+	assert.InDelta(t, 1000, sampled, 200)
+
+	for _, policy := range []config.MirrorPolicy{
+		{Pool: "shadow", Percent: 150},
+		{Pool: "shadow", Percent: -1},
+		{Pool: "shadow", Timeout: -time.Second},
+	} {
+		assert.Error(t, ValidateRoute(config.Route{Pool: "primary", Mirror: policy}))
+	}
+}
@@ -0,0 +1,218 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+)
+
+// Retry policy defaults
+const (
+	defaultBackoffBase         = 25 * time.Millisecond
+	defaultBackoffMax          = 250 * time.Millisecond
+	defaultBudgetRatio         = 0.2
+	defaultMinRetriesPerSecond = 10
+	defaultMaxRetryBody        = 64 * 1024
+	defaultHedgeDelay          = 50 * time.Millisecond
+	defaultHedgeAttempts       = 2
+
+	// retryBudgetWindow is how far back the retry budget looks
+	retryBudgetWindow = 10
+)
+
+// errRetryableStatus aborts a response that will be retried on another backend
+var errRetryableStatus = errors.New("retryable upstream status")
+
+// retryPolicy is the compiled retry configuration of a route
+type retryPolicy struct {
+	attempts     int
+	retryOn      map[string]bool
+	backoffBase  time.Duration
+	backoffMax   time.Duration
+	maxBodyBytes int64
+	budget       *retryBudget
+}
+
+func newRetryPolicy(cfg config.RetryPolicy) *retryPolicy {
+	p := &retryPolicy{
+		attempts:     cfg.Attempts,
+		retryOn:      make(map[string]bool),
+		backoffBase:  cfg.BackoffBase,
+		backoffMax:   cfg.BackoffMax,
+		maxBodyBytes: cfg.MaxBodyBytes,
+	}
+	if p.backoffBase == 0 {
+		p.backoffBase = defaultBackoffBase
+	}
+	if p.backoffMax == 0 {
+		p.backoffMax = defaultBackoffMax
+	}
+	if p.maxBodyBytes == 0 {
+		p.maxBodyBytes = defaultMaxRetryBody
+	}
+
+	conditions := cfg.RetryOn
+	if len(conditions) == 0 {
+		conditions = []string{
+			config.RetryOnConnectFailure,
+			config.RetryOnReset,
+			config.RetryOn502,
+			config.RetryOn503,
+			config.RetryOn504,
+		}
+	}
+	for _, condition := range conditions {
+		p.retryOn[condition] = true
+	}
+
+	ratio := cfg.BudgetRatio
+	if ratio == 0 {
+		ratio = defaultBudgetRatio
+	}
+	minPerSecond := cfg.MinRetriesPerSecond
+	if minPerSecond == 0 {
+		minPerSecond = defaultMinRetriesPerSecond
+	}
+	p.budget = newRetryBudget(ratio, minPerSecond)
+
+	return p
+}
+
+// retryableStatus reports whether a backend response may be retried
+func (p *retryPolicy) retryableStatus(statusCode int) bool {
+	return p.retryOn[strconv.Itoa(statusCode)]
+}
+
+// backoff returns the jittered delay before the given retry, starting at 1
+func (p *retryPolicy) backoff(retry int) time.Duration {
+	d := p.backoffBase
+	for i := 1; i < retry && d < p.backoffMax; i++ {
+		d *= 2
+	}
+	if d > p.backoffMax {
+		d = p.backoffMax
+	}
+	// Full jitter spreads retries of concurrent requests apart
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// retryReason classifies a transport error into a retry condition. An empty
+// result means the error is not retryable.
+func retryReason(err error) string {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return config.RetryOnConnectFailure
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return config.RetryOnReset
+	}
+	return ""
+}
+
+// retryBudget caps retries to a ratio of the requests seen over the last
+// retryBudgetWindow seconds, plus a minimum number of retries per second.
+type retryBudget struct {
+	mu           sync.Mutex
+	ratio        float64
+	minPerSecond int
+	buckets      [retryBudgetWindow]budgetBucket
+}
+
+type budgetBucket struct {
+	second   int64
+	requests int
+	retries  int
+}
+
+func newRetryBudget(ratio float64, minPerSecond int) *retryBudget {
+	return &retryBudget{ratio: ratio, minPerSecond: minPerSecond}
+}
+
+// bucket returns the bucket of the current second. Callers must hold b.mu.
+func (b *retryBudget) bucket(now time.Time) *budgetBucket {
+	second := now.Unix()
+	bucket := &b.buckets[second%retryBudgetWindow]
+	if bucket.second != second {
+		*bucket = budgetBucket{second: second}
+	}
+	return bucket
+}
+
+// recordRequest counts a request towards the budget
+func (b *retryBudget) recordRequest() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bucket(time.Now()).requests++
+}
+
+// allowRetry reports whether one more retry fits in the budget and consumes it
+func (b *retryBudget) allowRetry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	current := b.bucket(now)
+
+	requests, retries := 0, 0
+	for _, bucket := range b.buckets {
+		if now.Unix()-bucket.second < retryBudgetWindow {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+
+	allowed := float64(requests)*b.ratio + float64(b.minPerSecond*retryBudgetWindow)
+	if float64(retries) >= allowed {
+		return false
+	}
+	current.retries++
+	return true
+}
+
+// bufferBody reads the request body into memory so it can be sent again. When
+// the body exceeds limit it is left streaming and replayable is false.
+func bufferBody(req *http.Request, limit int64) (body []byte, replayable bool, err error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if req.ContentLength > limit {
+		return nil, false, nil
+	}
+
+	body, err = io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(body)) > limit {
+		// Put back what was read in front of the rest of the body
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return nil, false, nil
+	}
+
+	req.Body.Close()
+	return body, true, nil
+}
+
+// setBody installs a fresh reader over a buffered request body
+func setBody(req *http.Request, body []byte) {
+	if body == nil {
+		return
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+}
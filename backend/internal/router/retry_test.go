@@ -0,0 +1,215 @@
+package router
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/balancer"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// routeHandler builds the handler of a route proxying to the given backends
+func routeHandler(route config.Route, addresses ...string) http.Handler {
+	return routeRouter(route, addresses...).createRouteHandler(route)
+}
+
+// routeRouter builds a router whose pool for the route has the given backends
+func routeRouter(route config.Route, addresses ...string) *Router {
+	backends := make([]config.Backend, len(addresses))
+	for i, addr := range addresses {
+		backends[i] = config.Backend{Address: addr, Weight: 1}
+	}
+	pool := config.Pool{Name: route.Pool, Algorithm: "round_robin", Backends: backends}
+
+	bal := balancer.New()
+	bal.AddPool(pool)
+	return &Router{
+		config:   &config.Config{Pools: []config.Pool{pool}},
+		balancer: bal,
+		logger:   zap.NewNop(),
+	}
+}
+
+// assertConnectionsReleased checks that no backend of a pool still counts a
+// connection once the requests sent to it are over
+func assertConnectionsReleased(t *testing.T, router *Router, pool string) {
+	t.Helper()
+	for _, backend := range router.balancer.GetAllBackends()[pool] {
+		assert.Zero(t, backend.Connections.Load(), backend.Address)
+	}
+}
+
+func echoServer(t *testing.T, prefix string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(prefix + string(body)))
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func statusServer(t *testing.T, status int) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func serve(handler http.Handler, method, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, "/", reader)
+	req.RemoteAddr = "1.2.3.4:5678"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestProxiedRequestsReleaseConnections(t *testing.T) {
+	route := config.Route{Pool: "web"}
+	router := routeRouter(route, echoServer(t, "one"), echoServer(t, "two"))
+	handler := router.createRouteHandler(route)
+
+	for i := 0; i < 6; i++ {
+		assert.Equal(t, http.StatusOK, serve(handler, http.MethodGet, "").Code)
+	}
+	assertConnectionsReleased(t, router, "web")
+}
+
+func TestRetryOnRetryableStatusReplaysBody(t *testing.T) {
+	route := config.Route{Pool: "web", Retry: config.RetryPolicy{Attempts: 1, BackoffBase: time.Millisecond}}
+	router := routeRouter(route, statusServer(t, http.StatusServiceUnavailable), echoServer(t, "ok:"))
+	handler := router.createRouteHandler(route)
+
+	for i := 0; i < 4; i++ {
+		w := serve(handler, http.MethodPost, "payload")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "ok:payload", w.Body.String())
+	}
+	// Picks skipped as already tried count no connection
+	assertConnectionsReleased(t, router, "web")
+}
+
+func TestRetryOnConnectFailure(t *testing.T) {
+	route := config.Route{Pool: "web", Retry: config.RetryPolicy{Attempts: 2, BackoffBase: time.Millisecond}}
+	handler := routeHandler(route, "127.0.0.1:1", echoServer(t, "ok"))
+
+	for i := 0; i < 4; i++ {
+		w := serve(handler, http.MethodGet, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+}
+
+func TestNoRetryWithoutPolicy(t *testing.T) {
+	handler := routeHandler(config.Route{Pool: "web"}, statusServer(t, http.StatusServiceUnavailable))
+	assert.Equal(t, http.StatusServiceUnavailable, serve(handler, http.MethodGet, "").Code)
+
+	// Statuses outside retry_on are served as they are
+	route := config.Route{Pool: "web", Retry: config.RetryPolicy{Attempts: 3, RetryOn: []string{config.RetryOn502}}}
+	handler = routeHandler(route, statusServer(t, http.StatusServiceUnavailable))
+	assert.Equal(t, http.StatusServiceUnavailable, serve(handler, http.MethodGet, "").Code)
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(0.5, 0)
+	for i := 0; i < 4; i++ {
+		budget.recordRequest()
+	}
+	assert.True(t, budget.allowRetry())
+	assert.True(t, budget.allowRetry())
+	assert.False(t, budget.allowRetry())
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := newRetryPolicy(config.RetryPolicy{BackoffBase: 10 * time.Millisecond, BackoffMax: 40 * time.Millisecond})
+	for i := 0; i < 20; i++ {
+		assert.LessOrEqual(t, policy.backoff(1), 10*time.Millisecond)
+		assert.LessOrEqual(t, policy.backoff(10), 40*time.Millisecond)
+	}
+}
+
+func TestBufferBodyOverLimit(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(bytes.NewReader([]byte("0123456789"))))
+	req.ContentLength = -1
+
+	body, replayable, err := bufferBody(req, 4)
+	require.NoError(t, err)
+	assert.False(t, replayable)
+	assert.Nil(t, body)
+
+	rest, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(rest), "the body must still be sent in full")
+}
+
+func TestHedgedRequest(t *testing.T) {
+	var slowCalls atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowCalls.Add(1)
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+		w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+
+	route := config.Route{Pool: "web", Hedge: config.HedgePolicy{Enabled: true, Delay: 20 * time.Millisecond}}
+	router := routeRouter(route, strings.TrimPrefix(slow.URL, "http://"), echoServer(t, "fast"))
+	handler := router.createRouteHandler(route)
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		w := serve(handler, http.MethodGet, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "fast", w.Body.String())
+		assert.Less(t, time.Since(start), time.Second)
+	}
+	assert.Equal(t, int32(1), slowCalls.Load(), "only one request should have reached the slow backend first")
+
+	// The losing attempt releases its connection once it is cancelled
+	assert.Eventually(t, func() bool {
+		for _, backend := range router.balancer.GetAllBackends()["web"] {
+			if backend.Connections.Load() != 0 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestHedgingStopsOnceAResponseWins(t *testing.T) {
+	var calls atomic.Int32
+	streaming := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		// The headers arrive at once while the body takes several hedge delays
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("streamed"))
+	})
+	first := httptest.NewServer(streaming)
+	defer first.Close()
+	second := httptest.NewServer(streaming)
+	defer second.Close()
+
+	route := config.Route{Pool: "web", Hedge: config.HedgePolicy{Enabled: true, Delay: 20 * time.Millisecond}}
+	handler := routeHandler(route, strings.TrimPrefix(first.URL, "http://"), strings.TrimPrefix(second.URL, "http://"))
+
+	w := serve(handler, http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "streamed", w.Body.String())
+	assert.Equal(t, int32(1), calls.Load(), "no copy should be sent once a response is streaming")
+}
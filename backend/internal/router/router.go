@@ -1,18 +1,24 @@
 package router
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strings"
+	"reflect"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/eltonciatto/veloflux/internal/balancer"
+	"github.com/eltonciatto/veloflux/internal/clientip"
 	"github.com/eltonciatto/veloflux/internal/config"
 	"github.com/eltonciatto/veloflux/internal/drain"
 	"github.com/eltonciatto/veloflux/internal/ratelimit"
+	"github.com/eltonciatto/veloflux/internal/upstream"
 	"github.com/eltonciatto/veloflux/internal/waf"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
@@ -20,6 +26,18 @@ import (
 	"github.com/eltonciatto/veloflux/internal/metrics"
 )
 
+// PassiveHealthChecker receives the outcome of every proxied request so
+// misbehaving backends can be ejected from their pool.
+type PassiveHealthChecker interface {
+	PassiveCheck(poolName, backendAddress string, statusCode int, responseTime time.Duration)
+}
+
+// DomainResolver returns the tenant that registered the custom domain a
+// request was sent to
+type DomainResolver interface {
+	TenantForHost(host string) (string, bool)
+}
+
 type Router struct {
 	config           *config.Config
 	balancer         *balancer.Balancer
@@ -27,10 +45,22 @@ type Router struct {
 	rateLimiter      *ratelimit.Limiter
 	waf              *waf.WAF
 	drain            *drain.Manager
+	passiveHealth    PassiveHealthChecker
+	domains          DomainResolver
+	clientIP         *clientip.Resolver // protected by mu, trusts no proxy when nil
 	redis            *redis.Client
 	nodeID           string
 	logger           *zap.Logger
 	router           *mux.Router
+	mu               sync.RWMutex // protects config and router during reloads
+	transportsMu     sync.RWMutex
+	transports       map[string]*poolTransport // upstream transports keyed by pool
+	stickySecretOnce sync.Once
+	stickySecret     []byte // signs sticky cookies of pools without a secret
+	geo              GeoLocator
+	splitsMu         sync.RWMutex
+	splits           map[string]*trafficSplit // traffic splits keyed by route ID
+	upgrades         upgradeTracker
 }
 
 func New(cfg *config.Config, bal *balancer.Balancer, nodeID string, logger *zap.Logger) *Router {
@@ -39,6 +69,11 @@ func New(cfg *config.Config, bal *balancer.Balancer, nodeID string, logger *zap.
 		logger.Error("failed to load WAF rules", zap.Error(err))
 	}
 
+	resolver, err := clientip.New(cfg.Global.ClientIP.TrustedProxies)
+	if err != nil {
+		logger.Error("invalid trusted proxies, trusting none", zap.Error(err))
+	}
+
 	var rc *redis.Client
 	if cfg.Cluster.RedisAddress != "" {
 		rc = redis.NewClient(&redis.Options{
@@ -78,6 +113,7 @@ func New(cfg *config.Config, bal *balancer.Balancer, nodeID string, logger *zap.
 		adaptiveBalancer: adaptiveBal,
 		rateLimiter:      ratelimit.New(cfg.Global.RateLimit),
 		waf:              wf,
+		clientIP:         resolver,
 		redis:            rc,
 		nodeID:           nodeID,
 		logger:           logger,
@@ -92,21 +128,136 @@ func New(cfg *config.Config, bal *balancer.Balancer, nodeID string, logger *zap.
 	return r
 }
 
+// SetPassiveHealthChecker sets the checker notified of proxied responses
+func (r *Router) SetPassiveHealthChecker(checker PassiveHealthChecker) {
+	r.passiveHealth = checker
+}
+
+// SetDomainResolver sets the registry of tenants' custom domains. Requests
+// for a custom domain only match the routes of the tenant that registered it.
+func (r *Router) SetDomainResolver(resolver DomainResolver) {
+	r.domains = resolver
+}
+
 func (r *Router) setupRoutes() {
-	// Setup routes based on configuration
-	for _, route := range r.config.Routes {
-		handler := r.createProxyHandler(route.Pool)
+	r.router = r.buildRoutes(r.config.Routes)
+}
 
-		routeBuilder := r.router.Host(route.Host)
+func (r *Router) buildRoutes(routes []config.Route) *mux.Router {
+	m := mux.NewRouter()
+
+	// Setup routes based on configuration, in priority order
+	for _, route := range orderRoutes(routes) {
+		matcher, err := compileRoute(route)
+		if err != nil {
+			r.logger.Error("Skipping route with invalid match conditions",
+				zap.Error(err),
+				zap.String("route", route.ID))
+			continue
+		}
+		handler := r.middleware(r.createRouteHandler(route))
+
+		routeBuilder := m.NewRoute().Name(route.ID)
+		if route.Host != "" {
+			routeBuilder = routeBuilder.Host(route.Host)
+		}
 		if route.PathPrefix != "" {
 			routeBuilder = routeBuilder.PathPrefix(route.PathPrefix)
 		}
+		routeBuilder = routeBuilder.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+			// Routes for any host leave custom domains to their tenant's routes
+			if route.Host == "" && !r.servesDomain(req, route.Tenant, false) {
+				return false
+			}
+			return matcher.match(req, r.getClientIP)
+		})
+		routeBuilder.Handler(handler)
 
-		routeBuilder.Handler(r.middleware(handler))
+		// The custom domains of a tenant reach its routes for other hosts too
+		if route.Tenant != "" && route.Host != "" {
+			domainRoute := m.NewRoute().Name(route.ID)
+			if route.PathPrefix != "" {
+				domainRoute = domainRoute.PathPrefix(route.PathPrefix)
+			}
+			domainRoute.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+				return r.servesDomain(req, route.Tenant, true) && matcher.match(req, r.getClientIP)
+			}).Handler(handler)
+		}
 	}
 
 	// Default handler for unmatched routes
-	r.router.NotFoundHandler = http.HandlerFunc(r.notFoundHandler)
+	m.NotFoundHandler = http.HandlerFunc(r.notFoundHandler)
+	return m
+}
+
+// Reload swaps in a new configuration. The route table is only rebuilt when
+// the configured routes changed; requests already being served keep using the
+// handler they were dispatched to.
+func (r *Router) Reload(cfg *config.Config) {
+	apply, err := r.Prepare(cfg)
+	if err != nil {
+		r.logger.Error("Invalid configuration, keeping the previous one", zap.Error(err))
+		return
+	}
+	apply()
+}
+
+// Prepare builds what Reload serves for a configuration without serving it
+// yet, so callers can check every part of a configuration before applying
+// any. The returned function swaps it in.
+func (r *Router) Prepare(cfg *config.Config) (func(), error) {
+	resolver, err := clientip.New(cfg.Global.ClientIP.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	routesChanged := !reflect.DeepEqual(r.config.Routes, cfg.Routes)
+	r.mu.RUnlock()
+
+	var m *mux.Router
+	if routesChanged {
+		m = r.buildRoutes(cfg.Routes)
+	}
+
+	return func() {
+		r.mu.Lock()
+		r.config = cfg
+		if m != nil {
+			r.router = m
+		}
+		r.clientIP = resolver
+		r.mu.Unlock()
+
+		r.pruneTransports(cfg.Pools)
+		if routesChanged {
+			r.pruneSplits(cfg.Routes)
+		}
+
+		r.logger.Info("Router configuration reloaded",
+			zap.Int("routes", len(cfg.Routes)),
+			zap.Bool("routes_changed", routesChanged))
+	}, nil
+}
+
+// servesDomain reports whether a route of a tenant may serve a request. For
+// requests to a custom domain that is when the tenant registered it; other
+// requests are served unless onlyDomains is set.
+func (r *Router) servesDomain(req *http.Request, tenantID string, onlyDomains bool) bool {
+	if r.domains == nil {
+		return !onlyDomains
+	}
+	owner, ok := r.domains.TenantForHost(req.Host)
+	if !ok {
+		return !onlyDomains
+	}
+	return owner == tenantID
+}
+
+func (r *Router) currentConfig() *config.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.config
 }
 
 func (r *Router) middleware(next http.Handler) http.Handler {
@@ -143,7 +294,10 @@ func (r *Router) middleware(next http.Handler) http.Handler {
 
 		// Log request
 		duration := time.Since(start)
+		route := matchedRoute(req)
+		metrics.RouteRequestsTotal.WithLabelValues(route, strconv.Itoa(wrapped.statusCode)).Inc()
 		r.logger.Info("Request processed",
+			zap.String("route", route),
 			zap.String("method", req.Method),
 			zap.String("url", req.URL.String()),
 			zap.String("client_ip", clientIP.String()),
@@ -154,152 +308,452 @@ func (r *Router) middleware(next http.Handler) http.Handler {
 }
 
 func (r *Router) createProxyHandler(poolName string) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-        start := time.Now()
-        clientIP := r.getClientIP(req)
-        sessionID := r.getSessionID(req)
+	return r.createRouteHandler(config.Route{Pool: poolName})
+}
 
-		var backend *balancer.Backend
-		var err error
-		var algorithm string = "traditional"
+// proxyRequest holds the state shared by every attempt of a proxied request
+type proxyRequest struct {
+	pool          string
+	clientIP      net.IP
+	sessionID     string
+	sticky        *stickyPolicy
+	pinToken      string    // backend token of a valid sticky cookie
+	pinExpires    time.Time // expiry of that cookie
+	start         time.Time
+	upstream      *poolTransport
+	retry         *retryPolicy
+	body          []byte // buffered request body, nil when there is none or it cannot be replayed
+	transform     *routeTransform
+	vars          *requestVars
+	upgrade       *config.UpgradeConfig // limits of the pool, set for upgrade requests
+	flushInterval time.Duration
+
+	mu      sync.Mutex
+	tried   map[string]bool // backends already used by an attempt
+	pinUsed bool            // the sticky cookie was already honoured
+}
 
-		// Use adaptive balancer if AI is enabled and available
-		if r.adaptiveBalancer != nil && r.config.Global.AI.Enabled {
-			backend, err = r.adaptiveBalancer.SelectBackend(req)
-			algorithm = r.adaptiveBalancer.GetCurrentStrategy()
-			
-			r.logger.Debug("Using AI-powered load balancing",
-				zap.String("algorithm", algorithm),
-				zap.String("pool", poolName))
-		} else {
-			// Fallback to traditional balancer
-			backend, err = r.balancer.GetBackend(poolName, clientIP, sessionID, req)
-			algorithm = r.balancer.GetAlgorithm(poolName)
+// attemptResult reports how a single attempt ended
+type attemptResult struct {
+	done   bool   // a response or an error was written to the client
+	reason string // retry condition of an attempt that failed without writing
+}
+
+// createRouteHandler builds the proxy handler of a route, applying its
+// client certificate verification, traffic split, transformation, mirroring,
+// streaming and retry and hedging policies.
+func (r *Router) createRouteHandler(route config.Route) http.Handler {
+	retry := newRetryPolicy(route.Retry)
+	hedge := route.Hedge
+	transform, routeErr := compileTransform(route.Transform)
+	split, err := compileSplit(route)
+	if err != nil {
+		routeErr = err
+	} else {
+		split = r.registerSplit(route.ID, split)
+	}
+	mirror, err := compileMirror(route)
+	if err != nil {
+		routeErr = err
+	}
+	streaming, err := compileStreaming(route.Streaming)
+	if err != nil {
+		routeErr = err
+	}
+	rpc, err := compileGRPC(route)
+	if err != nil {
+		routeErr = err
+	}
+	clientCerts, err := compileClientAuth(route)
+	if err != nil {
+		routeErr = err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if routeErr != nil {
+			r.logger.Error("Invalid route configuration",
+				zap.Error(routeErr),
+				zap.String("route", route.ID))
+			http.Error(w, "Bad gateway", http.StatusBadGateway)
+			return
 		}
 
+		// Client certificate identities only reach backends verified
+		for _, name := range clientCertHeaders {
+			req.Header.Del(name)
+		}
+		if clientCerts != nil {
+			cert, result, err := clientCerts.verify(req)
+			metrics.ClientCertificatesTotal.WithLabelValues(route.ID, result).Inc()
+			if err != nil {
+				r.logger.Debug("Client certificate rejected",
+					zap.Error(err),
+					zap.String("route", route.ID))
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if cert != nil {
+				setClientCertHeaders(req.Header, cert)
+			}
+		}
+
+		poolName := split.choose(w, req)
+		upstreamTransport, err := r.transportFor(poolName)
 		if err != nil {
-			r.logger.Error("Failed to get backend", 
+			r.logger.Error("Invalid upstream configuration",
 				zap.Error(err),
-				zap.String("algorithm", algorithm))
-			http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+				zap.String("pool", poolName))
+			http.Error(w, "Bad gateway", http.StatusBadGateway)
+			return
+		}
+		if rpc != nil && !upstream.UsesHTTP2(upstreamTransport.upstream) {
+			r.logger.Error("gRPC route to a pool without HTTP/2",
+				zap.String("route", route.ID),
+				zap.String("pool", poolName))
+			http.Error(w, "Bad gateway", http.StatusBadGateway)
 			return
 		}
 
-		// Increment connection count
-        r.balancer.IncrementConnections(poolName, backend.Address)
-        defer r.balancer.DecrementConnections(poolName, backend.Address)
-        
-        // Update active connections metrics
-        metrics.UpdateActiveConnections(backend.Address, true)
-        defer metrics.UpdateActiveConnections(backend.Address, false)
-        
-        // Update backend health metric
-        metrics.UpdateBackendHealth(poolName, backend.Address, true)
+		// Upgraded connections hold on to their backend until either side closes
+		upgrade := isUpgrade(req)
+		var upgradeCfg config.UpgradeConfig
+		if upgrade {
+			pool, _ := r.poolConfig(poolName)
+			upgradeCfg = pool.Upgrades
+			if reason := r.upgrades.acquire(poolName, upgradeCfg.MaxConnections); reason != "" {
+				metrics.UpgradeRejectedTotal.WithLabelValues(poolName, reason).Inc()
+				http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			defer r.upgrades.release(poolName)
+		}
 
+		sticky := r.stickyPolicy(poolName)
+		p := &proxyRequest{
+			pool:      poolName,
+			clientIP:  r.getClientIP(req),
+			sessionID: r.getSessionID(req, sticky),
+			sticky:    sticky,
+			start:     time.Now(),
+			upstream:  upstreamTransport,
+			retry:     retry,
+			tried:     make(map[string]bool),
+		}
+		if upgrade {
+			p.upgrade = &upgradeCfg
+		} else {
+			w = streaming.prepare(w)
+			p.flushInterval = streaming.flushInterval
+		}
+		p.pinToken, p.pinExpires, _ = sticky.pinned(req, p.start)
+
+		// Streaming calls send their messages as the body, which must not be
+		// held back to replay it
+		replayable := false
+		if retry.attempts > 0 && rpc == nil {
+			p.body, replayable, err = bufferBody(req, retry.maxBodyBytes)
+			if err != nil {
+				r.logger.Warn("Failed to read request body", zap.Error(err))
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+		}
+		// Mirrored requests need their body twice
+		mirrored := !upgrade && rpc == nil && mirror.sample()
+		if mirrored && !replayable {
+			p.body, replayable, err = bufferBody(req, mirror.maxBodyBytes)
+			if err != nil {
+				r.logger.Warn("Failed to read request body", zap.Error(err))
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+		}
+		if mirrored && (!replayable || int64(len(p.body)) > mirror.maxBodyBytes) {
+			mirror.drop(mirrorDropBodyTooLarge)
+			mirrored = false
+		}
+		retry.budget.recordRequest()
 
-		// Create reverse proxy
-		target, err := url.Parse(fmt.Sprintf("http://%s", backend.Address))
-		if err != nil {
-			r.logger.Error("Invalid backend URL", zap.Error(err))
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
+		// Set headers
+		req.Header.Set("X-Forwarded-For", p.clientIP.String())
+		req.Header.Set("X-Real-IP", p.clientIP.String())
+		req.Header.Set("X-Forwarded-Proto", r.getScheme(req))
+
+		// Rewrite the request after the forwarding headers, so rules can override them
+		if transform != nil {
+			p.transform = transform
+			p.vars = r.requestVars(req, route, p.clientIP)
+			transform.applyRequest(req, p.vars)
 		}
 
-		proxy := httputil.NewSingleHostReverseProxy(target)
+		// The copy is sent as the upstream would receive the request
+		if mirrored {
+			r.mirror(req, p.body, p.clientIP, mirror)
+		}
 
-		// Customize proxy behavior
-		proxy.ModifyResponse = func(resp *http.Response) error {
-			// Record metrics for AI learning
-			if r.adaptiveBalancer != nil {
-				duration := time.Since(start)
-				errorRate := 0.0
-				if resp.StatusCode >= 400 {
-					errorRate = 1.0
-				}
-				
-				features := map[string]interface{}{
-					"method":        req.Method,
-					"path":          req.URL.Path,
-					"content_type":  req.Header.Get("Content-Type"),
-					"user_agent":    req.Header.Get("User-Agent"),
-					"status_code":   resp.StatusCode,
-					"pool":          poolName,
-					"backend":       backend.Address,
-					"algorithm":     algorithm,
-				}
-				
-				r.adaptiveBalancer.RecordRequestMetrics(
-					1.0, // request rate
-					float64(duration.Milliseconds()), // response time
-					errorRate,
-					features,
-				)
+		serve := func(w http.ResponseWriter, req *http.Request) {
+			if hedge.Enabled && hedgeable(req) {
+				r.serveHedged(w, req, p, hedge)
+				return
+			}
+			if replayable {
+				r.serveWithRetries(w, req, p)
+				return
 			}
+			r.proxyAttempt(w, req, p, nil, nil)
+		}
+
+		// Wrapper para capturar métricas
+		metricsHandler := metrics.MetricsMiddleware(http.HandlerFunc(serve), poolName)
+		if split.splitting() || rpc != nil {
+			// Per variant metrics let a canary be judged against the other
+			// pools; gRPC calls are judged by the status in their trailers
+			wrapper := metrics.NewResponseWriterWrapper(w)
+			metricsHandler.ServeHTTP(wrapper, req)
+			if split.splitting() {
+				split.observe(poolName, wrapper.StatusCode(), time.Since(p.start))
+			}
+			if rpc != nil {
+				rpc.observe(req, wrapper.Header(), wrapper.StatusCode(), time.Since(p.start))
+			}
+			return
+		}
+		metricsHandler.ServeHTTP(w, req)
+	})
+}
 
-			// Set sticky session cookie if enabled
-			if r.isStickySessions(poolName) {
-				cookie := &http.Cookie{
-					Name:     "veloflux",
-					Value:    backend.Address,
-					Path:     "/",
-					MaxAge:   3600, // 1 hour
-					HttpOnly: true,
-					Secure:   req.TLS != nil,
+// serveWithRetries sends the request to successive backends until one
+// answers with a response the retry policy accepts or the retries run out.
+func (r *Router) serveWithRetries(w http.ResponseWriter, req *http.Request, p *proxyRequest) {
+	for attempt := 0; ; attempt++ {
+		var canRetry func(reason string) bool
+		if attempt < p.retry.attempts {
+			canRetry = func(reason string) bool {
+				if reason == "" || !p.retry.retryOn[reason] {
+					return false
+				}
+				if !p.retry.budget.allowRetry() {
+					metrics.RetryBudgetExhaustedTotal.WithLabelValues(p.pool).Inc()
+					return false
 				}
-				resp.Header.Add("Set-Cookie", cookie.String())
+				return true
 			}
+		}
 
-			return nil
+		setBody(req, p.body)
+		result := r.proxyAttempt(w, req, p, canRetry, nil)
+		if result.done {
+			return
 		}
 
-		proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
-			r.logger.Error("Proxy error", zap.Error(err))
+		metrics.RetriesTotal.WithLabelValues(p.pool, result.reason).Inc()
+		r.logger.Debug("Retrying request on another backend",
+			zap.String("pool", p.pool),
+			zap.String("reason", result.reason),
+			zap.Int("retry", attempt+1))
+
+		timer := time.NewTimer(p.retry.backoff(attempt + 1))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
 			http.Error(w, "Bad gateway", http.StatusBadGateway)
+			return
+		case <-timer.C:
 		}
+	}
+}
 
-		// Set headers
-		req.Header.Set("X-Forwarded-For", clientIP.String())
-		req.Header.Set("X-Real-IP", clientIP.String())
-		req.Header.Set("X-Forwarded-Proto", r.getScheme(req))
+// selectBackend picks the backend for an attempt, preferring backends that
+// were not tried yet by the same request. Picks are made without touching
+// connection counts; only the backend returned counts one more connection,
+// which the caller releases with DecrementConnections.
+func (r *Router) selectBackend(req *http.Request, p *proxyRequest) (*balancer.Backend, string, error) {
+	var backend *balancer.Backend
+	var algorithm string
+	var err error
+
+	if backend = r.pinnedBackend(p); backend != nil {
+		r.balancer.IncrementConnections(p.pool, backend.Address)
+		return backend, r.balancer.GetAlgorithm(p.pool), nil
+	}
 
-        // Wrapper para capturar métricas
-        metricsHandler := metrics.MetricsMiddleware(proxy, poolName)
-        metricsHandler.ServeHTTP(w, req)
+	for i := 0; i < maxBackendPicks; i++ {
+		// Use adaptive balancer if AI is enabled and available
+		if r.adaptiveBalancer != nil && r.currentConfig().Global.AI.Enabled {
+			backend, err = r.adaptiveBalancer.SelectBackend(req)
+			algorithm = r.adaptiveBalancer.GetCurrentStrategy()
 
-	})
-}
+			r.logger.Debug("Using AI-powered load balancing",
+				zap.String("algorithm", algorithm),
+				zap.String("pool", p.pool))
+		} else {
+			// Fallback to traditional balancer
+			backend, err = r.balancer.GetBackend(p.pool, p.clientIP, p.sessionID, req)
+			algorithm = r.balancer.GetAlgorithm(p.pool)
+		}
+		if err != nil {
+			return nil, algorithm, err
+		}
 
-func (r *Router) getClientIP(req *http.Request) net.IP {
-	// Check X-Forwarded-For header
-	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		if len(parts) > 0 {
-			if ip := net.ParseIP(strings.TrimSpace(parts[0])); ip != nil {
-				return ip
-			}
+		p.mu.Lock()
+		tried := p.tried[backend.Address]
+		if !tried || i == maxBackendPicks-1 {
+			p.tried[backend.Address] = true
+			p.mu.Unlock()
+			break
 		}
+		p.mu.Unlock()
 	}
 
-	// Check X-Real-IP header
-	if xri := req.Header.Get("X-Real-IP"); xri != "" {
-		if ip := net.ParseIP(xri); ip != nil {
-			return ip
+	r.balancer.IncrementConnections(p.pool, backend.Address)
+	return backend, algorithm, nil
+}
+
+// maxBackendPicks bounds how often the balancer is asked for an untried backend
+const maxBackendPicks = 3
+
+// proxyAttempt sends one attempt of the request to a backend chosen by the
+// balancer. When canRetry accepts the failure of the attempt, nothing is
+// written to the client and the failure is reported in the result instead.
+// Hedged attempts must claim the race before their response is delivered.
+func (r *Router) proxyAttempt(w http.ResponseWriter, req *http.Request, p *proxyRequest, canRetry func(reason string) bool, hedge *hedgeAttempt) attemptResult {
+	poolName := p.pool
+	start := time.Now()
+
+	backend, algorithm, err := r.selectBackend(req, p)
+	if err != nil {
+		r.logger.Error("Failed to get backend",
+			zap.Error(err),
+			zap.String("algorithm", algorithm))
+		if hedge != nil {
+			return attemptResult{}
 		}
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return attemptResult{done: true}
 	}
 
-	// Fall back to remote address
-	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	// selectBackend counted the connection
+	defer r.balancer.DecrementConnections(poolName, backend.Address)
+
+	// Update active connections metrics
+	metrics.UpdateActiveConnections(backend.Address, true)
+	defer metrics.UpdateActiveConnections(backend.Address, false)
+
+	// Update backend health metric
+	metrics.UpdateBackendHealth(poolName, backend.Address, true)
+
+	// Create reverse proxy
+	target, err := url.Parse(fmt.Sprintf("%s://%s", p.upstream.scheme, backend.Address))
 	if err != nil {
-		return net.ParseIP(req.RemoteAddr)
+		r.logger.Error("Invalid backend URL", zap.Error(err))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return attemptResult{done: true}
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = p.upstream.transport
+	proxy.FlushInterval = p.flushInterval
+
+	if p.upgrade != nil {
+		w = &upgradeWriter{ResponseWriter: w, tracker: &r.upgrades, pool: poolName, cfg: *p.upgrade}
+	}
+
+	result := attemptResult{done: true}
+
+	// Customize proxy behavior
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if r.passiveHealth != nil {
+			r.passiveHealth.PassiveCheck(poolName, backend.Address, resp.StatusCode, time.Since(start))
+		}
+
+		if canRetry != nil && p.retry.retryableStatus(resp.StatusCode) {
+			if reason := strconv.Itoa(resp.StatusCode); canRetry(reason) {
+				result = attemptResult{reason: reason}
+				return errRetryableStatus
+			}
+		}
+		if hedge != nil && !hedge.claim() {
+			result = attemptResult{}
+			return errHedgeLost
+		}
+
+		// Record metrics for AI learning
+		if r.adaptiveBalancer != nil {
+			duration := time.Since(p.start)
+			errorRate := 0.0
+			if resp.StatusCode >= 400 {
+				errorRate = 1.0
+			}
+
+			features := map[string]interface{}{
+				"method":       req.Method,
+				"path":         req.URL.Path,
+				"content_type": req.Header.Get("Content-Type"),
+				"user_agent":   req.Header.Get("User-Agent"),
+				"status_code":  resp.StatusCode,
+				"pool":         poolName,
+				"backend":      backend.Address,
+				"algorithm":    algorithm,
+			}
+
+			r.adaptiveBalancer.RecordRequestMetrics(
+				1.0, // request rate
+				float64(duration.Milliseconds()), // response time
+				errorRate,
+				features,
+			)
+		}
+
+		if p.transform != nil {
+			p.transform.applyResponse(resp, p.vars)
+		}
+
+		// Pin the client to the backend that served it
+		if cookie := p.sticky.cookie(p, backend.Address, req.TLS != nil, time.Now()); cookie != nil {
+			resp.Header.Add("Set-Cookie", cookie.String())
+		}
+
+		return nil
 	}
-	return net.ParseIP(host)
+
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		if errors.Is(err, errRetryableStatus) || errors.Is(err, errHedgeLost) {
+			return
+		}
+
+		r.logger.Error("Proxy error", zap.Error(err))
+		if r.passiveHealth != nil && req.Context().Err() == nil {
+			// No response from the backend counts as a gateway error
+			r.passiveHealth.PassiveCheck(poolName, backend.Address, 0, time.Since(start))
+		}
+
+		if canRetry != nil && req.Context().Err() == nil {
+			if reason := retryReason(err); canRetry(reason) {
+				result = attemptResult{reason: reason}
+				return
+			}
+		}
+		if hedge != nil {
+			result = attemptResult{}
+			return
+		}
+		http.Error(w, "Bad gateway", http.StatusBadGateway)
+	}
+
+	proxy.ServeHTTP(w, req)
+	return result
 }
 
-func (r *Router) getSessionID(req *http.Request) string {
-	if cookie, err := req.Cookie("veloflux"); err == nil {
-		return cookie.Value
+// getClientIP returns the client address ServeHTTP resolved for a request,
+// resolving it for requests that did not go through it
+func (r *Router) getClientIP(req *http.Request) net.IP {
+	if ip, ok := clientip.FromContext(req.Context()); ok {
+		return ip
 	}
-	return ""
+	r.mu.RLock()
+	resolver := r.clientIP
+	r.mu.RUnlock()
+	return resolver.Resolve(req)
 }
 
 func (r *Router) getScheme(req *http.Request) string {
@@ -312,25 +766,25 @@ func (r *Router) getScheme(req *http.Request) string {
 	return "http"
 }
 
-func (r *Router) isStickySessions(poolName string) bool {
-	for _, pool := range r.config.Pools {
-		if pool.Name == poolName {
-			return pool.StickySessions
-		}
-	}
-	return false
-}
-
 func (r *Router) generateRequestID() string {
 	return fmt.Sprintf("%d", time.Now().UnixNano())
 }
 
 func (r *Router) notFoundHandler(w http.ResponseWriter, req *http.Request) {
+	metrics.RouteRequestsTotal.WithLabelValues(unmatchedRoute, strconv.Itoa(http.StatusNotFound)).Inc()
 	http.Error(w, "Not found", http.StatusNotFound)
 }
 
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	r.router.ServeHTTP(w, req)
+	r.mu.RLock()
+	m := r.router
+	resolver := r.clientIP
+	r.mu.RUnlock()
+
+	// Route matching, rate limits, the WAF, balancing and logs all see the
+	// address resolved here
+	req = req.WithContext(clientip.NewContext(req.Context(), resolver.Resolve(req)))
+	m.ServeHTTP(w, req)
 }
 
 type responseWriter struct {
@@ -342,3 +796,28 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+func (rw *responseWriter) Flush() {
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// CloseNotify supports handlers still relying on http.CloseNotifier; the
+// request context is cancelled at the same time
+func (rw *responseWriter) CloseNotify() <-chan bool {
+	return closeNotify(rw.ResponseWriter)
+}
+
+// Hijack hands the client connection over to an upgrade, which answers with
+// 101 Switching Protocols on the connection itself
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
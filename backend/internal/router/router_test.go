@@ -6,7 +6,9 @@ import (
     "net/http/httptest"
     "testing"
     "github.com/stretchr/testify/assert"
+    "github.com/stretchr/testify/require"
     "go.uber.org/zap"
+    "github.com/eltonciatto/veloflux/internal/clientip"
     "github.com/eltonciatto/veloflux/internal/config"
     "github.com/eltonciatto/veloflux/internal/balancer"
     "github.com/eltonciatto/veloflux/internal/ratelimit"
@@ -61,7 +63,9 @@ func TestGetScheme(t *testing.T) {
 
 func TestGetClientIP(t *testing.T) {
     logger, _ := zap.NewDevelopment()
-    router := &Router{logger: logger}
+    resolver, err := clientip.New([]string{"127.0.0.1", "10.0.0.0/8"})
+    require.NoError(t, err)
+    router := &Router{logger: logger, clientIP: resolver}
     cases := []struct {
         name     string
         headers  map[string]string
@@ -69,7 +73,10 @@ func TestGetClientIP(t *testing.T) {
         expected string
     }{
         {"X-Forwarded-For", map[string]string{"X-Forwarded-For": "192.168.2.1, 10.0.0.1"}, "127.0.0.1:12345", "192.168.2.1"},
+        {"X-Forwarded-For spoofed by the client", map[string]string{"X-Forwarded-For": "1.1.1.1, 192.168.2.1, 10.0.0.1"}, "127.0.0.1:12345", "192.168.2.1"},
+        {"Forwarded", map[string]string{"Forwarded": `for="[2001:db8::1]:4711", for=10.0.0.2`}, "127.0.0.1:12345", "2001:db8::1"},
         {"X-Real-IP", map[string]string{"X-Real-IP": "192.168.3.1"}, "127.0.0.1:12345", "192.168.3.1"},
+        {"Untrusted peer", map[string]string{"X-Forwarded-For": "192.168.2.1", "X-Real-IP": "192.168.3.1"}, "192.168.4.1:12345", "192.168.4.1"},
         {"RemoteAddr", map[string]string{}, "192.168.4.1:12345", "192.168.4.1"},
     }
     for _, tc := range cases {
@@ -85,14 +92,49 @@ func TestGetClientIP(t *testing.T) {
     }
 }
 
+func TestRateLimitResolvedClientIP(t *testing.T) {
+    cfg := &config.Config{
+        Global: config.GlobalConfig{
+            RateLimit: config.RateLimitConfig{RequestsPerSecond: 1, BurstSize: 1},
+            ClientIP:  config.ClientIPConfig{TrustedProxies: []string{"10.0.0.0/8"}},
+        },
+        Pools:  []config.Pool{{Name: "testpool", Backends: []config.Backend{{Address: echoServer(t, "ok"), Weight: 1}}}},
+        Routes: []config.Route{{Pool: "testpool", PathPrefix: "/"}},
+    }
+    bal := balancer.New()
+    bal.AddPool(cfg.Pools[0])
+    router := New(cfg, bal, "node1", zap.NewNop())
+
+    send := func(remoteAddr, xff string) int {
+        req := httptest.NewRequest("GET", "http://example.com/", nil)
+        req.RemoteAddr = remoteAddr
+        req.Header.Set("X-Forwarded-For", xff)
+        w := httptest.NewRecorder()
+        router.ServeHTTP(w, req)
+        return w.Code
+    }
+
+    // A client cannot escape its limit by naming other addresses
+    assert.Equal(t, http.StatusOK, send("198.51.100.9:1000", "203.0.113.1"))
+    assert.Equal(t, http.StatusTooManyRequests, send("198.51.100.9:1000", "203.0.113.2"))
+
+    // Clients behind a trusted proxy are limited on their own
+    assert.Equal(t, http.StatusOK, send("10.0.0.1:1000", "203.0.113.1"))
+    assert.Equal(t, http.StatusOK, send("10.0.0.1:1000", "203.0.113.2"))
+    assert.Equal(t, http.StatusTooManyRequests, send("10.0.0.1:1000", "203.0.113.2"))
+}
+
 func TestGetSessionID(t *testing.T) {
     logger, _ := zap.NewDevelopment()
     router := &Router{logger: logger}
     req := httptest.NewRequest("GET", "http://example.com", nil)
-    req.AddCookie(&http.Cookie{Name: "veloflux", Value: "test-session"})
-    assert.Equal(t, "test-session", router.getSessionID(req))
-    req2 := httptest.NewRequest("GET", "http://example.com", nil)
-    assert.Equal(t, "", router.getSessionID(req2))
+    req.AddCookie(&http.Cookie{Name: "session_id", Value: "test-session"})
+    req.Header.Set("X-Session-ID", "header-session")
+    assert.Equal(t, "test-session", router.getSessionID(req, &stickyPolicy{source: config.AffinityAppCookie, name: "session_id"}))
+    assert.Equal(t, "header-session", router.getSessionID(req, &stickyPolicy{source: config.AffinityHeader, name: "X-Session-ID"}))
+    // Issued cookies are not affinity keys
+    assert.Equal(t, "", router.getSessionID(req, &stickyPolicy{source: config.AffinityCookie, name: "session_id"}))
+    assert.Equal(t, "", router.getSessionID(req, nil))
 }
 
 func TestNotFoundHandler(t *testing.T) {
@@ -106,7 +148,7 @@ func TestNotFoundHandler(t *testing.T) {
 
 
 
-func TestIsStickySessions(t *testing.T) {
+func TestStickyPolicy(t *testing.T) {
     logger, _ := zap.NewDevelopment()
     cfg := &config.Config{
         Pools: []config.Pool{
@@ -115,9 +157,9 @@ func TestIsStickySessions(t *testing.T) {
         },
     }
     router := &Router{logger: logger, config: cfg}
-    assert.True(t, router.isStickySessions("pool1"))
-    assert.False(t, router.isStickySessions("pool2"))
-    assert.False(t, router.isStickySessions("doesnotexist"))
+    assert.NotNil(t, router.stickyPolicy("pool1"))
+    assert.Nil(t, router.stickyPolicy("pool2"))
+    assert.Nil(t, router.stickyPolicy("doesnotexist"))
 }
 
 func TestNewRouter_AllBranches(t *testing.T) {
@@ -343,3 +385,32 @@ func TestCreateProxyHandler_AllBranches(t *testing.T) {
 }
 
 
+
+func TestReload(t *testing.T) {
+	logger := zap.NewNop()
+	cfg := &config.Config{
+		Global: config.GlobalConfig{
+			RateLimit: config.RateLimitConfig{RequestsPerSecond: 100},
+		},
+		Pools:  []config.Pool{{Name: "testpool", Backends: []config.Backend{{Address: "localhost:8080"}}}},
+		Routes: []config.Route{{Host: "old.example.com", Pool: "testpool"}},
+	}
+	bal := balancer.New()
+	bal.AddPool(cfg.Pools[0])
+	router := New(cfg, bal, "node1", logger)
+
+	oldMux := router.router
+	router.Reload(&config.Config{Pools: cfg.Pools, Routes: cfg.Routes})
+	assert.Same(t, oldMux, router.router, "unchanged routes should not rebuild the mux")
+
+	router.Reload(&config.Config{
+		Pools:  []config.Pool{{Name: "testpool", StickySessions: true}},
+		Routes: []config.Route{{Host: "new.example.com", Pool: "testpool"}},
+	})
+	assert.NotNil(t, router.stickyPolicy("testpool"))
+
+	req := httptest.NewRequest("GET", "http://old.example.com/", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
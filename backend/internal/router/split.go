@@ -0,0 +1,312 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+)
+
+// Traffic split defaults
+const (
+	defaultSplitCookie = "veloflux_split"
+	defaultSplitTTL    = 24 * time.Hour
+	defaultSplitWeight = 100 // weight of the pool of a route without a configured split
+
+	// splitBuckets is the number of buckets clients are spread over. Buckets
+	// are assigned to variants in order, so shifting weight between two
+	// variants only moves the clients of the buckets changing hands.
+	splitBuckets = 10000
+)
+
+// ErrRouteNotFound is returned for operations on a route that is not in the route table
+var ErrRouteNotFound = errors.New("route not found")
+
+// trafficSplit chooses the pool serving each request of a route. A route
+// without a configured split has a single variant, so a canary can be added
+// to any route at runtime.
+type trafficSplit struct {
+	route      string
+	sticky     bool
+	cookie     string
+	ttl        time.Duration
+	configured []config.SplitVariant // variants the split was compiled from
+
+	mu       sync.Mutex // serializes weight changes
+	variants atomic.Pointer[[]splitVariant]
+}
+
+type splitVariant struct {
+	config.SplitVariant
+	override *routeMatcher // nil when requests cannot force the variant
+}
+
+func compileSplit(route config.Route) (*trafficSplit, error) {
+	s := &trafficSplit{
+		route:  route.ID,
+		sticky: route.Split.Sticky,
+		cookie: route.Split.Cookie,
+		ttl:    route.Split.TTL,
+	}
+	if s.cookie == "" {
+		s.cookie = defaultSplitCookie
+	}
+	if s.ttl <= 0 {
+		s.ttl = defaultSplitTTL
+	}
+
+	configured := route.Split.Variants
+	if len(configured) == 0 {
+		configured = []config.SplitVariant{{Pool: route.Pool, Weight: defaultSplitWeight}}
+	}
+	variants, err := compileVariants(configured)
+	if err != nil {
+		return nil, err
+	}
+	s.configured = configured
+	s.variants.Store(&variants)
+	return s, nil
+}
+
+// sameConfig reports whether two splits were compiled from the same settings
+func (s *trafficSplit) sameConfig(other *trafficSplit) bool {
+	return s.route == other.route && s.sticky == other.sticky && s.cookie == other.cookie &&
+		s.ttl == other.ttl && reflect.DeepEqual(s.configured, other.configured)
+}
+
+func compileVariants(configured []config.SplitVariant) ([]splitVariant, error) {
+	variants := make([]splitVariant, 0, len(configured))
+	seen := make(map[string]bool, len(configured))
+	total := 0
+	for _, cfg := range configured {
+		if cfg.Pool == "" {
+			return nil, fmt.Errorf("split variant without a pool")
+		}
+		if seen[cfg.Pool] {
+			return nil, fmt.Errorf("pool %s appears twice in the split", cfg.Pool)
+		}
+		seen[cfg.Pool] = true
+		if cfg.Weight < 0 {
+			return nil, fmt.Errorf("negative weight for split variant %s", cfg.Pool)
+		}
+		total += cfg.Weight
+
+		variant := splitVariant{SplitVariant: cfg}
+		if len(cfg.Headers) > 0 || len(cfg.Cookies) > 0 {
+			variant.override = &routeMatcher{}
+			var err error
+			if variant.override.headers, err = compileValueMatches("header", cfg.Headers); err != nil {
+				return nil, err
+			}
+			if variant.override.cookies, err = compileValueMatches("cookie", cfg.Cookies); err != nil {
+				return nil, err
+			}
+		}
+		variants = append(variants, variant)
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("split variants have no weight")
+	}
+	return variants, nil
+}
+
+// publish reports the weights of a split spreading requests over several
+// pools. Only splits in use publish them: compiling a split to validate a
+// configuration must not change the gauge.
+func (s *trafficSplit) publish() {
+	variants := *s.variants.Load()
+	if len(variants) < 2 {
+		return
+	}
+	for _, variant := range variants {
+		metrics.SplitWeight.WithLabelValues(s.route, variant.Pool).Set(float64(variant.Weight))
+	}
+}
+
+// splitting reports whether requests are spread over more than one pool
+func (s *trafficSplit) splitting() bool {
+	return len(*s.variants.Load()) > 1
+}
+
+// choose returns the pool serving a request. Clients of sticky splits are
+// assigned a bucket cookie on their first request.
+func (s *trafficSplit) choose(w http.ResponseWriter, req *http.Request) string {
+	variants := *s.variants.Load()
+	if len(variants) == 1 {
+		return variants[0].Pool
+	}
+
+	for _, variant := range variants {
+		if variant.override != nil && variant.override.match(req, nil) {
+			return variant.Pool
+		}
+	}
+
+	total := 0
+	for _, variant := range variants {
+		total += variant.Weight
+	}
+	bucket := s.bucket(w, req)
+	cumulative := 0
+	for _, variant := range variants {
+		cumulative += variant.Weight
+		if bucket*total < cumulative*splitBuckets {
+			return variant.Pool
+		}
+	}
+	return variants[len(variants)-1].Pool
+}
+
+func (s *trafficSplit) bucket(w http.ResponseWriter, req *http.Request) int {
+	if s.sticky {
+		if cookie, err := req.Cookie(s.cookie); err == nil {
+			if bucket, err := strconv.Atoi(cookie.Value); err == nil && bucket >= 0 && bucket < splitBuckets {
+				return bucket
+			}
+		}
+	}
+
+	bucket := rand.Intn(splitBuckets)
+	if s.sticky {
+		http.SetCookie(w, &http.Cookie{
+			Name:     s.cookie,
+			Value:    strconv.Itoa(bucket),
+			Path:     "/",
+			MaxAge:   int(s.ttl / time.Second),
+			HttpOnly: true,
+			Secure:   req.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return bucket
+}
+
+// observe records the outcome of a request served by a variant
+func (s *trafficSplit) observe(pool string, statusCode int, duration time.Duration) {
+	metrics.SplitRequestsTotal.WithLabelValues(s.route, pool, strconv.Itoa(statusCode)).Inc()
+	metrics.SplitRequestDuration.WithLabelValues(s.route, pool).Observe(duration.Seconds())
+}
+
+// weights returns the variants with their current weights
+func (s *trafficSplit) weights() []config.SplitVariant {
+	variants := *s.variants.Load()
+	weights := make([]config.SplitVariant, len(variants))
+	for i, variant := range variants {
+		weights[i] = variant.SplitVariant
+	}
+	return weights
+}
+
+// setWeights changes the weights of the given pools, adding pools that are
+// not variants yet. Other variants keep their weight.
+func (s *trafficSplit) setWeights(weights map[string]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := *s.variants.Load()
+	configured := make([]config.SplitVariant, 0, len(current)+len(weights))
+	known := make(map[string]bool, len(current))
+	for _, variant := range current {
+		known[variant.Pool] = true
+		if weight, ok := weights[variant.Pool]; ok {
+			variant.Weight = weight
+		}
+		configured = append(configured, variant.SplitVariant)
+	}
+	added := make([]string, 0, len(weights))
+	for pool := range weights {
+		if !known[pool] {
+			added = append(added, pool)
+		}
+	}
+	sort.Strings(added)
+	for _, pool := range added {
+		configured = append(configured, config.SplitVariant{Pool: pool, Weight: weights[pool]})
+	}
+
+	variants, err := compileVariants(configured)
+	if err != nil {
+		return err
+	}
+	s.variants.Store(&variants)
+	s.publish()
+	return nil
+}
+
+// registerSplit makes the split of a route reachable by its ID and returns
+// the split the route uses. Rebuilding the route table keeps the split a
+// route already has when its settings did not change, with the weights set at
+// runtime.
+func (r *Router) registerSplit(routeID string, split *trafficSplit) *trafficSplit {
+	if routeID == "" {
+		return split
+	}
+	r.splitsMu.Lock()
+	defer r.splitsMu.Unlock()
+	if current, ok := r.splits[routeID]; ok && current.sameConfig(split) {
+		return current
+	}
+	if r.splits == nil {
+		r.splits = make(map[string]*trafficSplit)
+	}
+	r.splits[routeID] = split
+	split.publish()
+	return split
+}
+
+// pruneSplits forgets the splits of routes that are no longer configured
+func (r *Router) pruneSplits(routes []config.Route) {
+	active := make(map[string]bool, len(routes))
+	for _, route := range orderRoutes(routes) {
+		active[route.ID] = true
+	}
+
+	r.splitsMu.Lock()
+	defer r.splitsMu.Unlock()
+	for id := range r.splits {
+		if !active[id] {
+			delete(r.splits, id)
+		}
+	}
+}
+
+func (r *Router) split(routeID string) (*trafficSplit, bool) {
+	r.splitsMu.RLock()
+	defer r.splitsMu.RUnlock()
+	split, ok := r.splits[routeID]
+	return split, ok
+}
+
+// SplitWeights returns the pool variants of a route with their current weights
+func (r *Router) SplitWeights(routeID string) ([]config.SplitVariant, bool) {
+	split, ok := r.split(routeID)
+	if !ok {
+		return nil, false
+	}
+	return split.weights(), true
+}
+
+// SetSplitWeights changes the weights of the pool variants of a route. Pools
+// that are not variants yet are added, the others keep their weight. Weights
+// set at runtime last until the split of the route is configured differently.
+func (r *Router) SetSplitWeights(routeID string, weights map[string]int) error {
+	split, ok := r.split(routeID)
+	if !ok {
+		return ErrRouteNotFound
+	}
+	for pool := range weights {
+		if r.balancer.GetPool(pool) == nil {
+			return fmt.Errorf("pool %s does not exist", pool)
+		}
+	}
+	return split.setWeights(weights)
+}
@@ -0,0 +1,199 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/eltonciatto/veloflux/internal/balancer"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// splitRouter builds a router with a stable and a canary pool whose backends
+// answer with their pool name
+func splitRouter(t *testing.T) *Router {
+	pools := []config.Pool{
+		{Name: "stable", Backends: []config.Backend{{Address: echoServer(t, "stable")}}},
+		{Name: "canary", Backends: []config.Backend{{Address: echoServer(t, "canary")}}},
+	}
+	bal := balancer.New()
+	for _, pool := range pools {
+		bal.AddPool(pool)
+	}
+	return &Router{
+		config:   &config.Config{Pools: pools},
+		balancer: bal,
+		logger:   zap.NewNop(),
+	}
+}
+
+func serveSplit(handler http.Handler, modify func(req *http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "1.2.3.4:5678"
+	if modify != nil {
+		modify(req)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestTrafficSplitWeights(t *testing.T) {
+	router := splitRouter(t)
+	handler := router.createRouteHandler(config.Route{
+		ID: "weighted",
+		Split: config.TrafficSplit{Variants: []config.SplitVariant{
+			{Pool: "stable", Weight: 90},
+			{Pool: "canary", Weight: 10},
+		}},
+	})
+
+	const requests = 2000
+	canary := 0
+	for i := 0; i < requests; i++ {
+		if serveSplit(handler, nil).Body.String() == "canary" {
+			canary++
+		}
+	}
+	assert.InDelta(t, 0.10, float64(canary)/requests, 0.03)
+
+	// Requests are counted per variant
+	assert.Equal(t, float64(canary), testutil.ToFloat64(metrics.SplitRequestsTotal.WithLabelValues("weighted", "canary", "200")))
+	assert.Equal(t, float64(requests-canary), testutil.ToFloat64(metrics.SplitRequestsTotal.WithLabelValues("weighted", "stable", "200")))
+	assert.Equal(t, 10.0, testutil.ToFloat64(metrics.SplitWeight.WithLabelValues("weighted", "canary")))
+}
+
+func TestTrafficSplitOverride(t *testing.T) {
+	router := splitRouter(t)
+	handler := router.createRouteHandler(config.Route{
+		ID: "override",
+		Split: config.TrafficSplit{Variants: []config.SplitVariant{
+			{Pool: "stable", Weight: 1},
+			{Pool: "canary", Weight: 0, Headers: []config.ValueMatch{{Name: "X-Canary", Value: "always"}}},
+		}},
+	})
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, "stable", serveSplit(handler, nil).Body.String())
+		forced := serveSplit(handler, func(req *http.Request) { req.Header.Set("X-Canary", "always") })
+		assert.Equal(t, "canary", forced.Body.String())
+	}
+}
+
+func TestTrafficSplitSticky(t *testing.T) {
+	router := splitRouter(t)
+	handler := router.createRouteHandler(config.Route{
+		ID: "sticky",
+		Split: config.TrafficSplit{Sticky: true, Variants: []config.SplitVariant{
+			{Pool: "stable", Weight: 50},
+			{Pool: "canary", Weight: 50},
+		}},
+	})
+
+	// Every client stays on the variant it was first assigned to
+	clients := make([]*http.Cookie, 20)
+	variants := make([]string, len(clients))
+	for i := range clients {
+		w := serveSplit(handler, nil)
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, defaultSplitCookie, cookies[0].Name)
+		clients[i], variants[i] = cookies[0], w.Body.String()
+	}
+	for round := 0; round < 3; round++ {
+		for i, cookie := range clients {
+			w := serveSplit(handler, func(req *http.Request) { req.AddCookie(cookie) })
+			assert.Equal(t, variants[i], w.Body.String())
+			assert.Empty(t, w.Result().Cookies(), "assigned clients get no new cookie")
+		}
+	}
+
+	// Shifting weight to the canary only moves clients towards it
+	require.NoError(t, router.SetSplitWeights("sticky", map[string]int{"stable": 20, "canary": 80}))
+	for i, cookie := range clients {
+		w := serveSplit(handler, func(req *http.Request) { req.AddCookie(cookie) })
+		if variants[i] == "canary" {
+			assert.Equal(t, "canary", w.Body.String())
+		}
+	}
+}
+
+func TestSetSplitWeights(t *testing.T) {
+	router := splitRouter(t)
+	handler := router.createRouteHandler(config.Route{ID: "plain", Pool: "stable"})
+	assert.Equal(t, "stable", serveSplit(handler, nil).Body.String())
+
+	// A canary can be added to a route without a configured split
+	require.NoError(t, router.SetSplitWeights("plain", map[string]int{"stable": 0, "canary": 1}))
+	assert.Equal(t, "canary", serveSplit(handler, nil).Body.String())
+
+	weights, ok := router.SplitWeights("plain")
+	require.True(t, ok)
+	assert.Equal(t, []config.SplitVariant{{Pool: "stable", Weight: 0}, {Pool: "canary", Weight: 1}}, weights)
+
+	assert.ErrorIs(t, router.SetSplitWeights("missing", map[string]int{"canary": 1}), ErrRouteNotFound)
+	assert.Error(t, router.SetSplitWeights("plain", map[string]int{"unknown": 1}))
+	assert.Error(t, router.SetSplitWeights("plain", map[string]int{"canary": 0}), "a split needs some weight")
+
+	// Reloading without the route forgets its split
+	router.pruneSplits(nil)
+	_, ok = router.SplitWeights("plain")
+	assert.False(t, ok)
+}
+
+func TestReloadKeepsSplitWeights(t *testing.T) {
+	router := splitRouter(t)
+	pools := router.config.Pools
+	routes := []config.Route{{ID: "plain", Host: "plain.example.com", Pool: "stable"}, {ID: "other", Host: "other.example.com", Pool: "stable"}}
+	router.Reload(&config.Config{Pools: pools, Routes: routes})
+	require.NoError(t, router.SetSplitWeights("plain", map[string]int{"stable": 0, "canary": 1}))
+	canary := []config.SplitVariant{{Pool: "stable", Weight: 0}, {Pool: "canary", Weight: 1}}
+	gauge := metrics.SplitWeight.WithLabelValues("plain", "canary")
+	assert.Equal(t, 1.0, testutil.ToFloat64(gauge))
+
+	// Validating a configuration does not touch the weights in use
+	validated := config.Route{ID: "plain", Pool: "stable", Split: config.TrafficSplit{Variants: []config.SplitVariant{
+		{Pool: "stable", Weight: 1}, {Pool: "canary", Weight: 9},
+	}}}
+	require.NoError(t, ValidateRoute(validated))
+	assert.Equal(t, 1.0, testutil.ToFloat64(gauge))
+
+	// Changing another route rebuilds the route table but keeps the split
+	routes = []config.Route{routes[0], {ID: "other", Host: "other.example.org", Pool: "stable"}}
+	router.Reload(&config.Config{Pools: pools, Routes: routes})
+	weights, ok := router.SplitWeights("plain")
+	require.True(t, ok)
+	assert.Equal(t, canary, weights)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://plain.example.com/", nil))
+	assert.Equal(t, "canary", w.Body.String())
+
+	// Configuring the split differently replaces it
+	routes = slices.Clone(routes)
+	routes[0].Split.Variants = []config.SplitVariant{{Pool: "stable", Weight: 3}, {Pool: "canary", Weight: 2}}
+	router.Reload(&config.Config{Pools: pools, Routes: routes})
+	weights, _ = router.SplitWeights("plain")
+	assert.Equal(t, routes[0].Split.Variants, weights)
+	assert.Equal(t, 2.0, testutil.ToFloat64(gauge))
+}
+
+func TestCompileSplit(t *testing.T) {
+	invalid := map[string][]config.SplitVariant{
+		"no pool":         {{Weight: 1}},
+		"duplicate pool":  {{Pool: "a", Weight: 1}, {Pool: "a", Weight: 1}},
+		"negative weight": {{Pool: "a", Weight: 2}, {Pool: "b", Weight: -1}},
+		"no weight":       {{Pool: "a"}, {Pool: "b"}},
+		"bad override":    {{Pool: "a", Weight: 1, Cookies: []config.ValueMatch{{Name: "c", Regex: "("}}}},
+	}
+	for name, variants := range invalid {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, ValidateRoute(config.Route{Split: config.TrafficSplit{Variants: variants}}))
+		})
+	}
+}
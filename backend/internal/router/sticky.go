@@ -0,0 +1,219 @@
+package router
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/balancer"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Default names of the cookie or header carrying the affinity of a request
+const (
+	defaultStickyCookie   = "veloflux"
+	defaultAffinityHeader = "X-Session-ID"
+	defaultAffinityCookie = "session_id"
+	stickySecretSize      = 32
+	stickyTokenSize       = 12
+	stickySignatureSize   = 16
+)
+
+// stickyPolicy pins the clients of a sticky pool to a backend. Issued cookies
+// hold an opaque token derived from the backend address, an expiry and an
+// HMAC signature, so they neither reveal the topology nor can be forged.
+type stickyPolicy struct {
+	pool   string
+	source string
+	name   string
+	ttl    time.Duration
+	secret []byte
+}
+
+// poolConfig returns the configuration of a pool
+func (r *Router) poolConfig(poolName string) (config.Pool, bool) {
+	cfg := r.currentConfig()
+	if cfg == nil {
+		return config.Pool{}, false
+	}
+	for _, pool := range cfg.Pools {
+		if pool.Name == poolName {
+			return pool, true
+		}
+	}
+	return config.Pool{}, false
+}
+
+// stickyPolicy returns the sticky session policy of a pool, or nil when the
+// pool does not use sticky sessions.
+func (r *Router) stickyPolicy(poolName string) *stickyPolicy {
+	pool, ok := r.poolConfig(poolName)
+	if !ok || !pool.StickySessions {
+		return nil
+	}
+
+	policy := &stickyPolicy{
+		pool:   poolName,
+		source: pool.Sticky.Source,
+		name:   pool.Sticky.Name,
+		ttl:    pool.Sticky.TTL,
+		secret: []byte(pool.Sticky.Secret),
+	}
+	if policy.source == "" {
+		policy.source = config.AffinityCookie
+	}
+	if policy.name == "" {
+		switch policy.source {
+		case config.AffinityHeader:
+			policy.name = defaultAffinityHeader
+		case config.AffinityAppCookie:
+			policy.name = defaultAffinityCookie
+		default:
+			policy.name = defaultStickyCookie
+		}
+	}
+	if policy.ttl <= 0 {
+		policy.ttl = balancer.DefaultAffinityTTL
+	}
+	if len(policy.secret) == 0 {
+		policy.secret = r.processStickySecret()
+	}
+	return policy
+}
+
+// processStickySecret returns a random key used to sign cookies of pools
+// without a configured secret. Such cookies are only honoured by this process.
+func (r *Router) processStickySecret() []byte {
+	r.stickySecretOnce.Do(func() {
+		r.stickySecret = make([]byte, stickySecretSize)
+		if _, err := rand.Read(r.stickySecret); err != nil {
+			panic("router: cannot generate sticky session secret: " + err.Error())
+		}
+		if r.logger != nil {
+			r.logger.Warn("No sticky session secret configured, sticky cookies will not survive restarts or work across nodes")
+		}
+	})
+	return r.stickySecret
+}
+
+// getSessionID returns the affinity key of a request for pools keyed on a
+// header or an application cookie. Issued cookies are resolved separately.
+func (r *Router) getSessionID(req *http.Request, policy *stickyPolicy) string {
+	if policy == nil {
+		return ""
+	}
+	switch policy.source {
+	case config.AffinityHeader:
+		return req.Header.Get(policy.name)
+	case config.AffinityAppCookie:
+		if cookie, err := req.Cookie(policy.name); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func (s *stickyPolicy) mac(parts ...string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(s.pool))
+	for _, part := range parts {
+		h.Write([]byte{0})
+		h.Write([]byte(part))
+	}
+	return h.Sum(nil)
+}
+
+// token returns the opaque reference to a backend stored in issued cookies
+func (s *stickyPolicy) token(address string) string {
+	return base64.RawURLEncoding.EncodeToString(s.mac("backend", address)[:stickyTokenSize])
+}
+
+func (s *stickyPolicy) signature(token, expires string) string {
+	return base64.RawURLEncoding.EncodeToString(s.mac("cookie", token, expires)[:stickySignatureSize])
+}
+
+// pinned returns the backend token and expiry of a valid issued cookie
+func (s *stickyPolicy) pinned(req *http.Request, now time.Time) (string, time.Time, bool) {
+	if s == nil || s.source != config.AffinityCookie {
+		return "", time.Time{}, false
+	}
+	cookie, err := req.Cookie(s.name)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return "", time.Time{}, false
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(s.signature(parts[0], parts[1]))) {
+		return "", time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	expires := time.Unix(unix, 0)
+	if !now.Before(expires) {
+		return "", time.Time{}, false
+	}
+	return parts[0], expires, true
+}
+
+// cookie returns the cookie pinning the client to a backend, or nil when the
+// client already holds a cookie for it that is not due for renewal.
+func (s *stickyPolicy) cookie(p *proxyRequest, address string, secure bool, now time.Time) *http.Cookie {
+	if s == nil || s.source != config.AffinityCookie {
+		return nil
+	}
+	token := s.token(address)
+	if token == p.pinToken && p.pinExpires.Sub(now) > s.ttl/2 {
+		return nil
+	}
+
+	expires := strconv.FormatInt(now.Add(s.ttl).Unix(), 10)
+	return &http.Cookie{
+		Name:     s.name,
+		Value:    token + "." + expires + "." + s.signature(token, expires),
+		Path:     "/",
+		MaxAge:   int(s.ttl / time.Second),
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// pinnedBackend returns the backend an issued cookie pins the request to,
+// unless an earlier attempt of the request already used it.
+func (r *Router) pinnedBackend(p *proxyRequest) *balancer.Backend {
+	if p.pinToken == "" {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pinUsed {
+		return nil
+	}
+	p.pinUsed = true
+
+	backend, ok := r.balancer.GetPinnedBackend(p.pool, func(address string) bool {
+		return p.sticky.token(address) == p.pinToken
+	})
+	if !ok {
+		// The backend was removed, drained or ejected; the client gets a new
+		// cookie for whichever backend serves the request
+		r.logger.Debug("Sticky backend unavailable, failing over", zap.String("pool", p.pool))
+		metrics.StickyFailoversTotal.WithLabelValues(p.pool).Inc()
+		return nil
+	}
+	p.tried[backend.Address] = true
+	return backend
+}
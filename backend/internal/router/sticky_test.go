@@ -0,0 +1,170 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/balancer"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// stickyRouter builds a router for a sticky pool over the given backends
+func stickyRouter(sticky config.StickyConfig, addresses ...string) (*Router, *balancer.Balancer) {
+	backends := make([]config.Backend, len(addresses))
+	for i, addr := range addresses {
+		backends[i] = config.Backend{Address: addr, Weight: 1}
+	}
+	pool := config.Pool{Name: "web", Algorithm: "round_robin", StickySessions: true, Sticky: sticky, Backends: backends}
+
+	bal := balancer.New()
+	bal.AddPool(pool)
+	return &Router{
+		config:   &config.Config{Pools: []config.Pool{pool}},
+		balancer: bal,
+		logger:   zap.NewNop(),
+	}, bal
+}
+
+func serveWithCookie(handler http.Handler, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "1.2.3.4:5678"
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func stickyCookie(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "veloflux" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestStickyCookiePinsClient(t *testing.T) {
+	a, b := echoServer(t, "a"), echoServer(t, "b")
+	router, _ := stickyRouter(config.StickyConfig{Secret: "s3cret"}, a, b)
+	handler := router.createRouteHandler(config.Route{Pool: "web"})
+
+	first := serveWithCookie(handler, nil)
+	cookie := stickyCookie(t, first)
+	require.NotNil(t, cookie)
+	assert.NotContains(t, cookie.Value, a, "cookie must not reveal the backend address")
+	assert.NotContains(t, cookie.Value, b, "cookie must not reveal the backend address")
+	assert.True(t, cookie.HttpOnly)
+
+	// Round robin would alternate; the cookie keeps the client on its backend
+	for i := 0; i < 4; i++ {
+		w := serveWithCookie(handler, cookie)
+		assert.Equal(t, first.Body.String(), w.Body.String())
+		assert.Nil(t, stickyCookie(t, w), "a fresh cookie is not reissued")
+	}
+
+	// Another node sharing the secret honours the cookie
+	other, _ := stickyRouter(config.StickyConfig{Secret: "s3cret"}, a, b)
+	otherHandler := other.createRouteHandler(config.Route{Pool: "web"})
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, first.Body.String(), serveWithCookie(otherHandler, cookie).Body.String())
+	}
+}
+
+func TestStickyCookieRejectsTampering(t *testing.T) {
+	router, _ := stickyRouter(config.StickyConfig{Secret: "s3cret"}, echoServer(t, "a"))
+	policy := router.stickyPolicy("web")
+	now := time.Now()
+
+	valid := policy.cookie(&proxyRequest{}, "10.0.0.1:80", false, now)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(valid)
+	token, _, ok := policy.pinned(req, now)
+	require.True(t, ok)
+	assert.Equal(t, policy.token("10.0.0.1:80"), token)
+
+	parts := strings.Split(valid.Value, ".")
+	tests := map[string]string{
+		"other backend": policy.token("10.0.0.2:80") + "." + parts[1] + "." + parts[2],
+		"later expiry":  parts[0] + "." + "9999999999" + "." + parts[2],
+		"garbage":       "not-a-cookie",
+	}
+	for name, value := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: "veloflux", Value: value})
+			_, _, ok := policy.pinned(req, now)
+			assert.False(t, ok)
+		})
+	}
+
+	// Expired cookies are ignored
+	_, _, ok = policy.pinned(req, now.Add(2*time.Hour))
+	assert.False(t, ok)
+
+	// Cookies signed with another secret are ignored
+	other, _ := stickyRouter(config.StickyConfig{Secret: "other"}, echoServer(t, "a"))
+	_, _, ok = other.stickyPolicy("web").pinned(req, now)
+	assert.False(t, ok)
+}
+
+func TestStickyCookieFailsOver(t *testing.T) {
+	a, b := echoServer(t, "a"), echoServer(t, "b")
+	router, bal := stickyRouter(config.StickyConfig{Secret: "s3cret", TTL: time.Minute}, a, b)
+	handler := router.createRouteHandler(config.Route{Pool: "web"})
+
+	first := serveWithCookie(handler, nil)
+	cookie := stickyCookie(t, first)
+	require.NotNil(t, cookie)
+
+	pinned, survivor := a, "b"
+	if first.Body.String() == "b" {
+		pinned, survivor = b, "a"
+	}
+	bal.UpdateBackendHealth("web", pinned, false)
+
+	// The client moves to the healthy backend and is pinned to it from now on
+	w := serveWithCookie(handler, cookie)
+	assert.Equal(t, survivor, w.Body.String())
+	moved := stickyCookie(t, w)
+	require.NotNil(t, moved)
+	assert.Equal(t, 60, moved.MaxAge)
+
+	bal.UpdateBackendHealth("web", pinned, true)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, w.Body.String(), serveWithCookie(handler, moved).Body.String())
+	}
+}
+
+func TestStickyHeaderAffinity(t *testing.T) {
+	a, b := echoServer(t, "a"), echoServer(t, "b")
+	router, _ := stickyRouter(config.StickyConfig{Source: config.AffinityHeader, Name: "X-User"}, a, b)
+	handler := router.createRouteHandler(config.Route{Pool: "web"})
+
+	send := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "1.2.3.4:5678"
+		req.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	alice := send("alice").Body.String()
+	bob := send("bob").Body.String()
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, alice, send("alice").Body.String())
+		assert.Equal(t, bob, send("bob").Body.String())
+	}
+
+	// Header affinity does not issue cookies
+	assert.Nil(t, stickyCookie(t, send("carol")))
+}
@@ -0,0 +1,56 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+)
+
+// streamingPolicy is the compiled streaming configuration of a route
+type streamingPolicy struct {
+	flushInterval time.Duration
+	buffering     bool
+	noTimeouts    bool
+}
+
+func compileStreaming(cfg config.StreamingPolicy) (*streamingPolicy, error) {
+	if cfg.Buffering && cfg.FlushInterval != 0 {
+		return nil, fmt.Errorf("streaming buffering and flush interval are mutually exclusive")
+	}
+	return &streamingPolicy{
+		flushInterval: cfg.FlushInterval,
+		buffering:     cfg.Buffering,
+		noTimeouts:    cfg.DisableWriteTimeout,
+	}, nil
+}
+
+// prepare readies the response writer of a request for streaming. Once the
+// server write timeout expires, a stream can no longer be written to.
+func (s *streamingPolicy) prepare(w http.ResponseWriter) http.ResponseWriter {
+	if s.noTimeouts {
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	}
+	if s.buffering {
+		return &bufferedWriter{ResponseWriter: w}
+	}
+	return w
+}
+
+// bufferedWriter hides the http.Flusher of the writer beneath, so responses
+// are held in the server's write buffer rather than flushed as they arrive.
+// It deliberately does not unwrap, as http.ResponseController would find the
+// flusher again.
+type bufferedWriter struct {
+	http.ResponseWriter
+}
+
+// closeNotify passes http.CloseNotifier through response writer wrappers
+func closeNotify(w http.ResponseWriter) <-chan bool {
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	// Never fires, like the notification of a connection that stays open
+	return make(chan bool)
+}
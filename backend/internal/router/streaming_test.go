@@ -0,0 +1,160 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/balancer"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// eventServer sends one event right away and another one once next is closed
+func eventServer(t *testing.T, next chan struct{}) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-next:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte("data: second\n\n"))
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// streamingRouter routes to an event stream pool
+func streamingRouter(t *testing.T, next chan struct{}) *Router {
+	pools := []config.Pool{{Name: "events", Backends: []config.Backend{{Address: eventServer(t, next)}}}}
+	bal := balancer.New()
+	bal.AddPool(pools[0])
+	return &Router{
+		config:   &config.Config{Pools: pools},
+		balancer: bal,
+		logger:   zap.NewNop(),
+	}
+}
+
+// streamingProxy serves a route through the router middleware with short
+// server timeouts
+func streamingProxy(t *testing.T, router *Router, policy config.StreamingPolicy) string {
+	handler := router.createRouteHandler(config.Route{ID: "events", Pool: "events", Streaming: policy})
+	server := httptest.NewUnstartedServer(router.middleware(handler))
+	server.Config.ReadTimeout = 200 * time.Millisecond
+	server.Config.WriteTimeout = 200 * time.Millisecond
+	server.Start()
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func readEvent(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	reader.ReadString('\n') // blank line ending the event
+	return strings.TrimSpace(line), nil
+}
+
+func TestStreamingEvents(t *testing.T) {
+	next := make(chan struct{})
+	url := streamingProxy(t, streamingRouter(t, next), config.StreamingPolicy{DisableWriteTimeout: true})
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+
+	// The first event is flushed through every wrapper before the stream ends
+	event, err := readEvent(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "data: first", event)
+
+	// The stream outlives the server write timeout
+	time.Sleep(400 * time.Millisecond)
+	close(next)
+	event, err = readEvent(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "data: second", event)
+}
+
+func TestStreamingServerTimeouts(t *testing.T) {
+	next := make(chan struct{})
+	url := streamingProxy(t, streamingRouter(t, next), config.StreamingPolicy{})
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+
+	_, err = readEvent(reader)
+	require.NoError(t, err)
+
+	// Without lifting the write timeout the rest of the stream is lost
+	time.Sleep(400 * time.Millisecond)
+	close(next)
+	_, err = readEvent(reader)
+	assert.Error(t, err)
+}
+
+func TestStreamingBuffering(t *testing.T) {
+	next := make(chan struct{})
+	close(next)
+	router := streamingRouter(t, next)
+
+	for _, buffering := range []bool{false, true} {
+		handler := router.createRouteHandler(config.Route{
+			ID:        "events",
+			Pool:      "events",
+			Streaming: config.StreamingPolicy{Buffering: buffering},
+		})
+		w := serve(handler, http.MethodGet, "")
+		assert.Equal(t, "data: first\n\ndata: second\n\n", w.Body.String())
+		assert.Equal(t, !buffering, w.Flushed, "buffering %v", buffering)
+	}
+
+	err := ValidateRoute(config.Route{
+		Pool:      "events",
+		Streaming: config.StreamingPolicy{Buffering: true, FlushInterval: time.Second},
+	})
+	assert.Error(t, err)
+}
+
+func TestCloseNotifyPassesThrough(t *testing.T) {
+	router := &Router{logger: zap.NewNop()}
+	notified := make(chan struct{})
+	server := httptest.NewServer(router.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-w.(http.CloseNotifier).CloseNotify():
+			close(notified)
+		case <-time.After(5 * time.Second):
+		}
+	})))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	cancel()
+	resp.Body.Close()
+
+	select {
+	case <-notified:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client disconnect was not notified")
+	}
+}
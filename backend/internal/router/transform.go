@@ -0,0 +1,296 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/geo"
+)
+
+// GeoLocator resolves the location of a client address
+type GeoLocator interface {
+	GetLocationByIP(ip net.IP) (geo.Location, error)
+}
+
+// SetGeoLocator sets the locator resolving ${geo_country} in transformations
+func (r *Router) SetGeoLocator(locator GeoLocator) {
+	r.geo = locator
+}
+
+// Variables available in transformed values
+var transformVariables = map[string]bool{
+	"client_ip":   true,
+	"request_id":  true,
+	"geo_country": true,
+	"tenant":      true,
+	"route":       true,
+	"host":        true,
+	"scheme":      true,
+	"method":      true,
+	"path":        true,
+}
+
+// routeTransform is the compiled form of a route's transformation
+type routeTransform struct {
+	stripPrefix     string
+	addPrefix       string
+	pathRegex       *regexp.Regexp
+	pathReplacement string
+	host            template
+	query           []namedTemplate
+	request         headerTransform
+	response        headerTransform
+}
+
+type headerTransform struct {
+	remove []string
+	set    []namedTemplate
+	add    []namedTemplate
+}
+
+type namedTemplate struct {
+	name  string
+	value template
+}
+
+// template is a value with variable references split out. Even elements are
+// literals, odd elements are variable names.
+type template []string
+
+// compileTransform compiles a route's transformation, returning nil when the
+// route does not transform anything.
+func compileTransform(cfg config.Transform) (*routeTransform, error) {
+	t := &routeTransform{
+		stripPrefix:     cfg.StripPrefix,
+		addPrefix:       cfg.AddPrefix,
+		pathReplacement: cfg.PathReplacement,
+	}
+
+	if cfg.PathRegex != "" {
+		regex, err := regexp.Compile(cfg.PathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path rewrite regex: %w", err)
+		}
+		t.pathRegex = regex
+	}
+
+	var err error
+	if cfg.Host != "" {
+		if t.host, err = compileTemplate(cfg.Host); err != nil {
+			return nil, fmt.Errorf("invalid host override: %w", err)
+		}
+	}
+	if t.query, err = compileTemplates("query parameter", cfg.Query); err != nil {
+		return nil, err
+	}
+	if t.request, err = compileHeaderRules("request", cfg.RequestHeaders); err != nil {
+		return nil, err
+	}
+	if t.response, err = compileHeaderRules("response", cfg.ResponseHeaders); err != nil {
+		return nil, err
+	}
+
+	if t.stripPrefix == "" && t.addPrefix == "" && t.pathRegex == nil && t.host == nil &&
+		len(t.query) == 0 && t.request.empty() && t.response.empty() {
+		return nil, nil
+	}
+	return t, nil
+}
+
+func compileHeaderRules(kind string, rules config.HeaderRules) (headerTransform, error) {
+	h := headerTransform{}
+	for _, name := range rules.Remove {
+		h.remove = append(h.remove, http.CanonicalHeaderKey(name))
+	}
+
+	var err error
+	if h.set, err = compileTemplates(kind+" header", rules.Set); err != nil {
+		return h, err
+	}
+	if h.add, err = compileTemplates(kind+" header", rules.Add); err != nil {
+		return h, err
+	}
+	if kind == "request" {
+		for _, rules := range [][]namedTemplate{h.set, h.add} {
+			for _, rule := range rules {
+				if http.CanonicalHeaderKey(rule.name) == "Host" {
+					return h, fmt.Errorf("use the host override to change the Host header")
+				}
+			}
+		}
+	}
+	return h, nil
+}
+
+func (h headerTransform) empty() bool {
+	return len(h.remove) == 0 && len(h.set) == 0 && len(h.add) == 0
+}
+
+// compileTemplates compiles named values in name order, so they are applied deterministically
+func compileTemplates(kind string, values map[string]string) ([]namedTemplate, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		if name == "" {
+			return nil, fmt.Errorf("%s without a name", kind)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	compiled := make([]namedTemplate, 0, len(names))
+	for _, name := range names {
+		value, err := compileTemplate(values[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %s: %w", kind, name, err)
+		}
+		compiled = append(compiled, namedTemplate{name: name, value: value})
+	}
+	return compiled, nil
+}
+
+func compileTemplate(s string) (template, error) {
+	t := template{}
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			return append(t, s), nil
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated variable in %q", s)
+		}
+		name := s[start+2 : start+end]
+		if !transformVariables[name] {
+			return nil, fmt.Errorf("unknown variable %q", name)
+		}
+		t = append(t, s[:start], name)
+		s = s[start+end+1:]
+	}
+}
+
+func (t template) expand(vars *requestVars) string {
+	if len(t) == 1 {
+		return t[0]
+	}
+	var b strings.Builder
+	for i, part := range t {
+		if i%2 == 0 {
+			b.WriteString(part)
+		} else {
+			b.WriteString(vars.get(part))
+		}
+	}
+	return b.String()
+}
+
+// requestVars holds the values of transformation variables, captured before
+// the request is transformed.
+type requestVars struct {
+	values map[string]string
+	ip     net.IP
+	geo    GeoLocator
+
+	countryOnce sync.Once
+	country     string
+}
+
+func (r *Router) requestVars(req *http.Request, route config.Route, clientIP net.IP) *requestVars {
+	return &requestVars{
+		values: map[string]string{
+			"client_ip":  clientIP.String(),
+			"request_id": req.Header.Get("X-Request-ID"),
+			"tenant":     route.Tenant,
+			"route":      route.ID,
+			"host":       req.Host,
+			"scheme":     r.getScheme(req),
+			"method":     req.Method,
+			"path":       req.URL.Path,
+		},
+		ip:  clientIP,
+		geo: r.geo,
+	}
+}
+
+func (v *requestVars) get(name string) string {
+	if name == "geo_country" {
+		// Only looked up when a transformation uses it
+		v.countryOnce.Do(func() {
+			if v.geo == nil || v.ip == nil {
+				return
+			}
+			if loc, err := v.geo.GetLocationByIP(v.ip); err == nil {
+				v.country = loc.Country
+			}
+		})
+		return v.country
+	}
+	return v.values[name]
+}
+
+// stripPathPrefix removes a prefix ending on a segment boundary of a path:
+// /api strips /api and /api/users but leaves /apiv2/users alone
+func stripPathPrefix(path, prefix string) string {
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok || (rest != "" && rest[0] != '/' && !strings.HasSuffix(prefix, "/")) {
+		return path
+	}
+	return rest
+}
+
+// applyRequest rewrites the path, host, query and headers of a request
+func (t *routeTransform) applyRequest(req *http.Request, vars *requestVars) {
+	path := req.URL.Path
+	if t.stripPrefix != "" {
+		path = stripPathPrefix(path, t.stripPrefix)
+	}
+	if t.addPrefix != "" {
+		path = strings.TrimSuffix(t.addPrefix, "/") + "/" + strings.TrimPrefix(path, "/")
+	}
+	if t.pathRegex != nil {
+		path = t.pathRegex.ReplaceAllString(path, t.pathReplacement)
+	}
+	if path != req.URL.Path {
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		req.URL.Path = path
+		req.URL.RawPath = ""
+	}
+
+	if t.host != nil {
+		req.Host = t.host.expand(vars)
+	}
+
+	if len(t.query) > 0 {
+		query := req.URL.Query()
+		for _, param := range t.query {
+			query.Set(param.name, param.value.expand(vars))
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
+	t.request.apply(req.Header, vars)
+}
+
+// applyResponse edits the headers of a response
+func (t *routeTransform) applyResponse(resp *http.Response, vars *requestVars) {
+	t.response.apply(resp.Header, vars)
+}
+
+func (h headerTransform) apply(header http.Header, vars *requestVars) {
+	for _, name := range h.remove {
+		header.Del(name)
+	}
+	for _, rule := range h.set {
+		header.Set(rule.name, rule.value.expand(vars))
+	}
+	for _, rule := range h.add {
+		header.Add(rule.name, rule.value.expand(vars))
+	}
+}
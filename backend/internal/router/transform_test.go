@@ -0,0 +1,171 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eltonciatto/veloflux/internal/balancer"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/geo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type staticLocator struct{ country string }
+
+func (l staticLocator) GetLocationByIP(ip net.IP) (geo.Location, error) {
+	if l.country == "" {
+		return geo.Location{}, errors.New("unknown address")
+	}
+	return geo.Location{Country: l.country}, nil
+}
+
+// upstreamRequest is what the backend of a transform test received
+type upstreamRequest struct {
+	Host   string
+	Path   string
+	Query  string
+	Header http.Header
+}
+
+func inspectServer(t *testing.T) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "backend/1.0")
+		w.Header().Set("X-Powered-By", "php")
+		json.NewEncoder(w).Encode(upstreamRequest{Host: r.Host, Path: r.URL.Path, Query: r.URL.RawQuery, Header: r.Header})
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func transformRouter(addr string) *Router {
+	pool := config.Pool{Name: "web", Backends: []config.Backend{{Address: addr}}}
+	bal := balancer.New()
+	bal.AddPool(pool)
+	return &Router{
+		config:   &config.Config{Pools: []config.Pool{pool}},
+		balancer: bal,
+		logger:   zap.NewNop(),
+		geo:      staticLocator{country: "BR"},
+	}
+}
+
+func proxyThrough(t *testing.T, handler http.Handler, target string) (*httptest.ResponseRecorder, upstreamRequest) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.RemoteAddr = "203.0.113.9:5555"
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set("X-Internal", "secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var got upstreamRequest
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	return w, got
+}
+
+func TestTransformRequest(t *testing.T) {
+	router := transformRouter(inspectServer(t))
+	route := config.Route{
+		ID:     "api",
+		Pool:   "web",
+		Tenant: "acme",
+		Transform: config.Transform{
+			StripPrefix: "/api",
+			AddPrefix:   "/v1/",
+			Host:        "${tenant}.internal",
+			Query:       map[string]string{"source": "edge", "country": "${geo_country}"},
+			RequestHeaders: config.HeaderRules{
+				Set:    map[string]string{"X-Client": "${client_ip} ${request_id}", "X-Forwarded-Proto": "https"},
+				Add:    map[string]string{"X-Route": "${route} ${method} ${path}"},
+				Remove: []string{"x-internal"},
+			},
+			ResponseHeaders: config.HeaderRules{
+				Set:    map[string]string{"Server": "veloflux"},
+				Add:    map[string]string{"X-Tenant": "${tenant}"},
+				Remove: []string{"X-Powered-By"},
+			},
+		},
+	}
+
+	w, got := proxyThrough(t, router.createRouteHandler(route), "http://example.com/api/users?page=2")
+	assert.Equal(t, "acme.internal", got.Host)
+	assert.Equal(t, "/v1/users", got.Path)
+	assert.Equal(t, "country=BR&page=2&source=edge", got.Query)
+	assert.Equal(t, "203.0.113.9 req-1", got.Header.Get("X-Client"))
+	assert.Equal(t, "https", got.Header.Get("X-Forwarded-Proto"), "rules override forwarding headers")
+	assert.Equal(t, "api GET /api/users", got.Header.Get("X-Route"))
+	assert.Empty(t, got.Header.Get("X-Internal"))
+
+	assert.Equal(t, "veloflux", w.Header().Get("Server"))
+	assert.Equal(t, "acme", w.Header().Get("X-Tenant"))
+	assert.Empty(t, w.Header().Get("X-Powered-By"))
+}
+
+func TestTransformPath(t *testing.T) {
+	router := transformRouter(inspectServer(t))
+
+	tests := []struct {
+		name      string
+		transform config.Transform
+		target    string
+		want      string
+	}{
+		{"strip prefix", config.Transform{StripPrefix: "/api"}, "/api/users", "/users"},
+		{"strip whole path", config.Transform{StripPrefix: "/api"}, "/api", "/"},
+		{"prefix not present", config.Transform{StripPrefix: "/api"}, "/web/users", "/web/users"},
+		{"prefix within a segment", config.Transform{StripPrefix: "/api"}, "/apiv2/x", "/apiv2/x"},
+		{"prefix with a trailing slash", config.Transform{StripPrefix: "/api/"}, "/api/users", "/users"},
+		{"replace prefix", config.Transform{StripPrefix: "/old", AddPrefix: "/new"}, "/old/a", "/new/a"},
+		{"regex", config.Transform{PathRegex: `^/users/([0-9]+)$`, PathReplacement: "/profiles/$1"}, "/users/42", "/profiles/42"},
+		{"regex without match", config.Transform{PathRegex: `^/users/([0-9]+)$`, PathReplacement: "/profiles/$1"}, "/users/me", "/users/me"},
+		{"no transformation", config.Transform{}, "/", "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := router.createRouteHandler(config.Route{Pool: "web", Transform: tt.transform})
+			_, got := proxyThrough(t, handler, "http://example.com"+tt.target)
+			assert.Equal(t, tt.want, got.Path)
+			assert.Equal(t, "example.com", got.Host)
+		})
+	}
+}
+
+func TestCompileTransform(t *testing.T) {
+	transform, err := compileTransform(config.Transform{})
+	assert.NoError(t, err)
+	assert.Nil(t, transform, "an empty transformation compiles to nothing")
+
+	invalid := map[string]config.Transform{
+		"path regex":       {PathRegex: "("},
+		"unknown variable": {Host: "${nope}"},
+		"unterminated":     {RequestHeaders: config.HeaderRules{Set: map[string]string{"X-A": "${client_ip"}}},
+		"host header":      {RequestHeaders: config.HeaderRules{Add: map[string]string{"host": "a"}}},
+		"unnamed query":    {Query: map[string]string{"": "a"}},
+	}
+	for name, cfg := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := compileTransform(cfg)
+			assert.Error(t, err)
+			assert.Error(t, ValidateRoute(config.Route{Transform: cfg}))
+		})
+	}
+}
+
+func TestTemplateExpand(t *testing.T) {
+	tmpl, err := compileTemplate("country=${geo_country};ip=${client_ip}$")
+	require.NoError(t, err)
+
+	vars := &requestVars{values: map[string]string{"client_ip": "10.0.0.1"}, ip: net.IPv4(10, 0, 0, 1)}
+	assert.Equal(t, "country=;ip=10.0.0.1$", tmpl.expand(vars), "without a locator the country is empty")
+
+	vars = &requestVars{values: map[string]string{"client_ip": "10.0.0.1"}, ip: net.IPv4(10, 0, 0, 1), geo: staticLocator{country: "PT"}}
+	assert.Equal(t, "country=PT;ip=10.0.0.1$", tmpl.expand(vars))
+}
@@ -0,0 +1,81 @@
+package router
+
+import (
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/upstream"
+)
+
+// poolTransport is the round tripper shared by the requests proxied to the
+// backends of a pool, together with the settings it was built from.
+type poolTransport struct {
+	upstream  config.UpstreamConfig
+	scheme    string
+	transport *upstream.Transport
+}
+
+// poolUpstream returns the upstream settings of a pool
+func (r *Router) poolUpstream(poolName string) config.UpstreamConfig {
+	pool, _ := r.poolConfig(poolName)
+	return pool.Upstream
+}
+
+// transportFor returns the transport of a pool, rebuilding it when the
+// upstream settings changed since it was created.
+func (r *Router) transportFor(poolName string) (*poolTransport, error) {
+	upstreamCfg := r.poolUpstream(poolName)
+
+	r.transportsMu.RLock()
+	current, exists := r.transports[poolName]
+	r.transportsMu.RUnlock()
+	if exists && current.upstream == upstreamCfg {
+		return current, nil
+	}
+
+	r.transportsMu.Lock()
+	defer r.transportsMu.Unlock()
+
+	// Another request may have rebuilt the transport in the meantime
+	current, exists = r.transports[poolName]
+	if exists && current.upstream == upstreamCfg {
+		return current, nil
+	}
+
+	scheme, err := upstream.Scheme(upstreamCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := upstream.NewTransport(poolName, upstreamCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		current.transport.CloseIdleConnections()
+	}
+
+	pt := &poolTransport{upstream: upstreamCfg, scheme: scheme, transport: transport}
+	if r.transports == nil {
+		r.transports = make(map[string]*poolTransport)
+	}
+	r.transports[poolName] = pt
+	return pt, nil
+}
+
+// pruneTransports closes the transports of pools that are no longer configured
+func (r *Router) pruneTransports(pools []config.Pool) {
+	wanted := make(map[string]bool, len(pools))
+	for _, pool := range pools {
+		wanted[pool.Name] = true
+	}
+
+	r.transportsMu.Lock()
+	defer r.transportsMu.Unlock()
+
+	for name, pt := range r.transports {
+		if !wanted[name] {
+			pt.transport.CloseIdleConnections()
+			delete(r.transports, name)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package router
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eltonciatto/veloflux/internal/balancer"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestProxyToTLSUpstream(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secure"))
+	}))
+	defer backend.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, caPEM, 0o600))
+
+	address := strings.TrimPrefix(backend.URL, "https://")
+	pool := config.Pool{
+		Name:      "secure",
+		Algorithm: "round_robin",
+		Backends:  []config.Backend{{Address: address, Weight: 1}},
+		Upstream: config.UpstreamConfig{
+			Scheme: config.UpstreamHTTPS,
+			TLS:    config.UpstreamTLS{CAFile: caFile},
+		},
+	}
+	bal := balancer.New()
+	bal.AddPool(pool)
+	router := &Router{
+		config:   &config.Config{Pools: []config.Pool{pool}},
+		balancer: bal,
+		logger:   zap.NewNop(),
+	}
+
+	handler := router.createProxyHandler("secure")
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4:5678"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "secure", w.Body.String())
+
+	// The transport is shared until the upstream settings change
+	first, err := router.transportFor("secure")
+	require.NoError(t, err)
+	second, err := router.transportFor("secure")
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+
+	pool.Upstream.TLS.CAFile = ""
+	router.config = &config.Config{Pools: []config.Pool{pool}}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadGateway, w.Code, "the test certificate is not trusted without the CA")
+}
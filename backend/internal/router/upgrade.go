@@ -0,0 +1,264 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+)
+
+// defaultUpgradeDrainTimeout is how long upgraded connections may outlive a
+// drain when their pool does not say otherwise
+const defaultUpgradeDrainTimeout = 10 * time.Second
+
+// Reasons upgrade requests are refused
+const (
+	upgradeRejectLimit    = "limit"
+	upgradeRejectDraining = "draining"
+)
+
+// Reasons upgraded connections end
+const (
+	upgradeClosed      = "closed"
+	upgradeIdleTimeout = "idle_timeout"
+	upgradeDrained     = "drain"
+)
+
+// isUpgrade reports whether a request asks to switch protocols, as WebSocket
+// handshakes do
+func isUpgrade(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range req.Header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeTracker counts the upgraded connections of each pool and closes
+// them when the node drains. The zero value is ready to use.
+type upgradeTracker struct {
+	mu       sync.Mutex
+	draining bool
+	pending  map[string]int // upgrade requests and upgraded connections by pool
+	conns    map[*upgradedConn]bool
+}
+
+// acquire reserves a connection of a pool for an upgrade request. It returns
+// the reason the request is refused, or an empty string.
+func (t *upgradeTracker) acquire(pool string, max int) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return upgradeRejectDraining
+	}
+	if max > 0 && t.pending[pool] >= max {
+		return upgradeRejectLimit
+	}
+	if t.pending == nil {
+		t.pending = make(map[string]int)
+	}
+	t.pending[pool]++
+	return ""
+}
+
+// release gives back a connection reserved by acquire
+func (t *upgradeTracker) release(pool string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending[pool]--
+	if t.pending[pool] <= 0 {
+		delete(t.pending, pool)
+	}
+}
+
+// track wraps the client connection of a successful upgrade
+func (t *upgradeTracker) track(conn net.Conn, pool string, cfg config.UpgradeConfig) *upgradedConn {
+	// Deadlines the server set for the handshake must not end the connection
+	conn.SetDeadline(time.Time{})
+
+	c := &upgradedConn{
+		Conn:         conn,
+		pool:         pool,
+		tracker:      t,
+		idleTimeout:  cfg.IdleTimeout,
+		drainTimeout: cfg.DrainTimeout,
+		closed:       make(chan struct{}),
+	}
+	if c.drainTimeout <= 0 {
+		c.drainTimeout = defaultUpgradeDrainTimeout
+	}
+	c.touch()
+	if c.idleTimeout > 0 {
+		go c.watchIdle()
+	}
+
+	t.mu.Lock()
+	if t.conns == nil {
+		t.conns = make(map[*upgradedConn]bool)
+	}
+	t.conns[c] = true
+	draining := t.draining
+	t.mu.Unlock()
+
+	metrics.UpgradedConnections.WithLabelValues(pool).Inc()
+	if draining {
+		// Upgraded after the drain started
+		time.AfterFunc(c.drainTimeout, func() { c.closeWith(upgradeDrained) })
+	}
+	return c
+}
+
+func (t *upgradeTracker) untrack(c *upgradedConn) {
+	t.mu.Lock()
+	delete(t.conns, c)
+	t.mu.Unlock()
+	metrics.UpgradedConnections.WithLabelValues(c.pool).Dec()
+}
+
+// drain refuses further upgrades and returns the open connections
+func (t *upgradeTracker) drain() []*upgradedConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.draining = true
+	conns := make([]*upgradedConn, 0, len(t.conns))
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// upgradedConn is the client side of an upgraded connection. Traffic in both
+// directions passes through it, which makes it the place to enforce the idle
+// timeout and to close the tunnel when the node drains; closing it also ends
+// the backend side.
+type upgradedConn struct {
+	net.Conn
+	pool         string
+	tracker      *upgradeTracker
+	idleTimeout  time.Duration
+	drainTimeout time.Duration
+	lastActive   atomic.Int64 // unix nanoseconds of the last read or write
+	closeOnce    sync.Once
+	closed       chan struct{}
+}
+
+func (c *upgradedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *upgradedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *upgradedConn) Close() error {
+	return c.closeWith(upgradeClosed)
+}
+
+func (c *upgradedConn) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// watchIdle closes the connection once it saw no traffic for the idle timeout
+func (c *upgradedConn) watchIdle() {
+	timer := time.NewTimer(c.idleTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-timer.C:
+		}
+		idle := time.Since(time.Unix(0, c.lastActive.Load()))
+		if idle >= c.idleTimeout {
+			c.closeWith(upgradeIdleTimeout)
+			return
+		}
+		timer.Reset(c.idleTimeout - idle)
+	}
+}
+
+// closeWith closes the connection, recording why it ended
+func (c *upgradedConn) closeWith(reason string) error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		err = c.Conn.Close()
+		close(c.closed)
+		c.tracker.untrack(c)
+		metrics.UpgradedConnectionsClosedTotal.WithLabelValues(c.pool, reason).Inc()
+	})
+	return err
+}
+
+// upgradeWriter hands the client connection of an upgrade to the reverse
+// proxy wrapped in an upgradedConn
+type upgradeWriter struct {
+	http.ResponseWriter
+	tracker *upgradeTracker
+	pool    string
+	cfg     config.UpgradeConfig
+}
+
+func (w *upgradeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return w.tracker.track(conn, w.pool, w.cfg), brw, nil
+}
+
+func (w *upgradeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// DrainUpgrades refuses new upgrade requests and closes the upgraded
+// connections once the drain timeout of their pool or ctx expires, unless
+// they end on their own first. It returns when all of them are closed.
+// Connections upgraded by HTTP servers are not waited for by their Shutdown,
+// so it runs when the node drains as well as on shutdown.
+func (r *Router) DrainUpgrades(ctx context.Context) {
+	conns := r.upgrades.drain()
+
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *upgradedConn) {
+			defer wg.Done()
+			timer := time.NewTimer(c.drainTimeout)
+			defer timer.Stop()
+
+			select {
+			case <-c.closed:
+				return
+			case <-timer.C:
+			case <-ctx.Done():
+			}
+			c.closeWith(upgradeDrained)
+		}(c)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,193 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/balancer"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// upgradeEchoServer switches to an echo protocol on upgrade requests
+func upgradeEchoServer(t *testing.T) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isUpgrade(r) {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		brw.Flush()
+		io.Copy(conn, brw)
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// upgradeProxy serves a route to an upgrade echo pool through the router
+// middleware and returns the proxy address
+func upgradeProxy(t *testing.T, pool string, cfg config.UpgradeConfig) (*Router, string) {
+	pools := []config.Pool{{Name: pool, Backends: []config.Backend{{Address: upgradeEchoServer(t)}}, Upgrades: cfg}}
+	bal := balancer.New()
+	bal.AddPool(pools[0])
+	router := &Router{
+		config:   &config.Config{Pools: pools},
+		balancer: bal,
+		logger:   zap.NewNop(),
+	}
+
+	// The server timeouts must not end upgraded connections
+	server := httptest.NewUnstartedServer(router.middleware(router.createRouteHandler(config.Route{ID: pool, Pool: pool})))
+	server.Config.ReadTimeout = 200 * time.Millisecond
+	server.Config.WriteTimeout = 200 * time.Millisecond
+	server.Start()
+	t.Cleanup(server.Close)
+	return router, strings.TrimPrefix(server.URL, "http://")
+}
+
+// dialUpgrade performs an upgrade handshake and returns the connection
+func dialUpgrade(t *testing.T, addr string) (net.Conn, *bufio.Reader, int) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	return conn, reader, resp.StatusCode
+}
+
+func echo(t *testing.T, conn net.Conn, reader *bufio.Reader, message string) string {
+	_, err := conn.Write([]byte(message))
+	require.NoError(t, err)
+	buf := make([]byte, len(message))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(reader, buf)
+	require.NoError(t, err)
+	return string(buf)
+}
+
+// waitClosed waits until the proxy closes the connection
+func waitClosed(t *testing.T, conn net.Conn, reader *bufio.Reader) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestUpgradeProxying(t *testing.T) {
+	_, addr := upgradeProxy(t, "ws-proxy", config.UpgradeConfig{})
+	switched := metrics.RouteRequestsTotal.WithLabelValues(unmatchedRoute, "101")
+	before := testutil.ToFloat64(switched)
+
+	conn, reader, status := dialUpgrade(t, addr)
+	require.Equal(t, http.StatusSwitchingProtocols, status)
+	assert.Equal(t, "ping", echo(t, conn, reader, "ping"))
+
+	// The connection outlives the server read and write timeouts
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, "pong", echo(t, conn, reader, "pong"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.UpgradedConnections.WithLabelValues("ws-proxy")))
+
+	conn.Close()
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.UpgradedConnections.WithLabelValues("ws-proxy")) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.UpgradedConnectionsClosedTotal.WithLabelValues("ws-proxy", upgradeClosed)))
+	assert.Equal(t, before+1, testutil.ToFloat64(switched))
+}
+
+func TestUpgradeLimit(t *testing.T) {
+	_, addr := upgradeProxy(t, "ws-limit", config.UpgradeConfig{MaxConnections: 1})
+
+	conn, reader, status := dialUpgrade(t, addr)
+	require.Equal(t, http.StatusSwitchingProtocols, status)
+
+	_, _, status = dialUpgrade(t, addr)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.UpgradeRejectedTotal.WithLabelValues("ws-limit", upgradeRejectLimit)))
+
+	// Closing the first connection frees its slot
+	assert.Equal(t, "ping", echo(t, conn, reader, "ping"))
+	conn.Close()
+	assert.Eventually(t, func() bool {
+		c, _, status := dialUpgrade(t, addr)
+		c.Close()
+		return status == http.StatusSwitchingProtocols
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestUpgradeIdleTimeout(t *testing.T) {
+	_, addr := upgradeProxy(t, "ws-idle", config.UpgradeConfig{IdleTimeout: 300 * time.Millisecond})
+
+	conn, reader, status := dialUpgrade(t, addr)
+	require.Equal(t, http.StatusSwitchingProtocols, status)
+
+	// Traffic keeps the connection open
+	for i := 0; i < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, "ping", echo(t, conn, reader, "ping"))
+	}
+
+	waitClosed(t, conn, reader)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.UpgradedConnectionsClosedTotal.WithLabelValues("ws-idle", upgradeIdleTimeout)))
+}
+
+func TestDrainUpgrades(t *testing.T) {
+	router, addr := upgradeProxy(t, "ws-drain", config.UpgradeConfig{DrainTimeout: 100 * time.Millisecond})
+
+	conn, reader, status := dialUpgrade(t, addr)
+	require.Equal(t, http.StatusSwitchingProtocols, status)
+
+	start := time.Now()
+	router.DrainUpgrades(context.Background())
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	waitClosed(t, conn, reader)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.UpgradedConnectionsClosedTotal.WithLabelValues("ws-drain", upgradeDrained)))
+
+	_, _, status = dialUpgrade(t, addr)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.UpgradeRejectedTotal.WithLabelValues("ws-drain", upgradeRejectDraining)))
+}
+
+func TestIsUpgrade(t *testing.T) {
+	tests := []struct {
+		connection string
+		upgrade    string
+		want       bool
+	}{
+		{"Upgrade", "websocket", true},
+		{"keep-alive, Upgrade", "websocket", true},
+		{"upgrade", "h2c", true},
+		{"keep-alive", "websocket", false},
+		{"Upgrade", "", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Connection", tt.connection)
+		if tt.upgrade != "" {
+			req.Header.Set("Upgrade", tt.upgrade)
+		}
+		assert.Equal(t, tt.want, isUpgrade(req), "%s / %s", tt.connection, tt.upgrade)
+		if tt.want {
+			assert.False(t, hedgeable(req))
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Listeners labelling client connection metrics
+const (
+	listenerHTTP  = "http"
+	listenerHTTPS = "https"
+	listenerQUIC  = "quic"
+)
+
+// newHTTP3Server returns the HTTP/3 server of a configuration. TLS settings
+// come from getConfig for every handshake, so it serves the certificates,
+// session ticket keys and client certificate requests of the HTTPS server.
+func newHTTP3Server(cfg config.HTTP3Config, handler http.Handler, getConfig func(*tls.ClientHelloInfo) (*tls.Config, error)) *http3.Server {
+	return &http3.Server{
+		Addr:        cfg.Address,
+		Port:        cfg.AdvertisedPort,
+		Handler:     handler,
+		TLSConfig:   &tls.Config{GetConfigForClient: getConfig},
+		IdleTimeout: cfg.IdleTimeout,
+		ConnContext: countQUICConnection,
+	}
+}
+
+// quicConfigForClient returns the TLS settings of the HTTPS server for a QUIC
+// handshake, a copy so rotated session ticket keys are picked up
+func (s *Server) quicConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if tlsConfig, err := s.configForClient(hello); tlsConfig != nil || err != nil {
+		return tlsConfig, err
+	}
+	return s.httpsServer.TLSConfig.Clone(), nil
+}
+
+// advertiseHTTP3 announces the HTTP/3 listener in the responses of the
+// listeners clients reach over TCP
+func advertiseHTTP3(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor < 3 {
+			// Nothing is announced until the listener is up
+			h3.SetQUICHeaders(w.Header())
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// countConnections returns the connection state hook of an HTTP server
+// counting the connections of a listener
+func countConnections(listener string) func(net.Conn, http.ConnState) {
+	total := metrics.DownstreamConnectionsTotal.WithLabelValues(listener)
+	open := metrics.DownstreamOpenConnections.WithLabelValues(listener)
+	return func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			total.Inc()
+			open.Inc()
+		case http.StateClosed, http.StateHijacked:
+			open.Dec()
+		}
+	}
+}
+
+// countQUICConnection counts a QUIC connection until it closes
+func countQUICConnection(ctx context.Context, conn *quic.Conn) context.Context {
+	metrics.DownstreamConnectionsTotal.WithLabelValues(listenerQUIC).Inc()
+	open := metrics.DownstreamOpenConnections.WithLabelValues(listenerQUIC)
+	open.Inc()
+	go func() {
+		<-conn.Context().Done()
+		open.Dec()
+	}()
+	return ctx
+}
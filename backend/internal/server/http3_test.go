@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfSigned returns a certificate for localhost and the pool trusting it
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, roots
+}
+
+func TestHTTP3Server(t *testing.T) {
+	cert, roots := selfSigned(t)
+	s := &Server{httpsServer: &http.Server{TLSConfig: &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}}}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Proto, r.Host)
+	})
+	h3 := newHTTP3Server(config.HTTP3Config{IdleTimeout: time.Minute}, handler, s.quicConfigForClient)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	go h3.Serve(conn)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		h3.Shutdown(ctx)
+	})
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	connections := testutil.ToFloat64(metrics.DownstreamConnectionsTotal.WithLabelValues(listenerQUIC))
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	defer transport.Close()
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("https://localhost:%d/", port))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, fmt.Sprintf("HTTP/3.0 localhost:%d", port), string(body))
+	assert.Equal(t, connections+1, testutil.ToFloat64(metrics.DownstreamConnectionsTotal.WithLabelValues(listenerQUIC)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.DownstreamOpenConnections.WithLabelValues(listenerQUIC)))
+
+	// HTTPS responses announce the listener, HTTP/3 ones do not need to
+	advertised := advertiseHTTP3(h3, handler)
+	w := httptest.NewRecorder()
+	advertised.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://localhost/", nil))
+	assert.Equal(t, fmt.Sprintf(`h3=":%d"; ma=2592000`, port), w.Header().Get("Alt-Svc"))
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "https://localhost/", nil)
+	req.ProtoMajor = 3
+	advertised.ServeHTTP(w, req)
+	assert.Empty(t, w.Header().Get("Alt-Svc"))
+
+	transport.Close()
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.DownstreamOpenConnections.WithLabelValues(listenerQUIC)) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestQUICConfigForClient(t *testing.T) {
+	s := &Server{httpsServer: &http.Server{TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12}}}
+
+	tlsConfig, err := s.quicConfigForClient(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	require.NotNil(t, tlsConfig)
+	assert.NotSame(t, s.httpsServer.TLSConfig, tlsConfig)
+	assert.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+
+	// Client certificates are asked for over QUIC as over TCP
+	s.clientCerts.Store(true)
+	tlsConfig, err = s.quicConfigForClient(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequestClientCert, tlsConfig.ClientAuth)
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"net"
+
+	"github.com/eltonciatto/veloflux/internal/proxyproto"
+)
+
+// listen binds the TCP address of an HTTP listener. With the PROXY protocol
+// enabled, trusted proxies such as cloud L4 balancers may start connections
+// with the address of the client they forward.
+func (s *Server) listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil || !s.config.Load().Global.ClientIP.ProxyProtocol {
+		return ln, err
+	}
+	return &proxyproto.Listener{Listener: ln, Trusted: s.trustsProxy}, nil
+}
+
+// trustsProxy reports whether a peer is a trusted proxy
+func (s *Server) trustsProxy(addr net.Addr) bool {
+	return s.trusted.Load().TrustsAddr(addr)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/eltonciatto/veloflux/internal/clientip"
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenProxyProtocol(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Global.ClientIP.ProxyProtocol = true
+	s := &Server{}
+	s.config.Store(cfg)
+	trusted, err := clientip.New([]string{"127.0.0.1"})
+	require.NoError(t, err)
+	s.trusted.Store(trusted)
+
+	ln, err := s.listen("127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RemoteAddr)
+	})}
+	go server.Serve(ln)
+	defer server.Close()
+
+	get := func(header string) string {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		fmt.Fprintf(conn, "%sGET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n", header)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	assert.Equal(t, "192.0.2.1:56324", get("PROXY TCP4 192.0.2.1 198.51.100.7 56324 80\r\n"))
+	assert.Contains(t, get(""), "127.0.0.1:")
+
+	// Peers that are not trusted proxies cannot send a header
+	s.trusted.Store(nil)
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprint(conn, "PROXY TCP4 192.0.2.1 198.51.100.7 56324 80\r\nGET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -7,7 +7,11 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eltonciatto/veloflux/internal/admin"
@@ -15,32 +19,53 @@ import (
 	"github.com/eltonciatto/veloflux/internal/auth"
 	"github.com/eltonciatto/veloflux/internal/balancer"
 	"github.com/eltonciatto/veloflux/internal/billing"
+	"github.com/eltonciatto/veloflux/internal/certs"
+	"github.com/eltonciatto/veloflux/internal/clientip"
 	"github.com/eltonciatto/veloflux/internal/clustering"
 	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/domains"
+	"github.com/eltonciatto/veloflux/internal/drain"
 	"github.com/eltonciatto/veloflux/internal/geo"
 	"github.com/eltonciatto/veloflux/internal/health"
+	"github.com/eltonciatto/veloflux/internal/l4"
 	"github.com/eltonciatto/veloflux/internal/metrics"
 	"github.com/eltonciatto/veloflux/internal/orchestration"
+	"github.com/eltonciatto/veloflux/internal/rollout"
 	"github.com/eltonciatto/veloflux/internal/router"
 	"github.com/eltonciatto/veloflux/internal/tenant"
 	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/quic-go/quic-go/http3"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 type Server struct {
-	config         *config.Config
+	config         atomic.Pointer[config.Config] // replaced as a whole by Reload, never modified
+	configPath     string
+	reloadMu       sync.Mutex
 	logger         *zap.Logger
 	balancer       *balancer.Balancer
 	router         *router.Router
 	healthCheck    *health.Checker
 	httpServer     *http.Server
 	httpsServer    *http.Server
+	http3Server    *http3.Server
 	metricsServer  *http.Server
+	listeners      *l4.Manager
+	certStore      *certs.Store
+	domains        *domains.Registry
+	tickets        *certs.TicketKeys
+	clientCerts    atomic.Bool                       // whether TLS clients are asked for certificates
+	trusted        atomic.Pointer[clientip.Resolver] // proxies whose PROXY protocol headers are read
 	apiServer      *api.API
 	adminServer    *admin.Server
 	cluster        *clustering.Cluster
 	geoManager     *geo.Manager
+	rollouts       *rollout.Controller
 	billingManager *billing.BillingManager
 	oidcManager    *auth.OIDCManager
 	orchestrator   *orchestration.Orchestrator
@@ -90,6 +115,9 @@ func New(cfg *config.Config, logger *zap.Logger) (*Server, error) {
 		bal.AddPool(pool)
 	}
 
+	// Share sticky session bindings between nodes
+	bal.SetAffinityStore(balancer.NewRedisAffinityStore(redisClient))
+
 	// Set geo manager in balancer if available
 	if geoManager != nil {
 		bal.SetGeoManager(geoManager)
@@ -169,17 +197,24 @@ func New(cfg *config.Config, logger *zap.Logger) (*Server, error) {
 	}
 	// Create router
 	rtr := router.New(cfg, bal, nodeID, logger)
+	if geoManager != nil {
+		rtr.SetGeoLocator(geoManager)
+	}
 
 	// Create health checker
 	healthChecker := health.New(cfg, logger, bal)
+	rtr.SetPassiveHealthChecker(healthChecker)
+	healthChecker.Watch(bal)
 
-	// Create HTTP servers
+	// Create HTTP servers. The cleartext listener also accepts HTTP/2
+	// without TLS, which gRPC clients use when they do not negotiate it.
 	httpServer := &http.Server{
 		Addr:         cfg.Global.BindAddress,
-		Handler:      rtr,
+		Handler:      h2c.NewHandler(rtr, &http2.Server{}),
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		ConnState:    countConnections(listenerHTTP),
 	}
 
 	httpsServer := &http.Server{
@@ -188,20 +223,57 @@ func New(cfg *config.Config, logger *zap.Logger) (*Server, error) {
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		ConnState:    countConnections(listenerHTTPS),
 	}
 
-	// Setup TLS if enabled
-	if cfg.Global.TLS.AutoCert {
-		certManager := &autocert.Manager{
-			Prompt: autocert.AcceptTOS,
-			Email:  cfg.Global.TLS.ACMEEmail,
-			Cache:  autocert.DirCache(cfg.Global.TLS.CertDir),
+	// Setup TLS if enabled. Certificate files are served for the names they
+	// cover; ACME issues certificates for the other names, only for the
+	// custom domains tenants registered when those are enabled.
+	var certStore *certs.Store
+	if certs.Configured(cfg.Global.TLS) {
+		certStore, err = certs.NewStore(cfg.Global.TLS, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificates: %w", err)
 		}
-
-		httpsServer.TLSConfig = &tls.Config{
-			GetCertificate: certManager.GetCertificate,
-			NextProtos:     []string{"h2", "http/1.1"},
+	}
+	var domainRegistry *domains.Registry
+	if cfg.Global.TLS.CustomDomains {
+		domainRegistry = domains.New(redisClient, logger)
+		if err := domainRegistry.Refresh(ctx); err != nil {
+			return nil, fmt.Errorf("failed to load custom domains: %w", err)
+		}
+		rtr.SetDomainResolver(domainRegistry)
+	}
+	var certManager *autocert.Manager
+	if cfg.Global.TLS.AutoCert || domainRegistry != nil {
+		var policy autocert.HostPolicy
+		if domainRegistry != nil {
+			policy = domainRegistry.HostPolicy
+		}
+		certManager, err = certs.NewManager(cfg.Global.TLS, redisClient, policy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up ACME: %w", err)
 		}
+		// HTTP-01 challenges are answered on the cleartext listener
+		httpServer.Handler = certManager.HTTPHandler(httpServer.Handler)
+	}
+	var tickets *certs.TicketKeys
+	if getCertificate := certs.Source(certStore, certManager); getCertificate != nil {
+		tlsConfig, err := certs.ServerConfig(cfg.Global.TLS, getCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS settings: %w", err)
+		}
+		if certManager != nil {
+			tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+		}
+		// Nodes share session ticket keys so sessions resume on any of them
+		if interval := cfg.Global.TLS.SessionTicketRotation; interval > 0 {
+			tickets = certs.NewTicketKeys(redisClient, interval, logger)
+			if err := tickets.Update(ctx, tlsConfig); err != nil {
+				return nil, fmt.Errorf("failed to load TLS session ticket keys: %w", err)
+			}
+		}
+		httpsServer.TLSConfig = tlsConfig
 	}
 
 	// Metrics server
@@ -219,8 +291,18 @@ func New(cfg *config.Config, logger *zap.Logger) (*Server, error) {
 	// Create Admin server
 	adminServer := admin.New(cfg, bal, clusterManager, logger)
 
-	return &Server{
-		config:         cfg,
+	// TCP and UDP listeners share the pools, health checks and drain state of
+	// the HTTP router, whose upgraded connections close when the node drains
+	drainer := drain.New(redisClient, nodeID)
+	drainer.OnDrain(rtr.DrainUpgrades)
+	listeners := l4.New(bal, drainer, logger)
+	trusted, err := clientip.New(cfg.Global.ClientIP.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	listeners.SetTrustedProxies(trusted)
+
+	srv := &Server{
 		logger:         logger,
 		balancer:       bal,
 		router:         rtr,
@@ -231,14 +313,143 @@ func New(cfg *config.Config, logger *zap.Logger) (*Server, error) {
 		oidcManager:    oidcManager,
 		orchestrator:   orchestrator,
 		metricsServer:  metricsServer,
+		listeners:      listeners,
 		apiServer:      apiServer,
 		adminServer:    adminServer,
 		cluster:        clusterManager,
 		geoManager:     geoManager,
-	}, nil
+		certStore:      certStore,
+		domains:        domainRegistry,
+		tickets:        tickets,
+	}
+	srv.config.Store(cfg)
+	srv.trusted.Store(trusted)
+
+	// Routes verify client certificates against their own CAs, so the
+	// handshake only asks for them
+	if httpsServer.TLSConfig != nil {
+		srv.clientCerts.Store(router.RequestsClientCertificates(cfg.Routes))
+		httpsServer.TLSConfig.GetConfigForClient = srv.configForClient
+	}
+
+	// HTTP/3 shares the routes and certificates of the HTTPS server
+	if cfg.Global.HTTP3.Enabled {
+		if httpsServer.TLSConfig == nil {
+			logger.Warn("HTTP/3 needs TLS certificates and is not started")
+		} else {
+			srv.http3Server = newHTTP3Server(cfg.Global.HTTP3, rtr, srv.quicConfigForClient)
+			httpsServer.Handler = advertiseHTTP3(srv.http3Server, httpsServer.Handler)
+		}
+	}
+
+	// Drive progressive rollouts from the request metrics of this node
+	rollouts := rollout.New(redisClient, rtr, rollout.NewGathererSource(prometheus.DefaultGatherer), logger)
+	if clusterManager != nil {
+		rollouts.SetLeader(clusterManager)
+	}
+	srv.rollouts = rollouts
+
+	apiServer.SetReloader(srv)
+	apiServer.SetSplitController(rtr)
+	apiServer.SetRolloutController(rollouts)
+	apiServer.SetHealthStatusProvider(healthChecker)
+	if domainRegistry != nil {
+		apiServer.SetDomainRegistry(domainRegistry)
+	}
+
+	return srv, nil
+}
+
+// SetConfigPath sets the file the configuration is re-read from on Reload.
+func (s *Server) SetConfigPath(path string) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	s.configPath = path
+}
+
+// Reload re-reads the configuration file and applies pool, backend, route,
+// health check and TCP/UDP listener changes to the running server without
+// restarting the HTTP listeners. HTTP listener addresses, TLS, WAF and rate
+// limit settings still require a restart.
+//
+// Everything that can reject the new configuration is built before any of
+// it is served, so a failed reload leaves the running state untouched. The
+// previous configuration is never modified: components that read it keep a
+// consistent copy until the new one is swapped in.
+func (s *Server) Reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	if s.configPath == "" {
+		return fmt.Errorf("configuration path not set")
+	}
+
+	cfg, err := config.Load(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	trusted, err := clientip.New(cfg.Global.ClientIP.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	applyRoutes, err := s.router.Prepare(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	current := s.config.Load()
+	if cfg.Global.BindAddress != current.Global.BindAddress ||
+		cfg.Global.TLSBindAddress != current.Global.TLSBindAddress ||
+		cfg.Global.ClientIP.ProxyProtocol != current.Global.ClientIP.ProxyProtocol ||
+		!reflect.DeepEqual(cfg.Global.TLS, current.Global.TLS) {
+		s.logger.Warn("Listener and TLS changes are not applied until restart")
+	}
+
+	// Pools are loaded before the routes sending requests to them
+	s.balancer.ReloadPools(cfg.Pools)
+	applyRoutes()
+	s.clientCerts.Store(router.RequestsClientCertificates(cfg.Routes))
+	s.trusted.Store(trusted)
+	s.listeners.SetTrustedProxies(trusted)
+	s.healthCheck.Reload(cfg)
+	if err := s.listeners.Reload(cfg.Listeners); err != nil {
+		s.logger.Error("Failed to start listeners", zap.Error(err))
+	}
+	s.config.Store(cfg)
+	if s.apiServer != nil {
+		s.apiServer.UpdateConfig(cfg)
+	}
+
+	s.logger.Info("Configuration reloaded",
+		zap.String("path", s.configPath),
+		zap.Int("pools", len(cfg.Pools)),
+		zap.Int("routes", len(cfg.Routes)))
+
+	return nil
+}
+
+// configForClient returns the TLS settings of a handshake. ServeTLS copies
+// the server's configuration when the listener starts, so handshakes get a
+// fresh copy carrying what changed since: the session ticket keys Rotate
+// sets, and a request for client certificates while a route verifies them.
+// Handshakes otherwise use the listener's copy as is, which keeps the per
+// node ticket keys it generated.
+func (s *Server) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	clientCerts := s.clientCerts.Load()
+	if !clientCerts && s.tickets == nil {
+		return nil, nil
+	}
+	tlsConfig := s.httpsServer.TLSConfig.Clone()
+	if clientCerts {
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	}
+	return tlsConfig, nil
 }
 
 func (s *Server) Start(ctx context.Context) error {
+	cfg := s.config.Load()
+
 	// Start clustering if enabled
 	if s.cluster != nil {
 		if err := s.cluster.Start(ctx); err != nil {
@@ -247,12 +458,17 @@ func (s *Server) Start(ctx context.Context) error {
 		}
 
 		// Set node address for cluster discovery
-		s.cluster.SetNodeAddress(s.config.Global.BindAddress)
+		s.cluster.SetNodeAddress(cfg.Global.BindAddress)
 	}
 
 	// Start health checker
 	s.healthCheck.Start(ctx)
 
+	// Start rollout controller
+	if s.rollouts != nil {
+		go s.rollouts.Run(ctx)
+	}
+
 	// Start API server
 	if s.apiServer != nil {
 		if err := s.apiServer.Start(); err != nil {
@@ -269,7 +485,7 @@ func (s *Server) Start(ctx context.Context) error {
 
 	// Start metrics server
 	go func() {
-		s.logger.Info("Starting metrics server", zap.String("address", s.config.Global.MetricsAddress))
+		s.logger.Info("Starting metrics server", zap.String("address", cfg.Global.MetricsAddress))
 		if err := s.metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			s.logger.Error("Metrics server error", zap.Error(err))
 		}
@@ -277,21 +493,54 @@ func (s *Server) Start(ctx context.Context) error {
 
 	// Start HTTP server
 	go func() {
-		s.logger.Info("Starting HTTP server", zap.String("address", s.config.Global.BindAddress))
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		s.logger.Info("Starting HTTP server", zap.String("address", cfg.Global.BindAddress))
+		ln, err := s.listen(cfg.Global.BindAddress)
+		if err == nil {
+			err = s.httpServer.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			s.logger.Error("HTTP server error", zap.Error(err))
 		}
 	}()
 
+	// Start TCP and UDP listeners
+	if err := s.listeners.Start(cfg.Listeners); err != nil {
+		s.logger.Error("Failed to start listeners", zap.Error(err))
+	}
+
 	// Start HTTPS server if TLS is configured
-	if s.config.Global.TLS.AutoCert {
+	if s.certStore != nil {
+		go s.certStore.Watch(ctx)
+		if cfg.Global.TLS.OCSPStapling {
+			go s.certStore.WatchOCSP(ctx)
+		}
+	}
+	if s.tickets != nil {
+		go s.tickets.Rotate(ctx, s.httpsServer.TLSConfig)
+	}
+	if s.domains != nil {
+		go s.domains.Watch(ctx, domains.DefaultRefreshInterval)
+	}
+	if s.httpsServer.TLSConfig != nil {
 		go func() {
-			s.logger.Info("Starting HTTPS server", zap.String("address", s.config.Global.TLSBindAddress))
-			if err := s.httpsServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			s.logger.Info("Starting HTTPS server", zap.String("address", cfg.Global.TLSBindAddress))
+			ln, err := s.listen(cfg.Global.TLSBindAddress)
+			if err == nil {
+				err = s.httpsServer.ServeTLS(ln, "", "")
+			}
+			if err != nil && err != http.ErrServerClosed {
 				s.logger.Error("HTTPS server error", zap.Error(err))
 			}
 		}()
 	}
+	if s.http3Server != nil {
+		go func() {
+			s.logger.Info("Starting HTTP/3 server", zap.String("address", cfg.Global.HTTP3.Address))
+			if err := s.http3Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				s.logger.Error("HTTP/3 server error", zap.Error(err))
+			}
+		}()
+	}
 
 	// Block until context is cancelled
 	<-ctx.Done()
@@ -328,6 +577,18 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		s.geoManager.Close()
 	}
 
+	// Upgraded connections are not waited for by the HTTP servers
+	upgradesDrained := make(chan struct{})
+	go func() {
+		s.router.DrainUpgrades(ctx)
+		close(upgradesDrained)
+	}()
+	listenersDrained := make(chan struct{})
+	go func() {
+		s.listeners.Shutdown(ctx)
+		close(listenersDrained)
+	}()
+
 	// Shutdown HTTP servers
 	if err := s.httpServer.Shutdown(ctx); err != nil {
 		s.logger.Error("Error shutting down HTTP server", zap.Error(err))
@@ -337,9 +598,18 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		s.logger.Error("Error shutting down HTTPS server", zap.Error(err))
 	}
 
+	if s.http3Server != nil {
+		if err := s.http3Server.Shutdown(ctx); err != nil {
+			s.logger.Error("Error shutting down HTTP/3 server", zap.Error(err))
+		}
+	}
+
 	if err := s.metricsServer.Shutdown(ctx); err != nil {
 		s.logger.Error("Error shutting down metrics server", zap.Error(err))
 	}
 
+	<-upgradesDrained
+	<-listenersDrained
+
 	return nil
 }
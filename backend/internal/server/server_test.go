@@ -0,0 +1,65 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/certs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSessionTicketRotation(t *testing.T) {
+	cert, roots := selfSigned(t)
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	tlsConfig.SetSessionTicketKeys([][32]byte{{1}})
+	s := &Server{
+		httpsServer: &http.Server{
+			TLSConfig: tlsConfig,
+			Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		},
+		tickets: certs.NewTicketKeys(nil, time.Hour, zap.NewNop()),
+	}
+	tlsConfig.GetConfigForClient = s.configForClient
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.httpsServer.ServeTLS(ln, "", "")
+	defer s.httpsServer.Close()
+	url := fmt.Sprintf("https://localhost:%d/", ln.Addr().(*net.TCPAddr).Port)
+
+	// get connects with a session cache, reporting whether the session resumed
+	get := func(cache tls.ClientSessionCache) bool {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, ClientSessionCache: cache},
+			DisableKeepAlives: true,
+		}}
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.TLS.DidResume
+	}
+
+	before := tls.NewLRUClientSessionCache(1)
+	assert.False(t, get(before))
+	assert.True(t, get(before))
+
+	// Rotate sets the keys on the server's configuration, which the running
+	// listener must pick up: tickets of the former key no longer decrypt
+	tlsConfig.SetSessionTicketKeys([][32]byte{{2}})
+	assert.False(t, get(before))
+
+	// while tickets issued after the rotation are encrypted with the new key,
+	// which still decrypts them once it is the previous one
+	after := tls.NewLRUClientSessionCache(1)
+	assert.False(t, get(after))
+	tlsConfig.SetSessionTicketKeys([][32]byte{{3}, {2}})
+	assert.True(t, get(after))
+}
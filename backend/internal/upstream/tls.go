@@ -0,0 +1,91 @@
+// Package upstream builds the client side of connections from VeloFlux to
+// pool backends.
+package upstream
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+)
+
+// Scheme returns the scheme used to reach the backends of a pool
+func Scheme(cfg config.UpstreamConfig) (string, error) {
+	switch cfg.Scheme {
+	case "", config.UpstreamHTTP:
+		return config.UpstreamHTTP, nil
+	case config.UpstreamHTTPS:
+		return config.UpstreamHTTPS, nil
+	case config.UpstreamH2C:
+		return config.UpstreamHTTP, nil
+	default:
+		return "", fmt.Errorf("unsupported upstream scheme: %s", cfg.Scheme)
+	}
+}
+
+// UsesTLS reports whether connections to the backends of a pool are encrypted
+func UsesTLS(cfg config.UpstreamConfig) bool {
+	return cfg.Scheme == config.UpstreamHTTPS
+}
+
+// UsesHTTP2 reports whether requests to the backends of a pool can be sent
+// over HTTP/2. Over TLS the backends still have to negotiate it.
+func UsesHTTP2(cfg config.UpstreamConfig) bool {
+	return cfg.Scheme == config.UpstreamH2C || (UsesTLS(cfg) && !cfg.DisableHTTP2)
+}
+
+// ClientTLSConfig builds the TLS configuration used to dial backends. When no
+// server name is configured the caller is expected to use the backend host.
+func ClientTLSConfig(cfg config.UpstreamTLS) (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		MinVersion:         minVersion,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upstream CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in upstream CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load upstream client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return tls.VersionTLS12, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version: %s", version)
+	}
+}
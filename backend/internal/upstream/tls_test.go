@@ -0,0 +1,158 @@
+package upstream
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// issue creates a certificate signed by parent, or a self-signed CA when parent is nil
+func issue(t *testing.T, template *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	signer, signerKey := template, any(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+func TestClientTLSConfigMutualTLS(t *testing.T) {
+	now := time.Now()
+	ca := issue(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "veloflux test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	server := issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "backend.internal"},
+		DNSNames:     []string{"backend.internal"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+	client := issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "veloflux"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.Leaf)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	backend.TLS = &tls.Config{
+		Certificates: []tls.Certificate{server},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	backend.StartTLS()
+	defer backend.Close()
+
+	dir := t.TempDir()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(client.PrivateKey)
+	require.NoError(t, err)
+	cfg := config.UpstreamTLS{
+		CAFile:     writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.Leaf.Raw),
+		CertFile:   writePEM(t, dir, "client.pem", "CERTIFICATE", client.Leaf.Raw),
+		KeyFile:    writePEM(t, dir, "client-key.pem", "PRIVATE KEY", keyDER),
+		ServerName: "backend.internal",
+		MinVersion: "1.3",
+	}
+
+	tlsConfig, err := ClientTLSConfig(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := httpClient.Get("https://127.0.0.1:" + port + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Without a client certificate the backend rejects the handshake
+	cfg.CertFile, cfg.KeyFile = "", ""
+	tlsConfig, err = ClientTLSConfig(cfg)
+	require.NoError(t, err)
+	httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	_, err = httpClient.Get("https://127.0.0.1:" + port + "/")
+	assert.Error(t, err)
+}
+
+func TestClientTLSConfigErrors(t *testing.T) {
+	_, err := ClientTLSConfig(config.UpstreamTLS{MinVersion: "1.4"})
+	assert.Error(t, err)
+
+	_, err = ClientTLSConfig(config.UpstreamTLS{CAFile: "/nonexistent.pem"})
+	assert.Error(t, err)
+
+	_, err = ClientTLSConfig(config.UpstreamTLS{CertFile: "/nonexistent.pem"})
+	assert.Error(t, err)
+
+	tlsConfig, err := ClientTLSConfig(config.UpstreamTLS{})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+}
+
+func TestScheme(t *testing.T) {
+	scheme, err := Scheme(config.UpstreamConfig{})
+	assert.NoError(t, err)
+	assert.Equal(t, "http", scheme)
+
+	scheme, err = Scheme(config.UpstreamConfig{Scheme: "https"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https", scheme)
+	assert.True(t, UsesTLS(config.UpstreamConfig{Scheme: "https"}))
+
+	// h2c speaks HTTP/2 over cleartext connections
+	scheme, err = Scheme(config.UpstreamConfig{Scheme: config.UpstreamH2C})
+	assert.NoError(t, err)
+	assert.Equal(t, "http", scheme)
+	assert.False(t, UsesTLS(config.UpstreamConfig{Scheme: config.UpstreamH2C}))
+
+	assert.True(t, UsesHTTP2(config.UpstreamConfig{Scheme: config.UpstreamH2C}))
+	assert.True(t, UsesHTTP2(config.UpstreamConfig{Scheme: "https"}))
+	assert.False(t, UsesHTTP2(config.UpstreamConfig{Scheme: "https", DisableHTTP2: true}))
+	assert.False(t, UsesHTTP2(config.UpstreamConfig{}))
+
+	_, err = Scheme(config.UpstreamConfig{Scheme: "ftp"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,164 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"golang.org/x/net/http2"
+)
+
+// Transport defaults, tuned for a proxy that talks to a small set of hosts
+const (
+	DefaultMaxIdleConns        = 512
+	DefaultMaxIdleConnsPerHost = 64
+	DefaultIdleConnTimeout     = 90 * time.Second
+	DefaultDialTimeout         = 5 * time.Second
+	DefaultKeepAlive           = 30 * time.Second
+	DefaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+// Connection states reported by veloflux_upstream_connections_total
+const (
+	ConnectionNew    = "new"
+	ConnectionReused = "reused"
+)
+
+// Transport is the round tripper shared by every request proxied to the
+// backends of a pool. It keeps connections alive between requests and
+// records connection reuse statistics for the pool.
+type Transport struct {
+	pool      string
+	transport roundTripper
+}
+
+// roundTripper is implemented by the HTTP/1.1 and HTTP/2 transports
+type roundTripper interface {
+	http.RoundTripper
+	CloseIdleConnections()
+}
+
+// NewTransport builds the transport for a pool from its upstream settings
+func NewTransport(poolName string, cfg config.UpstreamConfig) (*Transport, error) {
+	dialer := &net.Dialer{
+		Timeout:   durationOr(cfg.DialTimeout, DefaultDialTimeout),
+		KeepAlive: durationOr(cfg.KeepAlive, DefaultKeepAlive),
+	}
+
+	if cfg.Scheme == config.UpstreamH2C {
+		return newH2CTransport(poolName, cfg, dialer), nil
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           countingDialer(poolName, dialer),
+		MaxIdleConns:          intOr(cfg.MaxIdleConns, DefaultMaxIdleConns),
+		MaxIdleConnsPerHost:   intOr(cfg.MaxIdleConnsPerHost, DefaultMaxIdleConnsPerHost),
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       durationOr(cfg.IdleConnTimeout, DefaultIdleConnTimeout),
+		TLSHandshakeTimeout:   durationOr(cfg.TLSHandshakeTimeout, DefaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		DisableKeepAlives:     cfg.DisableKeepAlives,
+		ForceAttemptHTTP2:     !cfg.DisableHTTP2,
+	}
+	if cfg.DisableHTTP2 {
+		// A non-nil empty map turns off the automatic HTTP/2 upgrade
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	if UsesTLS(cfg) {
+		tlsConfig, err := ClientTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &Transport{pool: poolName, transport: transport}, nil
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			state := ConnectionNew
+			if info.Reused {
+				state = ConnectionReused
+			}
+			metrics.UpstreamConnectionsTotal.WithLabelValues(t.pool, state).Inc()
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	return t.transport.RoundTrip(req)
+}
+
+// CloseIdleConnections closes connections that are not carrying a request
+func (t *Transport) CloseIdleConnections() {
+	t.transport.CloseIdleConnections()
+}
+
+// newH2CTransport builds a transport speaking HTTP/2 with prior knowledge
+// over cleartext connections. Requests are multiplexed over a connection per
+// backend, so every request is balanced on its own.
+func newH2CTransport(poolName string, cfg config.UpstreamConfig, dialer *net.Dialer) *Transport {
+	dial := countingDialer(poolName, dialer)
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		},
+		IdleConnTimeout:    durationOr(cfg.IdleConnTimeout, DefaultIdleConnTimeout),
+		ReadIdleTimeout:    durationOr(cfg.KeepAlive, DefaultKeepAlive),
+		DisableCompression: true,
+	}
+	return &Transport{pool: poolName, transport: transport}
+}
+
+// countingDialer wraps a dialer so open connections and dial failures are
+// tracked per pool.
+func countingDialer(poolName string, dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			metrics.UpstreamDialErrorsTotal.WithLabelValues(poolName).Inc()
+			return nil, err
+		}
+		metrics.UpstreamOpenConnections.WithLabelValues(poolName).Inc()
+		return &countedConn{Conn: conn, pool: poolName}, nil
+	}
+}
+
+// countedConn decrements the open connection gauge once when closed
+type countedConn struct {
+	net.Conn
+	pool string
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		metrics.UpstreamOpenConnections.WithLabelValues(c.pool).Dec()
+	})
+	return c.Conn.Close()
+}
+
+func durationOr(value, fallback time.Duration) time.Duration {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+func intOr(value, fallback int) int {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
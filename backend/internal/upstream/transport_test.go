@@ -0,0 +1,97 @@
+package upstream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eltonciatto/veloflux/internal/config"
+	"github.com/eltonciatto/veloflux/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestTransportReusesConnections(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	transport, err := NewTransport("reuse", config.UpstreamConfig{})
+	require.NoError(t, err)
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(backend.URL)
+		require.NoError(t, err)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.UpstreamConnectionsTotal.WithLabelValues("reuse", ConnectionNew)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.UpstreamConnectionsTotal.WithLabelValues("reuse", ConnectionReused)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.UpstreamOpenConnections.WithLabelValues("reuse")))
+
+	transport.CloseIdleConnections()
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.UpstreamOpenConnections.WithLabelValues("reuse")))
+}
+
+func TestH2CTransport(t *testing.T) {
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}), &http2.Server{}))
+	defer backend.Close()
+
+	transport, err := NewTransport("h2c", config.UpstreamConfig{Scheme: config.UpstreamH2C})
+	require.NoError(t, err)
+	client := &http.Client{Transport: transport}
+
+	// Requests share one multiplexed connection
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(backend.URL)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "HTTP/2.0", string(body))
+	}
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.UpstreamConnectionsTotal.WithLabelValues("h2c", ConnectionNew)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.UpstreamOpenConnections.WithLabelValues("h2c")))
+}
+
+func TestTransportDialErrors(t *testing.T) {
+	transport, err := NewTransport("unreachable", config.UpstreamConfig{DialTimeout: time.Second})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "http://127.0.0.1:1/", nil)
+	require.NoError(t, err)
+	_, err = transport.RoundTrip(req)
+	assert.Error(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.UpstreamDialErrorsTotal.WithLabelValues("unreachable")))
+}
+
+func TestNewTransportSettings(t *testing.T) {
+	transport, err := NewTransport("tuned", config.UpstreamConfig{
+		MaxIdleConnsPerHost:   8,
+		MaxConnsPerHost:       16,
+		ResponseHeaderTimeout: 3 * time.Second,
+		DisableHTTP2:          true,
+	})
+	require.NoError(t, err)
+	tuned, ok := transport.transport.(*http.Transport)
+	require.True(t, ok)
+
+	assert.Equal(t, 8, tuned.MaxIdleConnsPerHost)
+	assert.Equal(t, DefaultMaxIdleConns, tuned.MaxIdleConns)
+	assert.Equal(t, 16, tuned.MaxConnsPerHost)
+	assert.Equal(t, 3*time.Second, tuned.ResponseHeaderTimeout)
+	assert.False(t, tuned.ForceAttemptHTTP2)
+	assert.NotNil(t, tuned.TLSNextProto)
+
+	_, err = NewTransport("broken", config.UpstreamConfig{Scheme: "https", TLS: config.UpstreamTLS{MinVersion: "2.0"}})
+	assert.Error(t, err)
+}
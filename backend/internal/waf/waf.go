@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	coraza "github.com/corazawaf/coraza/v3"
+	"github.com/eltonciatto/veloflux/internal/clientip"
 )
 
 // WAF wraps a Coraza engine.
@@ -37,7 +38,8 @@ func (w *WAF) Middleware(next http.Handler) http.Handler {
 			tx.Close()
 		}()
 
-		tx.ProcessConnection(r.RemoteAddr, 0, r.Host, 0)
+		// Rules see the client behind trusted proxies, not the proxies
+		tx.ProcessConnection(clientip.FromRequest(r).String(), 0, r.Host, 0)
 		tx.ProcessURI(r.URL.String(), r.Method, r.Proto)
 		for k, v := range r.Header {
 			for _, vv := range v {